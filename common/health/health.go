@@ -0,0 +1,59 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 组件的状态
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// 一个依赖组件的检查，比如数据库连接
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// 单个组件的检查结果
+type Component struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// 并发执行所有检查，每个检查最多执行 timeout，全部正常的时候返回 true
+func Run(ctx context.Context, timeout time.Duration, checks []Check) (bool, map[string]Component) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ok := true
+	components := make(map[string]Component, len(checks))
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.Func(ctx)
+			component := Component{Status: StatusUp, Latency: time.Since(start).String()}
+			if err != nil {
+				component.Status = StatusDown
+				component.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			components[check.Name] = component
+			if err != nil {
+				ok = false
+			}
+		}(check)
+	}
+	wg.Wait()
+	return ok, components
+}
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIdKey struct{}
+
+// 把请求 id 放进 context，之后通过 Ctx 输出的日志都会带上
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// 获取 context 里的请求 id，没有的时候返回空字符串
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// 处理请求的时候使用，日志会带上请求 id 和 trace id
+func Ctx(ctx context.Context) *logrus.Entry {
+	entry := logrus.NewEntry(std).WithContext(ctx)
+	if id := RequestId(ctx); id != "" {
+		entry = entry.WithField("requestId", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		entry = entry.WithField("traceId", sc.TraceID().String())
+	}
+	return entry
+}
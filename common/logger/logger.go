@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// 日志的附加字段
+type Fields = logrus.Fields
+
+var levelNames = map[string]logrus.Level{
+	"debug": logrus.DebugLevel,
+	"info":  logrus.InfoLevel,
+	"warn":  logrus.WarnLevel,
+	"error": logrus.ErrorLevel,
+}
+
+// 所有日志都通过这个 logger 输出，输出前会隐藏密钥
+var std = &logrus.Logger{
+	Out:       os.Stderr,
+	Formatter: &redactFormatter{next: &logrus.JSONFormatter{}},
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.InfoLevel,
+	ExitFunc:  os.Exit,
+}
+
+// 检查日志级别名称是否合法
+func ParseLevel(name string) (logrus.Level, error) {
+	l, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level [%s], must be debug, info, warn or error", name)
+	}
+	return l, nil
+}
+
+// 设置日志级别，低于这个级别的日志不输出
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	std.SetLevel(l)
+	return nil
+}
+
+// 检查日志格式名称是否合法
+func ParseFormat(name string) (logrus.Formatter, error) {
+	switch strings.ToLower(name) {
+	case "json":
+		return &logrus.JSONFormatter{}, nil
+	case "text":
+		return &logrus.TextFormatter{FullTimestamp: true, DisableColors: true}, nil
+	}
+	return nil, fmt.Errorf("unknown log format [%s], must be json or text", name)
+}
+
+// 设置日志格式，json 或者 text
+func SetFormat(name string) error {
+	formatter, err := ParseFormat(name)
+	if err != nil {
+		return err
+	}
+	std.SetFormatter(&redactFormatter{next: formatter})
+	return nil
+}
+
+// 带上附加字段，比如用户 id、门锁 mac
+func WithFields(fields Fields) *logrus.Entry {
+	return std.WithFields(fields)
+}
+
+func Debugf(format string, args ...interface{}) {
+	std.Debugf(format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	std.Infof(format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	std.Warnf(format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	std.Errorf(format, args...)
+}
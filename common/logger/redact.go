@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+)
+
+// 替换密钥的值
+const mask = "******"
+
+// 字段名以这些结尾的时候认为是密钥，比如 key、lockKey、sessionKey、token、secret
+var secretSuffixes = []string{"key", "token", "secret", "password", "authorization", "jwt"}
+
+var (
+	// jwt 由三段 base64 组成，第一段是 {" 开头的 json
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// 消息里 key=xxx、sessionKey: xxx 这种形式的密钥
+	assignPattern = regexp.MustCompile(`(?i)((?:key|token|secret|password)["']?\s*[=:]\s*["']?)[^\s"',&]+`)
+)
+
+// 输出之前隐藏日志消息和字段里的密钥
+type redactFormatter struct {
+	next logrus.Formatter
+}
+
+func (f *redactFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	// 不修改原来的 entry，字段可能被其他日志共用
+	redacted := *entry
+	redacted.Message = Redact(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		redacted.Data[k] = redactField(k, v)
+	}
+	return f.next.Format(&redacted)
+}
+
+func redactField(name string, value interface{}) interface{} {
+	if isSecretName(name) {
+		return mask
+	}
+	switch v := value.(type) {
+	case string:
+		return Redact(v)
+	case error:
+		return Redact(v.Error())
+	}
+	return value
+}
+
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 隐藏字符串里的 jwt 和 key=xxx 形式的密钥
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, mask)
+	return assignPattern.ReplaceAllString(s, "${1}"+mask)
+}
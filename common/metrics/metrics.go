@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"time"
+)
+
+const namespace = "ezlock"
+
+// http 请求相关的指标
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP 请求数",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP 请求的处理时间",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	responseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "response_errors_total",
+		Help:      "按照业务错误码统计的错误响应数",
+	}, []string{"route", "code"})
+)
+
+// 门锁业务相关的指标
+var (
+	keysIssued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_keys_total",
+		Help:      "生成门锁操作密钥的次数，result 为 issued、denied 或者 error",
+	}, []string{"operation", "result"})
+
+	decryptFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_decrypt_failures_total",
+		Help:      "解密硬件上传数据失败的次数，reason 为 denied、decrypt 或者 error",
+	}, []string{"reason"})
+
+	logLinesStored = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_log_lines_stored_total",
+		Help:      "写入的开锁日志条数",
+	})
+
+	logLinesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_log_lines_dropped_total",
+		Help:      "丢弃的开锁日志条数，reason 为 malformed、unknown_user 或者 store_error",
+	}, []string{"reason"})
+
+	authRedemptions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_redemptions_total",
+		Help:      "领取授权的次数，result 为 success、used 或者 error",
+	}, []string{"result"})
+
+	authRevocations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_revocations_total",
+		Help:      "撤销授权的次数",
+	})
+)
+
+// 数据库相关的指标
+var (
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_operation_duration_seconds",
+		Help:      "数据库操作的耗时",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"backend", "operation"})
+
+	dbErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_operation_errors_total",
+		Help:      "数据库操作失败的次数",
+	}, []string{"backend", "operation"})
+)
+
+// 记录一次 http 请求
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// 记录一次错误响应
+func ResponseError(route string, code int) {
+	responseErrors.WithLabelValues(route, strconv.Itoa(code)).Inc()
+}
+
+// 记录一次生成门锁操作密钥
+func KeyIssued(operation, result string) {
+	keysIssued.WithLabelValues(operation, result).Inc()
+}
+
+// 记录一次解密失败
+func DecryptFailed(reason string) {
+	decryptFailures.WithLabelValues(reason).Inc()
+}
+
+// 记录写入的开锁日志
+func LogLineStored() {
+	logLinesStored.Inc()
+}
+
+// 记录丢弃的开锁日志
+func LogLineDropped(reason string) {
+	logLinesDropped.WithLabelValues(reason).Inc()
+}
+
+// 记录一次领取授权
+func AuthRedeemed(result string) {
+	authRedemptions.WithLabelValues(result).Inc()
+}
+
+// 记录一次撤销授权
+func AuthRevoked() {
+	authRevocations.Inc()
+}
+
+// 记录一次撤销多个授权
+func AuthsRevoked(count int) {
+	authRevocations.Add(float64(count))
+}
+
+// 返回记录数据库操作耗时的函数，传给 mongo.Options 和 sqldb.Options 的 Observer
+func DbObserver(backend string) func(operation string, duration time.Duration, err error) {
+	return func(operation string, duration time.Duration, err error) {
+		dbDuration.WithLabelValues(backend, operation).Observe(duration.Seconds())
+		if err != nil {
+			dbErrors.WithLabelValues(backend, operation).Inc()
+		}
+	}
+}
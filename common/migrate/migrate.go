@@ -0,0 +1,162 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// 迁移没有提供回滚操作
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// 一次编号的数据库变更，比如建立索引、字段改名、补全历史数据
+// Up 和 Down 都需要可以重复执行，记录迁移结果失败的时候下次会再执行一次
+type Migration struct {
+	Version int                             // 版本号，按照从小到大的顺序执行，发布之后不能修改
+	Name    string                          // 迁移名称
+	Up      func(ctx context.Context) error // 执行迁移
+	Down    func(ctx context.Context) error // 回滚迁移，为 nil 表示不能回滚
+	Check   func(ctx context.Context) error // 检查迁移的结果是否还在，比如索引有没有被手动删除，为 nil 表示不需要检查
+}
+
+// 记录已经执行过的迁移，mongo 和 sql 各自实现
+type Recorder interface {
+	// 获取已经执行过的迁移版本和执行时间
+	Applied(ctx context.Context) (map[int]time.Time, error)
+	// 记录迁移已经执行
+	Record(ctx context.Context, m Migration) error
+	// 删除迁移记录
+	Remove(ctx context.Context, m Migration) error
+}
+
+// 迁移的执行状态
+type Status struct {
+	Migration
+	Applied   bool      // 是否已经执行
+	AppliedAt time.Time // 执行时间
+}
+
+type Migrator struct {
+	recorder   Recorder
+	migrations []Migration
+}
+
+// 创建迁移执行器，版本号重复属于代码错误，直接 panic
+func New(recorder Recorder, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			panic(fmt.Sprintf("duplicate migration version %d", sorted[i].Version))
+		}
+	}
+	return &Migrator{recorder: recorder, migrations: sorted}
+}
+
+// 获取所有迁移的执行状态，按照版本号排序
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.recorder.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		at, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: at})
+	}
+	return statuses, nil
+}
+
+// 获取还没有执行的迁移
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// 检查数据库是否已经迁移到最新版本，并且已经执行的迁移结果都还在
+func (m *Migrator) Verify(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+			continue
+		}
+		if status.Check == nil {
+			continue
+		}
+		if err := status.Check(ctx); err != nil {
+			return fmt.Errorf("migration %d %s check failed: %s", status.Version, status.Name, err.Error())
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations pending", pending)
+	}
+	return nil
+}
+
+// 按照版本号从小到大执行所有没有执行过的迁移，每执行完一个调用一次 onApply
+// 遇到错误立即停止，返回已经执行的数量
+func (m *Migrator) Up(ctx context.Context, onApply func(Migration)) (int, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, migration := range pending {
+		if err := migration.Up(ctx); err != nil {
+			return i, fmt.Errorf("migration %d %s up failed: %s", migration.Version, migration.Name, err.Error())
+		}
+		if err := m.recorder.Record(ctx, migration); err != nil {
+			return i, fmt.Errorf("migration %d %s record failed: %s", migration.Version, migration.Name, err.Error())
+		}
+		if onApply != nil {
+			onApply(migration)
+		}
+	}
+	return len(pending), nil
+}
+
+// 从最新的版本开始回滚 steps 个已经执行的迁移，每回滚一个调用一次 onRevert
+// 遇到不能回滚的迁移或者错误立即停止，返回已经回滚的数量
+func (m *Migrator) Down(ctx context.Context, steps int, onRevert func(Migration)) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	reverted := 0
+	for i := len(statuses) - 1; i >= 0 && reverted < steps; i-- {
+		migration := statuses[i].Migration
+		if !statuses[i].Applied {
+			continue
+		}
+		if migration.Down == nil {
+			return reverted, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, ErrIrreversible)
+		}
+		if err := migration.Down(ctx); err != nil {
+			return reverted, fmt.Errorf("migration %d %s down failed: %s", migration.Version, migration.Name, err.Error())
+		}
+		if err := m.recorder.Remove(ctx, migration); err != nil {
+			return reverted, fmt.Errorf("migration %d %s remove record failed: %s", migration.Version, migration.Name, err.Error())
+		}
+		reverted++
+		if onRevert != nil {
+			onRevert(migration)
+		}
+	}
+	return reverted, nil
+}
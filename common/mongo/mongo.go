@@ -1,39 +1,182 @@
 package mongo
 
 import (
-	"ezlock/config"
-	"fmt"
-	"github.com/globalsign/mgo"
-	"os"
+	"context"
+	"errors"
+	"ezlock/common/tracing"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
 	"time"
 )
 
-var mgoSession *mgo.Session
+// 还没有连接上 mongo，或者连接已经关闭
+var ErrNotConnected = errors.New("mongodb is not connected")
 
-func init() {
-	// 链接到mongo 获取操作 mongo 的session
-	var err error
-	mgoSession, err = mgo.DialWithTimeout(config.MgoUrl, time.Duration(config.MgoTimeout)*time.Second)
+// mongo 连接相关的配置
+type Options struct {
+	Url              string        // 连接地址
+	DataBaseName     string        // 数据库名称
+	Timeout          time.Duration // 每次连接的超时时间
+	OperationTimeout time.Duration // 每一个mongo操作的超时时间
+	PoolLimit        int           // 连接池的最大值
+	RetryInterval    time.Duration // 连接失败后第一次重试的间隔，之后每次翻倍
+	MaxRetryInterval time.Duration // 重试间隔的最大值
+	// 每个 mongo 命令完成后调用，用于统计耗时，operation 为命令名称，可以为 nil
+	Observer func(operation string, duration time.Duration, err error)
+}
+
+// mongo 的连接，由 main 创建并传给需要访问数据库的模块，连接成功之前所有操作都返回 ErrNotConnected
+type DB struct {
+	opts Options
+
+	mu     sync.RWMutex
+	client *mongo.Client
+	closed bool
+}
+
+func New(opts Options) *DB {
+	return &DB{opts: opts}
+}
+
+// 连接到 mongo，失败后按照退避间隔一直重试，直到连接成功或者 stop 被关闭
+// 每次失败都会调用 onError，方便调用方输出日志
+func (db *DB) Connect(stop <-chan struct{}, onError func(err error, retryAfter time.Duration)) error {
+	interval := db.opts.RetryInterval
+	for {
+		err := db.dial()
+		if err == nil {
+			return nil
+		}
+		if onError != nil {
+			onError(err, interval)
+		}
+		select {
+		case <-stop:
+			return err
+		case <-time.After(interval):
+		}
+		interval *= 2
+		if interval > db.opts.MaxRetryInterval {
+			interval = db.opts.MaxRetryInterval
+		}
+	}
+}
+
+func (db *DB) dial() error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.opts.Timeout)
+	defer cancel()
+
+	opts := options.Client().
+		ApplyURI(db.opts.Url).
+		// 设置 如果主可用的可以的话 优先从主服务读，保证数据比较新
+		SetReadPreference(readpref.PrimaryPreferred()).
+		// 设置连接池的最大值
+		SetMaxPoolSize(uint64(db.opts.PoolLimit)).
+		SetMonitor(db.monitor())
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		fmt.Printf("mgo connect occur error [%s]", err.Error())
-		os.Exit(1)
+		return err
+	}
+	// Connect 不会真正建立连接，ping 一下确认 mongo 可用
+	if err := client.Ping(ctx, readpref.PrimaryPreferred()); err != nil {
+		client.Disconnect(context.Background())
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		client.Disconnect(context.Background())
+		return ErrNotConnected
 	}
-	// 设置 如果主可用的可以的话 优先从主服务读，保证数据比较新
-	mgoSession.SetMode(mgo.PrimaryPreferred, true)
-	// 设置连接池的最大值，默认4096
-	mgoSession.SetPoolLimit(config.MgoConnPoolLimit)
-	// 设置每一个mongo操作的超时时间，此处用默认值7秒
-	//mgoSession.SetSyncTimeout()
+	db.client = client
+	return nil
 }
 
-// 从连接池中获取一个session
-func GetMgoSession() *mgo.Session {
-	return mgoSession.Copy()
+// 每个 mongo 命令一个 span，命令完成后结束 span 并调用 Observer
+func (db *DB) monitor() *event.CommandMonitor {
+	// 命令开始和结束的事件通过 RequestID 对应起来
+	var spans sync.Map
+	finish := func(requestId int64, name string, duration time.Duration, err error) {
+		if db.opts.Observer != nil {
+			db.opts.Observer(name, duration, err)
+		}
+		if span, ok := spans.LoadAndDelete(requestId); ok {
+			tracing.End(span.(trace.Span), &err)
+		}
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			collection, _ := e.Command.Lookup(e.CommandName).StringValueOK()
+			_, span := tracing.StartClient(ctx, "mongo "+e.CommandName,
+				attribute.String("db.system", "mongodb"),
+				attribute.String("db.namespace", e.DatabaseName),
+				attribute.String("db.operation.name", e.CommandName),
+				attribute.String("db.collection.name", collection),
+			)
+			spans.Store(e.RequestID, span)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finish(e.RequestID, e.CommandName, e.Duration, nil)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finish(e.RequestID, e.CommandName, e.Duration, errors.New(e.Failure))
+		},
+	}
+}
+
+// 是否已经连接上 mongo
+func (db *DB) Ready() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.client != nil
+}
+
+// 检查 mongo 是否可用，连接池耗尽的时候会等到 ctx 超时
+func (db *DB) Ping(ctx context.Context) error {
+	db.mu.RLock()
+	client := db.client
+	db.mu.RUnlock()
+	if client == nil {
+		return ErrNotConnected
+	}
+	return client.Ping(ctx, readpref.PrimaryPreferred())
+}
+
+// 获取数据库，没有连接上的时候返回 ErrNotConnected
+func (db *DB) Database() (*mongo.Database, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.client == nil {
+		return nil, ErrNotConnected
+	}
+	return db.client.Database(db.opts.DataBaseName), nil
+}
+
+// 获取表，返回的 ctx 带上了单次操作的超时时间，用完需要调用 cancel
+func (db *DB) Collection(ctx context.Context, name string) (context.Context, context.CancelFunc, *mongo.Collection, error) {
+	database, err := db.Database()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, db.opts.OperationTimeout)
+	return ctx, cancel, database.Collection(name), nil
 }
 
-// 连接使用完毕后关闭连接
-func PutMgoSession(session *mgo.Session) {
-	if session != nil {
-		session.Close()
+// 关闭连接，之后所有操作都返回 ErrNotConnected
+func (db *DB) Close(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closed = true
+	if db.client == nil {
+		return nil
 	}
+	client := db.client
+	db.client = nil
+	return client.Disconnect(ctx)
 }
@@ -0,0 +1,39 @@
+package sms
+
+import (
+	"context"
+	"ezlock/common/logger"
+	"fmt"
+	"strings"
+)
+
+// 发送短信验证码，接入短信服务商的时候实现这个接口
+type Sender interface {
+	Send(ctx context.Context, phone, code string) error
+}
+
+// 检查发送方式是否合法
+func ValidSender(name string) bool {
+	switch strings.ToLower(name) {
+	case "log":
+		return true
+	}
+	return false
+}
+
+// 根据配置的名称创建 Sender
+func New(name string) (Sender, error) {
+	switch strings.ToLower(name) {
+	case "log":
+		return LogSender{}, nil
+	}
+	return nil, fmt.Errorf("unknown sms sender [%s], must be log", name)
+}
+
+// 本地调试使用，不发送短信，验证码直接写到日志里，不能在生产环境使用
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, phone, code string) error {
+	logger.Ctx(ctx).WithField("phone", phone).Warnf("sms sender is log, verify code is %s", code)
+	return nil
+}
@@ -0,0 +1,213 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"ezlock/common/tracing"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 支持的数据库类型
+const (
+	Postgres = "postgres"
+	Sqlite   = "sqlite3"
+)
+
+// 还没有连接上数据库，或者连接已经关闭
+var ErrNotConnected = errors.New("sql database is not connected")
+
+// 关系数据库连接相关的配置
+type Options struct {
+	Driver           string        // 数据库类型 postgres 或者 sqlite3
+	Dsn              string        // 连接地址，sqlite 为数据库文件
+	Timeout          time.Duration // 每次连接的超时时间
+	OperationTimeout time.Duration // 每一个sql操作的超时时间
+	PoolLimit        int           // 连接池的最大值
+	RetryInterval    time.Duration // 连接失败后第一次重试的间隔，之后每次翻倍
+	MaxRetryInterval time.Duration // 重试间隔的最大值
+	// 每个 sql 操作完成后调用，用于统计耗时，operation 为语句类型，可以为 nil
+	Observer func(operation string, duration time.Duration, err error)
+}
+
+// 关系数据库的连接，和 mongo.DB 一样由 main 创建，连接成功之前所有操作都返回 ErrNotConnected
+type DB struct {
+	opts Options
+
+	mu     sync.RWMutex
+	conn   *sql.DB
+	closed bool
+}
+
+func New(opts Options) (*DB, error) {
+	if opts.Driver != Postgres && opts.Driver != Sqlite {
+		return nil, fmt.Errorf("unsupported sql driver [%s]", opts.Driver)
+	}
+	return &DB{opts: opts}, nil
+}
+
+// 连接到数据库，失败后按照退避间隔一直重试，直到连接成功或者 stop 被关闭
+// 每次失败都会调用 onError，方便调用方输出日志
+func (db *DB) Connect(stop <-chan struct{}, onError func(err error, retryAfter time.Duration)) error {
+	interval := db.opts.RetryInterval
+	for {
+		err := db.dial()
+		if err == nil {
+			return nil
+		}
+		if onError != nil {
+			onError(err, interval)
+		}
+		select {
+		case <-stop:
+			return err
+		case <-time.After(interval):
+		}
+		interval *= 2
+		if interval > db.opts.MaxRetryInterval {
+			interval = db.opts.MaxRetryInterval
+		}
+	}
+}
+
+func (db *DB) dial() error {
+	conn, err := sql.Open(db.opts.Driver, db.opts.Dsn)
+	if err != nil {
+		return err
+	}
+	conn.SetMaxOpenConns(db.opts.PoolLimit)
+	if db.opts.Driver == Sqlite {
+		// sqlite 同一时间只能有一个写操作，多个连接写的时候会报 database is locked
+		conn.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), db.opts.Timeout)
+	defer cancel()
+	// Open 不会真正建立连接，ping 一下确认数据库可用
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		conn.Close()
+		return ErrNotConnected
+	}
+	db.conn = conn
+	return nil
+}
+
+// 是否已经连接上数据库
+func (db *DB) Ready() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.conn != nil
+}
+
+// 检查数据库是否可用，连接池耗尽的时候会等到 ctx 超时
+func (db *DB) Ping(ctx context.Context) error {
+	conn, err := db.Database()
+	if err != nil {
+		return err
+	}
+	return conn.PingContext(ctx)
+}
+
+// 记录一次 sql 操作的耗时
+func (db *DB) observe(operation string, start time.Time, err error) {
+	if db.opts.Observer != nil {
+		db.opts.Observer(operation, time.Since(start), err)
+	}
+}
+
+// 开始一次 sql 操作，返回的 ctx 带有这次操作的 span，操作完成后调用 done 记录耗时并结束 span
+func (db *DB) Trace(ctx context.Context, operation, query string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.StartClient(ctx, "sql "+operation,
+		attribute.String("db.system", db.opts.Driver),
+		attribute.String("db.operation.name", operation),
+		attribute.String("db.query.text", query),
+	)
+	return ctx, func(err error) {
+		db.observe(operation, start, err)
+		tracing.End(span, &err)
+	}
+}
+
+// 数据库类型
+func (db *DB) Driver() string {
+	return db.opts.Driver
+}
+
+// 获取连接池，不带操作超时，用于迁移这类耗时比较长的操作
+func (db *DB) Database() (*sql.DB, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return db.conn, nil
+}
+
+// 获取连接池，返回的 ctx 带上了单次操作的超时时间，用完需要调用 cancel
+func (db *DB) Conn(ctx context.Context) (context.Context, context.CancelFunc, *sql.DB, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.conn == nil {
+		return nil, nil, nil, ErrNotConnected
+	}
+	ctx, cancel := context.WithTimeout(ctx, db.opts.OperationTimeout)
+	return ctx, cancel, db.conn, nil
+}
+
+// sql 语句里统一用 ? 作为占位符，postgres 需要转换成 $1 $2 ...
+func (db *DB) Rebind(query string) string {
+	if db.opts.Driver != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, ch := range query {
+		if ch == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// 是否违反了唯一约束
+func IsDuplicate(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.ExtendedCode == sqlite3.ErrConstraintUnique || liteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
+
+// 关闭连接，之后所有操作都返回 ErrNotConnected
+func (db *DB) Close(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closed = true
+	if db.conn == nil {
+		return nil
+	}
+	conn := db.conn
+	db.conn = nil
+	return conn.Close()
+}
@@ -0,0 +1,90 @@
+package sqldb_test
+
+import (
+	"context"
+	"errors"
+	"ezlock/common/sqldb"
+	"testing"
+	"time"
+)
+
+func TestNewRejectsUnknownDriver(t *testing.T) {
+	if _, err := sqldb.New(sqldb.Options{Driver: "mysql"}); err == nil {
+		t.Fatal("expect error for unsupported driver")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		driver string
+		query  string
+		want   string
+	}{
+		{sqldb.Postgres, "SELECT id FROM users WHERE id=?", "SELECT id FROM users WHERE id=$1"},
+		{sqldb.Postgres, "UPDATE locks SET name=?, description=? WHERE mac=? AND own_id=?", "UPDATE locks SET name=$1, description=$2 WHERE mac=$3 AND own_id=$4"},
+		{sqldb.Postgres, "SELECT id FROM locks", "SELECT id FROM locks"},
+		{sqldb.Sqlite, "SELECT id FROM users WHERE id=? AND open_id=?", "SELECT id FROM users WHERE id=? AND open_id=?"},
+	}
+	for _, tt := range tests {
+		db, err := sqldb.New(sqldb.Options{Driver: tt.driver})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := db.Rebind(tt.query); got != tt.want {
+			t.Errorf("%s Rebind(%q) = %q, want %q", tt.driver, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	db, err := sqldb.New(sqldb.Options{Driver: sqldb.Sqlite, Dsn: ":memory:", Timeout: time.Second, OperationTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Connect(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(context.Background())
+	ctx, cancel, conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	exec := func(query string) error {
+		_, err := conn.ExecContext(ctx, query)
+		return err
+	}
+	if err := exec(`CREATE TABLE locks (id VARCHAR(24) PRIMARY KEY, mac VARCHAR(64) NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec(`CREATE UNIQUE INDEX index_locks_mac ON locks (mac)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec(`INSERT INTO locks (id, mac) VALUES ('1', 'AA')`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"primary key", `INSERT INTO locks (id, mac) VALUES ('1', 'BB')`, true},
+		{"unique index", `INSERT INTO locks (id, mac) VALUES ('2', 'AA')`, true},
+		{"not null", `INSERT INTO locks (id) VALUES ('3')`, false},
+		{"syntax", `INSERT INTO`, false},
+	}
+	for _, tt := range tests {
+		err := exec(tt.query)
+		if err == nil {
+			t.Fatalf("%s: expect error", tt.name)
+		}
+		if got := sqldb.IsDuplicate(err); got != tt.want {
+			t.Errorf("%s: IsDuplicate(%v) = %v, want %v", tt.name, err, got, tt.want)
+		}
+	}
+	if sqldb.IsDuplicate(nil) || sqldb.IsDuplicate(errors.New("duplicate key")) {
+		t.Error("only driver errors can be duplicates")
+	}
+}
@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// 所有 span 都由这个 tracer 创建
+const tracerName = "ezlock"
+
+// 导出 span 相关的配置
+type Options struct {
+	Exporter    string  // none、stdout、file 或者 otlp
+	Endpoint    string  // otlp http 接收地址，例如 127.0.0.1:4318
+	Insecure    bool    // otlp 不使用 https
+	File        string  // file 导出的时候写入的文件
+	SampleRatio float64 // 采样比例，0 到 1，上游已经采样的请求沿用上游的决定
+	ServiceName string
+	Version     string
+}
+
+// 检查导出方式是否合法
+func ValidExporter(name string) bool {
+	switch strings.ToLower(name) {
+	case "none", "stdout", "file", "otlp":
+		return true
+	}
+	return false
+}
+
+// 初始化全局的 tracer，返回的函数在退出的时候调用，导出还没有导出的 span
+// Exporter 为 none 的时候不导出，span 不会产生额外的开销
+func Init(opts Options) (func(ctx context.Context) error, error) {
+	// 不导出也需要解析上游的 traceparent，日志里可以带上 trace id
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		exporter sdktrace.SpanExporter
+		file     *os.File
+		err      error
+	)
+	switch strings.ToLower(opts.Exporter) {
+	case "none", "":
+		return func(ctx context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "file":
+		file, err = os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file failed: %s", err.Error())
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case "otlp":
+		httpOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), httpOpts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter [%s], must be none, stdout, file or otlp", opts.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+		semconv.ServiceVersion(opts.Version),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// 开始一个 span，ctx 里有 span 的时候作为子 span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// 开始一个客户端 span，用于数据库这类外部调用
+func StartClient(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// 开始处理 http 请求的 span，请求头里有 traceparent 的时候接上上游的 trace
+func StartServer(ctx context.Context, header http.Header, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// 结束 span，err 不为空的时候记录错误，一般和命名返回值一起使用 defer tracing.End(span, &err)
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// 用户 id 属性
+func UserId(id string) attribute.KeyValue {
+	return attribute.String("ezlock.user_id", id)
+}
+
+// 门锁 mac 属性
+func Mac(mac string) attribute.KeyValue {
+	return attribute.String("ezlock.lock_mac", mac)
+}
@@ -0,0 +1,30 @@
+package version
+
+import (
+	"runtime"
+)
+
+// 构建信息，编译的时候通过 ldflags 注入，例如
+// go build -ldflags "-X ezlock/common/version.Version=v1.2.0 -X ezlock/common/version.Commit=$(git rev-parse --short HEAD) -X ezlock/common/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// 对外展示的构建信息
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -0,0 +1,182 @@
+package config_test
+
+import (
+	"ezlock/config"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const yamlConfig = `
+server:
+  listenPort: 9000
+weapp:
+  appId: wx-app
+  secret: wx-secret
+jwt:
+  secretKey: file-secret-key-0123456789
+store:
+  backend: sqlite3
+`
+
+const tomlConfig = `
+[server]
+listenPort = 9000
+[weapp]
+appId = "wx-app"
+secret = "wx-secret"
+[jwt]
+secretKey = "file-secret-key-0123456789"
+[store]
+backend = "sqlite3"
+`
+
+// 在临时目录写一个配置文件，返回路径
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 可以通过检查的配置
+func validConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Weapp.AppID = "wx-app"
+	cfg.Weapp.Secret = "wx-secret"
+	cfg.Jwt.SecretKey = "file-secret-key-0123456789"
+	return cfg
+}
+
+func TestLoadFile(t *testing.T) {
+	for _, name := range []string{"config.yaml", "config.yml", "config.toml"} {
+		t.Run(name, func(t *testing.T) {
+			content := yamlConfig
+			if strings.HasSuffix(name, ".toml") {
+				content = tomlConfig
+			}
+			cfg, err := config.Load(writeFile(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.ListenPort != 9000 || cfg.Weapp.AppID != "wx-app" || cfg.Store.Backend != "sqlite3" {
+				t.Fatalf("file not applied: %+v", cfg)
+			}
+			// 文件里没有的配置保持默认值
+			if cfg.Server.ProjName != "ezlock" || cfg.Jwt.Timeout != 15 {
+				t.Fatalf("default overwritten: %+v", cfg)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	t.Setenv("EZLOCK_LISTEN_PORT", "9100")
+	t.Setenv("EZLOCK_JWT_SECRET_KEY", "env-secret-key-0123456789")
+	t.Setenv("EZLOCK_STORE_AUTO_MIGRATE", "false")
+	cfg, err := config.Load(writeFile(t, "config.yaml", yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.ListenPort != 9100 {
+		t.Fatalf("expect listen port from env, got %d", cfg.Server.ListenPort)
+	}
+	if cfg.Jwt.SecretKey != "env-secret-key-0123456789" {
+		t.Fatalf("expect jwt secret from env, got %s", cfg.Jwt.SecretKey)
+	}
+	if cfg.Store.AutoMigrate {
+		t.Fatal("expect auto migrate disabled by env")
+	}
+	// 环境变量没有覆盖的字段还是用文件里的值
+	if cfg.Weapp.AppID != "wx-app" {
+		t.Fatalf("expect app id from file, got %s", cfg.Weapp.AppID)
+	}
+}
+
+func TestSecretFileOverridesEnv(t *testing.T) {
+	t.Setenv("EZLOCK_JWT_SECRET_KEY", "env-secret-key-0123456789")
+	t.Setenv("EZLOCK_JWT_SECRET_KEY_FILE", writeFile(t, "jwt.key", "file-key-0123456789abcdef\n"))
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Jwt.SecretKey != "file-key-0123456789abcdef" {
+		t.Fatalf("expect jwt secret from file, got %q", cfg.Jwt.SecretKey)
+	}
+	// 展示的时候隐藏密钥，不影响原来的配置
+	if masked := cfg.Masked(); masked.Jwt.SecretKey != "******" || cfg.Jwt.SecretKey == masked.Jwt.SecretKey {
+		t.Fatalf("expect masked jwt secret, got %q", masked.Jwt.SecretKey)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	cases := []struct {
+		name string
+		file string
+		body string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown yaml key", file: "config.yaml", body: "server:\n  listenPort: 9000\n  unknown: 1\n", want: "parse config file"},
+		{name: "unknown toml key", file: "config.toml", body: "[server]\nunknown = 1\n", want: "unknown keys"},
+		{name: "unsupported extension", file: "config.json", body: "{}", want: "unsupported config file"},
+		{name: "invalid int env", file: "config.yaml", body: yamlConfig, env: map[string]string{"EZLOCK_LISTEN_PORT": "port"}, want: "EZLOCK_LISTEN_PORT"},
+		{name: "invalid bool env", file: "config.yaml", body: yamlConfig, env: map[string]string{"EZLOCK_STORE_AUTO_MIGRATE": "maybe"}, want: "EZLOCK_STORE_AUTO_MIGRATE"},
+		{name: "missing secret file", file: "config.yaml", body: yamlConfig, env: map[string]string{"EZLOCK_WEAPP_SECRET_FILE": "/nonexistent/secret"}, want: "read secret file"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for key, value := range c.env {
+				t.Setenv(key, value)
+			}
+			_, err := config.Load(writeFile(t, c.file, c.body))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expect error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// 默认配置的密钥都是占位符，不能直接启动
+	if err := config.Default().Validate(); err == nil {
+		t.Fatal("expect default config rejected")
+	}
+
+	cases := []struct {
+		name   string
+		modify func(cfg *config.Config)
+		want   string
+	}{
+		{"listen port", func(cfg *config.Config) { cfg.Server.ListenPort = 70000 }, "server.listenPort"},
+		{"placeholder app id", func(cfg *config.Config) { cfg.Weapp.AppID = "xxx" }, "weapp.appId"},
+		{"placeholder secret", func(cfg *config.Config) { cfg.Weapp.Secret = "changeme" }, "weapp.secret"},
+		{"short jwt key", func(cfg *config.Config) { cfg.Jwt.SecretKey = "short-key" }, "jwt.secretKey must be at least"},
+		{"jwt timeout", func(cfg *config.Config) { cfg.Jwt.Timeout = 0 }, "jwt.timeout"},
+		{"unknown backend", func(cfg *config.Config) { cfg.Store.Backend = "mysql" }, "store.backend"},
+		{"sql dsn", func(cfg *config.Config) { cfg.Store.Backend = "postgres"; cfg.Sql.Dsn = "" }, "sql.dsn"},
+		{"retry interval", func(cfg *config.Config) { cfg.Store.MaxRetryInterval = 0 }, "store.maxRetryInterval"},
+		{"password lock duration", func(cfg *config.Config) { cfg.Password.LockDuration = 0 }, "password.lockDuration"},
+		{"del card format", func(cfg *config.Config) { cfg.Command.DelCard = "delcard" }, "command.delCard"},
+		{"placeholder admin token", func(cfg *config.Config) { cfg.Admin.Token = "todo" }, "admin.token"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := validConfig()
+			c.modify(cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expect error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
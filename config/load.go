@@ -0,0 +1,166 @@
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 指定配置文件路径的环境变量
+const PathEnv = "EZLOCK_CONFIG"
+
+// 没有指定配置文件的时候使用的路径，文件不存在就只用默认值和环境变量
+const DefaultPath = "config.yaml"
+
+// 获取配置文件路径，通过环境变量指定的文件必须存在
+func Path() (string, error) {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path, nil
+	}
+	if _, err := os.Stat(DefaultPath); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return DefaultPath, nil
+}
+
+// 加载配置，path 为空的时候不读取配置文件
+// 配置文件支持 yaml 和 toml，按照扩展名区分，之后用环境变量覆盖，最后读取密钥文件
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := readFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	if err := readSecretFiles(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func readFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file failed: %s", err.Error())
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), cfg)
+		if err == nil && len(meta.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown keys %v", meta.Undecoded())
+		}
+	default:
+		return fmt.Errorf("unsupported config file [%s], must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file [%s] failed: %s", path, err.Error())
+	}
+	return nil
+}
+
+// 用 env 标签对应的环境变量覆盖配置
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("invalid environment variable %s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		// 列表用逗号分隔
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+	return nil
+}
+
+// 密钥字段对应的文件字段不为空的时候，从文件读取密钥，覆盖配置文件和环境变量里的值
+func readSecretFiles(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := readSecretFiles(field); err != nil {
+				return err
+			}
+			continue
+		}
+		fileField := t.Field(i).Tag.Get("secret")
+		if fileField == "" {
+			continue
+		}
+		path := v.FieldByName(fileField).String()
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read secret file for %s failed: %s", t.Field(i).Name, err.Error())
+		}
+		// 文件末尾通常有换行
+		field.SetString(strings.TrimSpace(string(data)))
+	}
+	return nil
+}
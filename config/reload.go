@@ -0,0 +1,49 @@
+package config
+
+import (
+	"reflect"
+)
+
+// 重新加载配置，只有不影响连接和监听的配置会生效
+// 端口、http 超时、短信发送方式、链路追踪和存储相关的配置需要重启才能生效，这些配置保持原来的值，返回被忽略的配置名称
+func Reload(next *Config) []string {
+	cur := Get()
+	merged := *next
+	ignored := []string{}
+	keep := func(name string, cur, next interface{}) {
+		if !reflect.DeepEqual(cur, next) {
+			ignored = append(ignored, name)
+		}
+	}
+
+	keep("server.listenPort", cur.Server.ListenPort, next.Server.ListenPort)
+	keep("server.projName", cur.Server.ProjName, next.Server.ProjName)
+	keep("server.readTimeout", cur.Server.ReadTimeout, next.Server.ReadTimeout)
+	keep("server.writeTimeout", cur.Server.WriteTimeout, next.Server.WriteTimeout)
+	keep("server.idleTimeout", cur.Server.IdleTimeout, next.Server.IdleTimeout)
+	merged.Server.ListenPort = cur.Server.ListenPort
+	merged.Server.ProjName = cur.Server.ProjName
+	merged.Server.ReadTimeout = cur.Server.ReadTimeout
+	merged.Server.WriteTimeout = cur.Server.WriteTimeout
+	merged.Server.IdleTimeout = cur.Server.IdleTimeout
+
+	keep("jwt.secretKey", cur.Jwt.SecretKey, next.Jwt.SecretKey)
+	merged.Jwt.SecretKey = cur.Jwt.SecretKey
+	merged.Jwt.SecretKeyFile = cur.Jwt.SecretKeyFile
+
+	keep("sms.sender", cur.Sms.Sender, next.Sms.Sender)
+	merged.Sms.Sender = cur.Sms.Sender
+
+	keep("tracing", cur.Tracing, next.Tracing)
+	merged.Tracing = cur.Tracing
+
+	keep("store", cur.Store, next.Store)
+	keep("mongo", cur.Mongo, next.Mongo)
+	keep("sql", cur.Sql, next.Sql)
+	merged.Store = cur.Store
+	merged.Mongo = cur.Mongo
+	merged.Sql = cur.Sql
+
+	Set(&merged)
+	return ignored
+}
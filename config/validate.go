@@ -0,0 +1,149 @@
+package config
+
+import (
+	"errors"
+	"ezlock/common/logger"
+	"ezlock/common/sms"
+	"ezlock/common/tracing"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// jwt 密钥的最短长度
+const minJwtKeyLength = 16
+
+// 支持的登录方式
+var validProviders = map[string]bool{"wechat": true, "sms": true, "password": true}
+
+// 对外展示的时候替换密钥的值
+const mask = "******"
+
+// 检查配置是否可以用来启动服务，返回所有不合法的配置
+func (cfg *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(cfg.Server.ListenPort > 0 && cfg.Server.ListenPort < 65536, "server.listenPort must be between 1 and 65535")
+	check(cfg.Server.ProjName != "", "server.projName is required")
+	check(cfg.Server.ReadTimeout > 0, "server.readTimeout must be positive")
+	check(cfg.Server.WriteTimeout > 0, "server.writeTimeout must be positive")
+	check(cfg.Server.IdleTimeout > 0, "server.idleTimeout must be positive")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+
+	_, err := logger.ParseLevel(cfg.Log.Level)
+	check(err == nil, "log.level must be debug, info, warn or error")
+	_, err = logger.ParseFormat(cfg.Log.Format)
+	check(err == nil, "log.format must be json or text")
+
+	check(tracing.ValidExporter(cfg.Tracing.Exporter), "tracing.exporter must be none, stdout, file or otlp")
+	switch strings.ToLower(cfg.Tracing.Exporter) {
+	case "file":
+		check(cfg.Tracing.File != "", "tracing.file is required")
+	case "otlp":
+		check(cfg.Tracing.Endpoint != "", "tracing.endpoint is required")
+	}
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+
+	check(len(cfg.Login.Providers) > 0, "login.providers must not be empty")
+	for _, provider := range cfg.Login.Providers {
+		check(validProviders[provider], "login.providers [%s] must be wechat, sms or password", provider)
+	}
+	// 没有开启微信登录的时候可以不配置小程序
+	if cfg.LoginEnabled("wechat") {
+		check(!isPlaceholder(cfg.Weapp.AppID), "weapp.appId is not set")
+		check(!isPlaceholder(cfg.Weapp.Secret), "weapp.secret is not set")
+	}
+	check(sms.ValidSender(cfg.Sms.Sender), "sms.sender must be log")
+	check(cfg.Sms.CodeExpire > 0, "sms.codeExpire must be positive")
+	check(cfg.Sms.SendInterval >= 0, "sms.sendInterval must not be negative")
+	check(cfg.Sms.MaxAttempts > 0, "sms.maxAttempts must be positive")
+	check(cfg.Password.MaxAttempts > 0, "password.maxAttempts must be positive")
+	check(cfg.Password.LockDuration > 0, "password.lockDuration must be positive")
+
+	check(!isPlaceholder(cfg.Jwt.SecretKey), "jwt.secretKey is not set")
+	check(len(cfg.Jwt.SecretKey) >= minJwtKeyLength, "jwt.secretKey must be at least %d characters", minJwtKeyLength)
+	check(cfg.Jwt.Timeout > 0, "jwt.timeout must be positive")
+	check(cfg.Jwt.MaxRefresh >= 0, "jwt.maxRefresh must not be negative")
+	check(cfg.Jwt.RefreshExpire > 0, "jwt.refreshExpire must be positive")
+	check(cfg.Jwt.StepUpExpire > 0, "jwt.stepUpExpire must be positive")
+
+	switch cfg.Store.Backend {
+	case "mongo":
+		check(cfg.Mongo.Url != "", "mongo.url is required")
+		check(cfg.Mongo.DataBaseName != "", "mongo.dataBaseName is required")
+		check(cfg.Mongo.ConnPoolLimit > 0, "mongo.connPoolLimit must be positive")
+	case "postgres", "sqlite3":
+		check(cfg.Sql.Dsn != "", "sql.dsn is required")
+		check(cfg.Sql.ConnPoolLimit > 0, "sql.connPoolLimit must be positive")
+	default:
+		check(false, "store.backend must be mongo, postgres or sqlite3")
+	}
+	check(cfg.Store.Timeout > 0, "store.timeout must be positive")
+	check(cfg.Store.OperationTimeout > 0, "store.operationTimeout must be positive")
+	check(cfg.Store.RetryInterval > 0, "store.retryInterval must be positive")
+	check(cfg.Store.MaxRetryInterval >= cfg.Store.RetryInterval, "store.maxRetryInterval must not be less than store.retryInterval")
+	check(cfg.Store.MigrateTimeout > 0, "store.migrateTimeout must be positive")
+
+	check(cfg.Command.OpenLock != "", "command.openLock is required")
+	check(cfg.Command.GetLog != "", "command.getLog is required")
+	check(cfg.Command.AddCard != "", "command.addCard is required")
+	check(strings.Count(cfg.Command.DelCard, "%s") == 1, "command.delCard must contain one %%s for the card number")
+
+	if cfg.Admin.Token != "" {
+		check(!isPlaceholder(cfg.Admin.Token), "admin.token must not be a placeholder")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// 是否开启了某种登录方式
+func (cfg *Config) LoginEnabled(provider string) bool {
+	for _, name := range cfg.Login.Providers {
+		if name == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否是没有修改过的占位符，比如 xxx
+func isPlaceholder(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || strings.Trim(value, "x") == "" {
+		return true
+	}
+	switch value {
+	case "changeme", "change-me", "secret", "todo":
+		return true
+	}
+	return false
+}
+
+// 返回隐藏了密钥的配置副本，用于展示
+func (cfg *Config) Masked() *Config {
+	masked := *cfg
+	maskSecrets(reflect.ValueOf(&masked).Elem())
+	return &masked
+}
+
+func maskSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			maskSecrets(field)
+			continue
+		}
+		if _, ok := t.Field(i).Tag.Lookup("secret"); ok && field.String() != "" {
+			field.SetString(mask)
+		}
+	}
+}
@@ -1,122 +1,165 @@
 package controller
 
 import (
-	"ezlock/common/mongo"
+	"errors"
+	"ezlock/common/logger"
 	"ezlock/config"
-	"ezlock/middleware"
-	"ezlock/model"
+	"ezlock/identity"
+	"ezlock/store"
 	"ezlock/utils"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
 	"github.com/medivhzhan/weapp"
-	"time"
 )
 
-// 登录逻辑
-func Login(c *gin.Context) {
-	// code 用户登录凭证,必传
-	params := &struct {
-		Code          string `form:"code" binding:"required"`
-		Iv            string `form:"iv" json:"iv" binding:"required"`
-		EncryptedData string `form:"encryptedData" json:"encryptedData" binding:"required"`
-		RawData       string `form:"rawData" json:"rawData" binding:"required"`
-		Signature     string `form:"signature" json:"signature" binding:"required"`
-	}{}
+// 微信小程序登录，保持原来的接口
+func (ctl *Controller) Login(c *gin.Context) {
+	ctl.login(identity.Wechat, c)
+}
+
+// 使用路径里指定的登录方式登录，wechat、sms 或者 password
+func (ctl *Controller) ProviderLogin(c *gin.Context) {
+	ctl.login(c.Param("provider"), c)
+}
+
+// 登录逻辑，所有登录方式都返回同样的 token
+func (ctl *Controller) login(name string, c *gin.Context) {
+	ctx := c.Request.Context()
+	provider, ok := ctl.providers[name]
+	if !ok || !config.Get().LoginEnabled(name) {
+		utils.ResponseError(utils.NOT_EXISTS, fmt.Sprintf("不支持的登录方式 [%s]", name), c)
+		return
+	}
 
+	params := provider.NewCredential()
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
 
-	res, err := weapp.Login(config.AppID, config.Secret, params.Code)
+	user, registered, err := provider.Authenticate(ctx, params)
 	if err != nil {
-		utils.ResponseError(utils.WEAPP_ERR, err.Error(), c)
+		responseIdentityError(err, c)
+		return
+	}
+	if user.Disabled {
+		utils.ResponseError(utils.UNAUTH, "账号已被禁用", c)
 		return
 	}
-	//
-	// 获取到用户到openid
-	openId := res.OpenID
-	sessionKey := res.SessionKey
+	// 短信登录验证了手机号，领取分享给这个手机号的授权
+	if name == identity.Sms {
+		ctl.claimPhoneAuths(ctx, user.Id, user.PhoneNumber)
+	}
 
-	// 获取用户信息
-	userInfo, err := weapp.DecryptUserInfo(params.RawData, params.EncryptedData, params.Signature, params.Iv, sessionKey)
+	// 每次登录新建一个会话，用户id和会话id放入jwt
+	device := params.DeviceInfo()
+	if device == "" {
+		device = c.Request.UserAgent()
+	}
+	tokens, err := ctl.createSession(ctx, user, device, c.ClientIP())
 	if err != nil {
-		utils.ResponseError(utils.WEAPP_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
+	outcome := "login"
+	if registered {
+		outcome = "register"
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": user.Id.Hex(), "provider": name, "outcome": outcome}).Info("user logged in")
+	utils.ResponseOk(tokens, c)
+}
+
+// 发送短信登录验证码
+func (ctl *Controller) SendSmsCode(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		Phone string `form:"phone" json:"phone" binding:"required"`
+	}{}
+
+	if !config.Get().LoginEnabled(identity.Sms) {
+		utils.ResponseError(utils.NOT_EXISTS, "没有开启短信登录", c)
+		return
+	}
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	if err := ctl.smsCodes.SendCode(ctx, params.Phone); err != nil {
+		responseIdentityError(err, c)
+		return
+	}
+	utils.ResponseOk("ok", c)
+}
+
+// 把登录方式返回的错误转换成响应
+func responseIdentityError(err error, c *gin.Context) {
+	switch {
+	case errors.Is(err, identity.ErrInvalidPhone):
+		utils.ResponseError(utils.PARAM_ERR, "手机号不合法", c)
+	case errors.Is(err, identity.ErrInvalidCredential):
+		utils.ResponseError(utils.UNAUTH, "账号、密码或者验证码错误", c)
+	case errors.Is(err, identity.ErrTooFrequent):
+		utils.ResponseError(utils.TOO_FREQUENT, "验证码发送太频繁，请稍后再试", c)
+	case errors.Is(err, identity.ErrLocked):
+		utils.ResponseError(utils.TOO_FREQUENT, "密码错误次数太多，请稍后再试", c)
+	case errors.Is(err, identity.ErrWeapp):
+		utils.ResponseError(utils.WEAPP_ERR, err.Error(), c)
+	case errors.Is(err, identity.ErrSmsSend):
+		utils.ResponseError(utils.SMS_ERR, err.Error(), c)
+	default:
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+	}
+}
 
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
+// 设置密码登录的用户名和密码，第一次设置和修改都需要先二次验证
+// 忘记密码的时候可以用短信或者微信重新登录验证后重新设置
+func (ctl *Controller) SetPassword(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		UserName string `form:"userName" json:"userName" binding:"required"`
+		Password string `form:"password" json:"password" binding:"required"`
+	}{}
 
-	q := bson.M{
-		"openId": openId,
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !identity.ValidUserName(params.UserName) {
+		utils.ResponseError(utils.PARAM_ERR, "用户名只能包含字母、数字和 _.@-，长度 3 到 64 位", c)
+		return
+	}
+	if !identity.ValidPassword(params.Password) {
+		utils.ResponseError(utils.PARAM_ERR, "密码长度必须是 8 到 72 位", c)
+		return
 	}
-	user := model.User{}
 
-	err = userColl.Find(q).One(&user)
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-			return
-		}
-		user = model.User{
-			Id:         bson.NewObjectId(),
-			OpenId:     openId,
-			SessionKey: sessionKey,
-			NickName:   userInfo.Nickname,
-			UnionId:    userInfo.UnionID,
-			Gender:     userInfo.Gender,
-			Province:   userInfo.Province,
-			City:       userInfo.Province,
-			Country:    userInfo.Country,
-			AvatarUrl:  userInfo.Avatar,
-			Language:   user.Language,
-			UpdateTime: time.Now().Local(),
-			CreateTime: time.Now().Local(),
-		}
-		if err := userColl.Insert(&user); err != nil {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-			return
-		}
-	}
-
-	// 更新sessionKey
-	updateVal := bson.M{
-		"sessionKey": sessionKey,
-		"nickName":   userInfo.Nickname,
-		"unionId":    userInfo.UnionID,
-		"gender":     userInfo.Gender,
-		"province":   userInfo.Province,
-		"city":       userInfo.City,
-		"country":    userInfo.Country,
-		"avatarUrl":  userInfo.Avatar,
-		"language":   userInfo.Language,
-		"updateTime": time.Now().Local(),
-	}
-
-	if err := userColl.Update(q, bson.M{
-		"$set": updateVal,
-	}); err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-		return
-	}
-
-	// 如果查询到了就获取用户id，放入jwt
-	token, _, err := middleware.CreateToken(fmt.Sprintf("%s", user.Id.Hex()))
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	hash, err := identity.HashPassword(params.Password)
 	if err != nil {
-		utils.ResponseError(utils.UNAUTH, err.Error(), c)
+		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
 		return
 	}
-	utils.ResponseOk(token, c)
+	err = ctl.store.Users.SetPassword(ctx, user.Id, params.UserName, hash)
+	if err == store.ErrDuplicate {
+		utils.ResponseError(utils.INVALID, "用户名已被他人使用", c)
+		return
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "outcome": "updated"}).Info("password updated")
+	utils.ResponseOk("ok", c)
 }
 
 // 获取手机号
-func GetPhone(c *gin.Context) {
+func (ctl *Controller) GetPhone(c *gin.Context) {
 	userId := c.GetString("id")
-	fmt.Println(userId)
+	ctx := c.Request.Context()
 
 	params := &struct {
 		Iv            string `form:"iv" json:"iv" binding:"required"`
@@ -127,15 +170,9 @@ func GetPhone(c *gin.Context) {
 		return
 	}
 
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
-	user := model.User{}
-
-	err := userColl.FindId(userId).One(&user)
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
@@ -145,44 +182,48 @@ func GetPhone(c *gin.Context) {
 		return
 	}
 
-	q := bson.M{"_id": bson.ObjectIdHex(userId)}
 	// 更新手机号字段
-	if err := userColl.Update(q, bson.M{
-		"$set": bson.M{"phone": phone, "updateTime": time.Now().Local()},
-	}); err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	if err := ctl.store.Users.SetPhoneNumber(ctx, user.Id, phone.PurePhoneNumber); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "outcome": "updated"}).Info("phone number updated")
+	ctl.claimPhoneAuths(ctx, user.Id, phone.PurePhoneNumber)
 
 	utils.ResponseOk(phone, c)
 }
 
 // 获取用户信息
-func GetUserInfo(c *gin.Context) {
+func (ctl *Controller) GetUserInfo(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
-
-	resp := &struct {
-		NickName    string `json:"nickName" bson:"nickName"`
-		PhoneNumber string `json:"phoneNumber" bson:"phoneNumber"`
-		Gender      int    `json:"gender" bson:"gender"`
-		Province    string `json:"province" bson:"province"`
-		City        string `json:"city" bson:"city"`
-		Country     string `json:"country" bson:"country"`
-		AvatarUrl   string `json:"avatarUrl" bson:"avatarUrl"`
-		Language    string `json:"language" bson:"language"`
-	}{}
-
-	err := userColl.FindId(userId).One(resp)
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
+	resp := &struct {
+		NickName    string `json:"nickName"`
+		PhoneNumber string `json:"phoneNumber"`
+		Gender      int    `json:"gender"`
+		Province    string `json:"province"`
+		City        string `json:"city"`
+		Country     string `json:"country"`
+		AvatarUrl   string `json:"avatarUrl"`
+		Language    string `json:"language"`
+	}{
+		NickName:    user.NickName,
+		PhoneNumber: user.PhoneNumber,
+		Gender:      user.Gender,
+		Province:    user.Province,
+		City:        user.City,
+		Country:     user.Country,
+		AvatarUrl:   user.AvatarUrl,
+		Language:    user.Language,
+	}
+
 	// 响应给前端
 	utils.ResponseOk(resp, c)
 }
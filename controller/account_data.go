@@ -0,0 +1,404 @@
+package controller
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"ezlock/common/logger"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+// 导出用户的个人数据，返回 zip 压缩包，每类数据一个 json 文件，包括用户在所有组织里的数据
+// 门锁不包括加密密钥，授权不包括一次性 token，日志只包括用户自己的开锁记录
+func (ctl *Controller) ExportAccountData(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	id := utils.ObjectIdHex(userId)
+
+	user, err := ctl.store.Users.Get(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	locks, err := ctl.store.Locks.FindByOwner(ctx, id, false)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	sent, err := ctl.store.Auths.FindBySender(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	received, err := ctl.store.Auths.FindByReceiver(ctx, id, false, model.Perms{})
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	cards, err := ctl.store.Cards.FindByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logs, err := ctl.store.Logs.FindByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	sessions, err := ctl.store.Sessions.FindActiveByUser(ctx, id, time.Now().Local())
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	apiKeys, err := ctl.store.ApiKeys.FindByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	transfers, err := ctl.store.Transfers.FindByUser(ctx, id, user.PhoneNumber)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	memberships, err := ctl.store.Members.FindByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	spaces, err := ctl.store.Spaces.FindByOwner(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	orgMemberships, err := ctl.store.OrgMembers.FindByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	lockSummaries := make([]LockSummary, 0, len(locks))
+	for _, lock := range locks {
+		lockSummaries = append(lockSummaries, LockSummary{Lock: lock})
+	}
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", UserSummary{User: *user}},
+		{"locks.json", lockSummaries},
+		{"auths_sent.json", authSummaries(sent)},
+		{"auths_received.json", authSummaries(received)},
+		{"cards.json", cards},
+		{"logs.json", logs},
+		{"sessions.json", sessions},
+		{"api_keys.json", apiKeys},
+		{"transfers.json", transfers},
+		{"memberships.json", memberships},
+		{"spaces.json", spaces},
+		{"org_memberships.json", orgMemberships},
+	}
+
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			utils.ResponseError(utils.EXPORT_ERR, err.Error(), c)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			utils.ResponseError(utils.EXPORT_ERR, err.Error(), c)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		utils.ResponseError(utils.EXPORT_ERR, err.Error(), c)
+		return
+	}
+
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "size": buf.Len(), "outcome": "exported"}).Info("account data exported")
+	filename := fmt.Sprintf("ezlock-%s-%s.zip", userId, time.Now().Local().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+func authSummaries(auths []model.Auth) []AuthSummary {
+	summaries := make([]AuthSummary, 0, len(auths))
+	for _, auth := range auths {
+		summaries = append(summaries, AuthSummary{Auth: auth})
+	}
+	return summaries
+}
+
+// 注销结果，各类数据处理的数量
+type DeleteResult struct {
+	LocksTransferred   int `json:"locksTransferred"`
+	LocksRetired       int `json:"locksRetired"`
+	AuthsRevoked       int `json:"authsRevoked"`
+	CardsInvalidated   int `json:"cardsInvalidated"`
+	LogsAnonymized     int `json:"logsAnonymized"`
+	MembershipsRemoved int `json:"membershipsRemoved"`
+	SpacesRemoved      int `json:"spacesRemoved"`
+	OrgsLeft           int `json:"orgsLeft"`
+}
+
+// 注销账号，transferTo 为空的时候拥有的门锁全部删除，否则转给这个手机号的用户并留下转让记录
+// 两种情况门锁上的授权、门卡和成员都会撤销，转让的门卡删除指令由接收者通过转让记录获取
+// 撤销发出和收到的授权，删除添加的门卡，清除日志里的开锁用户，最后清除个人资料并退出所有设备
+// 用户在所有组织里的数据都一起处理，并退出所有组织，组织里绑定的门锁留在组织里由管理员继续管理
+// 创建的组织还有其他成员或者门锁的时候不能注销，需要先把组织移交给其他成员
+// 每一步都可以重复执行，中途失败的时候可以重新注销
+func (ctl *Controller) DeleteAccount(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		TransferTo string `form:"transferTo" json:"transferTo"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	id := utils.ObjectIdHex(userId)
+
+	var receiver *model.User
+	if params.TransferTo != "" {
+		user, err := ctl.store.Users.GetByPhone(ctx, params.TransferTo)
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.NOT_EXISTS, "接收门锁的用户不存在", c)
+			return
+		}
+		if err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		if user.Id == id || user.Disabled {
+			utils.ResponseError(utils.PARAM_ERR, "不能转给这个用户", c)
+			return
+		}
+		receiver = user
+	}
+
+	if code, msg := ctl.checkOwnedOrgs(ctx, id); code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	result := DeleteResult{}
+	locks, err := ctl.store.Locks.FindByOwner(ctx, id, true)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	for _, lock := range locks {
+		// 请求不按租户隔离，查出来的门锁包括组织里的，组织里的门锁不转让也不删除，只移出要删除的空间
+		if !lock.OrgId.IsZero() {
+			if lock.SpaceId.IsZero() {
+				continue
+			}
+			if err := ctl.store.Locks.SetSpace(ctx, lock.Id, id, primitive.NilObjectID); err != nil {
+				utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+				return
+			}
+			continue
+		}
+		// 门锁换了拥有者或者删除了，先移除原来的成员，管理员不能再给这把锁发授权
+		removed, err := ctl.store.Members.DeleteByLock(ctx, lock.Id)
+		if err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		result.MembershipsRemoved += removed
+		// 门锁上所有的授权和门卡都和接受转让的时候一样撤销
+		// 转让的时候先换拥有者再撤销，和接受转让一致；删除的时候先撤销，失败后重试还能查到这把锁
+		if receiver != nil {
+			authsRevoked, wipedCards, err := ctl.handOverLock(ctx, &lock, receiver)
+			if err != nil {
+				utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+				return
+			}
+			result.LocksTransferred++
+			result.AuthsRevoked += authsRevoked
+			result.CardsInvalidated += len(wipedCards)
+			continue
+		}
+		authsRevoked, wipedCards, err := ctl.revokeLockAccess(ctx, lock.Id)
+		if err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		if err := ctl.store.Locks.Invalidate(ctx, lock.Mac, id); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		result.LocksRetired++
+		result.AuthsRevoked += authsRevoked
+		result.CardsInvalidated += len(wipedCards)
+	}
+
+	sent, err := ctl.store.Auths.FindBySender(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	received, err := ctl.store.Auths.FindByReceiver(ctx, id, true, model.Perms{})
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if !ctl.invalidateAuths(c, append(sent, received...), &result) {
+		return
+	}
+	removed, err := ctl.store.Members.DeleteByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	result.MembershipsRemoved += removed
+	result.OrgsLeft, err = ctl.store.OrgMembers.DeleteByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 转出去的门锁已经移出空间，删除的门锁不再使用，空间可以直接删除
+	result.SpacesRemoved, err = ctl.store.Spaces.DeleteByOwner(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	// 只是数据库里的门卡失效，门锁里的卡号需要门锁的管理者重新同步删除
+	cards, err := ctl.store.Cards.FindByUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	for _, card := range cards {
+		if !card.Valid {
+			continue
+		}
+		if err := ctl.store.Cards.InvalidateByNumber(ctx, card.Lock, card.Number); err != nil && err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		result.CardsInvalidated++
+	}
+
+	result.LogsAnonymized, err = ctl.store.Logs.AnonymizeUser(ctx, id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if _, err := ctl.store.ApiKeys.RevokeByUser(ctx, id); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if err := ctl.store.Users.Anonymize(ctx, id); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 最后退出所有设备，前面失败的时候还可以用当前的登录重试
+	if _, err := ctl.store.Sessions.RevokeByUser(ctx, id); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "locksTransferred": result.LocksTransferred, "locksRetired": result.LocksRetired,
+		"authsRevoked": result.AuthsRevoked, "cardsInvalidated": result.CardsInvalidated,
+		"logsAnonymized": result.LogsAnonymized, "membershipsRemoved": result.MembershipsRemoved, "spacesRemoved": result.SpacesRemoved, "orgsLeft": result.OrgsLeft,
+		"outcome": "deleted",
+	}).Info("account deleted")
+	utils.ResponseOk(result, c)
+}
+
+// 注销时把门锁直接转给接收者，不需要接收者接受，记录一条已经接受的转让
+// 和接受转让一样先换拥有者再按门锁撤销授权和门卡，返回撤销的授权数量和门卡号
+// 接收者可以在转让记录里看到门锁，并通过 WipeTransferCards 获取删除门卡的指令
+// 撤销或者转让记录写入失败的时候把门锁还给原拥有者
+func (ctl *Controller) handOverLock(ctx context.Context, lock *model.Lock, receiver *model.User) (int, []string, error) {
+	if err := ctl.store.Locks.SetOwner(ctx, lock.Id, lock.Own, receiver.Id); err != nil {
+		return 0, nil, err
+	}
+	restore := func() {
+		if err := ctl.store.Locks.SetOwner(ctx, lock.Id, receiver.Id, lock.Own); err != nil {
+			logger.Ctx(ctx).WithFields(logger.Fields{"mac": lock.Mac}).Errorf("restore lock owner failed: %s", err.Error())
+		}
+	}
+	authsRevoked, wipedCards, err := ctl.revokeLockAccess(ctx, lock.Id)
+	if err != nil {
+		restore()
+		return 0, nil, err
+	}
+	now := time.Now().Local()
+	transfer := &model.Transfer{
+		Id:           primitive.NewObjectID(),
+		LockId:       lock.Id,
+		FromId:       lock.Own,
+		ToPhone:      receiver.PhoneNumber,
+		ToId:         receiver.Id,
+		Status:       model.TransferAccepted,
+		AuthsRevoked: authsRevoked,
+		WipedCards:   wipedCards,
+		ExpireTime:   now,
+		UpdateTime:   now,
+		CreateTime:   now,
+	}
+	if err := ctl.store.Transfers.Insert(ctx, transfer); err != nil {
+		restore()
+		return 0, nil, err
+	}
+	return authsRevoked, wipedCards, nil
+}
+
+// 检查用户创建的组织，还有其他成员或者门锁的时候返回错误，注销后组织会没有创建者
+func (ctl *Controller) checkOwnedOrgs(ctx context.Context, userId primitive.ObjectID) (int, string) {
+	joined, err := ctl.store.OrgMembers.FindByUser(ctx, userId)
+	if err != nil {
+		return utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	for _, member := range joined {
+		if member.Role != model.OrgRoleOwner {
+			continue
+		}
+		members, err := ctl.store.OrgMembers.FindByOrg(ctx, member.OrgId)
+		if err != nil {
+			return utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+		}
+		if len(members) > 1 {
+			return utils.INVALID, "您是组织的创建者，请先把组织移交给其他成员"
+		}
+		locks, err := ctl.store.Locks.FindByOrg(ctx, member.OrgId, true)
+		if err != nil {
+			return utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+		}
+		if len(locks) > 0 {
+			return utils.INVALID, "您创建的组织里还有门锁，请先删除门锁"
+		}
+	}
+	return utils.OK, ""
+}
+
+// 设置授权失效，已经失效的跳过，失败的时候返回错误并返回 false
+func (ctl *Controller) invalidateAuths(c *gin.Context, auths []model.Auth, result *DeleteResult) bool {
+	for _, auth := range auths {
+		if !auth.Valid {
+			continue
+		}
+		if err := ctl.store.Auths.Invalidate(c.Request.Context(), auth.Id); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return false
+		}
+		result.AuthsRevoked++
+	}
+	return true
+}
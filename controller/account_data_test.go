@@ -0,0 +1,185 @@
+package controller_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 下载导出的数据，按文件名返回压缩包里每个文件的内容
+func (c *client) export() map[string][]byte {
+	t := c.srv.t
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/account/export", nil)
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	c.srv.engine.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("expect a zip file, got %s: %s", ct, w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "ezlock-"+c.userId) {
+		t.Fatalf("unexpected Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = data
+	}
+	return files
+}
+
+// 解析导出的 json 文件
+func decodeFile(t *testing.T, files map[string][]byte, name string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(files[name], v); err != nil {
+		t.Fatalf("decode %s %q: %s", name, string(files[name]), err.Error())
+	}
+}
+
+func TestExportAccountData(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	lock := owner.addLock("AA:00:00:00:17:01")
+
+	var token string
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1"}).ok(t, &token)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.OK)
+	owner.do(http.MethodPost, "/api/v1/lock/card", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, "12345678")}).expect(t, utils.OK)
+	content := fmt.Sprintf("open_2024-05-01 10:00_1_%s_1", friend.userId)
+	friend.do(http.MethodPost, "/api/v1/lock/log", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, content)}).expect(t, utils.OK)
+
+	files := owner.stepUp(model.StepUpDataExport).export()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expect := "api_keys.json,auths_received.json,auths_sent.json,cards.json,locks.json,logs.json,memberships.json,org_memberships.json,profile.json,sessions.json,spaces.json,transfers.json"
+	if strings.Join(names, ",") != expect {
+		t.Fatalf("unexpected files %v", names)
+	}
+	// 门锁的密钥和授权的 token 不导出
+	for name, data := range files {
+		if bytes.Contains(data, []byte(lock.Key)) || bytes.Contains(data, []byte(`"token"`)) {
+			t.Fatalf("%s contains secrets: %s", name, string(data))
+		}
+	}
+
+	var profile model.User
+	decodeFile(t, files, "profile.json", &profile)
+	if profile.Id.Hex() != owner.userId || profile.PhoneNumber != owner.phone {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+	var locks []model.Lock
+	decodeFile(t, files, "locks.json", &locks)
+	if len(locks) != 1 || locks[0].Mac != lock.Mac {
+		t.Fatalf("unexpected locks %+v", locks)
+	}
+	var sent, received []model.Auth
+	decodeFile(t, files, "auths_sent.json", &sent)
+	decodeFile(t, files, "auths_received.json", &received)
+	if len(sent) != 1 || sent[0].ReceiverId.Hex() != friend.userId || len(received) != 0 {
+		t.Fatalf("unexpected auths %+v, %+v", sent, received)
+	}
+	var cards []model.Card
+	decodeFile(t, files, "cards.json", &cards)
+	if len(cards) != 1 || cards[0].Number != "12345678" {
+		t.Fatalf("unexpected cards %+v", cards)
+	}
+	var sessions []model.Session
+	decodeFile(t, files, "sessions.json", &sessions)
+	if len(sessions) != 1 {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+
+	// 日志只导出用户自己的开锁记录
+	var logs []model.Log
+	decodeFile(t, files, "logs.json", &logs)
+	if len(logs) != 0 {
+		t.Fatalf("owner should have no logs, got %+v", logs)
+	}
+	files = friend.stepUp(model.StepUpDataExport).export()
+	decodeFile(t, files, "logs.json", &logs)
+	decodeFile(t, files, "auths_received.json", &received)
+	if len(logs) != 1 || logs[0].UserId.Hex() != friend.userId || len(received) != 1 {
+		t.Fatalf("unexpected friend export %+v, %+v", logs, received)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	lock := owner.addLock("AA:00:00:00:17:02")
+
+	var token string
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1"}).ok(t, &token)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.OK)
+	content := fmt.Sprintf("open_2024-05-01 10:00_1_%s_1", friend.userId)
+	friend.do(http.MethodPost, "/api/v1/lock/log", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, content)}).expect(t, utils.OK)
+
+	var result controller.DeleteResult
+	friend.stepUp(model.StepUpAccountDelete).do(http.MethodPost, "/account/delete", nil).ok(t, &result)
+	if result.AuthsRevoked != 1 || result.LogsAnonymized != 1 || result.LocksRetired != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	friend.do(http.MethodGet, "/account/sessions", nil).expect(t, utils.UNAUTH)
+	user, err := srv.store.Users.Get(ctx, utils.ObjectIdHex(friend.userId))
+	if err != nil || user.PhoneNumber != "" {
+		t.Fatalf("user not anonymized: %+v, %v", user, err)
+	}
+	logs := []controller.LogDetail{}
+	owner.do(http.MethodGet, "/api/v1/lock/log", gin.H{"mac": lock.Mac}).ok(t, &logs)
+	if len(logs) != 1 || !logs[0].UserId.IsZero() {
+		t.Fatalf("log not anonymized: %+v", logs)
+	}
+
+	// 门锁转给另一个用户，不能转给自己或者不存在的手机号
+	heir := srv.login("13800000003")
+	deleting := owner.stepUp(model.StepUpAccountDelete)
+	deleting.do(http.MethodPost, "/account/delete", gin.H{"transferTo": owner.phone}).expect(t, utils.PARAM_ERR)
+	deleting.do(http.MethodPost, "/account/delete", gin.H{"transferTo": "13800000009"}).expect(t, utils.NOT_EXISTS)
+	deleting.do(http.MethodPost, "/account/delete", gin.H{"transferTo": heir.phone}).ok(t, &result)
+	if result.LocksTransferred != 1 || result.LocksRetired != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	heir.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.OK)
+
+	// 没有接收人的时候门锁删除
+	var retired controller.DeleteResult
+	heir.stepUp(model.StepUpAccountDelete).do(http.MethodPost, "/account/delete", nil).ok(t, &retired)
+	if retired.LocksRetired != 1 {
+		t.Fatalf("unexpected result %+v", retired)
+	}
+	if got, err := srv.store.Locks.Get(ctx, lock.Id); err != nil || got.Valid {
+		t.Fatalf("lock not retired: %+v, %v", got, err)
+	}
+}
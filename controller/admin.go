@@ -0,0 +1,357 @@
+package controller
+
+import (
+	"context"
+	"ezlock/common/metrics"
+	"ezlock/config"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 查看当前生效的配置，密钥会被隐藏
+func GetConfig(c *gin.Context) {
+	utils.ResponseOk(config.Get().Masked(), c)
+}
+
+// 管理接口返回的用户信息，不包括微信的 session key
+// 同名字段会覆盖 model 里的字段，始终为空所以不会输出
+type UserSummary struct {
+	model.User
+	SessionKey string `json:"sessionKey,omitempty"`
+}
+
+// 管理接口返回的门锁信息，不包括加密密钥
+type LockSummary struct {
+	model.Lock
+	Key string `json:"key,omitempty"`
+}
+
+// 管理接口返回的授权信息，不包括一次性授权的 token
+type AuthSummary struct {
+	model.Auth
+	Token string `json:"token,omitempty"`
+}
+
+// 按照用户id或者手机号查找用户，同一个手机号可能对应多个用户
+func (ctl *Controller) AdminSearchUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		UserId string `form:"userId"`
+		Phone  string `form:"phone"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	var users []model.User
+	target := params.Phone
+	switch {
+	case params.UserId != "":
+		if !primitive.IsValidObjectID(params.UserId) {
+			utils.ResponseError(utils.PARAM_ERR, "用户id不合法", c)
+			return
+		}
+		target = params.UserId
+		user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(params.UserId))
+		if err != nil && err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		if user != nil {
+			users = append(users, *user)
+		}
+	case params.Phone != "":
+		found, err := ctl.store.Users.FindByPhone(ctx, params.Phone)
+		if err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		users = found
+	default:
+		utils.ResponseError(utils.PARAM_ERR, "userId 和 phone 至少需要一个", c)
+		return
+	}
+
+	if !ctl.audit(c, "search_user", "user", target, "") {
+		return
+	}
+	summaries := make([]UserSummary, 0, len(users))
+	for _, user := range users {
+		summaries = append(summaries, UserSummary{User: user})
+	}
+	utils.ResponseOk(summaries, c)
+}
+
+// 按照 mac 或者拥有者的手机号查找门锁，包括已经删除的
+func (ctl *Controller) AdminSearchLock(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac   string `form:"mac"`
+		Phone string `form:"phone"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	var locks []model.Lock
+	target := params.Phone
+	switch {
+	case params.Mac != "":
+		target = params.Mac
+		lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+		if err != nil && err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		if lock != nil {
+			locks = append(locks, *lock)
+		}
+	case params.Phone != "":
+		users, err := ctl.store.Users.FindByPhone(ctx, params.Phone)
+		if err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		for _, user := range users {
+			owned, err := ctl.store.Locks.FindByOwner(ctx, user.Id, false)
+			if err != nil {
+				utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+				return
+			}
+			locks = append(locks, owned...)
+		}
+	default:
+		utils.ResponseError(utils.PARAM_ERR, "mac 和 phone 至少需要一个", c)
+		return
+	}
+
+	if !ctl.audit(c, "search_lock", "lock", target, "") {
+		return
+	}
+	summaries := make([]LockSummary, 0, len(locks))
+	for _, lock := range locks {
+		summaries = append(summaries, LockSummary{Lock: lock})
+	}
+	utils.ResponseOk(summaries, c)
+}
+
+// 查看任意一把锁上的所有授权，包括已经失效的
+func (ctl *Controller) AdminGetLockAuthList(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac string `form:"mac" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	auths, err := ctl.store.Auths.FindByLock(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	if !ctl.audit(c, "view_lock_auths", "lock", params.Mac, "") {
+		return
+	}
+	summaries := make([]AuthSummary, 0, len(auths))
+	for _, auth := range auths {
+		summaries = append(summaries, AuthSummary{Auth: auth})
+	}
+	utils.ResponseOk(summaries, c)
+}
+
+// 查看任意一把锁的开锁日志
+func (ctl *Controller) AdminGetLockLog(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac string `form:"mac" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logs, err := ctl.store.Logs.FindByLock(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	if !ctl.audit(c, "view_lock_logs", "lock", params.Mac, "") {
+		return
+	}
+	utils.ResponseOk(logs, c)
+}
+
+// 强制撤销任意授权，不要求是自己发出的
+func (ctl *Controller) AdminRevokeAuth(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		AuthId string `form:"authId" json:"authId" binding:"required"`
+		Reason string `form:"reason" json:"reason" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.AuthId) {
+		utils.ResponseError(utils.PARAM_ERR, "授权id不合法", c)
+		return
+	}
+
+	audit, ok := ctl.auditBegin(c, "revoke_auth", "auth", params.AuthId, params.Reason)
+	if !ok {
+		return
+	}
+	err := ctl.store.Auths.Invalidate(ctx, utils.ObjectIdHex(params.AuthId))
+	ctl.auditDone(c, audit, err)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	metrics.AuthRevoked()
+	utils.ResponseOk("ok", c)
+}
+
+// 禁用或者恢复用户，禁用的同时撤销用户所有的会话和接口 key，恢复后需要重新创建
+func (ctl *Controller) AdminDisableUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		UserId   string `form:"userId" json:"userId" binding:"required"`
+		Disabled bool   `form:"disabled" json:"disabled"`
+		Reason   string `form:"reason" json:"reason" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.UserId) {
+		utils.ResponseError(utils.PARAM_ERR, "用户id不合法", c)
+		return
+	}
+	if params.UserId == c.GetString("id") {
+		utils.ResponseError(utils.INVALID, "不能禁用自己", c)
+		return
+	}
+
+	action := "enable_user"
+	if params.Disabled {
+		action = "disable_user"
+	}
+	audit, ok := ctl.auditBegin(c, action, "user", params.UserId, params.Reason)
+	if !ok {
+		return
+	}
+	err := ctl.disableUser(ctx, utils.ObjectIdHex(params.UserId), params.Disabled)
+	ctl.auditDone(c, audit, err)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk("ok", c)
+}
+
+// 修改用户的禁用状态，禁用的时候撤销所有的会话和接口 key
+func (ctl *Controller) disableUser(ctx context.Context, userId primitive.ObjectID, disabled bool) error {
+	if err := ctl.store.Users.SetDisabled(ctx, userId, disabled); err != nil {
+		return err
+	}
+	if !disabled {
+		return nil
+	}
+	if _, err := ctl.store.Sessions.RevokeByUser(ctx, userId); err != nil {
+		return err
+	}
+	_, err := ctl.store.ApiKeys.RevokeByUser(ctx, userId)
+	return err
+}
+
+// 修改用户角色，token 里带着角色，所以同时撤销用户所有的会话
+func (ctl *Controller) AdminSetUserRole(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		UserId string `form:"userId" json:"userId" binding:"required"`
+		Role   string `form:"role" json:"role" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.UserId) {
+		utils.ResponseError(utils.PARAM_ERR, "用户id不合法", c)
+		return
+	}
+	if !model.ValidRole(params.Role) {
+		utils.ResponseError(utils.PARAM_ERR, "角色不合法", c)
+		return
+	}
+	// 避免管理员把自己降级后没有管理员可用
+	if params.UserId == c.GetString("id") {
+		utils.ResponseError(utils.INVALID, "不能修改自己的角色", c)
+		return
+	}
+
+	audit, ok := ctl.auditBegin(c, "set_role", "user", params.UserId, params.Role)
+	if !ok {
+		return
+	}
+	userId := utils.ObjectIdHex(params.UserId)
+	err := ctl.store.Users.SetRole(ctx, userId, params.Role)
+	if err == nil {
+		_, err = ctl.store.Sessions.RevokeByUser(ctx, userId)
+	}
+	ctl.auditDone(c, audit, err)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk("ok", c)
+}
+
+// 查看审计记录，按照时间倒序，actorId 为空的时候返回所有管理员的操作
+func (ctl *Controller) AdminGetAuditList(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		ActorId string `form:"actorId"`
+		Limit   int    `form:"limit" binding:"omitempty,min=1,max=500"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if params.ActorId != "" && !primitive.IsValidObjectID(params.ActorId) {
+		utils.ResponseError(utils.PARAM_ERR, "管理员id不合法", c)
+		return
+	}
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	audits, err := ctl.store.Audits.Find(ctx, utils.ObjectIdHex(params.ActorId), params.Limit)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	if !ctl.audit(c, "view_audits", "audit", params.ActorId, "") {
+		return
+	}
+	utils.ResponseOk(audits, c)
+}
@@ -0,0 +1,80 @@
+package controller_test
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+// 把用户设置成 role 并重新登录，角色写在 token 里，重新登录以后才生效
+func (s *testServer) loginAs(phone, role string) *client {
+	s.t.Helper()
+	c := s.login(phone)
+	if err := s.store.Users.SetRole(context.Background(), utils.ObjectIdHex(c.userId), role); err != nil {
+		s.t.Fatal(err)
+	}
+	return s.login(phone)
+}
+
+func TestRoleClaim(t *testing.T) {
+	srv := newServer(t)
+	user := srv.login("13800000001")
+	user.do(http.MethodGet, "/admin/v1/user", gin.H{"phone": user.phone}).expect(t, utils.UNAUTH)
+
+	support := srv.loginAs("13800000002", model.RoleSupport)
+	support.do(http.MethodGet, "/admin/v1/user", gin.H{"phone": user.phone}).expect(t, utils.OK)
+	// 客服不能禁用用户和修改角色
+	support.do(http.MethodPost, "/admin/v1/user/disable", gin.H{"userId": user.userId, "disabled": true, "reason": "test"}).expect(t, utils.UNAUTH)
+	support.do(http.MethodPost, "/admin/v1/user/role", gin.H{"userId": user.userId, "role": model.RoleAdmin}).expect(t, utils.UNAUTH)
+
+	// 修改角色会撤销用户的会话，客服需要重新登录
+	admin := srv.loginAs("13800000003", model.RoleAdmin)
+	admin.do(http.MethodPost, "/admin/v1/user/role", gin.H{"userId": support.userId, "role": model.RoleUser}).expect(t, utils.OK)
+	support.do(http.MethodGet, "/admin/v1/user", gin.H{"phone": user.phone}).expect(t, utils.UNAUTH)
+	support = srv.login(support.phone)
+	support.do(http.MethodGet, "/admin/v1/user", gin.H{"phone": user.phone}).expect(t, utils.UNAUTH)
+
+	admin.do(http.MethodPost, "/admin/v1/user/role", gin.H{"userId": admin.userId, "role": model.RoleUser}).expect(t, utils.INVALID)
+}
+
+func TestDisabledUser(t *testing.T) {
+	srv := newServer(t)
+	admin := srv.loginAs("13800000001", model.RoleAdmin)
+	user := srv.login("13800000002")
+
+	admin.do(http.MethodPost, "/admin/v1/user/disable", gin.H{"userId": admin.userId, "disabled": true, "reason": "test"}).expect(t, utils.INVALID)
+	admin.do(http.MethodPost, "/admin/v1/user/disable", gin.H{"userId": user.userId, "disabled": true, "reason": "test"}).expect(t, utils.OK)
+	user.do(http.MethodGet, "/account/sessions", nil).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/token/refresh", nil, gin.H{"refreshToken": user.tokens.RefreshToken}).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/sms/send_code", nil, gin.H{"phone": user.phone}).expect(t, utils.OK)
+	srv.request(http.MethodPost, "/login/sms", nil, gin.H{"phone": user.phone, "code": srv.sms.code(user.phone)}).expect(t, utils.UNAUTH)
+
+	admin.do(http.MethodPost, "/admin/v1/user/disable", gin.H{"userId": user.userId, "disabled": false, "reason": "test"}).expect(t, utils.OK)
+	srv.login(user.phone).do(http.MethodGet, "/account/sessions", nil).expect(t, utils.OK)
+}
+
+func TestAuditOutcome(t *testing.T) {
+	srv := newServer(t)
+	admin := srv.loginAs("13800000001", model.RoleAdmin)
+	user := srv.login("13800000002")
+
+	admin.do(http.MethodPost, "/admin/v1/user/disable", gin.H{"userId": user.userId, "disabled": true, "reason": "spam"}).expect(t, utils.OK)
+	// 用户不存在的时候操作失败，审计记录也要保留
+	admin.do(http.MethodPost, "/admin/v1/user/role", gin.H{"userId": "0123456789abcdef01234567", "role": model.RoleSupport}).expect(t, utils.MONGO_ERR)
+
+	var audits []model.Audit
+	admin.do(http.MethodGet, "/admin/v1/audit", gin.H{"actorId": admin.userId}).ok(t, &audits)
+	outcomes := map[string]string{}
+	for _, audit := range audits {
+		if audit.ActorRole != model.RoleAdmin || audit.ActorId.Hex() != admin.userId {
+			t.Fatalf("unexpected actor %+v", audit)
+		}
+		outcomes[audit.Action] = audit.Outcome
+	}
+	if outcomes["disable_user"] != model.AuditSucceeded || outcomes["set_role"] != model.AuditFailed {
+		t.Fatalf("unexpected outcomes %v", outcomes)
+	}
+}
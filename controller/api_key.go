@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"ezlock/common/logger"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 每个用户最多可以创建的接口 key 数量，不包括已经撤销的
+const maxApiKeys = 20
+
+// 创建和轮换的时候返回 key 原文，只返回这一次，之后只能轮换
+type ApiKeyDetail struct {
+	model.ApiKey
+	Key string `json:"key,omitempty"`
+}
+
+// 获取用户没有撤销的接口 key
+func (ctl *Controller) GetApiKeyList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+
+	keys, err := ctl.store.ApiKeys.FindByUser(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk(keys, c)
+}
+
+// 创建接口 key，lockIds 只能是自己拥有或者管理的门锁，为空的时候可以操作用户所有可用的锁
+func (ctl *Controller) CreateApiKey(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Name    string   `form:"name" json:"name" binding:"required,max=64"`
+		Scopes  []string `form:"scopes" json:"scopes" binding:"required,min=1"`
+		LockIds []string `form:"lockIds" json:"lockIds"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !model.ValidScope(scope) {
+			utils.ResponseError(utils.PARAM_ERR, fmt.Sprintf("权限范围 %s 不合法", scope), c)
+			return
+		}
+	}
+	lockIds := make([]primitive.ObjectID, 0, len(params.LockIds))
+	for _, hex := range params.LockIds {
+		if !primitive.IsValidObjectID(hex) {
+			utils.ResponseError(utils.PARAM_ERR, "门锁id不合法", c)
+			return
+		}
+		lock, err := ctl.store.Locks.Get(ctx, utils.ObjectIdHex(hex))
+		if err != nil && err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		canManage := false
+		if err == nil {
+			if canManage, err = utils.CanManageLock(ctx, ctl.store, userId, lock); err != nil {
+				utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+				return
+			}
+		}
+		if !canManage {
+			utils.ResponseError(utils.NOT_EXISTS, fmt.Sprintf("门锁 %s 不属于您", hex), c)
+			return
+		}
+		lockIds = append(lockIds, lock.Id)
+	}
+
+	exists, err := ctl.store.ApiKeys.FindByUser(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if len(exists) >= maxApiKeys {
+		utils.ResponseError(utils.PARAM_ERR, fmt.Sprintf("最多只能创建 %d 个接口 key", maxApiKeys), c)
+		return
+	}
+
+	key := model.ApiKey{
+		Id:         primitive.NewObjectID(),
+		UserId:     utils.ObjectIdHex(userId),
+		Name:       params.Name,
+		Scopes:     params.Scopes,
+		LockIds:    lockIds,
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	raw, hash, err := utils.NewApiKey(key.Id)
+	if err != nil {
+		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		return
+	}
+	key.KeyHash = hash
+	if err := ctl.store.ApiKeys.Insert(ctx, &key); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "apiKeyId": key.Id.Hex(), "scopes": key.Scopes, "outcome": "created"}).Info("api key created")
+	utils.ResponseOk(ApiKeyDetail{ApiKey: key, Key: raw}, c)
+}
+
+// 轮换接口 key，权限范围和门锁不变，旧的 key 立即失效
+func (ctl *Controller) RotateApiKey(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		KeyId string `form:"keyId" json:"keyId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.KeyId) {
+		utils.ResponseError(utils.PARAM_ERR, "接口 key id不合法", c)
+		return
+	}
+
+	keyId := utils.ObjectIdHex(params.KeyId)
+	raw, hash, err := utils.NewApiKey(keyId)
+	if err != nil {
+		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		return
+	}
+	if err := ctl.store.ApiKeys.Rotate(ctx, keyId, utils.ObjectIdHex(userId), hash); err != nil {
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.NOT_EXISTS, "接口 key 不存在", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	key, err := ctl.store.ApiKeys.Get(ctx, keyId)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "apiKeyId": params.KeyId, "outcome": "rotated"}).Info("api key rotated")
+	utils.ResponseOk(ApiKeyDetail{ApiKey: *key, Key: raw}, c)
+}
+
+// 撤销接口 key，之后使用这个 key 的请求都会被拒绝
+func (ctl *Controller) RevokeApiKey(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		KeyId string `form:"keyId" json:"keyId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.KeyId) {
+		utils.ResponseError(utils.PARAM_ERR, "接口 key id不合法", c)
+		return
+	}
+
+	err := ctl.store.ApiKeys.Revoke(ctx, utils.ObjectIdHex(params.KeyId), utils.ObjectIdHex(userId))
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.NOT_EXISTS, "接口 key 不存在", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "apiKeyId": params.KeyId, "outcome": "revoked"}).Info("api key revoked")
+	utils.ResponseOk("ok", c)
+}
@@ -0,0 +1,91 @@
+package controller_test
+
+import (
+	"ezlock/controller"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+// 创建接口 key，返回只带接口 key 请求头的客户端
+func (c *client) apiKey(params gin.H) (*client, controller.ApiKeyDetail) {
+	c.srv.t.Helper()
+	var detail controller.ApiKeyDetail
+	c.do(http.MethodPost, "/account/api_key", params).ok(c.srv.t, &detail)
+	keyClient := &client{srv: c.srv, userId: c.userId, phone: c.phone, headers: map[string]string{middleware.ApiKeyHeader: detail.Key}}
+	return keyClient, detail
+}
+
+func TestApiKeyScope(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	lock := owner.addLock("AA:00:00:00:16:01")
+
+	key, _ := owner.apiKey(gin.H{"name": "reader", "scopes": []string{model.ScopeLockRead}})
+	key.do(http.MethodGet, "/api/v1/lock/card", gin.H{"mac": lock.Mac}).expect(t, utils.OK)
+	// 没有对应权限范围的接口被拒绝
+	key.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.UNAUTH)
+	key.do(http.MethodPost, "/api/v1/lock/card/add", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.UNAUTH)
+	key.do(http.MethodGet, "/api/v1/lock/log", gin.H{"mac": lock.Mac}).expect(t, utils.UNAUTH)
+	// 只能登录后操作的接口不接受接口 key
+	key.do(http.MethodDelete, "/api/v1/lock/info", gin.H{"mac": lock.Mac}).expect(t, utils.UNAUTH)
+	key.do(http.MethodGet, "/account/api_keys", nil).expect(t, utils.UNAUTH)
+
+	// 限制了门锁的 key 不能操作其他的锁
+	other := owner.addLock("AA:00:00:00:16:02")
+	opener, _ := owner.apiKey(gin.H{"name": "opener", "scopes": []string{model.ScopeLockOpen}, "lockIds": []string{lock.Id.Hex()}})
+	opener.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.OK)
+	opener.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": other.Mac, "code": lockCode}).expect(t, utils.ENCRYPT_ERR)
+
+	owner.do(http.MethodPost, "/account/api_key", gin.H{"name": "bad", "scopes": []string{"lock:write"}}).expect(t, utils.PARAM_ERR)
+	stranger := srv.login("13800000002")
+	stranger.do(http.MethodPost, "/account/api_key", gin.H{"name": "steal", "scopes": []string{model.ScopeLockOpen}, "lockIds": []string{lock.Id.Hex()}}).expect(t, utils.NOT_EXISTS)
+}
+
+func TestApiKeyRevokeAndRotate(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	lock := owner.addLock("AA:00:00:00:16:03")
+	read := gin.H{"mac": lock.Mac}
+
+	key, detail := owner.apiKey(gin.H{"name": "reader", "scopes": []string{model.ScopeLockRead}})
+	var rotated controller.ApiKeyDetail
+	owner.do(http.MethodPost, "/account/api_key/rotate", gin.H{"keyId": detail.Id.Hex()}).ok(t, &rotated)
+	if rotated.Key == "" || rotated.Key == detail.Key {
+		t.Fatal("api key should be rotated")
+	}
+	key.do(http.MethodGet, "/api/v1/lock/card", read).expect(t, utils.UNAUTH)
+	key.headers[middleware.ApiKeyHeader] = rotated.Key
+	key.do(http.MethodGet, "/api/v1/lock/card", read).expect(t, utils.OK)
+
+	// 只能撤销自己的 key
+	stranger := srv.login("13800000002")
+	stranger.do(http.MethodDelete, "/account/api_key", gin.H{"keyId": detail.Id.Hex()}).expect(t, utils.NOT_EXISTS)
+	key.do(http.MethodGet, "/api/v1/lock/card", read).expect(t, utils.OK)
+	owner.do(http.MethodDelete, "/account/api_key", gin.H{"keyId": detail.Id.Hex()}).expect(t, utils.OK)
+	key.do(http.MethodGet, "/api/v1/lock/card", read).expect(t, utils.UNAUTH)
+	owner.do(http.MethodPost, "/account/api_key/rotate", gin.H{"keyId": detail.Id.Hex()}).expect(t, utils.NOT_EXISTS)
+
+	var keys []model.ApiKey
+	owner.do(http.MethodGet, "/account/api_keys", nil).ok(t, &keys)
+	if len(keys) != 0 {
+		t.Fatalf("revoked key still listed: %+v", keys)
+	}
+	key.headers[middleware.ApiKeyHeader] = "not-a-key"
+	key.do(http.MethodGet, "/api/v1/lock/card", read).expect(t, utils.UNAUTH)
+}
+
+func TestDisabledUserApiKeyRevoked(t *testing.T) {
+	srv := newServer(t)
+	admin := srv.loginAs("13800000001", model.RoleAdmin)
+	owner := srv.login("13800000002")
+	lock := owner.addLock("AA:00:00:00:16:04")
+
+	key, _ := owner.apiKey(gin.H{"name": "reader", "scopes": []string{model.ScopeLockRead}})
+	key.do(http.MethodGet, "/api/v1/lock/card", gin.H{"mac": lock.Mac}).expect(t, utils.OK)
+	admin.do(http.MethodPost, "/admin/v1/user/disable", gin.H{"userId": owner.userId, "disabled": true, "reason": "test"}).expect(t, utils.OK)
+	key.do(http.MethodGet, "/api/v1/lock/card", gin.H{"mac": lock.Mac}).expect(t, utils.UNAUTH)
+}
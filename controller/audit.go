@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"ezlock/common/logger"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// 记录一次管理操作，写入失败的时候直接返回错误，调用方不再返回操作结果
+// 查询操作也要记录，查看用户数据本身就需要留痕
+func (ctl *Controller) audit(c *gin.Context, action, targetType, targetId, detail string) bool {
+	_, ok := ctl.insertAudit(c, action, targetType, targetId, detail, model.AuditSucceeded)
+	return ok
+}
+
+// 修改数据的管理操作在执行之前写入审计记录，写入失败的时候直接返回错误，调用方不能再执行操作
+// 执行之后调用 auditDone 更新结果，进程在中间退出的时候记录保持 pending
+func (ctl *Controller) auditBegin(c *gin.Context, action, targetType, targetId, detail string) (*model.Audit, bool) {
+	return ctl.insertAudit(c, action, targetType, targetId, detail, model.AuditPending)
+}
+
+// 更新审计记录的结果，操作已经执行，更新失败的时候只记录日志，不影响返回
+func (ctl *Controller) auditDone(c *gin.Context, audit *model.Audit, opErr error) {
+	ctx := c.Request.Context()
+	audit.Outcome = model.AuditSucceeded
+	if opErr != nil {
+		audit.Outcome = model.AuditFailed
+	}
+	if err := ctl.store.Audits.SetOutcome(ctx, audit.Id, audit.Outcome); err != nil {
+		logger.Ctx(ctx).WithFields(logger.Fields{
+			"auditId": audit.Id.Hex(), "action": audit.Action, "outcome": audit.Outcome,
+		}).Errorf("set audit outcome failed: %s", err.Error())
+	}
+}
+
+func (ctl *Controller) insertAudit(c *gin.Context, action, targetType, targetId, detail, outcome string) (*model.Audit, bool) {
+	ctx := c.Request.Context()
+	audit := &model.Audit{
+		ActorId:    utils.ObjectIdHex(c.GetString("id")),
+		ActorRole:  c.GetString(middleware.RoleKey),
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Detail:     detail,
+		Outcome:    outcome,
+		Ip:         c.ClientIP(),
+		RequestId:  logger.RequestId(ctx),
+		CreateTime: time.Now().Local(),
+	}
+	if err := ctl.store.Audits.Insert(ctx, audit); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return nil, false
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"actorId": audit.ActorId.Hex(), "action": action, "targetType": targetType, "targetId": targetId, "outcome": outcome,
+	}).Info("admin action")
+	return audit, true
+}
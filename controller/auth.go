@@ -1,25 +1,44 @@
 package controller
 
 import (
-	"ezlock/common/mongo"
-	"ezlock/config"
+	"context"
+	"ezlock/common/logger"
+	"ezlock/common/metrics"
+	"ezlock/identity"
 	"ezlock/model"
+	"ezlock/store"
 	"ezlock/utils"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
 type AuthDetail struct {
 	model.Auth
-	Sender   string        `json:"sender"`   // 发送者
-	Receiver string        `json:"receiver"` // 接受者
-	LockId   bson.ObjectId `json:"-"`        // 被授权的门锁id
+	Sender   string             `json:"sender"`   // 发送者
+	Receiver string             `json:"receiver"` // 接受者
+	LockId   primitive.ObjectID `json:"-"`        // 被授权的门锁id
 }
 
-func GetLockAuthList(c *gin.Context) {
+// 获取用户昵称，用户不存在时返回空字符串
+func (ctl *Controller) nickName(ctx context.Context, userId primitive.ObjectID) (string, error) {
+	if userId.IsZero() {
+		return "", nil
+	}
+	user, err := ctl.store.Users.Get(ctx, userId)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return user.NickName, nil
+}
+
+func (ctl *Controller) GetLockAuthList(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		Mac string `form:"mac" binding:"required"`
 	}{}
@@ -27,59 +46,45 @@ func GetLockAuthList(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
 
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	authColl := mgoSession.DB(config.DataBaseName).C(model.AuthTableName)
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
-	authLock := model.Lock{}
-	err := lockColl.Find(bson.M{
-		"mac": params.Mac,
-	}).Select(bson.M{"_id": 1}).One(&authLock)
+	authLock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
-
-	q := bson.M{
-		"lockId": authLock.Id,
-		"$or": []bson.M{
-			bson.M{"sendId": bson.ObjectIdHex(userId)},
-			bson.M{"receiverId": bson.ObjectIdHex(userId)},
-		},
+	if !utils.LockInScope(ctx, authLock.Id) {
+		utils.ResponseError(utils.NOT_EXISTS, "您无权查看此锁的授权", c)
+		return
 	}
-	auths := []AuthDetail{}
-	err = authColl.Find(q).All(&auths)
+
+	auths, err := ctl.store.Auths.FindByLockAndUser(ctx, authLock.Id, utils.ObjectIdHex(userId))
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
-	for index := range auths {
-		sender := model.User{}
-		receiver := model.User{}
-		err = userColl.FindId(auths[index].SendId).Select(bson.M{"nickName": 1}).One(&sender)
+	details := make([]AuthDetail, 0, len(auths))
+	for _, auth := range auths {
+		detail := AuthDetail{Auth: auth}
+		detail.Sender, err = ctl.nickName(ctx, auth.SendId)
 		if err != nil {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 			return
 		}
 
-		err = userColl.FindId(auths[index].ReceiverId).Select(bson.M{"nickName": 1}).One(&receiver)
+		detail.Receiver, err = ctl.nickName(ctx, auth.ReceiverId)
 		if err != nil {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 			return
 		}
-
-		auths[index].Sender = sender.NickName
-		auths[index].Receiver = receiver.NickName
+		details = append(details, detail)
 	}
-	utils.ResponseOk(auths, c)
+	utils.ResponseOk(details, c)
 }
 
-func CreateLockAuth(c *gin.Context) {
+func (ctl *Controller) CreateLockAuth(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		Mac       string `form:"mac" binding:"required"`
 		ViewLog   bool   `form:"viewLog"`   // 查看日志权限
@@ -91,13 +96,18 @@ func CreateLockAuth(c *gin.Context) {
 		EndDate   string `form:"endDate"`
 		StartTime string `form:"startTime"`
 		EndTime   string `form:"endTime"`
+		Phone     string `form:"phone"` // 直接分享给这个手机号，不需要对方领取
 	}{}
 
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
+	if params.Phone != "" && !identity.ValidPhone(params.Phone) {
+		utils.ResponseError(utils.PARAM_ERR, "手机号不合法", c)
+		return
+	}
 	authInfo := model.Auth{
-		SendId:     bson.ObjectIdHex(userId),
+		SendId:     utils.ObjectIdHex(userId),
 		AuthType:   params.AuthType,
 		Deadline:   params.Deadline,
 		StartDate:  params.StartDate,
@@ -118,55 +128,105 @@ func CreateLockAuth(c *gin.Context) {
 		return
 	}
 
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	authColl := mgoSession.DB(config.DataBaseName).C(model.AuthTableName)
-	q := bson.M{
-		"mac": params.Mac,
-	}
-	lock := model.Lock{}
-	err := lockColl.Find(q).Select(bson.M{"_id": 1}).One(&lock)
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 	// 判断默认锁是否在可用锁列表里面，不在 则默认锁已失效
-	locks, err := utils.GetAllLocks(userId, true, model.Perms{
+	locks, err := utils.GetAllLocks(ctx, ctl.store, userId, true, model.Perms{
 		ShareAuth: true,
 	})
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
-	isOwn, ok := locks[lock.Id]
+	canManage, ok := locks[lock.Id]
 	if !ok {
 		utils.ResponseError(utils.UNAUTH, "您无权分享此锁的授权", c)
 		return
 	}
-	// 如果这个锁不是自己的，没有办法让别人在分享授权
-	if !isOwn && authInfo.ShareAuth {
+	// 如果不是这个锁的拥有者或者管理员，没有办法让别人再分享授权
+	if !canManage && authInfo.ShareAuth {
 		utils.ResponseError(utils.UNAUTH, "您无权给别人开放此锁的分享权限", c)
 		return
 	}
 
-	mgoId := bson.NewObjectId()
+	mgoId := primitive.NewObjectID()
 	authInfo.LockId = lock.Id
 	authInfo.Id = mgoId
+	if params.Phone != "" {
+		ctl.createPhoneAuth(c, &authInfo, params.Phone)
+		return
+	}
 	authInfo.Token = mgoId.Hex()
-	err = authColl.Insert(authInfo)
+	err = ctl.store.Auths.Insert(ctx, &authInfo)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": params.Mac, "authId": authInfo.Id.Hex(), "outcome": "created"}).Info("auth created")
 
 	utils.ResponseOk(authInfo.Token, c)
 
 }
 
-func UseLockAuth(c *gin.Context) {
+// 分享给手机号的授权的状态，granted 已经给了这个手机号的用户，pending 等待这个手机号的用户注册
+type PhoneAuthResult struct {
+	AuthId string `json:"authId"`
+	Status string `json:"status"`
+}
+
+// 分享给手机号，手机号已经有用户的时候直接授权给这个用户，否则等这个手机号的用户验证手机号后自动领取
+// 没有 token，不能通过 UseLockAuth 被其他人领取
+func (ctl *Controller) createPhoneAuth(c *gin.Context, authInfo *model.Auth, phone string) {
+	ctx := c.Request.Context()
+	receiver, err := ctl.store.Users.GetByPhone(ctx, phone)
+	if err != nil && err != store.ErrNotFound {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	status := "pending"
+	if err == nil {
+		if receiver.Id == authInfo.SendId {
+			utils.ResponseError(utils.PARAM_ERR, "自己不能使用自己的授权哦！", c)
+			return
+		}
+		authInfo.ReceiverId = receiver.Id
+		status = "granted"
+	}
+	authInfo.Phone = phone
+	if err := ctl.store.Auths.Insert(ctx, authInfo); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": authInfo.SendId.Hex(), "lockId": authInfo.LockId.Hex(), "authId": authInfo.Id.Hex(), "outcome": status,
+	}).Info("phone auth created")
+
+	utils.ResponseOk(PhoneAuthResult{AuthId: authInfo.Id.Hex(), Status: status}, c)
+}
+
+// 领取分享给手机号的授权，手机号验证通过的时候调用，失败的时候只记录日志，不影响登录和绑定手机号
+func (ctl *Controller) claimPhoneAuths(ctx context.Context, userId primitive.ObjectID, phone string) {
+	if phone == "" {
+		return
+	}
+	log := logger.Ctx(ctx).WithField("userId", userId.Hex())
+	// 登录和绑定手机号的请求不在任何组织里，组织里分享给这个手机号的授权也一起领取
+	count, err := ctl.store.Auths.ClaimByPhone(store.AllTenants(ctx), phone, userId)
+	if err != nil {
+		log.Errorf("claim phone auths failed: %s", err.Error())
+		return
+	}
+	if count > 0 {
+		log.WithFields(logger.Fields{"count": count, "outcome": "claimed"}).Info("phone auths claimed")
+	}
+}
+
+func (ctl *Controller) UseLockAuth(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		Token string `form:"token" binding:"required"`
 	}{}
@@ -174,23 +234,25 @@ func UseLockAuth(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	authColl := mgoSession.DB(config.DataBaseName).C(model.AuthTableName)
-	q := bson.M{
-		"_id": bson.ObjectIdHex(params.Token),
+	if !primitive.IsValidObjectID(params.Token) {
+		utils.ResponseError(utils.PARAM_ERR, "授权token不合法", c)
+		return
 	}
-	// 后面得加分布式锁，高并发这么写会有问题
-	authInfo := model.Auth{}
-	err := authColl.Find(q).One(&authInfo)
+
+	authInfo, err := ctl.store.Auths.Get(ctx, utils.ObjectIdHex(params.Token))
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 分享给手机号的授权只能由这个手机号的用户领取
+	if authInfo.Phone != "" {
+		metrics.AuthRedeemed("phone")
+		utils.ResponseError(utils.INVALID, "此授权只能由指定手机号的用户领取", c)
 		return
 	}
 	// 此授权已经被别人使用
-	if len(authInfo.ReceiverId.Hex()) != 0 {
+	if !authInfo.ReceiverId.IsZero() {
+		metrics.AuthRedeemed("used")
 		utils.ResponseError(utils.INVALID, "已被他人使用", c)
 		return
 	}
@@ -199,45 +261,167 @@ func UseLockAuth(c *gin.Context) {
 		return
 	}
 
-	err = authColl.Update(q, bson.M{"receiverId": bson.ObjectIdHex(userId)})
+	// 领取的时候数据库会再检查一次是否被领取，防止并发的时候被多个人领取
+	err = ctl.store.Auths.SetReceiver(ctx, authInfo.Id, utils.ObjectIdHex(userId))
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		if err == store.ErrNotFound {
+			metrics.AuthRedeemed("used")
+			utils.ResponseError(utils.INVALID, "已被他人使用", c)
+			return
+		}
+		metrics.AuthRedeemed("error")
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	metrics.AuthRedeemed("success")
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "authId": authInfo.Id.Hex(), "outcome": "success"}).Info("auth redeemed")
 
 	utils.ResponseOk("ok", c)
 
 }
 
-func RevokeAuth(c *gin.Context) {
+func (ctl *Controller) RevokeAuth(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		AuthId string `form:"authId" binding:"required"`
 	}{}
 
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	authColl := mgoSession.DB(config.DataBaseName).C(model.AuthTableName)
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.AuthId) {
+		utils.ResponseError(utils.PARAM_ERR, "授权id不合法", c)
+		return
+	}
 
-	updateVal := &struct {
-		Valid      bool      `bson:"valid"`
-		UpdateTime time.Time `bson:"updateTime"` // 更新时间
-	}{
-		Valid:      false,
-		UpdateTime: time.Now().Local(),
+	// 接口 key 限制了门锁的时候，只能撤销这些锁上的授权
+	auth, err := ctl.store.Auths.Get(ctx, utils.ObjectIdHex(params.AuthId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if !utils.LockInScope(ctx, auth.LockId) {
+		utils.ResponseError(utils.NOT_EXISTS, "您无权撤销此授权", c)
+		return
 	}
 
-	if err := authColl.Update(bson.M{
-		"_id":    bson.ObjectIdHex(params.AuthId),
-		"sendId": bson.ObjectIdHex(userId),
-	}, bson.M{
-		"$set": updateVal,
-	}); err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	if err := ctl.store.Auths.Revoke(ctx, utils.ObjectIdHex(params.AuthId), utils.ObjectIdHex(userId)); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	metrics.AuthRevoked()
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "authId": params.AuthId, "outcome": "revoked"}).Info("auth revoked")
 
 	utils.ResponseOk(fmt.Sprintf("auth[%s] revoke success", params.AuthId), c)
 
 }
+
+// 撤销门锁上所有授权的结果，spaceAuthsRemaining 是没有撤销、仍然可以开这把锁的空间授权数量
+type RevokeAllResult struct {
+	Revoked             int `json:"revoked"`
+	SpaceAuthsRevoked   int `json:"spaceAuthsRevoked"`
+	SpaceAuthsRemaining int `json:"spaceAuthsRemaining"`
+}
+
+// 撤销门锁上所有有效的授权，包括其他人转发的，门锁拥有者和管理员可以操作
+// 授权给门锁所在空间或者上层空间的授权也能开这把锁，但是还包括空间里的其他门锁
+// spaces 为 true 的时候这些空间授权也一起撤销，否则只返回数量，由拥有者决定是否在空间里撤销
+func (ctl *Controller) RevokeAllLockAuth(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac    string `form:"mac" binding:"required"`
+		Spaces bool   `form:"spaces"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	canManage, err := utils.CanManageLock(ctx, ctl.store, userId, lock)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if !canManage {
+		utils.ResponseError(utils.NOT_EXISTS, "您无权撤销此锁的授权", c)
+		return
+	}
+	auths, err := ctl.store.Auths.FindByLock(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	spaceAuths, err := ctl.lockSpaceAuths(ctx, lock)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	result := RevokeAllResult{}
+	for _, auth := range auths {
+		if !auth.Valid {
+			continue
+		}
+		if err := ctl.store.Auths.Invalidate(ctx, auth.Id); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		metrics.AuthRevoked()
+		result.Revoked++
+	}
+	for _, auth := range spaceAuths {
+		if !params.Spaces {
+			result.SpaceAuthsRemaining++
+			continue
+		}
+		if err := ctl.store.Auths.Invalidate(ctx, auth.Id); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		metrics.AuthRevoked()
+		result.SpaceAuthsRevoked++
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "mac": params.Mac, "count": result.Revoked, "spaceAuthsRevoked": result.SpaceAuthsRevoked,
+		"spaceAuthsRemaining": result.SpaceAuthsRemaining, "outcome": "revoked",
+	}).Info("all auths revoked")
+
+	utils.ResponseOk(result, c)
+}
+
+// 获取还有效、并且包括这把锁的空间授权，也就是拥有者授权给门锁所在空间或者上层空间的
+func (ctl *Controller) lockSpaceAuths(ctx context.Context, lock *model.Lock) ([]model.Auth, error) {
+	auths := []model.Auth{}
+	if lock.SpaceId.IsZero() {
+		return auths, nil
+	}
+	space, err := ctl.store.Spaces.Get(ctx, lock.SpaceId)
+	if err == store.ErrNotFound {
+		return auths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := map[primitive.ObjectID]bool{}
+	for _, spaceId := range append([]primitive.ObjectID{space.Id}, space.Ancestors...) {
+		found, err := ctl.store.Auths.FindBySpace(ctx, spaceId)
+		if err != nil {
+			return nil, err
+		}
+		for _, auth := range found {
+			// 空间授权只对发出者自己的门锁有效
+			if seen[auth.Id] || !auth.Valid || auth.SendId != lock.Own || !utils.CheckAuthTimeValid(auth) {
+				continue
+			}
+			seen[auth.Id] = true
+			auths = append(auths, auth)
+		}
+	}
+	return auths, nil
+}
@@ -1,20 +1,21 @@
 package controller
 
 import (
-	"ezlock/common/mongo"
+	"context"
+	"ezlock/common/logger"
 	"ezlock/config"
 	"ezlock/model"
 	"ezlock/utils"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"strings"
 	"time"
 )
 
-func GetAddCardKey(c *gin.Context) {
+func (ctl *Controller) GetAddCardKey(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// 请求参数列表
 	params := &struct {
 		Code string `form:"code" binding:"len=16,required"`
@@ -25,17 +26,47 @@ func GetAddCardKey(c *gin.Context) {
 		return
 	}
 
-	key, err := utils.GenerateKey(userId, params.Mac, config.AddCard, params.Code)
+	key, err := utils.GenerateKey(ctx, ctl.store, userId, params.Mac, config.Get().Command.AddCard, params.Code)
 	if err != nil {
-		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.ENCRYPT_ERR, err, c)
 		return
 	}
 
 	utils.ResponseOk(key, c)
 }
 
-func GetDelCardKey(c *gin.Context) {
+// 获取有效的门卡和门卡绑定的门锁，只有门卡的添加者和门锁的拥有者、管理员可以操作门卡
+func (ctl *Controller) getOwnCard(ctx context.Context, userId, cardId string) (*model.Card, *model.Lock, int, string) {
+	if !primitive.IsValidObjectID(cardId) {
+		return nil, nil, utils.PARAM_ERR, "门卡id不合法"
+	}
+	// 获取卡片所有者
+	card, err := ctl.store.Cards.Get(ctx, utils.ObjectIdHex(cardId))
+	if err != nil {
+		return nil, nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	if !card.Valid {
+		return nil, nil, utils.NOT_EXISTS, "此卡片已经被删除"
+	}
+	// 获取门锁所有者
+	lock, err := ctl.store.Locks.Get(ctx, card.Lock)
+	if err != nil {
+		return nil, nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+
+	canManage, err := utils.CanManageLock(ctx, ctl.store, userId, lock)
+	if err != nil {
+		return nil, nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	if (card.UserId.Hex() != userId && !canManage) || !utils.LockInScope(ctx, lock.Id) {
+		return nil, nil, utils.NOT_EXISTS, "此卡片不属于您"
+	}
+	return card, lock, utils.OK, ""
+}
+
+func (ctl *Controller) GetDelCardKey(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// 请求参数列表
 	params := &struct {
 		Code   string `form:"code" binding:"len=16,required"`
@@ -45,50 +76,24 @@ func GetDelCardKey(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
 
-	cardColl := mgoSession.DB(config.DataBaseName).C(model.CardTableName)
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-
-	// 获取卡片所有者
-	card := model.Card{}
-	err := cardColl.Find(bson.M{
-		"_id":   params.CardId,
-		"valid": true,
-	}).Select(bson.M{"userId": 1}).One(&card)
-	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	card, lock, code, msg := ctl.getOwnCard(ctx, userId, params.CardId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
 		return
 	}
-	// 获取门锁所有者
-	lock := model.Lock{}
-	err = lockColl.FindId(card.Lock).Select(bson.M{"own": 1}).One(&lock)
+	key, err := utils.GenerateKey(ctx, ctl.store, userId, lock.Mac, fmt.Sprintf(config.Get().Command.DelCard, card.Number), params.Code)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-		return
-	}
-
-	userIds := map[bson.ObjectId]bool{
-		card.UserId: true,
-		lock.Own:    true,
-	}
-	if _, ok := userIds[bson.ObjectIdHex(userId)]; !ok {
-		utils.ResponseError(utils.NOT_EXISTS, "此卡片不属于您", c)
-		return
-	}
-	key, err := utils.GenerateKey(userId, lock.Mac, fmt.Sprintf(config.DelCard, card.Number), params.Code)
-	if err != nil {
-		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.ENCRYPT_ERR, err, c)
 		return
 	}
 
 	utils.ResponseOk(key, c)
 }
 
-func GetLockCardList(c *gin.Context) {
+func (ctl *Controller) GetLockCardList(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		Mac string `form:"mac" binding:"required"`
 		//ShowValid bool   `form:"showValid"` // false 就是获取所有门卡 包括被删除的
@@ -97,54 +102,48 @@ func GetLockCardList(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	cardColl := mgoSession.DB(config.DataBaseName).C(model.CardTableName)
 
-	lock := model.Lock{}
-	err := lockColl.Find(bson.M{
-		"mac": params.Mac,
-	}).Select(bson.M{"_id": 1}).One(&lock)
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
 	// 判断默认锁是否在可用锁列表里面，不在 则默认锁已失效
-	locks, err := utils.GetAllLocks(userId, true, model.Perms{})
+	locks, err := utils.GetAllLocks(ctx, ctl.store, userId, true, model.Perms{})
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
-	if _, ok := locks[lock.Id]; !ok {
+	canManage, ok := locks[lock.Id]
+	if !ok {
 		utils.ResponseError(utils.UNAUTH, "您无权查看此锁的门卡信息", c)
 		return
 	}
-	cards := []model.Card{}
-	q := bson.M{"lock": lock.Id, "valid": true}
-	//if params.ShowInValid {
-	//	q["valid"] = true
-	//}
-	currentUserId := bson.ObjectIdHex(userId)
-	if lock.Own != currentUserId {
-		q["userId"] = currentUserId
-	}
-	err = cardColl.Find(q).All(&cards)
+	allCards, err := ctl.store.Cards.FindByLock(ctx, lock.Id)
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-			return
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 门锁的拥有者和管理员可以看到所有门卡，其他人只能看到自己添加的
+	currentUserId := utils.ObjectIdHex(userId)
+	cards := []model.Card{}
+	for _, card := range allCards {
+		if !card.Valid {
+			continue
 		}
+		if !canManage && card.UserId != currentUserId {
+			continue
+		}
+		cards = append(cards, card)
 	}
 	utils.ResponseOk(cards, c)
 }
 
-func UpdateCard(c *gin.Context) {
+func (ctl *Controller) UpdateCard(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// 请求参数列表
 	params := &struct {
 		CardId string `form:"cardId" binding:"required"`
@@ -156,48 +155,13 @@ func UpdateCard(c *gin.Context) {
 		return
 	}
 
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	cardColl := mgoSession.DB(config.DataBaseName).C(model.CardTableName)
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-
-	// 获取卡片所有者
-	card := model.Card{}
-	err := cardColl.Find(bson.M{"_id": params.CardId, "valid": true}).Select(bson.M{"userId": 1}).One(&card)
-	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	card, _, code, msg := ctl.getOwnCard(ctx, userId, params.CardId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
 		return
 	}
-	// 获取门锁所有者
-	lock := model.Lock{}
-	err = lockColl.FindId(card.Lock).Select(bson.M{"own": 1}).One(&lock)
-	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-		return
-	}
-	updateVal := &struct {
-		Name       string    `bson:"name,omitempty"`
-		Desc       string    `bson:"desc,omitempty"`
-		UpdateTime time.Time `bson:"updateTime"` // 更新时间
-	}{
-		UpdateTime: time.Now().Local(),
-	}
-	userIds := map[bson.ObjectId]bool{
-		card.UserId: true,
-		lock.Own:    true,
-	}
-	if _, ok := userIds[bson.ObjectIdHex(userId)]; !ok {
-		utils.ResponseError(utils.NOT_EXISTS, "此卡片不属于您", c)
-		return
-	}
-	if err := cardColl.Update(bson.M{
-		"_id": bson.ObjectIdHex(params.CardId),
-	}, bson.M{
-		"$set": updateVal,
-	}); err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	if err := ctl.store.Cards.UpdateInfo(ctx, card.Id, params.Name, params.Desc); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 
 	}
@@ -206,8 +170,9 @@ func UpdateCard(c *gin.Context) {
 }
 
 // 硬件需要对锁的日志信息做个加密防止篡改，前端小程序蓝牙链接成功后 拿到这个加密信息直接发送给后端
-func SetLockCard(c *gin.Context) {
+func (ctl *Controller) SetLockCard(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// data 格式 cardNumber
 	params := &struct {
 		Mac  string `form:"mac" binding:"required"`
@@ -217,46 +182,33 @@ func SetLockCard(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	content, err := utils.DncryptData(userId, params.Mac, params.Data)
+	content, err := utils.DncryptData(ctx, ctl.store, userId, params.Mac, params.Data)
 	if err != nil {
-		utils.ResponseError(utils.DNCRYPT_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.DNCRYPT_ERR, err, c)
 		return
 	}
 
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LogTableName)
-	cardColl := mgoSession.DB(config.DataBaseName).C(model.CardTableName)
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
-
 	cardNum := strings.TrimSpace(content)
 
-	lock := model.Lock{}
-	err = lockColl.Find(bson.M{"mac": params.Mac}).Select(bson.M{"_id": 1}).One(&lock)
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
-	user := model.User{}
-	err = userColl.FindId(userId).One(&user)
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 	// 查看这个锁有多少门禁卡 按照序号自增
-	existsCards := []model.Card{}
-	err = cardColl.Find(bson.M{"lock": lock.Id}).All(&existsCards)
+	existsCards, err := ctl.store.Cards.FindByLock(ctx, lock.Id)
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-			return
-		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
 	}
 	for _, card := range existsCards {
-		if card.Number == cardNum {
+		if card.Number == cardNum && card.Valid {
 			utils.ResponseError(utils.PARAM_ERR, "门卡已经存在", c)
 			return
 		}
@@ -269,21 +221,23 @@ func SetLockCard(c *gin.Context) {
 		Lock:       lock.Id,
 		Number:     cardNum,
 		Valid:      true,
-		UserId:     bson.ObjectIdHex(userId),
+		UserId:     utils.ObjectIdHex(userId),
 		UpdateTime: time.Now().Local(),
 		CreateTime: time.Now().Local(),
 	}
-	err = cardColl.Insert(card)
+	err = ctl.store.Cards.Insert(ctx, &card)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": params.Mac, "cardId": card.Id.Hex(), "outcome": "added"}).Info("card added")
 	utils.ResponseOk("ok", c)
 }
 
 // 硬件需要对锁的日志信息做个加密防止篡改，前端小程序蓝牙链接成功后 拿到这个加密信息直接发送给后端
-func DelCard(c *gin.Context) {
+func (ctl *Controller) DelCard(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// data 格式 cardNumber
 	params := &struct {
 		Mac  string `form:"mac" binding:"required"`
@@ -293,41 +247,25 @@ func DelCard(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	content, err := utils.DncryptData(userId, params.Mac, params.Data)
+	content, err := utils.DncryptData(ctx, ctl.store, userId, params.Mac, params.Data)
 	if err != nil {
-		utils.ResponseError(utils.DNCRYPT_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.DNCRYPT_ERR, err, c)
 		return
 	}
 
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LogTableName)
-	cardColl := mgoSession.DB(config.DataBaseName).C(model.CardTableName)
-
 	cardNum := strings.TrimSpace(content)
 
-	lock := model.Lock{}
-	err = lockColl.Find(bson.M{"mac": params.Mac}).Select(bson.M{"_id": 1}).One(&lock)
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
-	updateVal := &struct {
-		Valid      bool      `bson:"valid"`
-		UpdateTime time.Time `bson:"updateTime"` // 更新时间
-	}{
-		Valid:      false,
-		UpdateTime: time.Now().Local(),
-	}
-	err = cardColl.Update(bson.M{"lock": lock.Id, "number": cardNum}, bson.M{
-		"$set": updateVal,
-	})
+	err = ctl.store.Cards.InvalidateByNumber(ctx, lock.Id, cardNum)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": params.Mac, "outcome": "deleted"}).Info("card deleted")
 
 	utils.ResponseOk("ok", c)
 }
@@ -0,0 +1,100 @@
+package controller_test
+
+import (
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+// 用挑战找回门锁
+func (c *client) reclaim(device *model.Device) *response {
+	c.srv.t.Helper()
+	token, answer := c.challenge(device)
+	return c.do(http.MethodPost, "/api/v1/lock/reclaim", gin.H{"mac": device.Mac, "challenge": token, "response": answer})
+}
+
+func TestChallengeSingleUseAndBound(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	other := srv.login("13800000002")
+	device, claimCode := srv.importDevice("AA:00:00:00:25:01")
+	nearby, _ := srv.importDevice("AA:00:00:00:25:02")
+	bind := func(token, answer string) gin.H {
+		return gin.H{"name": "front door", "desc": "home", "mac": device.Mac, "claimCode": claimCode, "challenge": token, "response": answer}
+	}
+
+	// 应答错误的挑战也作废，不能再提交正确的应答
+	token, answer := owner.challenge(device)
+	owner.do(http.MethodPost, "/api/v1/lock/info", bind(token, deviceEncrypt(t, device.Key, "not the nonce"))).expect(t, utils.UNAUTH)
+	owner.do(http.MethodPost, "/api/v1/lock/info", bind(token, answer)).expect(t, utils.INVALID)
+
+	// 挑战只对签发的用户和门锁有效
+	token, answer = owner.challenge(device)
+	other.do(http.MethodPost, "/api/v1/lock/info", bind(token, answer)).expect(t, utils.INVALID)
+	token, _ = owner.challenge(nearby)
+	owner.do(http.MethodPost, "/api/v1/lock/info", bind(token, deviceEncrypt(t, nearby.Key, "x"))).expect(t, utils.INVALID)
+	owner.do(http.MethodPost, "/api/v1/lock/info", bind("forged", answer)).expect(t, utils.INVALID)
+
+	// 被截获的应答不能再次提交
+	token, answer = owner.challenge(device)
+	owner.do(http.MethodPost, "/api/v1/lock/info", bind(token, answer)).expect(t, utils.OK)
+	other.do(http.MethodPost, "/api/v1/lock/reclaim", gin.H{"mac": device.Mac, "challenge": token, "response": answer}).expect(t, utils.INVALID)
+}
+
+func TestReclaimLock(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	tenant := srv.login("13800000003")
+	lock := owner.addLock("AA:00:00:00:25:03")
+	device := srv.device(lock.Mac)
+	owner.grant(friend, lock.Mac)
+	owner.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": friend.phone, "role": model.MemberAdmin}).expect(t, utils.OK)
+	owner.do(http.MethodPost, "/api/v1/lock/card", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, "12345678")}).expect(t, utils.OK)
+
+	unclaimed, _ := srv.importDevice("AA:00:00:00:25:04")
+	tenant.reclaim(unclaimed).expect(t, utils.INVALID)
+	owner.reclaim(device).expect(t, utils.PARAM_ERR)
+
+	transfer := model.Transfer{}
+	tenant.reclaim(device).ok(t, &transfer)
+	if transfer.Status != model.TransferReclaimed || transfer.FromId.Hex() != owner.userId || transfer.AuthsRevoked != 1 || len(transfer.WipedCards) != 1 {
+		t.Fatalf("unexpected transfer %+v", transfer)
+	}
+	open := gin.H{"mac": lock.Mac, "code": lockCode}
+	tenant.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+	owner.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+	// 原拥有者添加的管理员也一起移除
+	friend.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).expect(t, utils.NOT_EXISTS)
+	commands := map[string]string{}
+	tenant.do(http.MethodPost, "/api/v1/lock/transfer/wipe", gin.H{"transferId": transfer.Id.Hex(), "code": lockCode}).ok(t, &commands)
+	if commands["12345678"] == "" {
+		t.Fatalf("missing wipe command: %v", commands)
+	}
+
+	// 已经删除的门锁也可以找回，找回后重新启用
+	tenant.stepUp(model.StepUpLockDelete).do(http.MethodDelete, "/api/v1/lock/info", gin.H{"mac": lock.Mac}).expect(t, utils.OK)
+	owner.reclaim(device).ok(t, &transfer)
+	if reclaimed := srv.lock(lock.Id.Hex()); !reclaimed.Valid || reclaimed.Own.Hex() != owner.userId {
+		t.Fatalf("lock should be revived: %+v", reclaimed)
+	}
+}
+
+func TestReclaimRejectsOrgLock(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	tenant := srv.login("13800000002")
+	orgId := owner.createOrg("office")
+	lock := owner.inOrg(orgId).addLock("AA:00:00:00:25:05")
+	device := srv.device(lock.Mac)
+
+	tenant.reclaim(device).expect(t, utils.UNAUTH)
+	// 找回的门锁属于个人，不能在组织里找回
+	owner.inOrg(orgId).reclaim(device).expect(t, utils.PARAM_ERR)
+	if own := srv.lock(lock.Id.Hex()).Own.Hex(); own != owner.userId {
+		t.Fatalf("lock owner %s, expect %s", own, owner.userId)
+	}
+}
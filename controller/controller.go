@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"ezlock/common/sms"
+	"ezlock/identity"
+	"ezlock/store"
+)
+
+// 所有接口的处理器，数据访问通过注入的 Store 完成，方便替换成内存实现做测试
+type Controller struct {
+	store     *store.Store
+	smsCodes  *identity.SmsProvider
+	providers map[string]identity.Provider
+	verifiers map[string]identity.Verifier
+}
+
+// sender 用于发送短信登录和二次验证的验证码
+func New(s *store.Store, sender sms.Sender) *Controller {
+	smsCodes := identity.NewSms(s, sender)
+	passwords := identity.NewPassword(s)
+	wechat := identity.NewWechat(s)
+	return &Controller{
+		store:    s,
+		smsCodes: smsCodes,
+		providers: map[string]identity.Provider{
+			identity.Wechat:   wechat,
+			identity.Sms:      smsCodes,
+			identity.Password: passwords,
+		},
+		// 二次验证不区分是否开启了对应的登录方式
+		verifiers: map[string]identity.Verifier{
+			identity.Wechat:   wechat,
+			identity.Sms:      smsCodes,
+			identity.Password: passwords,
+		},
+	}
+}
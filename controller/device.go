@@ -0,0 +1,440 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"ezlock/common/logger"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"strings"
+	"time"
+)
+
+// 一次最多导入的设备数量
+const maxDeviceImport = 1000
+
+// 导入的一台设备，csv 每行依次是 mac、序列号和型号
+type DeviceRow struct {
+	Mac    string `json:"mac"`
+	Serial string `json:"serial"`
+	Model  string `json:"model"`
+}
+
+// 导入设备的结果，密钥和认领码只在导入的时候返回这一次，需要写入门锁和打印在包装盒上
+type DeviceImportResult struct {
+	DeviceRow
+	Key       string `json:"key,omitempty"`
+	ClaimCode string `json:"claimCode,omitempty"`
+	Error     string `json:"error,omitempty"` // 导入失败的原因，其他设备不受影响
+}
+
+// 从请求里读取要导入的设备，Content-Type 为 text/csv 的时候按 csv 解析，第一行是 mac 的时候当作表头跳过，否则按 json 解析
+func readDeviceRows(c *gin.Context) ([]DeviceRow, bool) {
+	if c.ContentType() != "text/csv" {
+		params := &struct {
+			Devices []DeviceRow `json:"devices" binding:"required"`
+		}{}
+		if ok := utils.CheckParam(params, c); !ok {
+			return nil, false
+		}
+		return params.Devices, true
+	}
+
+	reader := csv.NewReader(c.Request.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows := []DeviceRow{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			utils.ResponseError(utils.PARAM_ERR, fmt.Sprintf("csv 格式错误: %s", err.Error()), c)
+			return nil, false
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "mac") {
+			continue
+		}
+		if len(record) < 2 {
+			utils.ResponseError(utils.PARAM_ERR, fmt.Sprintf("csv 第 %d 行至少需要 mac 和序列号", line), c)
+			return nil, false
+		}
+		row := DeviceRow{Mac: record[0], Serial: record[1]}
+		if len(record) > 2 {
+			row.Model = record[2]
+		}
+		rows = append(rows, row)
+	}
+	return rows, true
+}
+
+// 导入工厂生产的门锁，服务端为每台设备生成加密密钥和认领码
+// 已经导入过的 mac 或者序列号不会覆盖，在结果里返回失败原因
+func (ctl *Controller) AdminImportDevices(c *gin.Context) {
+	ctx := c.Request.Context()
+	rows, ok := readDeviceRows(c)
+	if !ok {
+		return
+	}
+	if len(rows) == 0 {
+		utils.ResponseError(utils.PARAM_ERR, "没有要导入的设备", c)
+		return
+	}
+	if len(rows) > maxDeviceImport {
+		utils.ResponseError(utils.PARAM_ERR, fmt.Sprintf("一次最多导入 %d 台设备", maxDeviceImport), c)
+		return
+	}
+
+	audit, ok := ctl.auditBegin(c, "import_devices", "device", "", fmt.Sprintf("%d rows", len(rows)))
+	if !ok {
+		return
+	}
+	results := make([]DeviceImportResult, 0, len(rows))
+	imported := 0
+	for _, row := range rows {
+		row.Mac = strings.TrimSpace(row.Mac)
+		row.Serial = strings.TrimSpace(row.Serial)
+		row.Model = strings.TrimSpace(row.Model)
+		result := DeviceImportResult{DeviceRow: row}
+		if row.Mac == "" || row.Serial == "" {
+			result.Error = "mac 和序列号不能为空"
+			results = append(results, result)
+			continue
+		}
+
+		key, err := utils.NewDeviceKey()
+		if err != nil {
+			ctl.auditDone(c, audit, err)
+			utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+			return
+		}
+		claimCode, claimCodeHash, err := utils.NewClaimCode()
+		if err != nil {
+			ctl.auditDone(c, audit, err)
+			utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+			return
+		}
+		device := &model.Device{
+			Mac:           row.Mac,
+			Serial:        row.Serial,
+			Model:         row.Model,
+			Key:           key,
+			ClaimCodeHash: claimCodeHash,
+			UpdateTime:    time.Now().Local(),
+			CreateTime:    time.Now().Local(),
+		}
+		if err := ctl.store.Devices.Insert(ctx, device); err != nil {
+			if err != store.ErrDuplicate {
+				ctl.auditDone(c, audit, err)
+				utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+				return
+			}
+			result.Error = "mac 或者序列号已经导入过"
+			results = append(results, result)
+			continue
+		}
+		result.Key = key
+		result.ClaimCode = claimCode
+		results = append(results, result)
+		imported++
+	}
+
+	ctl.auditDone(c, audit, nil)
+	logger.Ctx(ctx).WithFields(logger.Fields{"imported": imported, "failed": len(rows) - imported}).Info("devices imported")
+	utils.ResponseOk(results, c)
+}
+
+// 重新生成还没有认领的设备的认领码，包装盒丢失或者认领码输错次数用完的时候使用，原来的认领码失效
+func (ctl *Controller) AdminResetClaimCode(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac    string `form:"mac" json:"mac" binding:"required"`
+		Reason string `form:"reason" json:"reason" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	device, err := ctl.store.Devices.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if device.Claimed() {
+		utils.ResponseError(utils.INVALID, "设备已经被认领", c)
+		return
+	}
+
+	claimCode, claimCodeHash, err := utils.NewClaimCode()
+	if err != nil {
+		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		return
+	}
+	audit, ok := ctl.auditBegin(c, "reset_claim_code", "device", params.Mac, params.Reason)
+	if !ok {
+		return
+	}
+	err = ctl.store.Devices.SetClaimCode(ctx, device.Id, claimCodeHash)
+	ctl.auditDone(c, audit, err)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk(DeviceImportResult{
+		DeviceRow: DeviceRow{Mac: device.Mac, Serial: device.Serial, Model: device.Model},
+		ClaimCode: claimCode,
+	}, c)
+}
+
+// 门锁挑战，小程序通过蓝牙把随机数发给门锁，门锁用出厂密钥加密后返回，绑定或者找回门锁的时候和 token 一起提交
+type DeviceChallenge struct {
+	Token  string    `json:"token"`
+	Nonce  string    `json:"nonce"`  // 需要门锁加密的随机数
+	Expire time.Time `json:"expire"` // 过期以后需要重新获取
+}
+
+// 获取出厂设备，设备没有导入过的时候返回错误
+func (ctl *Controller) getDevice(c *gin.Context, mac string) (*model.Device, bool) {
+	device, err := ctl.store.Devices.GetByMac(c.Request.Context(), mac)
+	if err == store.ErrNotFound {
+		utils.ResponseError(utils.NOT_EXISTS, "没有找到这个门锁的出厂信息", c)
+		return nil, false
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return nil, false
+	}
+	return device, true
+}
+
+// 签发绑定或者找回门锁使用的挑战，只对当前用户和这把门锁有效，只能提交一次应答
+func (ctl *Controller) IssueDeviceChallenge(c *gin.Context) {
+	userId := c.GetString("id")
+	params := &struct {
+		Mac string `form:"mac" json:"mac" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	device, ok := ctl.getDevice(c, params.Mac)
+	if !ok {
+		return
+	}
+	challenge := &model.DeviceChallenge{
+		Id:         primitive.NewObjectID(),
+		UserId:     utils.ObjectIdHex(userId),
+		Mac:        device.Mac,
+		CreateTime: time.Now().Local(),
+	}
+	token, nonce, expire, err := middleware.CreateDeviceChallenge(challenge.Id.Hex(), userId, device.Mac)
+	if err != nil {
+		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		return
+	}
+	challenge.ExpireTime = expire.Local()
+	if err := ctl.store.Challenges.Insert(c.Request.Context(), challenge); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk(DeviceChallenge{Token: token, Nonce: nonce, Expire: expire}, c)
+}
+
+// 检查门锁对挑战的应答，应答是门锁用出厂密钥加密随机数以后 base64 编码的结果，格式和门锁上传的数据相同
+// 门锁只在按下内侧的配对键以后应答挑战，验证通过说明用户就在门锁旁边
+// 检查应答之前先把挑战标记为已经使用，不管应答对不对，截获的应答都不能再次提交
+func (ctl *Controller) checkDeviceResponse(c *gin.Context, device *model.Device, challenge, response string) bool {
+	ctx := c.Request.Context()
+	challengeId, nonce, ok := middleware.ParseDeviceChallenge(challenge, c.GetString("id"), device.Mac)
+	if !ok || !primitive.IsValidObjectID(challengeId) {
+		utils.ResponseError(utils.INVALID, "门锁挑战已经失效，请重新获取", c)
+		return false
+	}
+	err := ctl.store.Challenges.Use(ctx, utils.ObjectIdHex(challengeId), utils.ObjectIdHex(c.GetString("id")), device.Mac)
+	if err == store.ErrNotFound {
+		utils.ResponseError(utils.INVALID, "门锁挑战已经失效，请重新获取", c)
+		return false
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return false
+	}
+	content, err := utils.Dncrypt(response, []byte(device.Key))
+	if err != nil || subtle.ConstantTimeCompare([]byte(content), []byte(nonce)) != 1 {
+		logger.Ctx(ctx).WithFields(logger.Fields{
+			"userId": c.GetString("id"), "mac": device.Mac, "outcome": "wrong_response",
+		}).Warn("device response rejected")
+		utils.ResponseError(utils.UNAUTH, "门锁应答验证失败，请靠近门锁重试", c)
+		return false
+	}
+	return true
+}
+
+// 检查绑定门锁时提供的认领码，认领码错误的时候累加输错次数
+func (ctl *Controller) checkClaimCode(c *gin.Context, device *model.Device, claimCode string) bool {
+	ctx := c.Request.Context()
+	if device.ClaimLocked() {
+		utils.ResponseError(utils.TOO_FREQUENT, "认领码输错次数太多，请联系客服重新生成", c)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashClaimCode(claimCode)), []byte(device.ClaimCodeHash)) != 1 {
+		if err := ctl.store.Devices.RecordFailure(ctx, device.Id); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return false
+		}
+		logger.Ctx(ctx).WithFields(logger.Fields{
+			"userId": c.GetString("id"), "mac": device.Mac, "attempts": device.Attempts + 1, "outcome": "wrong_claim_code",
+		}).Warn("lock claim rejected")
+		utils.ResponseError(utils.PARAM_ERR, "认领码不正确", c)
+		return false
+	}
+	return true
+}
+
+// 在门锁旁边找回已经被绑定的门锁，原拥有者联系不上的时候新的住户使用，不需要认领码和原拥有者同意
+// 和接受转让一样撤销门锁上所有的授权、删除门卡、移除成员，并记录一条找回的转让记录，原拥有者可以在转让记录里看到
+// 删除的门卡需要通过 WipeTransferCards 获取删除指令写入门锁，组织的门锁由组织的管理员处理，不能找回
+// 已经删除的门锁也可以找回，包括原来属于组织的，找回后重新启用
+func (ctl *Controller) ReclaimLock(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac       string `form:"mac" json:"mac" binding:"required"`
+		Challenge string `form:"challenge" json:"challenge" binding:"required"` // 挑战 token
+		Response  string `form:"response" json:"response" binding:"required"`   // 门锁的应答
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	// 找回的门锁归个人所有
+	if orgMemberOf(c) != nil {
+		utils.ResponseError(utils.PARAM_ERR, "找回的门锁属于个人，不能在组织里操作", c)
+		return
+	}
+	device, ok := ctl.getDevice(c, params.Mac)
+	if !ok {
+		return
+	}
+	if !device.Claimed() {
+		utils.ResponseError(utils.INVALID, "此锁还没有被绑定，请直接绑定", c)
+		return
+	}
+	if !ctl.checkDeviceResponse(c, device, params.Challenge, params.Response) {
+		return
+	}
+
+	// 门锁可能属于组织，查询的时候不按租户过滤
+	lock, err := ctl.store.Locks.Get(store.AllTenants(ctx), device.LockId)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 已经删除的门锁不再属于原来的组织，可以找回
+	if lock.Valid && !lock.OrgId.IsZero() {
+		utils.ResponseError(utils.UNAUTH, "组织的门锁不能找回，请联系组织的管理员", c)
+		return
+	}
+	if lock.Valid && lock.Own.Hex() == userId {
+		utils.ResponseError(utils.PARAM_ERR, "您已经是此锁的拥有者", c)
+		return
+	}
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	fromId := lock.Own
+	transfer, membersRemoved, err := ctl.takeOverLock(ctx, device, lock, user)
+	if err == store.ErrNotFound {
+		utils.ResponseError(utils.INVALID, "门锁的拥有者已经变化，请重试", c)
+		return
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "mac": lock.Mac, "fromId": fromId.Hex(), "authsRevoked": transfer.AuthsRevoked,
+		"cardsWiped": len(transfer.WipedCards), "membersRemoved": membersRemoved, "outcome": "reclaimed",
+	}).Info("lock reclaimed")
+	utils.ResponseOk(transfer, c)
+}
+
+// 把设备对应的门锁交给 user，找回门锁和重新绑定已经删除的门锁的时候使用
+// 有效的门锁修改拥有者，已经删除的门锁按照 lock 里的名称和描述重新启用，拥有者已经变化的时候返回 ErrNotFound
+// 删除门锁的时候没有清理授权、门卡和成员，先换拥有者再全部撤销，不撤销的话原来的授权还能继续使用
+// 记录一条找回的转让记录，删除的门卡通过 WipeTransferCards 获取删除指令写入门锁，返回转让记录和移除的成员数量
+// 中途失败的时候恢复原来的拥有者或者重新删除门锁
+func (ctl *Controller) takeOverLock(ctx context.Context, device *model.Device, lock *model.Lock, user *model.User) (*model.Transfer, int, error) {
+	fromId := lock.Own
+	revived := !lock.Valid
+	var err error
+	if revived {
+		lock.Own = user.Id
+		err = ctl.store.Locks.Revive(ctx, lock)
+	} else {
+		err = ctl.store.Locks.SetOwner(ctx, lock.Id, fromId, user.Id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	transfer, membersRemoved, err := ctl.revokeTakenOverLock(ctx, device, lock, fromId, user)
+	if err != nil {
+		var restoreErr error
+		if revived {
+			restoreErr = ctl.store.Locks.Invalidate(ctx, lock.Mac, user.Id)
+		} else {
+			restoreErr = ctl.store.Locks.SetOwner(ctx, lock.Id, user.Id, fromId)
+		}
+		if restoreErr != nil {
+			logger.Ctx(ctx).WithFields(logger.Fields{"mac": lock.Mac}).Errorf("restore lock owner failed: %s", restoreErr.Error())
+		}
+		return nil, 0, err
+	}
+	return transfer, membersRemoved, nil
+}
+
+// 门锁已经换了拥有者以后更新设备的认领用户，撤销原来的成员、授权和门卡，并记录转让
+func (ctl *Controller) revokeTakenOverLock(ctx context.Context, device *model.Device, lock *model.Lock, fromId primitive.ObjectID, user *model.User) (*model.Transfer, int, error) {
+	if err := ctl.store.Devices.Reclaim(ctx, device.Id, user.Id); err != nil {
+		return nil, 0, err
+	}
+	// 成员、授权和门卡可能属于门锁原来的组织，按门锁撤销的时候不按租户过滤
+	allCtx := store.AllTenants(ctx)
+	membersRemoved, err := ctl.store.Members.DeleteByLock(allCtx, lock.Id)
+	if err != nil {
+		return nil, 0, err
+	}
+	authsRevoked, wipedCards, err := ctl.revokeLockAccess(allCtx, lock.Id)
+	if err != nil {
+		return nil, 0, err
+	}
+	now := time.Now().Local()
+	transfer := &model.Transfer{
+		Id:           primitive.NewObjectID(),
+		LockId:       lock.Id,
+		FromId:       fromId,
+		ToPhone:      user.PhoneNumber,
+		ToId:         user.Id,
+		Status:       model.TransferReclaimed,
+		AuthsRevoked: authsRevoked,
+		WipedCards:   wipedCards,
+		ExpireTime:   now,
+		UpdateTime:   now,
+		CreateTime:   now,
+	}
+	if err := ctl.store.Transfers.Insert(ctx, transfer); err != nil {
+		return nil, 0, err
+	}
+	return transfer, membersRemoved, nil
+}
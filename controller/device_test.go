@@ -0,0 +1,106 @@
+package controller_test
+
+import (
+	"context"
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+// 获取设备最新的数据
+func (s *testServer) device(mac string) *model.Device {
+	s.t.Helper()
+	device, err := s.store.Devices.GetByMac(context.Background(), mac)
+	if err != nil {
+		s.t.Fatalf("get device %s: %s", mac, err.Error())
+	}
+	return device
+}
+
+// 获取门锁最新的数据，不按租户过滤
+func (s *testServer) lock(id string) *model.Lock {
+	s.t.Helper()
+	lock, err := s.store.Locks.Get(store.AllTenants(context.Background()), utils.ObjectIdHex(id))
+	if err != nil {
+		s.t.Fatalf("get lock %s: %s", id, err.Error())
+	}
+	return lock
+}
+
+func TestClaimCodeAttemptsAndReset(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	admin := srv.login("13800000002")
+	device, claimCode := srv.importDevice("AA:00:00:00:24:01")
+
+	// 没有认领码或者没有导入过的门锁都不能绑定
+	owner.do(http.MethodPost, "/api/v1/lock/info", gin.H{"name": "front door", "desc": "home", "mac": device.Mac}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodPost, "/api/v1/lock/challenge", gin.H{"mac": "AA:00:00:00:24:99"}).expect(t, utils.NOT_EXISTS)
+
+	for i := 1; i <= model.DeviceMaxClaimAttempts; i++ {
+		owner.bindLock(device, "WRONG-CODE").expect(t, utils.PARAM_ERR)
+		if attempts := srv.device(device.Mac).Attempts; attempts != i {
+			t.Fatalf("expect %d attempts, got %d", i, attempts)
+		}
+	}
+	// 输错次数用完以后正确的认领码也不能绑定
+	owner.bindLock(device, claimCode).expect(t, utils.TOO_FREQUENT)
+
+	if err := srv.store.Users.SetRole(context.Background(), utils.ObjectIdHex(admin.userId), model.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	admin = srv.login(admin.phone)
+	reset := controller.DeviceImportResult{}
+	admin.do(http.MethodPost, "/admin/v1/device/claim_code", gin.H{"mac": device.Mac, "reason": "box lost"}).ok(t, &reset)
+	if reset.ClaimCode == "" || reset.ClaimCode == claimCode {
+		t.Fatalf("claim code not reset: %+v", reset)
+	}
+	owner.bindLock(device, claimCode).expect(t, utils.PARAM_ERR)
+	owner.bindLock(device, reset.ClaimCode).expect(t, utils.OK)
+	if d := srv.device(device.Mac); !d.Claimed() || d.Attempts != 0 {
+		t.Fatalf("unexpected device %+v", d)
+	}
+	// 已经认领的设备不能再重新生成认领码
+	admin.do(http.MethodPost, "/admin/v1/device/claim_code", gin.H{"mac": device.Mac, "reason": "box lost"}).expect(t, utils.INVALID)
+}
+
+func TestClaimedLockRebindAfterDelete(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	tenant := srv.login("13800000003")
+	device, claimCode := srv.importDevice("AA:00:00:00:24:02")
+	owner.bindLock(device, claimCode).expect(t, utils.OK)
+	lock := srv.lock(srv.device(device.Mac).LockId.Hex())
+	owner.grant(friend, lock.Mac)
+	owner.do(http.MethodPost, "/api/v1/lock/card", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, device.Key, "12345678")}).expect(t, utils.OK)
+
+	// 知道认领码也不能绑定别人正在使用的门锁
+	tenant.bindLock(device, claimCode).expect(t, utils.INVALID)
+
+	owner.stepUp(model.StepUpLockDelete).do(http.MethodDelete, "/api/v1/lock/info", gin.H{"mac": lock.Mac}).expect(t, utils.OK)
+	tenant.bindLock(device, claimCode).expect(t, utils.OK)
+	if rebound := srv.lock(lock.Id.Hex()); !rebound.Valid || rebound.Own.Hex() != tenant.userId {
+		t.Fatalf("lock should be rebound: %+v", rebound)
+	}
+
+	// 原来的授权在重新绑定的时候撤销
+	open := gin.H{"mac": lock.Mac, "code": lockCode}
+	tenant.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+	owner.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+
+	transfers := []controller.TransferDetail{}
+	owner.do(http.MethodGet, "/api/v1/lock/transfer/list", nil).ok(t, &transfers)
+	if len(transfers) != 1 || transfers[0].Status != model.TransferReclaimed || transfers[0].AuthsRevoked != 1 {
+		t.Fatalf("unexpected transfers %+v", transfers)
+	}
+	// 门锁里原来的门卡由新的拥有者写入删除指令
+	if len(transfers[0].WipedCards) != 1 || transfers[0].WipedCards[0] != "12345678" {
+		t.Fatalf("unexpected wiped cards %v", transfers[0].WipedCards)
+	}
+}
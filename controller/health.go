@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"ezlock/common/health"
+	"ezlock/common/version"
+	"ezlock/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// 进程启动时间
+var startTime = time.Now()
+
+// 存活检查，进程能响应就返回200，不检查任何依赖，失败的时候编排系统会重启进程
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "alive",
+		"components": gin.H{
+			"process": gin.H{
+				"status":     health.StatusUp,
+				"uptime":     time.Since(startTime).Round(time.Second).String(),
+				"goroutines": runtime.NumGoroutine(),
+			},
+		},
+	})
+}
+
+// 就绪检查，任何一个依赖不可用的时候返回503，方便负载均衡摘掉流量
+func Readyz(checks []health.Check) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := time.Duration(config.Get().Store.OperationTimeout) * time.Second
+		ok, components := health.Run(c.Request.Context(), timeout, checks)
+		if !ok {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "components": components})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "components": components})
+	}
+}
+
+// 构建版本信息
+func Version(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Get())
+}
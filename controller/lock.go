@@ -1,57 +1,53 @@
 package controller
 
 import (
-	"ezlock/common/mongo"
+	"ezlock/common/logger"
 	"ezlock/config"
 	"ezlock/model"
+	"ezlock/store"
 	"ezlock/utils"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
-func GetDefaultLock(c *gin.Context) {
+func (ctl *Controller) GetDefaultLock(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
-
-	user := model.User{}
-	err := userColl.FindId(userId).Select(bson.M{"_id": 0, "defaultLock": 1}).One(&user)
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
 	// 用户没有默认锁，注意 加锁的时候判断用户有没有默认锁，没有以加入的第一把锁作为默认锁
-	if len(user.DefaultLock.Hex()) == 0 {
+	if user.DefaultLock.IsZero() {
 		utils.ResponseError(utils.NOT_EXISTS, "您没有可用使用的门锁", c)
 		return
 	}
 	defaultLockId := user.DefaultLock
 
-	// 响应给用户的结构
-	resp := &struct {
-		Name  string `json:"name" bson:"name"`
-		Mac   string `json:"mac" bson:"mac"`
-		Valid bool   `json:"valid" bson:"valid"` // valid字段表示这个默认锁是否有效，比如用户将授权锁作为自己的默认锁，授权过期，则这个字段就是false
-	}{}
-	err = lockColl.FindId(defaultLockId).Select(bson.M{"_id": 0, "name": 1, "mac": 1}).One(resp)
+	defaultLock, err := ctl.store.Locks.Get(ctx, defaultLockId)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	// 响应给用户的结构
+	resp := &struct {
+		Name  string `json:"name"`
+		Mac   string `json:"mac"`
+		Valid bool   `json:"valid"` // valid字段表示这个默认锁是否有效，比如用户将授权锁作为自己的默认锁，授权过期，则这个字段就是false
+	}{
+		Name: defaultLock.Name,
+		Mac:  defaultLock.Mac,
+	}
 
 	// 判断默认锁是否在可用锁列表里面，不在 则默认锁已失效
-	locks, err := utils.GetAllLocks(userId, true, model.Perms{})
+	locks, err := utils.GetAllLocks(ctx, ctl.store, userId, true, model.Perms{})
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 	if _, ok := locks[defaultLockId]; ok {
@@ -60,33 +56,28 @@ func GetDefaultLock(c *gin.Context) {
 	utils.ResponseOk(resp, c)
 }
 
-func SetDefaultLock(c *gin.Context) {
+func (ctl *Controller) SetDefaultLock(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		Mac string `form:"mac" binding:"required"`
 	}{}
-	// 看设置的默认锁是否在用户所有的锁的列表中，即使锁无效也可用设置为默认锁 防止是时段授权
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
 
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
-	q := bson.M{
-		"mac": params.Mac,
+	if ok := utils.CheckParam(params, c); !ok {
+		return
 	}
-	defaultLock := model.Lock{}
-	err := lockColl.Find(q).Select(bson.M{"_id": 1}).One(&defaultLock)
+	// 看设置的默认锁是否在用户所有的锁的列表中，即使锁无效也可用设置为默认锁 防止是时段授权
+	defaultLock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 	defaultLockId := defaultLock.Id
 
 	// 判断默认锁是否在可用锁列表里面，不在 则默认锁已失效
-	locks, err := utils.GetAllLocks(userId, false, model.Perms{})
+	locks, err := utils.GetAllLocks(ctx, ctl.store, userId, false, model.Perms{})
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 	// 要设置的门锁，不在用户所有的锁的列表中，则不让设置
@@ -96,21 +87,16 @@ func SetDefaultLock(c *gin.Context) {
 	}
 
 	// 设置默认锁
-	updateVal := bson.M{
-		"defaultLock": defaultLockId,
-		"updateTime":  time.Now().Local(),
-	}
-	if err := userColl.UpdateId(userId, bson.M{
-		"$set": updateVal,
-	}); err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	if err := ctl.store.Users.SetDefaultLock(ctx, utils.ObjectIdHex(userId), defaultLockId); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 	utils.ResponseOk("ok", c)
 }
 
-func GetOpenLockKey(c *gin.Context) {
+func (ctl *Controller) GetOpenLockKey(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// 请求参数列表
 	params := &struct {
 		Code string `form:"code" binding:"len=16,required"`
@@ -120,18 +106,18 @@ func GetOpenLockKey(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	key, err := utils.GenerateKey(userId, params.Mac, config.OpenLock, params.Code)
+	key, err := utils.GenerateKey(ctx, ctl.store, userId, params.Mac, config.Get().Command.OpenLock, params.Code)
 	if err != nil {
-		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.ENCRYPT_ERR, err, c)
 		return
 	}
-	// 获取mongo的操作session
 
 	utils.ResponseOk(key, c)
 }
 
-func GetLockList(c *gin.Context) {
+func (ctl *Controller) GetLockList(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		ShowValid bool `form:"showValid"`
 	}{}
@@ -139,77 +125,154 @@ func GetLockList(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
 
-	locks, err := utils.GetAllLocks(userId, params.ShowValid, model.Perms{})
+	locks, err := utils.GetAllLocks(ctx, ctl.store, userId, params.ShowValid, model.Perms{})
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 
-	lockIds := make([]bson.ObjectId, len(locks))
+	lockIds := make([]primitive.ObjectID, 0, len(locks))
 	for key := range locks {
 		lockIds = append(lockIds, key)
 	}
 
-	q := bson.M{
-		"_id": bson.M{"$in": lockIds},
-	}
-	resp := []model.Lock{}
-	// 响应给用户的结构
-	err = lockColl.Find(q).Select(bson.M{"key": 0, "own": 0}).All(&resp)
+	resp, err := ctl.store.Locks.FindByIds(ctx, lockIds)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	// 密钥和拥有者不响应给用户
+	for index := range resp {
+		resp[index].Key = ""
+		resp[index].Own = primitive.NilObjectID
+	}
 	utils.ResponseOk(resp, c)
 }
 
-func AddLock(c *gin.Context) {
+func (ctl *Controller) AddLock(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
-		Name    string `form:"name" binding:"required"` // 锁名称
-		Desc    string `form:"desc" binding:"required"` // 锁的描述信息
-		Mac     string `form:"mac" binding:"required"`
-		Model   string `form:"model"`                  // 硬件型号
-		Version string `form:"version"`                // 软件版本
-		Key     string `form:"key" binding:"required"` // 开锁AES密钥
+		Name      string `form:"name" binding:"required"` // 锁名称
+		Desc      string `form:"desc" binding:"required"` // 锁的描述信息
+		Mac       string `form:"mac" binding:"required"`
+		Version   string `form:"version"`                      // 软件版本
+		ClaimCode string `form:"claimCode" binding:"required"` // 包装盒上的认领码，型号和密钥使用出厂导入的
+		Challenge string `form:"challenge" binding:"required"` // 门锁挑战 token
+		Response  string `form:"response" binding:"required"`  // 门锁对挑战的应答
 
 	}{}
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	// 在组织里绑定的门锁属于这个组织，只有组织的创建者和管理员可以绑定
+	if member := orgMemberOf(c); member != nil && !member.CanManageLocks() {
+		utils.ResponseError(utils.UNAUTH, "只有组织的创建者和管理员可以绑定门锁", c)
+		return
+	}
+	device, ok := ctl.getDevice(c, params.Mac)
+	if !ok {
+		return
+	}
+	// 删除门锁只做逻辑删除，设备还指向原来的门锁，重新绑定的时候启用原来的门锁记录
+	var deleted *model.Lock
+	if device.Claimed() {
+		lock, err := ctl.store.Locks.Get(store.AllTenants(ctx), device.LockId)
+		if err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		if lock.Valid {
+			utils.ResponseError(utils.INVALID, "此锁已经被绑定，原拥有者联系不上的时候可以在门锁旁边找回", c)
+			return
+		}
+		deleted = lock
+	}
+	// 先验证门锁的应答，不在门锁旁边的时候不能尝试认领码
+	if !ctl.checkDeviceResponse(c, device, params.Challenge, params.Response) {
+		return
+	}
+	if !ctl.checkClaimCode(c, device, params.ClaimCode) {
+		return
+	}
+	if deleted != nil {
+		ctl.rebindLock(c, device, deleted, params.Name, params.Desc, params.Version)
+		return
+	}
 
 	newLock := model.Lock{
 		Name:       params.Name,
 		Desc:       params.Desc,
-		Mac:        params.Mac,
-		Model:      params.Model,
+		Mac:        device.Mac,
+		Model:      device.Model,
 		Version:    params.Version,
-		Key:        params.Key,
+		Key:        device.Key,
 		Valid:      true,
-		Own:        bson.ObjectIdHex(userId),
+		Own:        utils.ObjectIdHex(userId),
 		UpdateTime: time.Now().Local(),
 		CreateTime: time.Now().Local(),
 	}
-	err := lockColl.Insert(&newLock)
+	err := ctl.store.Locks.Insert(ctx, &newLock)
 	if err != nil {
-		utils.ResponseError(utils.PARAM_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.PARAM_ERR, err, c)
 		return
 	}
+	// 认领是带条件的更新，同一台设备并发绑定的时候只有一个能成功，认领失败的时候删除刚插入的门锁
+	// 设备的 lock_id 外键指向门锁，所以先插入门锁再认领
+	if err := ctl.store.Devices.Claim(ctx, device.Id, newLock.Id, newLock.Own); err != nil {
+		if err := ctl.store.Locks.Delete(ctx, newLock.Id); err != nil {
+			logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": params.Mac}).Errorf("rollback lock failed: %s", err.Error())
+		}
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.INVALID, "此锁已经被绑定，原拥有者联系不上的时候可以在门锁旁边找回", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": params.Mac, "outcome": "added"}).Info("lock added")
+	utils.ResponseOk("ok", c)
+}
+
+// 重新绑定已经删除的门锁，原来的授权、门卡和成员全部清除，记录一条找回的转让记录
+// 门锁里原来的门卡通过 WipeTransferCards 获取删除指令
+func (ctl *Controller) rebindLock(c *gin.Context, device *model.Device, lock *model.Lock, name, desc, version string) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	fromId := lock.Own
+	lock.Name = name
+	lock.Desc = desc
+	lock.Version = version
+	transfer, membersRemoved, err := ctl.takeOverLock(ctx, device, lock, user)
+	if err == store.ErrNotFound {
+		utils.ResponseError(utils.INVALID, "此锁已经被绑定，原拥有者联系不上的时候可以在门锁旁边找回", c)
+		return
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "mac": lock.Mac, "fromId": fromId.Hex(), "authsRevoked": transfer.AuthsRevoked,
+		"cardsWiped": len(transfer.WipedCards), "membersRemoved": membersRemoved, "outcome": "rebound",
+	}).Info("lock added")
 	utils.ResponseOk("ok", c)
 }
 
-func UpdateLock(c *gin.Context) {
+func (ctl *Controller) UpdateLock(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// 请求参数列表
 	params := &struct {
 		Name string `form:"name"`
-		Mac  string `binding:"required"`
+		Mac  string `form:"mac" binding:"required"`
 		Desc string `form:"desc"`
 	}{}
 
@@ -217,29 +280,24 @@ func UpdateLock(c *gin.Context) {
 		return
 	}
 
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-
-	updateVal := &struct {
-		Name       string    `bson:"name,omitempty"`
-		Desc       string    `bson:"desc,omitempty"`
-		UpdateTime time.Time `bson:"updateTime"` // 更新时间
-	}{
-		UpdateTime: time.Now().Local(),
+	// 拥有者和管理员可以修改没有被删除的锁
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	canManage, err := utils.CanManageLock(ctx, ctl.store, userId, lock)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
 	}
-	// 只可以修改属于自己的并且没有被删除的锁
-	if err := lockColl.Update(bson.M{
-		"mac":   params.Mac,
-		"own":   bson.ObjectIdHex(userId),
-		"valid": true,
-	}, bson.M{
-		"$set": updateVal,
-	}); err != nil {
-		if err != mgo.ErrNotFound {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	if !canManage {
+		utils.ResponseError(utils.NOT_EXISTS, "您无权修改此锁或者此锁已经被删除", c)
+		return
+	}
+	if err := ctl.store.Locks.UpdateInfo(ctx, params.Mac, lock.Own, params.Name, params.Desc); err != nil {
+		if err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 			return
 		}
 		utils.ResponseError(utils.NOT_EXISTS, "此锁不属于您或者已经被删除", c)
@@ -249,35 +307,23 @@ func UpdateLock(c *gin.Context) {
 	utils.ResponseOk(fmt.Sprintf("lock[%s] update success", params.Mac), c)
 }
 
-func DeleteLock(c *gin.Context) {
+func (ctl *Controller) DeleteLock(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// 请求参数列表
 	params := &struct {
-		Mac string `binding:"required"`
+		Mac string `form:"mac" binding:"required"`
 	}{}
 
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-
-	updateVal := &struct {
-		Valid      bool      `bson:"valid"`
-		UpdateTime time.Time `bson:"updateTime"` // 更新时间
-	}{
-		Valid:      false,
-		UpdateTime: time.Now().Local(),
+	if ok := utils.CheckParam(params, c); !ok {
+		return
 	}
 
-	if err := lockColl.Update(bson.M{
-		"mac": params.Mac,
-		"own": bson.ObjectIdHex(userId),
-	}, bson.M{
-		"$set": updateVal,
-	}); err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+	if err := ctl.store.Locks.Invalidate(ctx, params.Mac, utils.ObjectIdHex(userId)); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": params.Mac, "outcome": "deleted"}).Info("lock deleted")
 
 	utils.ResponseOk(fmt.Sprintf("lock[%s] delete success", params.Mac), c)
 }
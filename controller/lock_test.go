@@ -0,0 +1,83 @@
+package controller_test
+
+import (
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestGrantOpensLockUntilRevoked(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	stranger := srv.login("13800000003")
+	lock := owner.addLock("AA:00:00:00:01:01")
+
+	var token string
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1"}).ok(t, &token)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.OK)
+	// 授权只能被领取一次
+	stranger.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.INVALID)
+
+	open := gin.H{"mac": lock.Mac, "code": lockCode}
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+	stranger.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+
+	// 撤销后不能再获取开锁密钥
+	owner.stepUp(model.StepUpAuthRevoke).do(http.MethodPost, "/api/v1/auth/revoke", gin.H{"authId": token}).expect(t, utils.OK)
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+	owner.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+}
+
+func TestCardAddAndDelete(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	lock := owner.addLock("AA:00:00:00:01:03")
+
+	owner.do(http.MethodPost, "/api/v1/lock/card/add", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.OK)
+	card := gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, "12345678")}
+	owner.do(http.MethodPost, "/api/v1/lock/card", card).expect(t, utils.OK)
+	// 同一张卡不能重复添加
+	owner.do(http.MethodPost, "/api/v1/lock/card", card).expect(t, utils.PARAM_ERR)
+	// 数据不是用门锁的密钥加密的
+	owner.do(http.MethodPost, "/api/v1/lock/card", gin.H{"mac": lock.Mac, "data": "bm90IGVuY3J5cHRlZA=="}).expect(t, utils.DNCRYPT_ERR)
+
+	cards := []model.Card{}
+	owner.do(http.MethodGet, "/api/v1/lock/card", gin.H{"mac": lock.Mac}).ok(t, &cards)
+	if len(cards) != 1 || cards[0].Number != "12345678" {
+		t.Fatalf("unexpected cards %+v", cards)
+	}
+
+	owner.stepUp(model.StepUpCardDelete).do(http.MethodDelete, "/api/v1/lock/card", card).expect(t, utils.OK)
+	owner.do(http.MethodGet, "/api/v1/lock/card", gin.H{"mac": lock.Mac}).ok(t, &cards)
+	if len(cards) != 0 {
+		t.Fatalf("card not deleted: %+v", cards)
+	}
+}
+
+func TestLockLogUploadAndView(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	lock := owner.addLock("AA:00:00:00:01:04")
+
+	var token string
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1"}).ok(t, &token)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.OK)
+
+	// 一条蓝牙开锁、一条刷卡开锁，最后一条格式错误被丢弃
+	content := fmt.Sprintf("open_2024-05-01 10:00_1_%s_1,open_2024-05-01 11:00_0_12345678_0,broken", friend.userId)
+	friend.do(http.MethodPost, "/api/v1/lock/log", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, content)}).expect(t, utils.OK)
+
+	logs := []controller.LogDetail{}
+	owner.do(http.MethodGet, "/api/v1/lock/log", gin.H{"mac": lock.Mac}).ok(t, &logs)
+	if len(logs) != 2 {
+		t.Fatalf("expect 2 logs, got %d", len(logs))
+	}
+	// 授权里没有查看日志的权限
+	friend.do(http.MethodGet, "/api/v1/lock/log", gin.H{"mac": lock.Mac}).expect(t, utils.NOT_EXISTS)
+}
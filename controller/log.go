@@ -1,18 +1,23 @@
 package controller
 
 import (
-	"ezlock/common/mongo"
+	"encoding/json"
+	"ezlock/common/logger"
+	"ezlock/common/metrics"
 	"ezlock/config"
 	"ezlock/model"
 	"ezlock/utils"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
 	"strings"
 	"time"
 )
 
-func GetLogKey(c *gin.Context) {
+func (ctl *Controller) GetLogKey(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	// 请求参数列表
 	params := &struct {
 		Code string `form:"code" binding:"len=16,required"`
@@ -22,9 +27,9 @@ func GetLogKey(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	key, err := utils.GenerateKey(userId, params.Mac, config.GetLog, params.Code)
+	key, err := utils.GenerateKey(ctx, ctl.store, userId, params.Mac, config.Get().Command.GetLog, params.Code)
 	if err != nil {
-		utils.ResponseError(utils.ENCRYPT_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.ENCRYPT_ERR, err, c)
 		return
 	}
 
@@ -33,12 +38,11 @@ func GetLogKey(c *gin.Context) {
 
 type LogDetail struct {
 	model.Log
-	User   string        `json:"user"`
-	LockId bson.ObjectId `json:"-"` // 被授权的门锁id
+	User   string             `json:"user"`
+	LockId primitive.ObjectID `json:"-"` // 被授权的门锁id
 }
 
-func GetLockOperateLog(c *gin.Context) {
-	userId := c.GetString("id")
+func (ctl *Controller) GetLockOperateLog(c *gin.Context) {
 	params := &struct {
 		Mac string `form:"mac"`
 	}{}
@@ -46,62 +50,86 @@ func GetLockOperateLog(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
+	details, ok := ctl.lockLogDetails(c, params.Mac)
+	if !ok {
+		return
+	}
+	utils.ResponseOk(details, c)
+}
 
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	logColl := mgoSession.DB(config.DataBaseName).C(model.LogTableName)
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
+// 导出某一把锁的全部开锁日志，返回 json 文件，需要二次验证
+func (ctl *Controller) ExportLockLog(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac string `form:"mac" binding:"required"`
+	}{}
 
-	lock := model.Lock{}
-	err := lockColl.Find(bson.M{
-		"mac": params.Mac,
-	}).Select(bson.M{"_id": 1}).One(&lock)
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	details, ok := ctl.lockLogDetails(c, params.Mac)
+	if !ok {
+		return
+	}
+	data, err := json.MarshalIndent(details, "", "  ")
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseError(utils.EXPORT_ERR, err.Error(), c)
 		return
 	}
 
-	locks, err := utils.GetAllLocks(userId, false, model.Perms{
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": c.GetString("id"), "mac": params.Mac, "count": len(details), "outcome": "exported"}).Info("lock log exported")
+	filename := fmt.Sprintf("ezlock-log-%s-%s.json", strings.ReplaceAll(params.Mac, ":", ""), time.Now().Local().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/json", data)
+}
+
+// 获取用户有权查看的门锁的开锁日志，没有权限或者出错的时候返回错误并返回 false
+func (ctl *Controller) lockLogDetails(c *gin.Context, mac string) ([]LogDetail, bool) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+
+	lock, err := ctl.store.Locks.GetByMac(ctx, mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return nil, false
+	}
+
+	locks, err := utils.GetAllLocks(ctx, ctl.store, userId, false, model.Perms{
 		ViewLog: true,
 	})
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-		return
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return nil, false
 	}
 	// 要查看的门锁，不在用户所有的锁的列表中，则不让查看日志
 	if _, ok := locks[lock.Id]; !ok {
 		utils.ResponseError(utils.NOT_EXISTS, "您无权查看此锁的开锁日志", c)
-		return
+		return nil, false
 	}
 
-	q := bson.M{
-		"lockId": lock.Id,
-	}
-	logs := []LogDetail{}
-	err = logColl.Find(q).All(&logs)
+	logs, err := ctl.store.Logs.FindByLock(ctx, lock.Id)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-		return
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return nil, false
 	}
 
-	for index := range logs {
-		user := model.User{}
-		err = userColl.FindId(logs[index].UserId).Select(bson.M{"nickName": 1}).One(&user)
+	details := make([]LogDetail, 0, len(logs))
+	for _, log := range logs {
+		detail := LogDetail{Log: log}
+		detail.User, err = ctl.nickName(ctx, log.UserId)
 		if err != nil {
-			utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
-			return
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return nil, false
 		}
-
-		logs[index].User = user.NickName
+		details = append(details, detail)
 	}
-	utils.ResponseOk(logs, c)
+	return details, true
 }
 
 // 硬件需要对锁的日志信息做个加密防止篡改，前端小程序蓝牙链接成功后 拿到这个加密信息直接发送给后端
-func SetLockOperateLog(c *gin.Context) {
+func (ctl *Controller) SetLockOperateLog(c *gin.Context) {
 	userId := c.GetString("id")
+	ctx := c.Request.Context()
 	params := &struct {
 		Mac  string `form:"mac" binding:"required"`
 		Data string `form:"data" binding:"required"`
@@ -110,37 +138,31 @@ func SetLockOperateLog(c *gin.Context) {
 	if ok := utils.CheckParam(params, c); !ok {
 		return
 	}
-	content, err := utils.DncryptData(userId, params.Mac, params.Data)
+	content, err := utils.DncryptData(ctx, ctl.store, userId, params.Mac, params.Data)
 	if err != nil {
-		utils.ResponseError(utils.DNCRYPT_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.DNCRYPT_ERR, err, c)
 		return
 	}
 
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	logColl := mgoSession.DB(config.DataBaseName).C(model.LogTableName)
-	userColl := mgoSession.DB(config.DataBaseName).C(model.UserTableName)
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LogTableName)
-
-	lock := model.Lock{}
-	err = lockColl.Find(bson.M{"mac": params.Mac}).Select(bson.M{"_id": 1}).One(&lock)
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
 	if err != nil {
-		utils.ResponseError(utils.MONGO_ERR, err.Error(), c)
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
 		return
 	}
 	// 操作指令_时间_方式_卡号/操作用户_1,操作指令_锁的mac地址_方式_卡号/操作用户
 	opLogs := strings.Split(strings.TrimSpace(content), ",")
+	stored := 0
 	for _, opLog := range opLogs {
 		log := strings.Split(strings.TrimSpace(opLog), "_")
 		if len(log) != 5 {
 			// 日志格式错误
+			metrics.LogLineDropped("malformed")
 			continue
 		}
 		opTime, err := time.ParseInLocation("2006-01-02 15:04", strings.TrimSpace(log[1]), time.Local)
 		if err != nil {
 			// 日志格式错误
+			metrics.LogLineDropped("malformed")
 			continue
 		}
 
@@ -162,22 +184,35 @@ func SetLockOperateLog(c *gin.Context) {
 			// 门卡开锁
 		case "1":
 			// 蓝牙开锁
-			userId := info
-			user := model.User{}
-			err := userColl.FindId(userId).One(&user)
+			if !primitive.IsValidObjectID(info) {
+				// 日志格式错误
+				metrics.LogLineDropped("malformed")
+				continue
+			}
+			user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(info))
 			if err != nil {
 				// 日志格式错误
+				metrics.LogLineDropped("unknown_user")
 				continue
 			}
 			logInfo.UserId = user.Id
 
 		}
-		_, err = logColl.Upsert(bson.M{"rawInfo": strings.TrimSpace(opLog)}, logInfo)
+		err = ctl.store.Logs.Upsert(ctx, &logInfo)
 		if err != nil {
 			// 日志格式错误
+			metrics.LogLineDropped("store_error")
 			continue
 		}
+		metrics.LogLineStored()
+		stored++
 	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId":  userId,
+		"mac":     params.Mac,
+		"stored":  stored,
+		"dropped": len(opLogs) - stored,
+	}).Info("lock logs uploaded")
 
 	utils.ResponseOk("ok", c)
 }
@@ -0,0 +1,101 @@
+package controller_test
+
+import (
+	"ezlock/config"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 修改当前生效的配置
+func setConfig(modify func(cfg *config.Config)) {
+	cfg := *config.Get()
+	modify(&cfg)
+	config.Set(&cfg)
+}
+
+func TestSmsLogin(t *testing.T) {
+	srv := newServer(t)
+	first := srv.login("13800000001")
+	// 同一个手机号再次登录的是同一个用户
+	if again := srv.login("13800000001"); again.userId != first.userId {
+		t.Fatalf("expect user %s, got %s", first.userId, again.userId)
+	}
+
+	srv.request(http.MethodPost, "/sms/send_code", nil, gin.H{"phone": "12345"}).expect(t, utils.PARAM_ERR)
+	srv.request(http.MethodPost, "/sms/send_code", nil, gin.H{"phone": "13800000002"}).expect(t, utils.OK)
+	code := srv.sms.code("13800000002")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	// 输错次数达到上限之后正确的验证码也不能再使用
+	for i := 0; i < config.Get().Sms.MaxAttempts; i++ {
+		srv.request(http.MethodPost, "/login/sms", nil, gin.H{"phone": "13800000002", "code": wrong}).expect(t, utils.UNAUTH)
+	}
+	srv.request(http.MethodPost, "/login/sms", nil, gin.H{"phone": "13800000002", "code": code}).expect(t, utils.UNAUTH)
+
+	// 验证码只能使用一次
+	srv.request(http.MethodPost, "/sms/send_code", nil, gin.H{"phone": "13800000003"}).expect(t, utils.OK)
+	login := gin.H{"phone": "13800000003", "code": srv.sms.code("13800000003")}
+	srv.request(http.MethodPost, "/login/sms", nil, login).expect(t, utils.OK)
+	srv.request(http.MethodPost, "/login/sms", nil, login).expect(t, utils.UNAUTH)
+
+	setConfig(func(cfg *config.Config) { cfg.Sms.SendInterval = 60 })
+	srv.request(http.MethodPost, "/sms/send_code", nil, gin.H{"phone": "13800000003"}).expect(t, utils.TOO_FREQUENT)
+}
+
+func TestPasswordLogin(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+
+	// 第一次设置和修改密码都需要二次验证
+	owner.do(http.MethodPost, "/account/set_password", gin.H{"userName": "alice", "password": "password-1"}).expect(t, utils.STEP_UP_REQUIRED)
+	verified := owner.stepUp(model.StepUpPasswordSet)
+	verified.do(http.MethodPost, "/account/set_password", gin.H{"userName": "alice", "password": "short"}).expect(t, utils.PARAM_ERR)
+	verified.do(http.MethodPost, "/account/set_password", gin.H{"userName": "alice", "password": "password-1"}).expect(t, utils.OK)
+	verified.do(http.MethodPost, "/account/set_password", gin.H{"userName": "alice", "password": "password-2"}).expect(t, utils.OK)
+	// 用户名不能和别人重复
+	srv.login("13800000002").stepUp(model.StepUpPasswordSet).do(http.MethodPost, "/account/set_password", gin.H{"userName": "alice", "password": "password-3"}).expect(t, utils.INVALID)
+
+	srv.request(http.MethodPost, "/login/password", nil, gin.H{"userName": "alice", "password": "password-1"}).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/login/password", nil, gin.H{"userName": "alice", "password": "password-2"}).expect(t, utils.OK)
+	srv.request(http.MethodPost, "/login/password", nil, gin.H{"userName": "nobody", "password": "password-2"}).expect(t, utils.UNAUTH)
+}
+
+func TestPasswordLockout(t *testing.T) {
+	srv := newServer(t)
+	setConfig(func(cfg *config.Config) {
+		cfg.Password.MaxAttempts = 3
+		cfg.Password.LockDuration = 1
+	})
+	owner := srv.login("13800000001")
+	owner.stepUp(model.StepUpPasswordSet).do(http.MethodPost, "/account/set_password", gin.H{"userName": "alice", "password": "password-1"}).expect(t, utils.OK)
+	right := gin.H{"userName": "alice", "password": "password-1"}
+	wrong := gin.H{"userName": "alice", "password": "password-x"}
+
+	// 登录成功之后重新计数
+	srv.request(http.MethodPost, "/login/password", nil, wrong).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/login/password", nil, wrong).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/login/password", nil, right).expect(t, utils.OK)
+	srv.request(http.MethodPost, "/login/password", nil, wrong).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/login/password", nil, wrong).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/login/password", nil, wrong).expect(t, utils.UNAUTH)
+
+	// 锁定期间正确的密码也被拒绝，用密码二次验证也一样
+	srv.request(http.MethodPost, "/login/password", nil, right).expect(t, utils.TOO_FREQUENT)
+	owner.do(http.MethodPost, "/account/step_up", gin.H{"method": "password", "secret": "password-1", "scopes": []string{model.StepUpPasswordSet}}).expect(t, utils.TOO_FREQUENT)
+
+	// 不存在的用户名同样计数和锁定，响应和存在的用户一样
+	unknown := gin.H{"userName": "nobody", "password": "password-1"}
+	for i := 0; i < 3; i++ {
+		srv.request(http.MethodPost, "/login/password", nil, unknown).expect(t, utils.UNAUTH)
+	}
+	srv.request(http.MethodPost, "/login/password", nil, unknown).expect(t, utils.TOO_FREQUENT)
+
+	time.Sleep(1100 * time.Millisecond)
+	srv.request(http.MethodPost, "/login/password", nil, right).expect(t, utils.OK)
+}
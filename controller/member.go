@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"context"
+	"ezlock/common/logger"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 门锁成员和成员的昵称
+type MemberDetail struct {
+	model.Member
+	NickName string `json:"nickName"`
+}
+
+// 获取门锁和当前用户在门锁上的角色，门锁已经删除或者用户不是成员的时候返回错误
+func (ctl *Controller) getMemberLock(ctx context.Context, userId, mac string) (*model.Lock, string, int, string) {
+	lock, err := ctl.store.Locks.GetByMac(ctx, mac)
+	if err != nil {
+		return nil, "", utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	if !lock.Valid {
+		return nil, "", utils.NOT_EXISTS, "此锁已经被删除"
+	}
+	role, err := utils.LockRole(ctx, ctl.store, userId, lock)
+	if err != nil {
+		return nil, "", utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	if role == "" {
+		return nil, "", utils.NOT_EXISTS, "您不是此锁的成员"
+	}
+	return lock, role, utils.OK, ""
+}
+
+// 角色是 actor 的用户能不能添加、修改或者移除角色是 target 的成员，拥有者可以管理所有成员，管理员只能管理普通成员
+func canManageMember(actor, target string) bool {
+	return actor == model.MemberOwner || (actor == model.MemberAdmin && target == model.MemberMember)
+}
+
+// 查看门锁的拥有者和所有成员，门锁的成员都可以查看
+func (ctl *Controller) GetLockMemberList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac string `form:"mac" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	lock, _, code, msg := ctl.getMemberLock(ctx, userId, params.Mac)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	members, err := ctl.store.Members.FindByLock(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 拥有者不在成员表里，放在第一个
+	members = append([]model.Member{{
+		LockId:     lock.Id,
+		UserId:     lock.Own,
+		Role:       model.MemberOwner,
+		UpdateTime: lock.UpdateTime,
+		CreateTime: lock.CreateTime,
+	}}, members...)
+	details := make([]MemberDetail, 0, len(members))
+	for _, member := range members {
+		detail := MemberDetail{Member: member}
+		if detail.NickName, err = ctl.nickName(ctx, member.UserId); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		details = append(details, detail)
+	}
+	utils.ResponseOk(details, c)
+}
+
+// 按手机号添加门锁成员，拥有者可以添加管理员和普通成员，管理员只能添加普通成员
+func (ctl *Controller) AddLockMember(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac   string `form:"mac" json:"mac" binding:"required"`
+		Phone string `form:"phone" json:"phone" binding:"required"`
+		Role  string `form:"role" json:"role" binding:"required"` // admin 或者 member
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !model.ValidMemberRole(params.Role) {
+		utils.ResponseError(utils.PARAM_ERR, "成员角色只能是 admin 或者 member", c)
+		return
+	}
+	lock, role, code, msg := ctl.getMemberLock(ctx, userId, params.Mac)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if !canManageMember(role, params.Role) {
+		utils.ResponseError(utils.UNAUTH, "您无权添加这个角色的成员", c)
+		return
+	}
+
+	user, err := ctl.store.Users.GetByPhone(ctx, params.Phone)
+	if err == store.ErrNotFound {
+		utils.ResponseError(utils.NOT_EXISTS, "这个手机号还没有注册", c)
+		return
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if user.Id == lock.Own {
+		utils.ResponseError(utils.PARAM_ERR, "不能添加门锁的拥有者", c)
+		return
+	}
+
+	member := &model.Member{
+		Id:         primitive.NewObjectID(),
+		LockId:     lock.Id,
+		UserId:     user.Id,
+		Role:       params.Role,
+		InvitedBy:  utils.ObjectIdHex(userId),
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	if err := ctl.store.Members.Insert(ctx, member); err != nil {
+		if err == store.ErrDuplicate {
+			utils.ResponseError(utils.PARAM_ERR, "这个用户已经是门锁成员", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "mac": params.Mac, "memberId": user.Id.Hex(), "role": params.Role, "outcome": "added",
+	}).Info("lock member added")
+
+	utils.ResponseOk(MemberDetail{Member: *member, NickName: user.NickName}, c)
+}
+
+// 获取要修改或者移除的成员，memberId 不合法或者不是成员的时候返回错误
+func (ctl *Controller) getMember(ctx context.Context, lock *model.Lock, memberId string) (*model.Member, int, string) {
+	if !primitive.IsValidObjectID(memberId) {
+		return nil, utils.PARAM_ERR, "用户id不合法"
+	}
+	member, err := ctl.store.Members.Get(ctx, lock.Id, utils.ObjectIdHex(memberId))
+	if err == store.ErrNotFound {
+		return nil, utils.NOT_EXISTS, "这个用户不是门锁成员"
+	}
+	if err != nil {
+		return nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	return member, utils.OK, ""
+}
+
+// 修改成员的角色，只有拥有者可以操作
+func (ctl *Controller) SetLockMemberRole(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac    string `form:"mac" json:"mac" binding:"required"`
+		UserId string `form:"userId" json:"userId" binding:"required"`
+		Role   string `form:"role" json:"role" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !model.ValidMemberRole(params.Role) {
+		utils.ResponseError(utils.PARAM_ERR, "成员角色只能是 admin 或者 member", c)
+		return
+	}
+	lock, role, code, msg := ctl.getMemberLock(ctx, userId, params.Mac)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if role != model.MemberOwner {
+		utils.ResponseError(utils.UNAUTH, "只有门锁的拥有者可以修改成员角色", c)
+		return
+	}
+	member, code, msg := ctl.getMember(ctx, lock, params.UserId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	if err := ctl.store.Members.SetRole(ctx, lock.Id, member.UserId, params.Role); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "mac": params.Mac, "memberId": params.UserId, "role": params.Role, "outcome": "updated",
+	}).Info("lock member role updated")
+	utils.ResponseOk("ok", c)
+}
+
+// 移除成员，拥有者可以移除所有成员，管理员只能移除普通成员，成员可以自己退出
+// 成员发出的授权和添加的门卡不受影响，需要的时候单独撤销
+func (ctl *Controller) RemoveLockMember(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac    string `form:"mac" json:"mac" binding:"required"`
+		UserId string `form:"userId" json:"userId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	lock, role, code, msg := ctl.getMemberLock(ctx, userId, params.Mac)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	member, code, msg := ctl.getMember(ctx, lock, params.UserId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if params.UserId != userId && !canManageMember(role, member.Role) {
+		utils.ResponseError(utils.UNAUTH, "您无权移除这个成员", c)
+		return
+	}
+
+	if err := ctl.store.Members.Delete(ctx, lock.Id, member.UserId); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "mac": params.Mac, "memberId": params.UserId, "outcome": "removed",
+	}).Info("lock member removed")
+	utils.ResponseOk("ok", c)
+}
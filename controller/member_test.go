@@ -0,0 +1,84 @@
+package controller_test
+
+import (
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestLockMemberRoles(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	admin := srv.login("13800000002")
+	member := srv.login("13800000003")
+	other := srv.login("13800000004")
+	stranger := srv.login("13800000005")
+	lock := owner.addLock("AA:00:00:00:21:01")
+
+	owner.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": admin.phone, "role": model.MemberAdmin}).expect(t, utils.OK)
+	owner.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": admin.phone, "role": model.MemberMember}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": owner.phone, "role": model.MemberMember}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": member.phone, "role": "owner"}).expect(t, utils.PARAM_ERR)
+	stranger.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": member.phone, "role": model.MemberMember}).expect(t, utils.NOT_EXISTS)
+
+	// 管理员只能添加普通成员，不能添加管理员
+	admin.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": other.phone, "role": model.MemberAdmin}).expect(t, utils.UNAUTH)
+	admin.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": member.phone, "role": model.MemberMember}).expect(t, utils.OK)
+	admin.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": other.phone, "role": model.MemberMember}).expect(t, utils.OK)
+	// 普通成员不能添加成员
+	member.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": stranger.phone, "role": model.MemberMember}).expect(t, utils.UNAUTH)
+
+	members := []controller.MemberDetail{}
+	member.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).ok(t, &members)
+	if len(members) != 4 || members[0].Role != model.MemberOwner || members[0].UserId.Hex() != owner.userId {
+		t.Fatalf("unexpected members %+v", members)
+	}
+	stranger.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).expect(t, utils.NOT_EXISTS)
+
+	// 只有拥有者可以修改角色，管理员也不行
+	promote := gin.H{"mac": lock.Mac, "userId": member.userId, "role": model.MemberAdmin}
+	admin.do(http.MethodPut, "/api/v1/lock/member", promote).expect(t, utils.UNAUTH)
+	member.do(http.MethodPut, "/api/v1/lock/member", promote).expect(t, utils.UNAUTH)
+	owner.do(http.MethodPut, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "userId": stranger.userId, "role": model.MemberAdmin}).expect(t, utils.NOT_EXISTS)
+	owner.do(http.MethodPut, "/api/v1/lock/member", promote).expect(t, utils.OK)
+
+	// 管理员不能移除其他管理员，可以移除普通成员
+	admin.do(http.MethodDelete, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "userId": member.userId}).expect(t, utils.UNAUTH)
+	admin.do(http.MethodDelete, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "userId": other.userId}).expect(t, utils.OK)
+	other.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).expect(t, utils.NOT_EXISTS)
+
+	// 成员可以自己退出，不管角色
+	member.do(http.MethodDelete, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "userId": member.userId}).expect(t, utils.OK)
+	member.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).expect(t, utils.NOT_EXISTS)
+	admin.do(http.MethodDelete, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "userId": admin.userId}).expect(t, utils.OK)
+
+	owner.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).ok(t, &members)
+	if len(members) != 1 {
+		t.Fatalf("expect only the owner, got %+v", members)
+	}
+}
+
+func TestLockMembersRemovedOnTransfer(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	admin := srv.login("13800000002")
+	buyer := srv.login("13800000003")
+	lock := owner.addLock("AA:00:00:00:21:02")
+	owner.do(http.MethodPost, "/api/v1/lock/member", gin.H{"mac": lock.Mac, "phone": admin.phone, "role": model.MemberAdmin}).expect(t, utils.OK)
+
+	transfer := model.Transfer{}
+	owner.stepUp(model.StepUpLockTransfer).do(http.MethodPost, "/api/v1/lock/transfer", gin.H{"mac": lock.Mac, "phone": buyer.phone}).ok(t, &transfer)
+	buyer.do(http.MethodPost, "/api/v1/lock/transfer/accept", gin.H{"transferId": transfer.Id.Hex()}).expect(t, utils.OK)
+
+	// 原拥有者添加的管理员不再保留，原拥有者也不再是成员
+	admin.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).expect(t, utils.NOT_EXISTS)
+	owner.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).expect(t, utils.NOT_EXISTS)
+	members := []controller.MemberDetail{}
+	buyer.do(http.MethodGet, "/api/v1/lock/member/list", gin.H{"mac": lock.Mac}).ok(t, &members)
+	if len(members) != 1 || members[0].UserId.Hex() != buyer.userId {
+		t.Fatalf("unexpected members %+v", members)
+	}
+}
@@ -0,0 +1,356 @@
+package controller
+
+import (
+	"context"
+	"ezlock/common/logger"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 用户加入的组织和在组织里的角色
+type OrgDetail struct {
+	model.Organization
+	Role string `json:"role"`
+}
+
+// 组织成员和成员的昵称
+type OrgMemberDetail struct {
+	model.OrgMember
+	NickName string `json:"nickName"`
+}
+
+// 当前请求所在组织里的成员记录，访问个人数据的请求返回 nil
+func orgMemberOf(c *gin.Context) *model.OrgMember {
+	value, ok := c.Get(middleware.OrgMemberKey)
+	if !ok {
+		return nil
+	}
+	return value.(*model.OrgMember)
+}
+
+// 获取当前用户在组织里的成员记录，orgId 不合法或者用户不是成员的时候返回错误
+func (ctl *Controller) getOrgMember(ctx context.Context, orgId, userId string) (*model.OrgMember, int, string) {
+	if !primitive.IsValidObjectID(orgId) {
+		return nil, utils.PARAM_ERR, "组织id不合法"
+	}
+	if !primitive.IsValidObjectID(userId) {
+		return nil, utils.PARAM_ERR, "用户id不合法"
+	}
+	member, err := ctl.store.OrgMembers.Get(ctx, utils.ObjectIdHex(orgId), utils.ObjectIdHex(userId))
+	if err == store.ErrNotFound {
+		return nil, utils.NOT_EXISTS, "这个用户不是组织的成员"
+	}
+	if err != nil {
+		return nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	return member, utils.OK, ""
+}
+
+// 新建组织，创建者自动成为组织的 owner
+func (ctl *Controller) CreateOrg(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Name string `form:"name" json:"name" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	now := time.Now().Local()
+	org := &model.Organization{
+		Id:         primitive.NewObjectID(),
+		Name:       params.Name,
+		OwnerId:    utils.ObjectIdHex(userId),
+		UpdateTime: now,
+		CreateTime: now,
+	}
+	if err := ctl.store.Orgs.Insert(ctx, org); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	member := &model.OrgMember{
+		Id:         primitive.NewObjectID(),
+		OrgId:      org.Id,
+		UserId:     org.OwnerId,
+		Role:       model.OrgRoleOwner,
+		UpdateTime: now,
+		CreateTime: now,
+	}
+	if err := ctl.store.OrgMembers.Insert(ctx, member); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "orgId": org.Id.Hex(), "outcome": "created"}).Info("organization created")
+
+	utils.ResponseOk(OrgDetail{Organization: *org, Role: member.Role}, c)
+}
+
+// 获取当前用户加入的所有组织
+func (ctl *Controller) GetOrgList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+
+	members, err := ctl.store.OrgMembers.FindByUser(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	roles := make(map[primitive.ObjectID]string, len(members))
+	orgIds := make([]primitive.ObjectID, 0, len(members))
+	for _, member := range members {
+		roles[member.OrgId] = member.Role
+		orgIds = append(orgIds, member.OrgId)
+	}
+	orgs, err := ctl.store.Orgs.FindByIds(ctx, orgIds)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	details := make([]OrgDetail, 0, len(orgs))
+	for _, org := range orgs {
+		details = append(details, OrgDetail{Organization: org, Role: roles[org.Id]})
+	}
+	utils.ResponseOk(details, c)
+}
+
+// 查看组织的所有成员，组织的成员都可以查看
+func (ctl *Controller) GetOrgMemberList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		OrgId string `form:"orgId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	current, code, msg := ctl.getOrgMember(ctx, params.OrgId, userId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	members, err := ctl.store.OrgMembers.FindByOrg(ctx, current.OrgId)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	details := make([]OrgMemberDetail, 0, len(members))
+	for _, member := range members {
+		detail := OrgMemberDetail{OrgMember: member}
+		if detail.NickName, err = ctl.nickName(ctx, member.UserId); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		details = append(details, detail)
+	}
+	utils.ResponseOk(details, c)
+}
+
+// 按手机号添加组织成员，创建者可以添加管理员和普通成员，管理员只能添加普通成员
+func (ctl *Controller) AddOrgMember(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		OrgId string `form:"orgId" json:"orgId" binding:"required"`
+		Phone string `form:"phone" json:"phone" binding:"required"`
+		Role  string `form:"role" json:"role" binding:"required"` // admin 或者 member
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !model.ValidOrgRole(params.Role) {
+		utils.ResponseError(utils.PARAM_ERR, "成员角色只能是 admin 或者 member", c)
+		return
+	}
+	current, code, msg := ctl.getOrgMember(ctx, params.OrgId, userId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if !canManageMember(current.Role, params.Role) {
+		utils.ResponseError(utils.UNAUTH, "您无权添加这个角色的成员", c)
+		return
+	}
+
+	user, err := ctl.store.Users.GetByPhone(ctx, params.Phone)
+	if err == store.ErrNotFound {
+		utils.ResponseError(utils.NOT_EXISTS, "这个手机号还没有注册", c)
+		return
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	member := &model.OrgMember{
+		Id:         primitive.NewObjectID(),
+		OrgId:      current.OrgId,
+		UserId:     user.Id,
+		Role:       params.Role,
+		InvitedBy:  current.UserId,
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	if err := ctl.store.OrgMembers.Insert(ctx, member); err != nil {
+		if err == store.ErrDuplicate {
+			utils.ResponseError(utils.PARAM_ERR, "这个用户已经是组织的成员", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "orgId": params.OrgId, "memberId": user.Id.Hex(), "role": params.Role, "outcome": "added",
+	}).Info("organization member added")
+
+	utils.ResponseOk(OrgMemberDetail{OrgMember: *member, NickName: user.NickName}, c)
+}
+
+// 修改组织成员的角色，只有创建者可以操作，创建者自己的角色不能修改
+func (ctl *Controller) SetOrgMemberRole(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		OrgId  string `form:"orgId" json:"orgId" binding:"required"`
+		UserId string `form:"userId" json:"userId" binding:"required"`
+		Role   string `form:"role" json:"role" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !model.ValidOrgRole(params.Role) {
+		utils.ResponseError(utils.PARAM_ERR, "成员角色只能是 admin 或者 member", c)
+		return
+	}
+	current, code, msg := ctl.getOrgMember(ctx, params.OrgId, userId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if current.Role != model.OrgRoleOwner {
+		utils.ResponseError(utils.UNAUTH, "只有组织的创建者可以修改成员角色", c)
+		return
+	}
+	member, code, msg := ctl.getOrgMember(ctx, params.OrgId, params.UserId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if member.Role == model.OrgRoleOwner {
+		utils.ResponseError(utils.PARAM_ERR, "不能修改创建者的角色", c)
+		return
+	}
+
+	if err := ctl.store.OrgMembers.SetRole(ctx, member.OrgId, member.UserId, params.Role); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "orgId": params.OrgId, "memberId": params.UserId, "role": params.Role, "outcome": "updated",
+	}).Info("organization member role updated")
+	utils.ResponseOk("ok", c)
+}
+
+// 把组织移交给其他成员，只有创建者可以操作，移交后原来的创建者成为管理员
+// 先把新的创建者写进组织再修改原来创建者的角色，中途失败的时候可以重新移交
+func (ctl *Controller) TransferOrgOwner(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		OrgId  string `form:"orgId" json:"orgId" binding:"required"`
+		UserId string `form:"userId" json:"userId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if params.UserId == userId {
+		utils.ResponseError(utils.PARAM_ERR, "不能移交给自己", c)
+		return
+	}
+	current, code, msg := ctl.getOrgMember(ctx, params.OrgId, userId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if current.Role != model.OrgRoleOwner {
+		utils.ResponseError(utils.UNAUTH, "只有组织的创建者可以移交组织", c)
+		return
+	}
+	member, code, msg := ctl.getOrgMember(ctx, params.OrgId, params.UserId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	if err := ctl.store.OrgMembers.SetRole(ctx, member.OrgId, member.UserId, model.OrgRoleOwner); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 重试的时候组织可能已经移交过了
+	err := ctl.store.Orgs.SetOwner(ctx, current.OrgId, current.UserId, member.UserId)
+	if err != nil && err != store.ErrNotFound {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if err := ctl.store.OrgMembers.SetRole(ctx, current.OrgId, current.UserId, model.OrgRoleAdmin); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "orgId": params.OrgId, "memberId": params.UserId, "outcome": "transferred",
+	}).Info("organization transferred")
+	utils.ResponseOk("ok", c)
+}
+
+// 移除组织成员，创建者可以移除所有成员，管理员只能移除普通成员，成员可以自己退出，创建者不能退出
+// 成员在组织里绑定的门锁、发出的授权和添加的门卡不受影响，组织的创建者和管理员可以继续管理
+func (ctl *Controller) RemoveOrgMember(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		OrgId  string `form:"orgId" json:"orgId" binding:"required"`
+		UserId string `form:"userId" json:"userId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	current, code, msg := ctl.getOrgMember(ctx, params.OrgId, userId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	member, code, msg := ctl.getOrgMember(ctx, params.OrgId, params.UserId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if member.Role == model.OrgRoleOwner {
+		utils.ResponseError(utils.PARAM_ERR, "不能移除组织的创建者", c)
+		return
+	}
+	if params.UserId != userId && !canManageMember(current.Role, member.Role) {
+		utils.ResponseError(utils.UNAUTH, "您无权移除这个成员", c)
+		return
+	}
+
+	if err := ctl.store.OrgMembers.Delete(ctx, member.OrgId, member.UserId); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "orgId": params.OrgId, "memberId": params.UserId, "outcome": "removed",
+	}).Info("organization member removed")
+	utils.ResponseOk("ok", c)
+}
@@ -0,0 +1,104 @@
+package controller_test
+
+import (
+	"context"
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+// 新建组织，返回组织 id
+func (c *client) createOrg(name string) string {
+	t := c.srv.t
+	t.Helper()
+	org := controller.OrgDetail{}
+	c.do(http.MethodPost, "/api/v1/org", gin.H{"name": name}).ok(t, &org)
+	return org.Id.Hex()
+}
+
+// 获取当前租户里可以访问的门锁的 mac
+func (c *client) lockMacs() map[string]bool {
+	t := c.srv.t
+	t.Helper()
+	locks := []model.Lock{}
+	c.do(http.MethodGet, "/api/v1/lock/list", gin.H{"showValid": true}).ok(t, &locks)
+	macs := map[string]bool{}
+	for _, lock := range locks {
+		macs[lock.Mac] = true
+	}
+	return macs
+}
+
+func TestTenantIsolation(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	admin := srv.login("13800000002")
+	outsider := srv.login("13800000003")
+
+	orgId := owner.createOrg("office")
+	owner.do(http.MethodPost, "/api/v1/org/member", gin.H{"orgId": orgId, "phone": admin.phone, "role": model.OrgRoleAdmin}).expect(t, utils.OK)
+	personal := owner.addLock("AA:00:00:00:23:01")
+	office := owner.inOrg(orgId).addLock("AA:00:00:00:23:02")
+	if office.OrgId.Hex() != orgId || !personal.OrgId.IsZero() {
+		t.Fatalf("unexpected tenants: office %s, personal %s", office.OrgId.Hex(), personal.OrgId.Hex())
+	}
+
+	if macs := owner.lockMacs(); !macs[personal.Mac] || macs[office.Mac] {
+		t.Fatalf("personal lock list %v", macs)
+	}
+	if macs := owner.inOrg(orgId).lockMacs(); macs[personal.Mac] || !macs[office.Mac] {
+		t.Fatalf("org lock list %v", macs)
+	}
+	// 组织的管理员可以管理组织里所有的门锁，但是看不到创建者的个人门锁
+	if macs := admin.inOrg(orgId).lockMacs(); !macs[office.Mac] || macs[personal.Mac] {
+		t.Fatalf("admin lock list %v", macs)
+	}
+	admin.inOrg(orgId).do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": office.Mac, "code": lockCode}).expect(t, utils.OK)
+	admin.inOrg(orgId).do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": personal.Mac, "code": lockCode}).expect(t, utils.ENCRYPT_ERR)
+	// 个人请求访问不到组织的门锁
+	owner.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": office.Mac, "code": lockCode}).expect(t, utils.ENCRYPT_ERR)
+
+	// 组织的门锁不能转让给个人
+	owner.inOrg(orgId).stepUp(model.StepUpLockTransfer).do(http.MethodPost, "/api/v1/lock/transfer", gin.H{"mac": office.Mac, "phone": outsider.phone}).expect(t, utils.PARAM_ERR)
+
+	outsider.inOrg(orgId).do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.UNAUTH)
+	if macs := outsider.lockMacs(); len(macs) != 0 {
+		t.Fatalf("outsider lock list %v", macs)
+	}
+}
+
+func TestDeleteAccountKeepsOrgLocksAndRequiresHandoff(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	admin := srv.login("13800000002")
+
+	orgId := owner.createOrg("office")
+	owner.do(http.MethodPost, "/api/v1/org/member", gin.H{"orgId": orgId, "phone": admin.phone, "role": model.OrgRoleAdmin}).expect(t, utils.OK)
+	personal := owner.addLock("AA:00:00:00:23:03")
+	office := owner.inOrg(orgId).addLock("AA:00:00:00:23:04")
+
+	// 组织还有其他成员，需要先移交
+	owner.stepUp(model.StepUpAccountDelete).do(http.MethodPost, "/account/delete", nil).expect(t, utils.INVALID)
+	owner.do(http.MethodPost, "/api/v1/org/owner", gin.H{"orgId": orgId, "userId": admin.userId}).expect(t, utils.STEP_UP_REQUIRED)
+	owner.stepUp(model.StepUpOrgTransfer).do(http.MethodPost, "/api/v1/org/owner", gin.H{"orgId": orgId, "userId": admin.userId}).expect(t, utils.OK)
+
+	result := controller.DeleteResult{}
+	owner.stepUp(model.StepUpAccountDelete).do(http.MethodPost, "/account/delete", nil).ok(t, &result)
+	if result.LocksRetired != 1 || result.OrgsLeft != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	ctx := store.AllTenants(context.Background())
+	if lock, err := srv.store.Locks.Get(ctx, personal.Id); err != nil || lock.Valid {
+		t.Fatalf("personal lock should be retired: %+v, %v", lock, err)
+	}
+	if lock, err := srv.store.Locks.Get(ctx, office.Id); err != nil || !lock.Valid || lock.OrgId.Hex() != orgId {
+		t.Fatalf("org lock should stay in the org: %+v, %v", lock, err)
+	}
+	if macs := admin.inOrg(orgId).lockMacs(); !macs[office.Mac] {
+		t.Fatalf("new owner lock list %v", macs)
+	}
+}
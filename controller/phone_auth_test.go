@@ -0,0 +1,63 @@
+package controller_test
+
+import (
+	"context"
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestPhoneAuthClaimedOnSmsLogin(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	stranger := srv.login("13800000003")
+	lock := owner.addLock("AA:00:00:00:18:01")
+
+	var result controller.PhoneAuthResult
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1", "phone": "13800000002"}).ok(t, &result)
+	if result.Status != "pending" {
+		t.Fatalf("expect pending, got %+v", result)
+	}
+	// 分享给手机号的授权不能通过 token 领取，拿到 id 也不行
+	stranger.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": result.AuthId}).expect(t, utils.INVALID)
+	auth, err := srv.store.Auths.Get(context.Background(), utils.ObjectIdHex(result.AuthId))
+	if err != nil || !auth.ReceiverId.IsZero() {
+		t.Fatalf("auth should stay unclaimed: %+v, %v", auth, err)
+	}
+
+	// 这个手机号注册以后自动领取
+	friend := srv.login("13800000002")
+	friend.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.OK)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": result.AuthId}).expect(t, utils.INVALID)
+	stranger.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.ENCRYPT_ERR)
+
+	// 手机号已经有用户的时候直接授权
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1", "phone": stranger.phone}).ok(t, &result)
+	if result.Status != "granted" {
+		t.Fatalf("expect granted, got %+v", result)
+	}
+	stranger.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.OK)
+
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1", "phone": owner.phone}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1", "phone": "12345"}).expect(t, utils.PARAM_ERR)
+}
+
+func TestRevokedPhoneAuthNotClaimed(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	lock := owner.addLock("AA:00:00:00:18:02")
+
+	var result controller.PhoneAuthResult
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1", "phone": "13800000002"}).ok(t, &result)
+	owner.stepUp(model.StepUpAuthRevoke).do(http.MethodPost, "/api/v1/auth/revoke", gin.H{"authId": result.AuthId}).expect(t, utils.OK)
+
+	friend := srv.login("13800000002")
+	friend.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.ENCRYPT_ERR)
+	auth, err := srv.store.Auths.Get(context.Background(), utils.ObjectIdHex(result.AuthId))
+	if err != nil || !auth.ReceiverId.IsZero() {
+		t.Fatalf("revoked auth should not be claimed: %+v, %v", auth, err)
+	}
+}
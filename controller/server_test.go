@@ -0,0 +1,276 @@
+package controller_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"ezlock/config"
+	"ezlock/controller"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/router"
+	"ezlock/store"
+	"ezlock/store/memstore"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试使用的短信发送，记录每个手机号最后一次收到的验证码
+type smsBox struct {
+	sync.Mutex
+	codes map[string]string
+}
+
+func (b *smsBox) Send(ctx context.Context, phone, code string) error {
+	b.Lock()
+	defer b.Unlock()
+	b.codes[phone] = code
+	return nil
+}
+
+func (b *smsBox) code(phone string) string {
+	b.Lock()
+	defer b.Unlock()
+	return b.codes[phone]
+}
+
+// 使用内存存储的完整服务，路由和 main.go 里注册的一致
+type testServer struct {
+	t      *testing.T
+	store  *store.Store
+	sms    *smsBox
+	engine *gin.Engine
+}
+
+func newServer(t *testing.T) *testServer {
+	cfg := config.Default()
+	cfg.Login.Providers = []string{"wechat", "sms", "password"}
+	cfg.Sms.SendInterval = 0
+	cfg.Jwt.SecretKey = "ezlock-test-secret"
+	config.Set(cfg)
+	middleware.InitAuth()
+	gin.SetMode(gin.TestMode)
+
+	s := memstore.New()
+	box := &smsBox{codes: map[string]string{}}
+	ctl := controller.New(s, box)
+	engine := gin.New()
+	app := engine.Group("/")
+	auth := middleware.Auth(s.Sessions)
+	router.Account(app, ctl, auth)
+	router.Api(app, ctl, auth, middleware.AuthOrApiKey(s.Sessions, s.ApiKeys), middleware.RequireTenant(s.OrgMembers))
+	router.Platform(app, ctl, auth)
+	return &testServer{t: t, store: s, sms: box, engine: engine}
+}
+
+// 接口响应，result 按需要解析
+type response struct {
+	Code   int             `json:"code"`
+	Msg    string          `json:"msg"`
+	Result json.RawMessage `json:"result"`
+}
+
+// 检查错误码，不一致的时候结束测试
+func (r *response) expect(t *testing.T, code int) *response {
+	t.Helper()
+	if r.Code != code {
+		t.Fatalf("expect code %d, got %d: %s", code, r.Code, r.Msg)
+	}
+	return r
+}
+
+// 检查请求成功并解析 result
+func (r *response) ok(t *testing.T, result interface{}) {
+	t.Helper()
+	r.expect(t, utils.OK)
+	if result == nil {
+		return
+	}
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		t.Fatalf("decode result %s: %s", string(r.Result), err.Error())
+	}
+}
+
+// 发送请求，GET 请求的参数放在查询字符串里，其他请求的参数作为 json 请求体
+func (s *testServer) request(method, path string, headers map[string]string, params gin.H) *response {
+	s.t.Helper()
+	var body *bytes.Buffer
+	if method == http.MethodGet {
+		query := url.Values{}
+		for key, value := range params {
+			query.Set(key, fmt.Sprint(value))
+		}
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+		body = &bytes.Buffer{}
+	} else {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			s.t.Fatalf("encode params: %s", err.Error())
+		}
+		body = bytes.NewBuffer(raw)
+	}
+	req := httptest.NewRequest(method, path, body)
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	s.engine.ServeHTTP(w, req)
+	resp := &response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		s.t.Fatalf("%s %s: decode response %q: %s", method, path, w.Body.String(), err.Error())
+	}
+	return resp
+}
+
+// 一个登录的用户，headers 是每次请求都会带上的请求头
+type client struct {
+	srv     *testServer
+	userId  string
+	phone   string
+	tokens  controller.TokenPair
+	headers map[string]string
+}
+
+// 短信验证码登录，手机号没有注册过的时候注册新用户
+func (s *testServer) login(phone string) *client {
+	s.t.Helper()
+	s.request(http.MethodPost, "/sms/send_code", nil, gin.H{"phone": phone}).expect(s.t, utils.OK)
+	c := &client{srv: s, phone: phone}
+	s.request(http.MethodPost, "/login/sms", nil, gin.H{"phone": phone, "code": s.sms.code(phone)}).ok(s.t, &c.tokens)
+	user, err := s.store.Users.GetByPhone(context.Background(), phone)
+	if err != nil {
+		s.t.Fatalf("get user %s: %s", phone, err.Error())
+	}
+	c.userId = user.Id.Hex()
+	c.headers = map[string]string{"Authorization": config.Get().Server.ProjName + " " + c.tokens.Token}
+	return c
+}
+
+// 带上当前用户的 token 发送请求
+func (c *client) do(method, path string, params gin.H) *response {
+	c.srv.t.Helper()
+	return c.srv.request(method, path, c.headers, params)
+}
+
+// 复制一份带上额外请求头的用户
+func (c *client) with(key, value string) *client {
+	headers := make(map[string]string, len(c.headers)+1)
+	for k, v := range c.headers {
+		headers[k] = v
+	}
+	headers[key] = value
+	copied := *c
+	copied.headers = headers
+	return &copied
+}
+
+// 在组织里操作
+func (c *client) inOrg(orgId string) *client {
+	return c.with(middleware.OrgIdHeader, orgId)
+}
+
+// 用短信验证码二次验证，返回带上二次验证 token 的用户
+func (c *client) stepUp(scopes ...string) *client {
+	t := c.srv.t
+	t.Helper()
+	c.do(http.MethodPost, "/account/step_up/send_code", nil).expect(t, utils.OK)
+	token := controller.StepUpToken{}
+	c.do(http.MethodPost, "/account/step_up", gin.H{
+		"method": "sms", "secret": c.srv.sms.code(c.phone), "scopes": scopes,
+	}).ok(t, &token)
+	return c.with(middleware.StepUpHeader, token.Token)
+}
+
+// 导入一台出厂设备，返回设备和印在包装盒上的认领码
+func (s *testServer) importDevice(mac string) (*model.Device, string) {
+	s.t.Helper()
+	key, err := utils.NewDeviceKey()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	code, hash, err := utils.NewClaimCode()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	now := time.Now().Local()
+	device := &model.Device{
+		Mac:           mac,
+		Serial:        "SN-" + mac,
+		Model:         "EZ-1",
+		Key:           key,
+		ClaimCodeHash: hash,
+		UpdateTime:    now,
+		CreateTime:    now,
+	}
+	if err := s.store.Devices.Insert(context.Background(), device); err != nil {
+		s.t.Fatalf("import device %s: %s", mac, err.Error())
+	}
+	return device, code
+}
+
+// 导入设备并绑定到这个用户，返回门锁
+func (c *client) addLock(mac string) *model.Lock {
+	t := c.srv.t
+	t.Helper()
+	device, code := c.srv.importDevice(mac)
+	c.bindLock(device, code).expect(t, utils.OK)
+	lock, err := c.srv.store.Locks.GetByMac(store.AllTenants(context.Background()), mac)
+	if err != nil {
+		t.Fatalf("get lock %s: %s", mac, err.Error())
+	}
+	return lock
+}
+
+// 模拟门锁用出厂密钥加密数据，格式和门锁上传的一致：随机 iv 加上 AES-CBC 密文，再 base64 编码
+func deviceEncrypt(t *testing.T, key, content string) string {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := utils.PKCS7Padding([]byte(content), block.BlockSize())
+	data := make([]byte, block.BlockSize()+len(raw))
+	if _, err := rand.Read(data[:block.BlockSize()]); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, data[:block.BlockSize()]).CryptBlocks(data[block.BlockSize():], raw)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// 获取门锁挑战，返回挑战 token 和门锁正确的应答
+func (c *client) challenge(device *model.Device) (string, string) {
+	t := c.srv.t
+	t.Helper()
+	challenge := controller.DeviceChallenge{}
+	c.do(http.MethodPost, "/api/v1/lock/challenge", gin.H{"mac": device.Mac}).ok(t, &challenge)
+	return challenge.Token, deviceEncrypt(t, device.Key, challenge.Nonce)
+}
+
+// 在门锁旁边用认领码绑定门锁
+func (c *client) bindLock(device *model.Device, claimCode string) *response {
+	c.srv.t.Helper()
+	token, answer := c.challenge(device)
+	return c.do(http.MethodPost, "/api/v1/lock/info", gin.H{
+		"name": "front door", "desc": "home", "mac": device.Mac, "claimCode": claimCode,
+		"challenge": token, "response": answer,
+	})
+}
+
+// 门锁 16 位随机数，生成指令的时候使用
+const lockCode = "0123456789abcdef"
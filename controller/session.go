@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"ezlock/common/logger"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type SessionDetail struct {
+	model.Session
+	Current bool `json:"current"` // 是否是当前请求使用的会话
+}
+
+// 获取用户已登录的设备，不包括已经退出和过期的会话
+func (ctl *Controller) GetSessionList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	current := c.GetString(middleware.SessionKey)
+
+	sessions, err := ctl.store.Sessions.FindActiveByUser(ctx, utils.ObjectIdHex(userId), time.Now().Local())
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	details := make([]SessionDetail, 0, len(sessions))
+	for _, session := range sessions {
+		details = append(details, SessionDetail{Session: session, Current: session.Id.Hex() == current})
+	}
+	utils.ResponseOk(details, c)
+}
+
+// 撤销用户的某个会话，这个设备需要重新登录，也不能再获取开锁密钥
+func (ctl *Controller) RevokeSession(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		SessionId string `form:"sessionId" json:"sessionId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.SessionId) {
+		utils.ResponseError(utils.PARAM_ERR, "会话id不合法", c)
+		return
+	}
+
+	err := ctl.store.Sessions.Revoke(ctx, utils.ObjectIdHex(params.SessionId), utils.ObjectIdHex(userId))
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.NOT_EXISTS, "会话不存在", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "sessionId": params.SessionId, "outcome": "revoked"}).Info("session revoked")
+	utils.ResponseOk("ok", c)
+}
+
+// 退出所有设备的登录，包括当前设备
+func (ctl *Controller) SignOutEverywhere(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+
+	count, err := ctl.store.Sessions.RevokeByUser(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "count": count, "outcome": "logout_all"}).Info("all sessions revoked")
+	utils.ResponseOk(count, c)
+}
@@ -0,0 +1,94 @@
+package controller_test
+
+import (
+	"ezlock/config"
+	"ezlock/controller"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestLogoutRevokesToken(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+
+	owner.do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.OK)
+	owner.do(http.MethodPost, "/logout", nil).expect(t, utils.OK)
+	// token 还没有过期，但是会话已经撤销
+	owner.do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/token/refresh", nil, gin.H{"refreshToken": owner.tokens.RefreshToken}).expect(t, utils.UNAUTH)
+}
+
+func TestLogoutKeepsOtherSessions(t *testing.T) {
+	srv := newServer(t)
+	phone := srv.login("13800000001")
+	web := srv.login("13800000001")
+
+	web.do(http.MethodPost, "/logout", nil).expect(t, utils.OK)
+	web.do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.UNAUTH)
+	phone.do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.OK)
+}
+
+func TestRefreshTokenRotationAndReuse(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+
+	refreshed := controller.TokenPair{}
+	srv.request(http.MethodPost, "/token/refresh", nil, gin.H{"refreshToken": owner.tokens.RefreshToken}).ok(t, &refreshed)
+	if refreshed.RefreshToken == owner.tokens.RefreshToken {
+		t.Fatal("refresh token should be rotated")
+	}
+	renewed := owner.with("Authorization", config.Get().Server.ProjName+" "+refreshed.Token)
+	renewed.do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.OK)
+
+	// 旧的刷新 token 再次使用，说明可能被盗用，整个会话撤销
+	srv.request(http.MethodPost, "/token/refresh", nil, gin.H{"refreshToken": owner.tokens.RefreshToken}).expect(t, utils.UNAUTH)
+	renewed.do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/token/refresh", nil, gin.H{"refreshToken": refreshed.RefreshToken}).expect(t, utils.UNAUTH)
+}
+
+func TestInvalidTokenRejected(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+
+	srv.request(http.MethodGet, "/api/v1/lock/list", nil, nil).expect(t, utils.UNAUTH)
+	owner.with("Authorization", config.Get().Server.ProjName+" broken").do(http.MethodGet, "/api/v1/lock/list", nil).expect(t, utils.UNAUTH)
+	srv.request(http.MethodPost, "/token/refresh", nil, gin.H{"refreshToken": "broken"}).expect(t, utils.UNAUTH)
+}
+
+func TestRevokeOtherSession(t *testing.T) {
+	srv := newServer(t)
+	phone := srv.login("13800000001")
+	web := srv.login("13800000001")
+	other := srv.login("13800000002")
+
+	sessions := []controller.SessionDetail{}
+	web.do(http.MethodGet, "/account/sessions", nil).ok(t, &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("expect 2 sessions, got %d", len(sessions))
+	}
+	for _, session := range sessions {
+		// 使用过的会话会记录 ip 和最近使用时间
+		if session.Current && (session.Ip == "" || session.LastUsedTime.IsZero()) {
+			t.Fatalf("current session not touched: %+v", session)
+		}
+	}
+	for _, session := range sessions {
+		if !session.Current {
+			// 不能撤销别人的会话
+			other.do(http.MethodDelete, "/account/session", gin.H{"sessionId": session.Id.Hex()}).expect(t, utils.NOT_EXISTS)
+			web.do(http.MethodDelete, "/account/session", gin.H{"sessionId": session.Id.Hex()}).expect(t, utils.OK)
+		}
+	}
+	phone.do(http.MethodGet, "/account/sessions", nil).expect(t, utils.UNAUTH)
+	web.do(http.MethodGet, "/account/sessions", nil).ok(t, &sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("expect only the current session, got %+v", sessions)
+	}
+
+	// 退出所有设备包括当前设备，不影响其他用户
+	web.do(http.MethodPost, "/account/logout_all", nil).expect(t, utils.OK)
+	web.do(http.MethodGet, "/account/sessions", nil).expect(t, utils.UNAUTH)
+	other.do(http.MethodGet, "/account/sessions", nil).expect(t, utils.OK)
+}
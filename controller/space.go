@@ -0,0 +1,401 @@
+package controller
+
+import (
+	"context"
+	"ezlock/common/logger"
+	"ezlock/identity"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 空间树的一个节点，包括直接挂在这个空间下的门锁和下一层空间
+type SpaceNode struct {
+	model.Space
+	Locks    []LockSummary `json:"locks"`
+	Children []*SpaceNode  `json:"children"`
+}
+
+// 获取用户自己的空间，id 不合法、空间不存在或者不属于用户的时候返回错误
+func (ctl *Controller) getOwnSpace(ctx context.Context, userId, spaceId string) (*model.Space, int, string) {
+	if !primitive.IsValidObjectID(spaceId) {
+		return nil, utils.PARAM_ERR, "空间id不合法"
+	}
+	space, err := ctl.store.Spaces.Get(ctx, utils.ObjectIdHex(spaceId))
+	if err == store.ErrNotFound || (err == nil && space.Own.Hex() != userId) {
+		return nil, utils.NOT_EXISTS, "此空间不存在或者不属于您"
+	}
+	if err != nil {
+		return nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	return space, utils.OK, ""
+}
+
+// 新建空间，site 不能有上一层，其他层级的上一层必须是紧挨着的上一个层级
+func (ctl *Controller) CreateSpace(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		ParentId string `form:"parentId" json:"parentId"`
+		Type     string `form:"type" json:"type" binding:"required"` // site、building、floor 或者 unit
+		Name     string `form:"name" json:"name" binding:"required,max=255"`
+		Desc     string `form:"desc" json:"desc"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !model.ValidSpaceType(params.Type) {
+		utils.ResponseError(utils.PARAM_ERR, "空间层级只能是 site、building、floor 或者 unit", c)
+		return
+	}
+
+	space := model.Space{
+		Id:         primitive.NewObjectID(),
+		Own:        utils.ObjectIdHex(userId),
+		Ancestors:  []primitive.ObjectID{},
+		Type:       params.Type,
+		Name:       params.Name,
+		Desc:       params.Desc,
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	parentType := model.SpaceParentType(params.Type)
+	if parentType == "" && params.ParentId != "" {
+		utils.ResponseError(utils.PARAM_ERR, "site 不能放在其他空间下", c)
+		return
+	}
+	if parentType != "" {
+		if params.ParentId == "" {
+			utils.ResponseError(utils.PARAM_ERR, "需要指定上一层空间", c)
+			return
+		}
+		parent, code, msg := ctl.getOwnSpace(ctx, userId, params.ParentId)
+		if code != utils.OK {
+			utils.ResponseError(code, msg, c)
+			return
+		}
+		if parent.Type != parentType {
+			utils.ResponseError(utils.PARAM_ERR, params.Type+" 只能放在 "+parentType+" 下", c)
+			return
+		}
+		space.ParentId = parent.Id
+		space.Ancestors = append(append(space.Ancestors, parent.Ancestors...), parent.Id)
+	}
+
+	if err := ctl.store.Spaces.Insert(ctx, &space); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "spaceId": space.Id.Hex(), "type": space.Type, "outcome": "created"}).Info("space created")
+	utils.ResponseOk(space, c)
+}
+
+// 修改空间的名称和描述，空值不修改
+func (ctl *Controller) UpdateSpace(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		SpaceId string `form:"spaceId" json:"spaceId" binding:"required"`
+		Name    string `form:"name" json:"name" binding:"max=255"`
+		Desc    string `form:"desc" json:"desc"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	space, code, msg := ctl.getOwnSpace(ctx, userId, params.SpaceId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	if err := ctl.store.Spaces.UpdateInfo(ctx, space.Id, space.Own, params.Name, params.Desc); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk("ok", c)
+}
+
+// 删除没有下层空间的空间，里面的门锁移出空间
+// 授权给这个空间的授权不再包括任何门锁，同时授权给其他空间的还继续有效
+func (ctl *Controller) DeleteSpace(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		SpaceId string `form:"spaceId" json:"spaceId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	space, code, msg := ctl.getOwnSpace(ctx, userId, params.SpaceId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	subtree, err := ctl.store.Spaces.FindSubtree(ctx, []primitive.ObjectID{space.Id})
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if len(subtree) > 1 {
+		utils.ResponseError(utils.PARAM_ERR, "请先删除下层空间", c)
+		return
+	}
+
+	locksRemoved, err := ctl.store.Locks.ClearSpace(ctx, space.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if err := ctl.store.Spaces.Delete(ctx, space.Id, space.Own); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "spaceId": params.SpaceId, "locksRemoved": locksRemoved, "outcome": "deleted",
+	}).Info("space deleted")
+	utils.ResponseOk("ok", c)
+}
+
+// 获取用户的空间树，spaceId 为空的时候返回所有 site，否则只返回这个空间和下层空间
+func (ctl *Controller) GetSpaceTree(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		SpaceId string `form:"spaceId"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	own := utils.ObjectIdHex(userId)
+
+	var spaces []model.Space
+	var err error
+	if params.SpaceId == "" {
+		spaces, err = ctl.store.Spaces.FindByOwner(ctx, own)
+	} else {
+		space, code, msg := ctl.getOwnSpace(ctx, userId, params.SpaceId)
+		if code != utils.OK {
+			utils.ResponseError(code, msg, c)
+			return
+		}
+		spaces, err = ctl.store.Spaces.FindSubtree(ctx, []primitive.ObjectID{space.Id})
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(spaces))
+	nodes := make(map[primitive.ObjectID]*SpaceNode, len(spaces))
+	for _, space := range spaces {
+		ids = append(ids, space.Id)
+		nodes[space.Id] = &SpaceNode{Space: space, Locks: []LockSummary{}, Children: []*SpaceNode{}}
+	}
+	locks, err := utils.GetSpaceLocks(ctx, ctl.store, own, ids)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	for _, lock := range locks {
+		node := nodes[lock.SpaceId]
+		node.Locks = append(node.Locks, LockSummary{Lock: lock})
+	}
+	// 上一层不在结果里的就是树根，空间按创建时间排序，子节点的顺序也是创建时间
+	roots := []*SpaceNode{}
+	for _, space := range spaces {
+		node := nodes[space.Id]
+		if parent, ok := nodes[space.ParentId]; ok && !space.ParentId.IsZero() {
+			parent.Children = append(parent.Children, node)
+			continue
+		}
+		roots = append(roots, node)
+	}
+	utils.ResponseOk(roots, c)
+}
+
+// 获取空间和所有下层空间里的门锁
+func (ctl *Controller) GetSpaceLockList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		SpaceId string `form:"spaceId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	space, code, msg := ctl.getOwnSpace(ctx, userId, params.SpaceId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	locks, err := utils.GetSpaceLocks(ctx, ctl.store, space.Own, []primitive.ObjectID{space.Id})
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	summaries := make([]LockSummary, 0, len(locks))
+	for _, lock := range locks {
+		summaries = append(summaries, LockSummary{Lock: lock})
+	}
+	utils.ResponseOk(summaries, c)
+}
+
+// 把自己的门锁放到自己的空间里，spaceId 为空的时候移出空间
+func (ctl *Controller) SetLockSpace(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac     string `form:"mac" json:"mac" binding:"required"`
+		SpaceId string `form:"spaceId" json:"spaceId"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if lock.Own.Hex() != userId || !lock.Valid {
+		utils.ResponseError(utils.NOT_EXISTS, "此锁不属于您或者已经被删除", c)
+		return
+	}
+	spaceId := primitive.NilObjectID
+	if params.SpaceId != "" {
+		space, code, msg := ctl.getOwnSpace(ctx, userId, params.SpaceId)
+		if code != utils.OK {
+			utils.ResponseError(code, msg, c)
+			return
+		}
+		spaceId = space.Id
+	}
+
+	if err := ctl.store.Locks.SetSpace(ctx, lock.Id, lock.Own, spaceId); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": params.Mac, "spaceId": params.SpaceId, "outcome": "updated"}).Info("lock space updated")
+	utils.ResponseOk("ok", c)
+}
+
+// 授权给一个或者多个空间，包括这些空间和下层空间里现在和以后放进来的所有门锁，只有空间的拥有者可以授权
+func (ctl *Controller) CreateSpaceAuth(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		SpaceIds  []string `form:"spaceIds" json:"spaceIds" binding:"required,min=1"`
+		ViewLog   bool     `form:"viewLog" json:"viewLog"`     // 查看日志权限
+		AddCard   bool     `form:"addCard" json:"addCard"`     // 添加门卡权限
+		ShareAuth bool     `form:"shareAuth" json:"shareAuth"` // 分享授权权限
+		AuthType  string   `form:"authType" json:"authType" binding:"required"`
+		Deadline  string   `form:"deadline" json:"deadline"`
+		StartDate string   `form:"startDate" json:"startDate"`
+		EndDate   string   `form:"endDate" json:"endDate"`
+		StartTime string   `form:"startTime" json:"startTime"`
+		EndTime   string   `form:"endTime" json:"endTime"`
+		Phone     string   `form:"phone" json:"phone"` // 直接分享给这个手机号，不需要对方领取
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if params.Phone != "" && !identity.ValidPhone(params.Phone) {
+		utils.ResponseError(utils.PARAM_ERR, "手机号不合法", c)
+		return
+	}
+	spaceIds := make([]primitive.ObjectID, 0, len(params.SpaceIds))
+	for _, hex := range params.SpaceIds {
+		space, code, msg := ctl.getOwnSpace(ctx, userId, hex)
+		if code != utils.OK {
+			utils.ResponseError(code, msg, c)
+			return
+		}
+		spaceIds = append(spaceIds, space.Id)
+	}
+
+	mgoId := primitive.NewObjectID()
+	authInfo := model.Auth{
+		Id:         mgoId,
+		SendId:     utils.ObjectIdHex(userId),
+		SpaceIds:   spaceIds,
+		AuthType:   params.AuthType,
+		Deadline:   params.Deadline,
+		StartDate:  params.StartDate,
+		EndDate:    params.EndDate,
+		StartTime:  params.StartTime,
+		EndTime:    params.EndTime,
+		Valid:      true,
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	authInfo.Perms = model.Perms{
+		ShareAuth: params.ShareAuth,
+		AddCard:   params.AddCard,
+		ViewLog:   params.ViewLog,
+	}
+	if !utils.CheckAuthTimeValid(authInfo) {
+		utils.ResponseError(utils.PARAM_ERR, "授权时间不合法", c)
+		return
+	}
+	if params.Phone != "" {
+		ctl.createPhoneAuth(c, &authInfo, params.Phone)
+		return
+	}
+	authInfo.Token = mgoId.Hex()
+	if err := ctl.store.Auths.Insert(ctx, &authInfo); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "spaceIds": params.SpaceIds, "authId": authInfo.Id.Hex(), "outcome": "created",
+	}).Info("space auth created")
+
+	utils.ResponseOk(authInfo.Token, c)
+}
+
+// 查看自己发出的包括这个空间的授权，授权给上层空间的不包括在内
+func (ctl *Controller) GetSpaceAuthList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		SpaceId string `form:"spaceId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	space, code, msg := ctl.getOwnSpace(ctx, userId, params.SpaceId)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+
+	auths, err := ctl.store.Auths.FindBySpace(ctx, space.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	details := make([]AuthDetail, 0, len(auths))
+	for _, auth := range auths {
+		if auth.SendId != space.Own {
+			continue
+		}
+		detail := AuthDetail{Auth: auth}
+		if detail.Receiver, err = ctl.nickName(ctx, auth.ReceiverId); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		details = append(details, detail)
+	}
+	utils.ResponseOk(details, c)
+}
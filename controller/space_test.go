@@ -0,0 +1,83 @@
+package controller_test
+
+import (
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+func TestSpaceGrantCoversSubtree(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	lock := owner.addLock("AA:00:00:00:22:01")
+	outside := owner.addLock("AA:00:00:00:22:02")
+
+	site, building, floor := model.Space{}, model.Space{}, model.Space{}
+	owner.do(http.MethodPost, "/api/v1/space", gin.H{"type": model.SpaceSite, "name": "park"}).ok(t, &site)
+	// 层级只能逐层往下建
+	owner.do(http.MethodPost, "/api/v1/space", gin.H{"type": model.SpaceFloor, "name": "1F", "parentId": site.Id.Hex()}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodPost, "/api/v1/space", gin.H{"type": model.SpaceBuilding, "name": "A"}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodPost, "/api/v1/space", gin.H{"type": model.SpaceBuilding, "name": "A", "parentId": site.Id.Hex()}).ok(t, &building)
+	owner.do(http.MethodPost, "/api/v1/space", gin.H{"type": model.SpaceFloor, "name": "1F", "parentId": building.Id.Hex()}).ok(t, &floor)
+	if len(floor.Ancestors) != 2 || floor.Ancestors[0] != site.Id || floor.Ancestors[1] != building.Id {
+		t.Fatalf("unexpected ancestors %+v", floor.Ancestors)
+	}
+	// 别人的空间不能用
+	friend.do(http.MethodPost, "/api/v1/space", gin.H{"type": model.SpaceBuilding, "name": "B", "parentId": site.Id.Hex()}).expect(t, utils.NOT_EXISTS)
+	friend.do(http.MethodPut, "/api/v1/space/lock", gin.H{"mac": lock.Mac, "spaceId": floor.Id.Hex()}).expect(t, utils.NOT_EXISTS)
+
+	owner.do(http.MethodPut, "/api/v1/space/lock", gin.H{"mac": lock.Mac, "spaceId": floor.Id.Hex()}).expect(t, utils.OK)
+	locks := []model.Lock{}
+	owner.do(http.MethodGet, "/api/v1/space/lock/list", gin.H{"spaceId": site.Id.Hex()}).ok(t, &locks)
+	if len(locks) != 1 || locks[0].Mac != lock.Mac {
+		t.Fatalf("unexpected locks %+v", locks)
+	}
+
+	// 授权给 site 包括 floor 里的门锁，不包括空间外的门锁
+	var token string
+	owner.do(http.MethodPost, "/api/v1/space/auth", gin.H{"spaceIds": []string{site.Id.Hex()}, "authType": "1"}).ok(t, &token)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.OK)
+	friend.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.OK)
+	friend.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": outside.Mac, "code": lockCode}).expect(t, utils.ENCRYPT_ERR)
+
+	// 有下层空间的不能删除，删除 floor 以后门锁移出空间，site 的授权不再包括这把锁
+	owner.do(http.MethodDelete, "/api/v1/space", gin.H{"spaceId": building.Id.Hex()}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodDelete, "/api/v1/space", gin.H{"spaceId": floor.Id.Hex()}).expect(t, utils.OK)
+	friend.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.ENCRYPT_ERR)
+}
+
+func TestRevokeAllReportsSpaceGrants(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	lock := owner.addLock("AA:00:00:00:01:02")
+
+	space := model.Space{}
+	owner.do(http.MethodPost, "/api/v1/space", gin.H{"type": model.SpaceSite, "name": "home"}).ok(t, &space)
+	owner.do(http.MethodPut, "/api/v1/space/lock", gin.H{"mac": lock.Mac, "spaceId": space.Id.Hex()}).expect(t, utils.OK)
+	var token string
+	owner.do(http.MethodPost, "/api/v1/space/auth", gin.H{"spaceIds": []string{space.Id.Hex()}, "authType": "1"}).ok(t, &token)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.OK)
+
+	open := gin.H{"mac": lock.Mac, "code": lockCode}
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+
+	// 默认只撤销门锁上的授权，空间授权只返回数量，仍然可以开锁
+	revoker := owner.stepUp(model.StepUpAuthRevoke)
+	result := controller.RevokeAllResult{}
+	revoker.do(http.MethodPost, "/api/v1/lock/auth/revoke_all", gin.H{"mac": lock.Mac}).ok(t, &result)
+	if result.SpaceAuthsRemaining != 1 || result.SpaceAuthsRevoked != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+
+	revoker.do(http.MethodPost, "/api/v1/lock/auth/revoke_all", gin.H{"mac": lock.Mac, "spaces": true}).ok(t, &result)
+	if result.SpaceAuthsRevoked != 1 || result.SpaceAuthsRemaining != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+}
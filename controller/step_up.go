@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"errors"
+	"ezlock/common/logger"
+	"ezlock/identity"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// 二次验证返回的 token，请求敏感操作的时候放在 X-Step-Up-Token 请求头里
+type StepUpToken struct {
+	Token  string    `json:"token"`
+	Expire time.Time `json:"expire"`
+	Scopes []string  `json:"scopes"` // 可以执行的操作
+}
+
+// 给当前用户绑定的手机号发送二次验证的验证码
+func (ctl *Controller) SendStepUpCode(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if user.PhoneNumber == "" {
+		utils.ResponseError(utils.PARAM_ERR, "没有绑定手机号，请使用密码或者微信重新登录验证", c)
+		return
+	}
+	if err := ctl.smsCodes.SendCode(ctx, user.PhoneNumber); err != nil {
+		responseIdentityError(err, c)
+		return
+	}
+	utils.ResponseOk("ok", c)
+}
+
+// 使用短信验证码、登录密码或者微信重新登录再次验证身份，返回只能用于指定操作的短时间有效的 token
+func (ctl *Controller) StepUp(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Method string   `form:"method" json:"method" binding:"required"` // sms、password 或者 wechat
+		Secret string   `form:"secret" json:"secret" binding:"required"` // 短信验证码、登录密码或者 wx.login 返回的 code
+		Scopes []string `form:"scopes" json:"scopes" binding:"required"` // 需要执行的操作
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	verifier, ok := ctl.verifiers[params.Method]
+	if !ok {
+		utils.ResponseError(utils.PARAM_ERR, fmt.Sprintf("不支持的验证方式 [%s]", params.Method), c)
+		return
+	}
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range params.Scopes {
+		if !model.ValidStepUpScope(scope) {
+			utils.ResponseError(utils.PARAM_ERR, fmt.Sprintf("不支持的操作 [%s]", scope), c)
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		utils.ResponseError(utils.PARAM_ERR, "操作不能为空", c)
+		return
+	}
+
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	log := logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "method": params.Method, "scopes": scopes})
+	if err := verifier.Verify(ctx, user, params.Secret); err != nil {
+		if errors.Is(err, identity.ErrNoFactor) {
+			utils.ResponseError(utils.PARAM_ERR, "没有绑定手机号、设置密码或者不是微信登录的用户，不能使用这种验证方式", c)
+			return
+		}
+		if errors.Is(err, identity.ErrInvalidCredential) {
+			log.WithField("outcome", "failed").Warn("step up failed")
+		}
+		responseIdentityError(err, c)
+		return
+	}
+
+	token, expire, err := middleware.CreateStepUpToken(userId, c.GetString(middleware.SessionKey), scopes)
+	if err != nil {
+		utils.ResponseError(utils.UNAUTH, err.Error(), c)
+		return
+	}
+	log.WithField("outcome", "verified").Info("step up verified")
+	utils.ResponseOk(StepUpToken{Token: token, Expire: expire, Scopes: scopes}, c)
+}
@@ -0,0 +1,153 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"ezlock/controller"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStepUpRejectsWrongCodeAndUnknownScope(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+
+	owner.do(http.MethodPost, "/account/step_up/send_code", nil).expect(t, utils.OK)
+	owner.do(http.MethodPost, "/account/step_up", gin.H{
+		"method": "sms", "secret": "000000x", "scopes": []string{model.StepUpLockDelete},
+	}).expect(t, utils.UNAUTH)
+	owner.do(http.MethodPost, "/account/step_up", gin.H{
+		"method": "sms", "secret": srv.sms.code(owner.phone), "scopes": []string{"lock:everything"},
+	}).expect(t, utils.PARAM_ERR)
+	owner.do(http.MethodPost, "/account/step_up", gin.H{
+		"method": "face", "secret": "x", "scopes": []string{model.StepUpLockDelete},
+	}).expect(t, utils.PARAM_ERR)
+	// 没有设置密码的用户不能用密码验证
+	owner.do(http.MethodPost, "/account/step_up", gin.H{
+		"method": "password", "secret": "password-1", "scopes": []string{model.StepUpLockDelete},
+	}).expect(t, utils.PARAM_ERR)
+}
+
+func TestStepUpTokenLimitedToScopeAndSession(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	lock := owner.addLock("AA:00:00:00:19:01")
+	card := gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, "12345678")}
+	owner.do(http.MethodPost, "/api/v1/lock/card", card).expect(t, utils.OK)
+
+	// 删除门锁的二次验证不能用来删除门卡
+	deleter := owner.stepUp(model.StepUpLockDelete)
+	deleter.do(http.MethodDelete, "/api/v1/lock/card", card).expect(t, utils.STEP_UP_REQUIRED)
+
+	// 二次验证 token 只能在签发它的会话里使用
+	other := srv.login(owner.phone)
+	other.with(middleware.StepUpHeader, deleter.headers[middleware.StepUpHeader]).
+		do(http.MethodDelete, "/api/v1/lock/info", gin.H{"mac": lock.Mac}).expect(t, utils.STEP_UP_REQUIRED)
+	// 登录 token 不能当作二次验证 token 使用
+	owner.with(middleware.StepUpHeader, owner.tokens.Token).
+		do(http.MethodDelete, "/api/v1/lock/info", gin.H{"mac": lock.Mac}).expect(t, utils.STEP_UP_REQUIRED)
+
+	deleter.do(http.MethodDelete, "/api/v1/lock/info", gin.H{"mac": lock.Mac}).expect(t, utils.OK)
+	if got, err := srv.store.Locks.Get(context.Background(), lock.Id); err != nil || got.Valid {
+		t.Fatalf("lock should be deleted: %+v, %v", got, err)
+	}
+}
+
+func TestSetPasswordRequiresStepUp(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	password := gin.H{"userName": "owner_01", "password": "correct horse"}
+
+	owner.do(http.MethodPost, "/account/set_password", password).expect(t, utils.STEP_UP_REQUIRED)
+	owner.stepUp(model.StepUpPasswordSet).do(http.MethodPost, "/account/set_password", password).expect(t, utils.OK)
+	srv.request(http.MethodPost, "/login/password", nil, password).expect(t, utils.OK)
+
+	// 设置密码以后可以用密码二次验证
+	owner.do(http.MethodPost, "/account/step_up", gin.H{
+		"method": "password", "secret": "wrong password", "scopes": []string{model.StepUpPasswordSet},
+	}).expect(t, utils.UNAUTH)
+	token := controller.StepUpToken{}
+	owner.do(http.MethodPost, "/account/step_up", gin.H{
+		"method": "password", "secret": "correct horse", "scopes": []string{model.StepUpPasswordSet},
+	}).ok(t, &token)
+	owner.with(middleware.StepUpHeader, token.Token).
+		do(http.MethodPost, "/account/set_password", gin.H{"userName": "owner_01", "password": "battery staple"}).expect(t, utils.OK)
+	srv.request(http.MethodPost, "/login/password", nil, password).expect(t, utils.UNAUTH)
+}
+
+// 下载导出的开锁日志，失败的时候返回接口的错误码
+func (c *client) exportLog(mac string) ([]controller.LogDetail, int) {
+	t := c.srv.t
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/lock/log/export?mac="+mac, nil)
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	c.srv.engine.ServeHTTP(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+		resp := &response{}
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatalf("decode response %q: %s", w.Body.String(), err.Error())
+		}
+		return nil, resp.Code
+	}
+	logs := []controller.LogDetail{}
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+		t.Fatalf("decode exported logs %q: %s", w.Body.String(), err.Error())
+	}
+	return logs, utils.OK
+}
+
+func TestLockLogExportRequiresStepUp(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	lock := owner.addLock("AA:00:00:00:19:02")
+	content := fmt.Sprintf("open_2024-05-01 10:00_1_%s_1", owner.userId)
+	owner.do(http.MethodPost, "/api/v1/lock/log", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, content)}).expect(t, utils.OK)
+
+	// 日常查看日志和获取日志密钥不需要二次验证
+	logs := []controller.LogDetail{}
+	owner.do(http.MethodGet, "/api/v1/lock/log", gin.H{"mac": lock.Mac}).ok(t, &logs)
+	owner.do(http.MethodPut, "/api/v1/lock/log", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.OK)
+	if len(logs) != 1 {
+		t.Fatalf("expect 1 log, got %+v", logs)
+	}
+
+	if _, code := owner.exportLog(lock.Mac); code != utils.STEP_UP_REQUIRED {
+		t.Fatalf("expect step-up required, got %d", code)
+	}
+	exported, code := owner.stepUp(model.StepUpDataExport).exportLog(lock.Mac)
+	if code != utils.OK || len(exported) != 1 || exported[0].RowInfo != logs[0].RowInfo {
+		t.Fatalf("unexpected export %+v, %d", exported, code)
+	}
+	// 二次验证不能绕过门锁的查看权限
+	stranger := srv.login("13800000002")
+	if _, code := stranger.stepUp(model.StepUpDataExport).exportLog(lock.Mac); code != utils.NOT_EXISTS {
+		t.Fatalf("expect not exists, got %d", code)
+	}
+}
+
+func TestApiKeyStepUpExemption(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	lock := owner.addLock("AA:00:00:00:19:03")
+
+	bot, _ := owner.apiKey(gin.H{"name": "report", "scopes": []string{model.ScopeLogRead, model.ScopeCardWrite}})
+	// 接口 key 导出日志不需要二次验证
+	if _, code := bot.exportLog(lock.Mac); code != utils.OK {
+		t.Fatalf("expect api key export, got %d", code)
+	}
+	card := gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, "12345678")}
+	bot.do(http.MethodPost, "/api/v1/lock/card", card).expect(t, utils.OK)
+	bot.do(http.MethodDelete, "/api/v1/lock/card", card).expect(t, utils.OK)
+	// 接口 key 没有的权限范围不能访问
+	bot.do(http.MethodPost, "/api/v1/lock/open", gin.H{"mac": lock.Mac, "code": lockCode}).expect(t, utils.UNAUTH)
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"ezlock/common/logger"
+	"ezlock/config"
+	"ezlock/middleware"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 登录和刷新返回的 token
+type TokenPair struct {
+	Token         string    `json:"token"`         // 访问接口使用的 jwt
+	Expire        time.Time `json:"expire"`        // jwt 的过期时间
+	RefreshToken  string    `json:"refreshToken"`  // 换取新 token 使用，每次使用后失效
+	RefreshExpire time.Time `json:"refreshExpire"` // 刷新 token 的过期时间
+}
+
+// 刷新 token 的过期时间
+func refreshExpireTime() time.Time {
+	return time.Now().Local().Add(time.Duration(config.Get().Jwt.RefreshExpire) * time.Hour)
+}
+
+// 设备信息的最大长度
+const maxDeviceLength = 255
+
+// 登录成功后新建会话，记录设备信息和 ip，返回 token 和刷新 token
+func (ctl *Controller) createSession(ctx context.Context, user *model.User, device, ip string) (*TokenPair, error) {
+	if len(device) > maxDeviceLength {
+		device = device[:maxDeviceLength]
+	}
+	session := &model.Session{
+		Id:           primitive.NewObjectID(),
+		UserId:       user.Id,
+		ExpireTime:   refreshExpireTime(),
+		Device:       device,
+		Ip:           ip,
+		LastUsedTime: time.Now().Local(),
+		UpdateTime:   time.Now().Local(),
+		CreateTime:   time.Now().Local(),
+	}
+	refreshToken, hash, err := utils.NewRefreshToken(session.Id)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshHash = hash
+	if err := ctl.store.Sessions.Insert(ctx, session); err != nil {
+		return nil, err
+	}
+	return issueTokens(session, user.GetRole(), refreshToken)
+}
+
+func issueTokens(session *model.Session, role, refreshToken string) (*TokenPair, error) {
+	token, expire, err := middleware.CreateToken(session.UserId.Hex(), session.Id.Hex(), role)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		Token:         token,
+		Expire:        expire,
+		RefreshToken:  refreshToken,
+		RefreshExpire: session.ExpireTime,
+	}, nil
+}
+
+// 用刷新 token 换取新的 token，刷新 token 每次使用后都会换成新的
+func (ctl *Controller) RefreshToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	params := &struct {
+		RefreshToken string `form:"refreshToken" json:"refreshToken" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	sessionId, hash, ok := utils.ParseRefreshToken(params.RefreshToken)
+	if !ok {
+		utils.ResponseError(utils.UNAUTH, "刷新 token 不合法", c)
+		return
+	}
+
+	session, err := ctl.store.Sessions.Get(ctx, sessionId)
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.UNAUTH, "登录已失效，请重新登录", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	log := logger.Ctx(ctx).WithFields(logger.Fields{"userId": session.UserId.Hex(), "sessionId": session.Id.Hex()})
+	if session.Revoked || time.Now().After(session.ExpireTime) {
+		utils.ResponseError(utils.UNAUTH, "登录已失效，请重新登录", c)
+		return
+	}
+
+	// 角色可能已经被修改，每次刷新都重新读取
+	user, err := ctl.store.Users.Get(ctx, session.UserId)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if user.Disabled {
+		utils.ResponseError(utils.UNAUTH, "账号已被禁用", c)
+		return
+	}
+
+	newToken, newHash, err := utils.NewRefreshToken(session.Id)
+	if err != nil {
+		utils.ResponseError(utils.UNAUTH, err.Error(), c)
+		return
+	}
+	session.ExpireTime = refreshExpireTime()
+	err = ctl.store.Sessions.Rotate(ctx, session.Id, hash, newHash, session.ExpireTime)
+	if err == store.ErrNotFound {
+		// 已经轮换过的刷新 token 又被使用，说明 token 可能被盗用了，撤销整个会话，两边都需要重新登录
+		if err := ctl.store.Sessions.Revoke(ctx, session.Id, session.UserId); err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		log.WithField("outcome", "reused").Warn("refresh token reused, session revoked")
+		utils.ResponseError(utils.UNAUTH, "登录已失效，请重新登录", c)
+		return
+	}
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if err := ctl.store.Sessions.Touch(ctx, session.Id, c.ClientIP(), time.Now().Local()); err != nil {
+		log.Warnf("touch session failed: %s", err.Error())
+	}
+
+	tokens, err := issueTokens(session, user.GetRole(), newToken)
+	if err != nil {
+		utils.ResponseError(utils.UNAUTH, err.Error(), c)
+		return
+	}
+	log.WithField("outcome", "refreshed").Info("token refreshed")
+	utils.ResponseOk(tokens, c)
+}
+
+// 退出登录，撤销当前会话，会话签发的 token 和刷新 token 都不能再使用
+func (ctl *Controller) Logout(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	sessionId := c.GetString(middleware.SessionKey)
+
+	if err := ctl.store.Sessions.Revoke(ctx, utils.ObjectIdHex(sessionId), utils.ObjectIdHex(userId)); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "sessionId": sessionId, "outcome": "logout"}).Info("user logged out")
+	utils.ResponseOk("ok", c)
+}
@@ -0,0 +1,411 @@
+package controller
+
+import (
+	"context"
+	"ezlock/common/logger"
+	"ezlock/common/metrics"
+	"ezlock/config"
+	"ezlock/identity"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 转让发起后等待接受的时间
+const transferExpire = 7 * 24 * time.Hour
+
+// 转让记录和门锁、双方的信息
+type TransferDetail struct {
+	model.Transfer
+	Mac      string `json:"mac"`      // 门锁的 mac
+	LockName string `json:"lockName"` // 门锁的名称
+	From     string `json:"from"`     // 发起者昵称
+	To       string `json:"to"`       // 接受者昵称
+}
+
+// 补充门锁和双方的信息，已经过期但是还没有更新状态的显示为过期
+func (ctl *Controller) transferDetails(ctx context.Context, transfers []model.Transfer) ([]TransferDetail, error) {
+	now := time.Now()
+	details := make([]TransferDetail, 0, len(transfers))
+	for _, transfer := range transfers {
+		detail := TransferDetail{Transfer: transfer}
+		if transfer.Status == model.TransferPending && !transfer.IsPending(now) {
+			detail.Status = model.TransferExpired
+		}
+		lock, err := ctl.store.Locks.Get(ctx, transfer.LockId)
+		if err != nil && err != store.ErrNotFound {
+			return nil, err
+		}
+		if err == nil {
+			detail.Mac = lock.Mac
+			detail.LockName = lock.Name
+		}
+		if detail.From, err = ctl.nickName(ctx, transfer.FromId); err != nil {
+			return nil, err
+		}
+		if detail.To, err = ctl.nickName(ctx, transfer.ToId); err != nil {
+			return nil, err
+		}
+		details = append(details, detail)
+	}
+	return details, nil
+}
+
+// 发起门锁转让，接收者用绑定的手机号接受后门锁才属于接收者，同一把锁同时只能有一个等待中的转让
+func (ctl *Controller) CreateLockTransfer(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac   string `form:"mac" json:"mac" binding:"required"`
+		Phone string `form:"phone" json:"phone" binding:"required"` // 接收者的手机号
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !identity.ValidPhone(params.Phone) {
+		utils.ResponseError(utils.PARAM_ERR, "手机号不合法", c)
+		return
+	}
+
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if !lock.Valid || lock.Own.Hex() != userId {
+		utils.ResponseError(utils.NOT_EXISTS, "只能转让属于自己的门锁", c)
+		return
+	}
+	// 接收者不一定在组织里，组织的门锁不能转让
+	if !lock.OrgId.IsZero() {
+		utils.ResponseError(utils.PARAM_ERR, "组织的门锁不能转让", c)
+		return
+	}
+	user, err := ctl.store.Users.Get(ctx, lock.Own)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if user.PhoneNumber == params.Phone {
+		utils.ResponseError(utils.PARAM_ERR, "不能转让给自己", c)
+		return
+	}
+
+	transfers, err := ctl.store.Transfers.FindByLock(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	now := time.Now().Local()
+	for _, transfer := range transfers {
+		if transfer.IsPending(now) {
+			utils.ResponseError(utils.INVALID, "这把锁已经有等待接受的转让，请先取消", c)
+			return
+		}
+		// 过期的转让在这里更新状态，已经被其他请求更新的忽略
+		if transfer.Status == model.TransferPending {
+			if err := ctl.store.Transfers.Close(ctx, transfer.Id, model.TransferExpired); err != nil && err != store.ErrNotFound {
+				utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+				return
+			}
+		}
+	}
+
+	transfer := &model.Transfer{
+		Id:         primitive.NewObjectID(),
+		LockId:     lock.Id,
+		FromId:     lock.Own,
+		ToPhone:    params.Phone,
+		Status:     model.TransferPending,
+		WipedCards: []string{},
+		ExpireTime: now.Add(transferExpire),
+		UpdateTime: now,
+		CreateTime: now,
+	}
+	if err := ctl.store.Transfers.Insert(ctx, transfer); err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"userId": userId, "mac": params.Mac, "transferId": transfer.Id.Hex(), "outcome": "created",
+	}).Info("lock transfer created")
+
+	utils.ResponseOk(transfer, c)
+}
+
+// 获取自己发起的、接受的和转给自己手机号的转让
+func (ctl *Controller) GetTransferList(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	transfers, err := ctl.store.Transfers.FindByUser(ctx, user.Id, user.PhoneNumber)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	details, err := ctl.transferDetails(ctx, transfers)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk(details, c)
+}
+
+// 查看门锁的转让历史，只有门锁现在的拥有者可以查看
+func (ctl *Controller) GetLockTransferHistory(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		Mac string `form:"mac" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+
+	lock, err := ctl.store.Locks.GetByMac(ctx, params.Mac)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if !lock.Valid || lock.Own.Hex() != userId {
+		utils.ResponseError(utils.NOT_EXISTS, "您无权查看此锁的转让记录", c)
+		return
+	}
+	transfers, err := ctl.store.Transfers.FindByLock(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	details, err := ctl.transferDetails(ctx, transfers)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk(details, c)
+}
+
+// 获取转让记录，转让不存在或者 userId 不是发起者（sender 为 true）或者接收者的时候返回错误
+func (ctl *Controller) getTransfer(ctx context.Context, userId, transferId string, sender bool) (*model.Transfer, *model.User, int, string) {
+	if !primitive.IsValidObjectID(transferId) {
+		return nil, nil, utils.PARAM_ERR, "转让id不合法"
+	}
+	transfer, err := ctl.store.Transfers.Get(ctx, utils.ObjectIdHex(transferId))
+	if err != nil {
+		return nil, nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	user, err := ctl.store.Users.Get(ctx, utils.ObjectIdHex(userId))
+	if err != nil {
+		return nil, nil, utils.StoreErrorCode(utils.MONGO_ERR, err), err.Error()
+	}
+	if sender && transfer.FromId != user.Id {
+		return nil, nil, utils.NOT_EXISTS, "此转让不是您发起的"
+	}
+	if !sender && (user.PhoneNumber == "" || transfer.ToPhone != user.PhoneNumber) {
+		return nil, nil, utils.NOT_EXISTS, "此转让不是给您的"
+	}
+	return transfer, user, utils.OK, ""
+}
+
+// 接受门锁转让，门锁转到自己名下，门锁上所有的授权失效，所有的门卡删除
+// 门锁里还保存着这些门卡，需要通过 WipeTransferCards 获取删除指令写入门锁
+func (ctl *Controller) AcceptLockTransfer(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		TransferId string `form:"transferId" json:"transferId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	transfer, user, code, msg := ctl.getTransfer(ctx, userId, params.TransferId, false)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if !transfer.IsPending(time.Now()) {
+		utils.ResponseError(utils.INVALID, "转让已经失效", c)
+		return
+	}
+	log := logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "transferId": params.TransferId})
+
+	// 门锁已经被删除或者转给了别人，这个转让不能再接受
+	lock, err := ctl.store.Locks.Get(ctx, transfer.LockId)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if !lock.Valid || lock.Own != transfer.FromId {
+		if err := ctl.store.Transfers.Close(ctx, transfer.Id, model.TransferCanceled); err != nil && err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			return
+		}
+		utils.ResponseError(utils.INVALID, "门锁已经不属于转让人", c)
+		return
+	}
+
+	// 先修改拥有者，拥有者已经变化的时候不会接受转让，原拥有者之后不能再给这把锁发授权
+	if err := ctl.store.Locks.SetOwner(ctx, lock.Id, transfer.FromId, user.Id); err != nil {
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.INVALID, "门锁已经不属于转让人", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 原拥有者添加的管理员和成员不再保留，由新拥有者重新添加，移除以后管理员不能再发授权
+	membersRemoved, err := ctl.store.Members.DeleteByLock(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 再按门锁撤销所有授权和门卡，包括修改拥有者之前刚发出的
+	authsRevoked, wipedCards, err := ctl.revokeLockAccess(ctx, lock.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	// 接受失败的时候把门锁还给原拥有者，已经撤销的授权不恢复，原拥有者可以重新分享
+	err = ctl.store.Transfers.Accept(ctx, transfer.Id, user.Id, authsRevoked, wipedCards)
+	if err != nil {
+		if err := ctl.store.Locks.SetOwner(ctx, lock.Id, user.Id, transfer.FromId); err != nil {
+			log.Errorf("restore lock owner failed: %s", err.Error())
+		}
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.INVALID, "转让已经失效", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	log.WithFields(logger.Fields{
+		"mac": lock.Mac, "fromId": transfer.FromId.Hex(), "authsRevoked": authsRevoked, "cardsWiped": len(wipedCards), "membersRemoved": membersRemoved, "outcome": "accepted",
+	}).Info("lock transfer accepted")
+
+	transfer, err = ctl.store.Transfers.Get(ctx, transfer.Id)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	utils.ResponseOk(transfer, c)
+}
+
+// 拒绝转给自己的转让
+func (ctl *Controller) RejectLockTransfer(c *gin.Context) {
+	ctl.closeTransfer(c, false, model.TransferRejected)
+}
+
+// 取消自己发起的转让
+func (ctl *Controller) CancelLockTransfer(c *gin.Context) {
+	ctl.closeTransfer(c, true, model.TransferCanceled)
+}
+
+func (ctl *Controller) closeTransfer(c *gin.Context, sender bool, status string) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		TransferId string `form:"transferId" json:"transferId" binding:"required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	transfer, _, code, msg := ctl.getTransfer(ctx, userId, params.TransferId, sender)
+	if code != utils.OK {
+		utils.ResponseError(code, msg, c)
+		return
+	}
+	if !transfer.IsPending(time.Now()) {
+		utils.ResponseError(utils.INVALID, "转让已经失效", c)
+		return
+	}
+	if err := ctl.store.Transfers.Close(ctx, transfer.Id, status); err != nil {
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.INVALID, "转让已经失效", c)
+			return
+		}
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "transferId": params.TransferId, "outcome": status}).Info("lock transfer closed")
+	utils.ResponseOk("ok", c)
+}
+
+// 获取接受转让或者找回门锁时删除的门卡的删除指令，返回卡号对应的指令，需要逐条通过蓝牙写入门锁
+// code 和生成其他指令一样是门锁返回的 16 位随机数，只有接受转让并且现在还拥有门锁的用户可以获取
+func (ctl *Controller) WipeTransferCards(c *gin.Context) {
+	userId := c.GetString("id")
+	ctx := c.Request.Context()
+	params := &struct {
+		TransferId string `form:"transferId" json:"transferId" binding:"required"`
+		Code       string `form:"code" json:"code" binding:"len=16,required"`
+	}{}
+
+	if ok := utils.CheckParam(params, c); !ok {
+		return
+	}
+	if !primitive.IsValidObjectID(params.TransferId) {
+		utils.ResponseError(utils.PARAM_ERR, "转让id不合法", c)
+		return
+	}
+	transfer, err := ctl.store.Transfers.Get(ctx, utils.ObjectIdHex(params.TransferId))
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+	if (transfer.Status != model.TransferAccepted && transfer.Status != model.TransferReclaimed) || transfer.ToId.Hex() != userId {
+		utils.ResponseError(utils.NOT_EXISTS, "此转让不是您接受的", c)
+		return
+	}
+	lock, err := ctl.store.Locks.Get(ctx, transfer.LockId)
+	if err != nil {
+		utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+		return
+	}
+
+	commands := make(map[string]string, len(transfer.WipedCards))
+	for _, number := range transfer.WipedCards {
+		key, err := utils.GenerateKey(ctx, ctl.store, userId, lock.Mac, fmt.Sprintf(config.Get().Command.DelCard, number), params.Code)
+		if err != nil {
+			utils.ResponseStoreError(utils.ENCRYPT_ERR, err, c)
+			return
+		}
+		commands[number] = key
+	}
+	utils.ResponseOk(commands, c)
+}
+
+// 撤销门锁上所有有效的授权和门卡，返回撤销的授权数量和门卡号，可以重复执行
+func (ctl *Controller) revokeLockAccess(ctx context.Context, lockId primitive.ObjectID) (int, []string, error) {
+	authsRevoked, err := ctl.store.Auths.InvalidateByLock(ctx, lockId)
+	if err != nil {
+		return 0, nil, err
+	}
+	metrics.AuthsRevoked(authsRevoked)
+	cards, err := ctl.store.Cards.FindByLock(ctx, lockId)
+	if err != nil {
+		return authsRevoked, nil, err
+	}
+	wipedCards := []string{}
+	for _, card := range cards {
+		if !card.Valid {
+			continue
+		}
+		if err := ctl.store.Cards.InvalidateByNumber(ctx, lockId, card.Number); err != nil && err != store.ErrNotFound {
+			return authsRevoked, nil, err
+		}
+		wipedCards = append(wipedCards, card.Number)
+	}
+	return authsRevoked, wipedCards, nil
+}
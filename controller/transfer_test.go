@@ -0,0 +1,138 @@
+package controller_test
+
+import (
+	"context"
+	"ezlock/controller"
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+// 给 friend 一个开锁授权
+func (c *client) grant(friend *client, mac string) {
+	t := c.srv.t
+	t.Helper()
+	var token string
+	c.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": mac, "authType": "1"}).ok(t, &token)
+	friend.do(http.MethodPut, "/api/v1/lock/auth", gin.H{"token": token}).expect(t, utils.OK)
+}
+
+func TestLockTransferAccept(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	buyer := srv.login("13800000003")
+	lock := owner.addLock("AA:00:00:00:20:01")
+	owner.grant(friend, lock.Mac)
+	owner.do(http.MethodPost, "/api/v1/lock/card", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, "12345678")}).expect(t, utils.OK)
+	// 分享给还没有注册的手机号的授权也要撤销
+	owner.do(http.MethodPost, "/api/v1/lock/auth", gin.H{"mac": lock.Mac, "authType": "1", "phone": "13800000004"}).expect(t, utils.OK)
+
+	params := gin.H{"mac": lock.Mac, "phone": buyer.phone}
+	owner.do(http.MethodPost, "/api/v1/lock/transfer", params).expect(t, utils.STEP_UP_REQUIRED)
+	transfer := model.Transfer{}
+	owner.stepUp(model.StepUpLockTransfer).do(http.MethodPost, "/api/v1/lock/transfer", params).ok(t, &transfer)
+	if transfer.Status != model.TransferPending {
+		t.Fatalf("unexpected transfer %+v", transfer)
+	}
+
+	accept := gin.H{"transferId": transfer.Id.Hex()}
+	// 只有接收者可以接受
+	friend.do(http.MethodPost, "/api/v1/lock/transfer/accept", accept).expect(t, utils.NOT_EXISTS)
+	buyer.do(http.MethodPost, "/api/v1/lock/transfer/accept", accept).ok(t, &transfer)
+	if transfer.Status != model.TransferAccepted || transfer.AuthsRevoked != 2 || len(transfer.WipedCards) != 1 {
+		t.Fatalf("unexpected accepted transfer %+v", transfer)
+	}
+	buyer.do(http.MethodPost, "/api/v1/lock/transfer/accept", accept).expect(t, utils.INVALID)
+	if got, err := srv.store.Locks.Get(context.Background(), lock.Id); err != nil || got.Own.Hex() != buyer.userId {
+		t.Fatalf("lock not transferred: %+v, %v", got, err)
+	}
+
+	// 原拥有者和原来的授权都不能再开锁
+	open := gin.H{"mac": lock.Mac, "code": lockCode}
+	buyer.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+	owner.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+	srv.login("13800000004").do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+
+	wipe := gin.H{"transferId": transfer.Id.Hex(), "code": lockCode}
+	commands := map[string]string{}
+	buyer.do(http.MethodPost, "/api/v1/lock/transfer/wipe", wipe).ok(t, &commands)
+	if commands["12345678"] == "" {
+		t.Fatalf("missing wipe command: %v", commands)
+	}
+	owner.do(http.MethodPost, "/api/v1/lock/transfer/wipe", wipe).expect(t, utils.NOT_EXISTS)
+}
+
+func TestLockTransferRejectAndCancel(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	buyer := srv.login("13800000002")
+	lock := owner.addLock("AA:00:00:00:20:02")
+	sender := owner.stepUp(model.StepUpLockTransfer)
+	params := gin.H{"mac": lock.Mac, "phone": buyer.phone}
+
+	transfer := model.Transfer{}
+	sender.do(http.MethodPost, "/api/v1/lock/transfer", params).ok(t, &transfer)
+	// 一把锁同时只能有一个等待接受的转让
+	sender.do(http.MethodPost, "/api/v1/lock/transfer", params).expect(t, utils.INVALID)
+	// 发起者不能拒绝，接收者不能取消
+	owner.do(http.MethodPost, "/api/v1/lock/transfer/reject", gin.H{"transferId": transfer.Id.Hex()}).expect(t, utils.NOT_EXISTS)
+	buyer.do(http.MethodDelete, "/api/v1/lock/transfer", gin.H{"transferId": transfer.Id.Hex()}).expect(t, utils.NOT_EXISTS)
+	buyer.do(http.MethodPost, "/api/v1/lock/transfer/reject", gin.H{"transferId": transfer.Id.Hex()}).expect(t, utils.OK)
+	buyer.do(http.MethodPost, "/api/v1/lock/transfer/accept", gin.H{"transferId": transfer.Id.Hex()}).expect(t, utils.INVALID)
+
+	sender.do(http.MethodPost, "/api/v1/lock/transfer", params).ok(t, &transfer)
+	owner.do(http.MethodDelete, "/api/v1/lock/transfer", gin.H{"transferId": transfer.Id.Hex()}).expect(t, utils.OK)
+	buyer.do(http.MethodPost, "/api/v1/lock/transfer/accept", gin.H{"transferId": transfer.Id.Hex()}).expect(t, utils.INVALID)
+	if got, err := srv.store.Locks.Get(context.Background(), lock.Id); err != nil || got.Own.Hex() != owner.userId {
+		t.Fatalf("lock owner changed: %+v, %v", got, err)
+	}
+
+	transfers := []controller.TransferDetail{}
+	buyer.do(http.MethodGet, "/api/v1/lock/transfer/list", nil).ok(t, &transfers)
+	statuses := map[string]int{}
+	for _, transfer := range transfers {
+		statuses[transfer.Status]++
+	}
+	if len(transfers) != 2 || statuses[model.TransferRejected] != 1 || statuses[model.TransferCanceled] != 1 {
+		t.Fatalf("unexpected transfers %+v", transfers)
+	}
+}
+
+func TestDeleteAccountTransfersLocks(t *testing.T) {
+	srv := newServer(t)
+	owner := srv.login("13800000001")
+	friend := srv.login("13800000002")
+	heir := srv.login("13800000003")
+	lock := owner.addLock("AA:00:00:00:20:03")
+	owner.grant(friend, lock.Mac)
+	owner.do(http.MethodPost, "/api/v1/lock/card", gin.H{"mac": lock.Mac, "data": deviceEncrypt(t, lock.Key, "12345678")}).expect(t, utils.OK)
+
+	result := controller.DeleteResult{}
+	owner.stepUp(model.StepUpAccountDelete).do(http.MethodPost, "/account/delete", gin.H{"transferTo": heir.phone}).ok(t, &result)
+	if result.LocksTransferred != 1 || result.LocksRetired != 0 || result.AuthsRevoked != 1 || result.CardsInvalidated != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if got, err := srv.store.Locks.Get(context.Background(), lock.Id); err != nil || !got.Valid || got.Own.Hex() != heir.userId {
+		t.Fatalf("lock should be handed over: %+v, %v", got, err)
+	}
+
+	open := gin.H{"mac": lock.Mac, "code": lockCode}
+	heir.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.OK)
+	friend.do(http.MethodPost, "/api/v1/lock/open", open).expect(t, utils.ENCRYPT_ERR)
+
+	// 接收者通过转让记录获取门卡的删除指令
+	transfers := []controller.TransferDetail{}
+	heir.do(http.MethodGet, "/api/v1/lock/transfer/list", nil).ok(t, &transfers)
+	if len(transfers) != 1 || transfers[0].Status != model.TransferAccepted || transfers[0].LockId != lock.Id {
+		t.Fatalf("unexpected transfers %+v", transfers)
+	}
+	commands := map[string]string{}
+	heir.do(http.MethodPost, "/api/v1/lock/transfer/wipe", gin.H{"transferId": transfers[0].Id.Hex(), "code": lockCode}).ok(t, &commands)
+	if commands["12345678"] == "" {
+		t.Fatalf("missing wipe command: %v", commands)
+	}
+}
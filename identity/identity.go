@@ -0,0 +1,59 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"ezlock/model"
+)
+
+// 登录方式的名称，和配置里的 login.providers 对应
+const (
+	Wechat   = "wechat"
+	Sms      = "sms"
+	Password = "password"
+)
+
+var (
+	// 验证码或者密码错误，不区分用户是否存在
+	ErrInvalidCredential = errors.New("invalid credential")
+	// 手机号格式不对
+	ErrInvalidPhone = errors.New("invalid phone number")
+	// 发送验证码太频繁
+	ErrTooFrequent = errors.New("too frequent")
+	// 连续输错密码太多次，锁定期间不再校验密码
+	ErrLocked = errors.New("too many failed attempts")
+	// 微信接口返回错误，需要用 %w 包装
+	ErrWeapp = errors.New("weapp error")
+	// 短信发送失败，需要用 %w 包装
+	ErrSmsSend = errors.New("send sms failed")
+	// 用户没有绑定手机号、没有设置密码或者不是微信用户，不能用这种方式二次验证
+	ErrNoFactor = errors.New("verify factor not set")
+)
+
+// 登录凭证，各个登录方式的参数结构体都需要嵌入 Client
+type Credential interface {
+	DeviceInfo() string
+}
+
+// 所有登录方式共用的参数
+type Client struct {
+	Device string `form:"device" json:"device"` // 设备信息，比如 wx.getSystemInfo 返回的品牌、型号和系统，或者浏览器信息
+}
+
+func (c *Client) DeviceInfo() string {
+	return c.Device
+}
+
+// 登录方式，不管用哪种方式登录，同一个人对应的都是同一个 model.User，门锁和授权不受登录方式影响
+type Provider interface {
+	// 创建空的登录凭证，用于绑定请求参数
+	NewCredential() Credential
+	// 校验登录凭证，返回登录的用户，用户不存在的时候按需注册，registered 为 true 表示新注册的用户
+	Authenticate(ctx context.Context, credential Credential) (user *model.User, registered bool, err error)
+}
+
+// 二次验证方式，已经登录的用户执行敏感操作之前再次证明身份
+type Verifier interface {
+	// 校验 user 提供的验证码或者密码，不通过的时候返回 ErrInvalidCredential
+	Verify(ctx context.Context, user *model.User, secret string) error
+}
@@ -0,0 +1,131 @@
+package identity
+
+import (
+	"context"
+	"ezlock/config"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"time"
+)
+
+// bcrypt 只使用密码的前 72 个字节
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]{3,64}$`)
+
+// 用户名不存在的时候也做一次哈希比较，响应时间不会暴露用户名是否存在
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("ezlock-dummy-password"), bcrypt.DefaultCost)
+
+// 用户名密码登录，用于 web 管理后台，用户名和密码需要先登录小程序后设置，不能通过密码注册
+type PasswordProvider struct {
+	store *store.Store
+}
+
+func NewPassword(s *store.Store) *PasswordProvider {
+	return &PasswordProvider{store: s}
+}
+
+type passwordCredential struct {
+	Client
+	UserName string `form:"userName" json:"userName" binding:"required"`
+	Password string `form:"password" json:"password" binding:"required"`
+}
+
+// 检查用户名格式，字母、数字和 _.@- 组成，3 到 64 位
+func ValidUserName(userName string) bool {
+	return userNamePattern.MatchString(userName)
+}
+
+// 检查密码长度，8 到 72 个字节
+func ValidPassword(password string) bool {
+	return len(password) >= minPasswordLength && len(password) <= maxPasswordLength
+}
+
+// 计算保存到数据库的密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// 检查密码和哈希是否匹配
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (p *PasswordProvider) NewCredential() Credential {
+	return &passwordCredential{}
+}
+
+func (p *PasswordProvider) Authenticate(ctx context.Context, credential Credential) (*model.User, bool, error) {
+	params := credential.(*passwordCredential)
+	user, err := p.store.Users.GetByUserName(ctx, params.UserName)
+	if err == store.ErrNotFound {
+		return nil, false, p.check(ctx, "name:"+params.UserName, "", params.Password)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := p.Check(ctx, user, params.Password); err != nil {
+		return nil, false, err
+	}
+	return user, false, nil
+}
+
+// 校验用户的密码，和登录共用输错次数，锁定期间返回 ErrLocked
+func (p *PasswordProvider) Check(ctx context.Context, user *model.User, password string) error {
+	return p.check(ctx, failureKey(user.Id), user.PasswordHash, password)
+}
+
+// 按照用户计数，用户改名之后输错次数不会清零
+func failureKey(userId primitive.ObjectID) string {
+	return "user:" + userId.Hex()
+}
+
+// 连续输错 password.maxAttempts 次之后锁定 password.lockDuration 秒，锁定期间不再比较哈希
+// hash 为空的时候也比较一次假的哈希，响应时间和密码错误一样
+func (p *PasswordProvider) check(ctx context.Context, key, hash, password string) error {
+	cfg := config.Get().Password
+	now := time.Now().Local()
+	failure, err := p.store.LoginFailures.Get(ctx, key)
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	if err == nil && now.Before(failure.LockedUntil) {
+		return ErrLocked
+	}
+
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	} else if CheckPassword(hash, password) {
+		if failure == nil {
+			return nil
+		}
+		return p.store.LoginFailures.Reset(ctx, key)
+	}
+
+	lockUntil := now.Add(time.Duration(cfg.LockDuration) * time.Second)
+	failures, err := p.store.LoginFailures.AddFailure(ctx, key, now, lockUntil)
+	if err != nil {
+		return err
+	}
+	if failures >= cfg.MaxAttempts {
+		if err := p.store.LoginFailures.Lock(ctx, key, lockUntil); err != nil {
+			return err
+		}
+	}
+	return ErrInvalidCredential
+}
+
+// 二次验证，校验用户设置的登录密码，和登录共用输错次数
+func (p *PasswordProvider) Verify(ctx context.Context, user *model.User, password string) error {
+	if user.PasswordHash == "" {
+		return ErrNoFactor
+	}
+	return p.Check(ctx, user, password)
+}
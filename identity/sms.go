@@ -0,0 +1,163 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"ezlock/common/sms"
+	"ezlock/config"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math/big"
+	"regexp"
+	"time"
+)
+
+// 验证码的位数
+const codeLength = 6
+
+// 和微信返回的 purePhoneNumber 格式一致，大陆手机号不带区号
+var phonePattern = regexp.MustCompile(`^1[3-9]\d{9}$`)
+
+// 短信验证码登录，按照手机号对应用户，手机号没有被任何用户绑定的时候注册新用户
+type SmsProvider struct {
+	store  *store.Store
+	sender sms.Sender
+}
+
+func NewSms(s *store.Store, sender sms.Sender) *SmsProvider {
+	return &SmsProvider{store: s, sender: sender}
+}
+
+type smsCredential struct {
+	Client
+	Phone string `form:"phone" json:"phone" binding:"required"`
+	Code  string `form:"code" json:"code" binding:"required"`
+}
+
+// 检查手机号格式
+func ValidPhone(phone string) bool {
+	return phonePattern.MatchString(phone)
+}
+
+// 验证码的哈希带上手机号，不同手机号相同的验证码哈希不同
+func hashCode(phone, code string) string {
+	return utils.HashToken(phone + ":" + code)
+}
+
+// 生成随机的数字验证码
+func randomCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < codeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeLength, n), nil
+}
+
+// 给手机号发送登录验证码，距离上一次发送不到 sms.sendInterval 的时候返回 ErrTooFrequent
+func (p *SmsProvider) SendCode(ctx context.Context, phone string) error {
+	if !ValidPhone(phone) {
+		return ErrInvalidPhone
+	}
+	cfg := config.Get().Sms
+	now := time.Now().Local()
+	latest, err := p.store.VerifyCodes.Latest(ctx, phone)
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	if err == nil && now.Sub(latest.CreateTime) < time.Duration(cfg.SendInterval)*time.Second {
+		return ErrTooFrequent
+	}
+
+	code, err := randomCode()
+	if err != nil {
+		return err
+	}
+	err = p.store.VerifyCodes.Insert(ctx, &model.VerifyCode{
+		Id:         primitive.NewObjectID(),
+		Phone:      phone,
+		CodeHash:   hashCode(phone, code),
+		ExpireTime: now.Add(time.Duration(cfg.CodeExpire) * time.Second),
+		CreateTime: now,
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.sender.Send(ctx, phone, code); err != nil {
+		return fmt.Errorf("%w: %s", ErrSmsSend, err.Error())
+	}
+	return nil
+}
+
+func (p *SmsProvider) NewCredential() Credential {
+	return &smsCredential{}
+}
+
+func (p *SmsProvider) Authenticate(ctx context.Context, credential Credential) (*model.User, bool, error) {
+	params := credential.(*smsCredential)
+	if !ValidPhone(params.Phone) {
+		return nil, false, ErrInvalidPhone
+	}
+	if err := p.verify(ctx, params.Phone, params.Code); err != nil {
+		return nil, false, err
+	}
+
+	user, err := p.store.Users.GetByPhone(ctx, params.Phone)
+	if err != store.ErrNotFound {
+		return user, false, err
+	}
+	user = &model.User{
+		Id:          primitive.NewObjectID(),
+		NickName:    maskPhone(params.Phone),
+		PhoneNumber: params.Phone,
+		UpdateTime:  time.Now().Local(),
+		CreateTime:  time.Now().Local(),
+	}
+	return user, true, p.store.Users.Insert(ctx, user)
+}
+
+// 二次验证，校验发送到用户绑定的手机号的验证码
+func (p *SmsProvider) Verify(ctx context.Context, user *model.User, code string) error {
+	if user.PhoneNumber == "" {
+		return ErrNoFactor
+	}
+	return p.verify(ctx, user.PhoneNumber, code)
+}
+
+// 只校验最近一次发送的验证码，输错次数超过 sms.maxAttempts 后需要重新发送
+func (p *SmsProvider) verify(ctx context.Context, phone, code string) error {
+	latest, err := p.store.VerifyCodes.Latest(ctx, phone)
+	if err == store.ErrNotFound {
+		return ErrInvalidCredential
+	}
+	if err != nil {
+		return err
+	}
+	if latest.Used || time.Now().After(latest.ExpireTime) || latest.Attempts >= config.Get().Sms.MaxAttempts {
+		return ErrInvalidCredential
+	}
+	if subtle.ConstantTimeCompare([]byte(hashCode(phone, code)), []byte(latest.CodeHash)) != 1 {
+		if err := p.store.VerifyCodes.AddAttempt(ctx, latest.Id); err != nil {
+			return err
+		}
+		return ErrInvalidCredential
+	}
+	// 并发使用同一个验证码的时候只有一个能登录
+	err = p.store.VerifyCodes.Use(ctx, latest.Id)
+	if err == store.ErrNotFound {
+		return ErrInvalidCredential
+	}
+	return err
+}
+
+// 新注册用户的昵称，隐藏手机号中间四位
+func maskPhone(phone string) string {
+	return phone[:3] + "****" + phone[len(phone)-4:]
+}
@@ -0,0 +1,107 @@
+package identity
+
+import (
+	"context"
+	"crypto/subtle"
+	"ezlock/config"
+	"ezlock/model"
+	"ezlock/store"
+	"fmt"
+	"github.com/medivhzhan/weapp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 微信小程序登录，按照 openId 对应用户
+type WechatProvider struct {
+	store *store.Store
+}
+
+func NewWechat(s *store.Store) *WechatProvider {
+	return &WechatProvider{store: s}
+}
+
+type wechatCredential struct {
+	Client
+	Code          string `form:"code" json:"code" binding:"required"` // 用户登录凭证
+	Iv            string `form:"iv" json:"iv" binding:"required"`
+	EncryptedData string `form:"encryptedData" json:"encryptedData" binding:"required"`
+	RawData       string `form:"rawData" json:"rawData" binding:"required"`
+	Signature     string `form:"signature" json:"signature" binding:"required"`
+}
+
+func (p *WechatProvider) NewCredential() Credential {
+	return &wechatCredential{}
+}
+
+func (p *WechatProvider) Authenticate(ctx context.Context, credential Credential) (*model.User, bool, error) {
+	params := credential.(*wechatCredential)
+	weappCfg := config.Get().Weapp
+	res, err := weapp.Login(weappCfg.AppID, weappCfg.Secret, params.Code)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %s", ErrWeapp, err.Error())
+	}
+	// 获取到用户到openid
+	openId := res.OpenID
+	sessionKey := res.SessionKey
+
+	// 获取用户信息
+	userInfo, err := weapp.DecryptUserInfo(params.RawData, params.EncryptedData, params.Signature, params.Iv, sessionKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %s", ErrWeapp, err.Error())
+	}
+
+	user, err := p.store.Users.GetByOpenId(ctx, openId)
+	switch err {
+	case nil:
+		// 更新sessionKey
+		user.SessionKey = sessionKey
+		user.NickName = userInfo.Nickname
+		user.UnionId = userInfo.UnionID
+		user.Gender = userInfo.Gender
+		user.Province = userInfo.Province
+		user.City = userInfo.City
+		user.Country = userInfo.Country
+		user.AvatarUrl = userInfo.Avatar
+		user.Language = userInfo.Language
+		return user, false, p.store.Users.UpdateProfile(ctx, user)
+	case store.ErrNotFound:
+		user = &model.User{
+			Id:         primitive.NewObjectID(),
+			OpenId:     openId,
+			SessionKey: sessionKey,
+			NickName:   userInfo.Nickname,
+			UnionId:    userInfo.UnionID,
+			Gender:     userInfo.Gender,
+			Province:   userInfo.Province,
+			City:       userInfo.City,
+			Country:    userInfo.Country,
+			AvatarUrl:  userInfo.Avatar,
+			Language:   userInfo.Language,
+			UpdateTime: time.Now().Local(),
+			CreateTime: time.Now().Local(),
+		}
+		return user, true, p.store.Users.Insert(ctx, user)
+	}
+	return nil, false, err
+}
+
+// 二次验证，小程序重新调用 wx.login 拿到的 code 换取的 openId 和当前用户一致才通过
+// code 只能在用户本人的微信里生成并且只能使用一次，只偷到登录 token 的时候没有办法通过
+// 没有绑定手机号也没有设置密码的微信用户用这种方式验证
+func (p *WechatProvider) Verify(ctx context.Context, user *model.User, code string) error {
+	if user.OpenId == "" {
+		return ErrNoFactor
+	}
+	weappCfg := config.Get().Weapp
+	res, err := weapp.Login(weappCfg.AppID, weappCfg.Secret, code)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrWeapp, err.Error())
+	}
+	if subtle.ConstantTimeCompare([]byte(res.OpenID), []byte(user.OpenId)) != 1 {
+		return ErrInvalidCredential
+	}
+	// 重新登录后微信会换掉 sessionKey，解密手机号需要用新的
+	user.SessionKey = res.SessionKey
+	return p.store.Users.UpdateProfile(ctx, user)
+}
@@ -1,35 +1,254 @@
 package main
 
 import (
+	"context"
+	"ezlock/common/health"
+	"ezlock/common/logger"
+	"ezlock/common/metrics"
+	"ezlock/common/migrate"
+	"ezlock/common/mongo"
+	"ezlock/common/sms"
+	"ezlock/common/sqldb"
+	"ezlock/common/tracing"
+	"ezlock/common/version"
 	"ezlock/config"
+	"ezlock/controller"
+	"ezlock/middleware"
 	"ezlock/router"
+	"ezlock/store"
+	"ezlock/store/mongostore"
+	"ezlock/store/sqlstore"
 	"fmt"
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
+	path, cfg, err := loadConfig()
+	if err != nil {
+		logger.Errorf("load config error: %s", err.Error())
+		os.Exit(1)
+	}
+
+	// ezlock migrate up|down|status 执行数据库迁移，只需要数据库的配置
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	// ezlock role <userId> <role> 修改用户角色，用于设置第一个管理员
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		os.Exit(runRole(os.Args[2:]))
+	}
+
+	// 启动服务之前检查配置，拒绝没有修改过的占位符
+	if err := cfg.Validate(); err != nil {
+		logger.Errorf("%s", err.Error())
+		os.Exit(1)
+	}
+	logger.SetLevel(cfg.Log.Level)
+	logger.SetFormat(cfg.Log.Format)
+	shutdownTracing, err := tracing.Init(tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: cfg.Server.ProjName,
+		Version:     version.Version,
+	})
+	if err != nil {
+		logger.Errorf("init tracing error: %s", err.Error())
+		os.Exit(1)
+	}
+	middleware.InitAuth()
+	smsSender, err := sms.New(cfg.Sms.Sender)
+	if err != nil {
+		logger.Errorf("init sms sender error: %s", err.Error())
+		os.Exit(1)
+	}
+
+	// 数据库连接在后台建立，连接上之前服务也可以启动，只是报告没有就绪
+	db, s, migrator, err := openStore()
+	if err != nil {
+		logger.Errorf("open store error: %s", err.Error())
+		os.Exit(1)
+	}
+	stop := make(chan struct{})
+	go connectStore(db, migrator, stop)
+
 	// 运行 job
 	//go controller.CronCountCapInfo()
 	server := gin.New()
 	// product 模式运行
 	gin.SetMode(gin.ReleaseMode)
 	// v1 版本的api
-	// 支持前端跨域，方便前后端分离调试
-	server.Use(cors.New(cors.Config{
-		AllowMethods:     []string{"GET", "POST", "PUT", "HEAD", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type"},
-		AllowCredentials: false,
-		AllowAllOrigins:  true,
-		MaxAge:           12 * time.Hour,
-	}))
-	router.Account(server)
-	router.Api(server)
-	if err := server.Run(fmt.Sprintf(":%d", config.ListenPort)); err != nil {
-		os.Exit(1)
-		fmt.Println("http server start error: ", err.Error())
+	server.Use(middleware.RequestId())
+	server.Use(middleware.Tracing())
+	server.Use(middleware.AccessLog())
+	server.Use(middleware.Metrics())
+	server.Use(middleware.Cors())
+	router.Metrics(server)
+	router.Health(server, []health.Check{
+		{Name: "database", Func: db.Ping},
+		{Name: "schema", Func: migrator.Verify},
+		{Name: "config", Func: func(ctx context.Context) error { return config.Get().Validate() }},
+	})
+	router.Admin(server)
+	// 业务接口都需要数据库，数据库没有就绪的时候直接拒绝
+	app := server.Group("/", middleware.RequireReady(db))
+	ctl := controller.New(s, smsSender)
+	auth := middleware.Auth(s.Sessions)
+	router.Account(app, ctl, auth)
+	router.Api(app, ctl, auth, middleware.AuthOrApiKey(s.Sessions, s.ApiKeys), middleware.RequireTenant(s.OrgMembers))
+	router.Platform(app, ctl, auth)
+
+	httpServer := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Server.ListenPort),
+		Handler:      server,
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
+	}
+	go func() {
+		logger.Infof("http server %s listen on %s", version.Version, httpServer.Addr)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("http server start error: %s", err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	// SIGHUP 重新加载配置，SIGINT 和 SIGTERM 退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			reloadConfig(path)
+			continue
+		}
+		logger.Infof("received %s, shutting down", sig)
+		break
+	}
+	close(stop)
+	shutdown(httpServer, db, shutdownTracing)
+}
+
+// 先停止接收新请求，等待正在处理的请求完成，超过等待时间后强制关闭，然后关闭数据库连接，最后导出剩下的 span
+func shutdown(httpServer *http.Server, db database, shutdownTracing func(ctx context.Context) error) {
+	grace := time.Duration(config.Get().Server.ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Warnf("http server shutdown error: %s, force close", err.Error())
+		httpServer.Close()
 	}
 
+	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(config.Get().Store.Timeout)*time.Second)
+	defer cancel()
+	if err := db.Close(ctx); err != nil {
+		logger.Errorf("database close error: %s", err.Error())
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Errorf("tracing shutdown error: %s", err.Error())
+	}
+	logger.Infof("server stopped")
+}
+
+// 重新加载配置文件和环境变量，配置不合法的时候保持原来的配置
+func reloadConfig(path string) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		logger.Errorf("reload config error: %s", err.Error())
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Errorf("reload config error: %s", err.Error())
+		return
+	}
+	ignored := config.Reload(cfg)
+	if len(ignored) > 0 {
+		logger.Warnf("config %v changed, restart to take effect", ignored)
+	}
+	cfg = config.Get()
+	logger.SetLevel(cfg.Log.Level)
+	logger.SetFormat(cfg.Log.Format)
+	middleware.ReloadAuth()
+	logger.Infof("config reloaded")
+}
+
+// 数据库连接，mongo.DB 和 sqldb.DB 都实现了这个接口
+type database interface {
+	middleware.ReadyChecker
+	Connect(stop <-chan struct{}, onError func(err error, retryAfter time.Duration)) error
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// 加载配置文件和环境变量，加载成功后作为当前配置，返回配置文件路径用于重新加载
+func loadConfig() (string, *config.Config, error) {
+	path, err := config.Path()
+	if err != nil {
+		return "", nil, err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return "", nil, err
+	}
+	config.Set(cfg)
+	return path, cfg, nil
+}
+
+// 根据配置的存储后端创建数据库连接、Store 和迁移执行器，这时还没有连接数据库
+func openStore() (database, *store.Store, *migrate.Migrator, error) {
+	cfg := config.Get()
+	if cfg.Store.Backend == "mongo" {
+		db := mongo.New(mongo.Options{
+			Url:              cfg.Mongo.Url,
+			DataBaseName:     cfg.Mongo.DataBaseName,
+			Timeout:          time.Duration(cfg.Store.Timeout) * time.Second,
+			OperationTimeout: time.Duration(cfg.Store.OperationTimeout) * time.Second,
+			PoolLimit:        cfg.Mongo.ConnPoolLimit,
+			RetryInterval:    time.Duration(cfg.Store.RetryInterval) * time.Second,
+			MaxRetryInterval: time.Duration(cfg.Store.MaxRetryInterval) * time.Second,
+			Observer:         metrics.DbObserver(cfg.Store.Backend),
+		})
+		return db, mongostore.New(db), mongostore.Migrator(db), nil
+	}
+
+	db, err := sqldb.New(sqldb.Options{
+		Driver:           cfg.Store.Backend,
+		Dsn:              cfg.Sql.Dsn,
+		Timeout:          time.Duration(cfg.Store.Timeout) * time.Second,
+		OperationTimeout: time.Duration(cfg.Store.OperationTimeout) * time.Second,
+		PoolLimit:        cfg.Sql.ConnPoolLimit,
+		RetryInterval:    time.Duration(cfg.Store.RetryInterval) * time.Second,
+		MaxRetryInterval: time.Duration(cfg.Store.MaxRetryInterval) * time.Second,
+		Observer:         metrics.DbObserver(cfg.Store.Backend),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return db, sqlstore.New(db), sqlstore.Migrator(db), nil
+}
+
+// 连接数据库，连接失败会一直退避重试，直到连接成功或者服务退出
+// 连接成功后如果开启了自动迁移，执行还没有执行的迁移
+func connectStore(db database, migrator *migrate.Migrator, stop <-chan struct{}) {
+	cfg := config.Get().Store
+	err := db.Connect(stop, func(err error, retryAfter time.Duration) {
+		logger.Warnf("%s connect occur error [%s], retry after %s", cfg.Backend, err.Error(), retryAfter)
+	})
+	if err != nil || !cfg.AutoMigrate {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.MigrateTimeout)*time.Second)
+	defer cancel()
+	_, err = migrator.Up(ctx, func(m migrate.Migration) {
+		logger.Infof("%s migration %d %s applied", cfg.Backend, m.Version, m.Name)
+	})
+	if err != nil {
+		logger.Errorf("%s migrate occur error [%s]", cfg.Backend, err.Error())
+	}
 }
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"ezlock/common/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// 每个请求处理完记录一条访问日志，不记录请求参数，参数里可能有密钥
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := logger.Ctx(c.Request.Context()).WithFields(logger.Fields{
+			"method":   c.Request.Method,
+			"path":     c.Request.URL.Path,
+			"status":   c.Writer.Status(),
+			"latency":  time.Since(start).String(),
+			"clientIp": c.ClientIP(),
+			"userId":   c.GetString("id"),
+		})
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			entry.Error("request finished")
+			return
+		}
+		entry.Info("request finished")
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"ezlock/config"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 管理接口的 token 放在这个请求头里
+const AdminTokenHeader = "X-Admin-Token"
+
+// 管理接口需要携带配置的 token，没有配置 token 的时候管理接口关闭
+func RequireAdminToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := config.Get().Admin.Token
+		if token == "" {
+			utils.ResponseError(utils.UNAUTH, "管理接口没有开启", c)
+			c.Abort()
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader(AdminTokenHeader)), []byte(token)) != 1 {
+			utils.ResponseError(utils.UNAUTH, "管理 token 不正确", c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
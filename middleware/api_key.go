@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"ezlock/common/logger"
+	"ezlock/model"
+	"ezlock/store"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// 接口 key 放在这个请求头里
+const ApiKeyHeader = "X-Api-Key"
+
+// 通过校验后接口 key 放在 gin.Context 的这个字段里，登录访问的请求没有
+const ApiKeyKey = "apiKey"
+
+// 可以使用接口 key 访问的接口使用，带了接口 key 请求头的按照接口 key 校验，否则和 Auth 一样校验 token
+func AuthOrApiKey(sessions store.SessionStore, apiKeys store.ApiKeyStore) gin.HandlersChain {
+	return gin.HandlersChain{
+		RequireApiKey(apiKeys),
+		skipApiKey(AuthMiddlerware.MiddlewareFunc()),
+		skipApiKey(RequireSession(sessions)),
+	}
+}
+
+// 已经通过接口 key 校验的请求跳过 token 校验
+func skipApiKey(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(ApiKeyKey); ok {
+			return
+		}
+		handler(c)
+	}
+}
+
+// 校验接口 key，请求以 key 的创建者身份执行，key 限制了门锁的时候只能操作这些锁
+// 没有带接口 key 请求头的请求不处理
+func RequireApiKey(apiKeys store.ApiKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.GetHeader(ApiKeyHeader)
+		if raw == "" {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		keyId, hash, ok := utils.ParseApiKey(raw)
+		if !ok {
+			utils.ResponseError(utils.UNAUTH, "接口 key 无效", c)
+			c.Abort()
+			return
+		}
+		key, err := apiKeys.Get(ctx, keyId)
+		if err != nil && err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			c.Abort()
+			return
+		}
+		if err == store.ErrNotFound || key.Revoked || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hash)) != 1 {
+			utils.ResponseError(utils.UNAUTH, "接口 key 无效", c)
+			c.Abort()
+			return
+		}
+		// 更新最后使用时间，更新失败不影响请求
+		now := time.Now().Local()
+		if now.Sub(key.LastUsedTime) > touchInterval {
+			if err := apiKeys.Touch(ctx, key.Id, now); err != nil {
+				logger.Ctx(ctx).WithField("apiKeyId", key.Id.Hex()).Errorf("touch api key failed: %s", err.Error())
+			}
+		}
+		if len(key.LockIds) > 0 {
+			c.Request = c.Request.WithContext(utils.WithLockScope(ctx, key.LockIds))
+		}
+		c.Set("id", key.UserId.Hex())
+		c.Set(ApiKeyKey, key)
+		c.Next()
+	}
+}
+
+// 接口 key 需要有 scope 权限范围才能访问，登录访问的请求不受限制，需要放在 AuthOrApiKey 之后
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, ok := c.Get(ApiKeyKey); ok && !value.(*model.ApiKey).HasScope(scope) {
+			utils.ResponseError(utils.UNAUTH, fmt.Sprintf("接口 key 没有 %s 权限", scope), c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
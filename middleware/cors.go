@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"ezlock/config"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// 支持前端跨域，方便前后端分离调试
+// 允许的来源每次请求都从当前配置读取，重新加载配置后立即生效
+func Cors() gin.HandlerFunc {
+	return cors.New(cors.Config{
+		AllowMethods:     []string{"GET", "POST", "PUT", "HEAD", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type"},
+		AllowCredentials: false,
+		AllowOriginFunc:  allowOrigin,
+		MaxAge:           12 * time.Hour,
+	})
+}
+
+func allowOrigin(origin string) bool {
+	origins := config.Get().Server.CorsOrigins
+	if len(origins) == 0 {
+		return true
+	}
+	for _, allowed := range origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/dgrijalva/jwt-go.v3"
+	"time"
+)
+
+// 门锁挑战的有效期，小程序需要在这段时间里通过蓝牙把随机数发给门锁并拿回应答
+const deviceChallengeExpire = 2 * time.Minute
+
+// 门锁挑战 token 里存放类型、门锁和随机数的字段
+const (
+	macClaim            = "mac"
+	nonceClaim          = "nonce"
+	deviceChallengeType = "device_challenge"
+)
+
+// 门锁挑战 token 使用从 jwt 密钥派生的密钥签名，和登录 token、二次验证 token 互相不能混用
+func deviceChallengeKey() []byte {
+	sum := sha256.Sum256(append([]byte("ezlock-device-challenge:"), AuthMiddlerware.Key...))
+	return sum[:]
+}
+
+// 签发门锁挑战，返回挑战 token、需要门锁用出厂密钥加密的随机数和过期时间
+// 随机数只放在签名的 token 里，只对签发时的用户和门锁有效，id 是数据库里挑战记录的 id，用来保证只能使用一次
+func CreateDeviceChallenge(id, userId, mac string) (token, nonce string, expire time.Time, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", time.Time{}, err
+	}
+	nonce = hex.EncodeToString(b)
+	now := time.Now()
+	expire = now.Add(deviceChallengeExpire)
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       userId,
+		"jti":      id,
+		typeClaim:  deviceChallengeType,
+		macClaim:   mac,
+		nonceClaim: nonce,
+		"iat":      now.Unix(),
+		"exp":      expire.Unix(),
+	}).SignedString(deviceChallengeKey())
+	return token, nonce, expire, err
+}
+
+// 检查挑战 token 的签名、有效期、类型，以及是不是签发给这个用户和门锁的，返回挑战记录的 id 和随机数
+// 调用方还需要把挑战记录标记为已经使用，同一个应答不能重放
+func ParseDeviceChallenge(raw, userId, mac string) (id, nonce string, ok bool) {
+	if raw == "" {
+		return "", "", false
+	}
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return deviceChallengeKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return "", "", false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims[typeClaim] != deviceChallengeType || claims["id"] != userId || claims[macClaim] != mac {
+		return "", "", false
+	}
+	id, _ = claims["jti"].(string)
+	nonce, _ = claims[nonceClaim].(string)
+	return id, nonce, id != "" && nonce != ""
+}
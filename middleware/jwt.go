@@ -1,31 +1,101 @@
 package middleware
 
 import (
+	"ezlock/common/logger"
 	"ezlock/config"
+	"ezlock/store"
 	"ezlock/utils"
 	"github.com/appleboy/gin-jwt"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
 	"time"
 )
 
 var AuthMiddlerware jwt.GinJWTMiddleware
 
-func CreateToken(userId string) (string, time.Time, error) {
+// 通过校验后当前会话 id 放在 gin.Context 的这个字段里
+const SessionKey = "sessionId"
+
+// 生成 token 的时候会读取有效期，重新加载配置的时候会修改有效期
+var authMu sync.Mutex
+
+// token 里存放会话 id 和用户角色的字段
+const (
+	sessionClaim = "sid"
+	roleClaim    = "role"
+)
+
+// 会话最后使用时间的更新间隔，避免每个请求都写一次数据库
+const touchInterval = time.Minute
+
+func CreateToken(userId, sessionId, role string) (string, time.Time, error) {
+	authMu.Lock()
+	defer authMu.Unlock()
 	AuthMiddlerware.MiddlewareInit()
 	// 默认id字段存放userid，如果要加自定义的payload则在下面的data字段加入
-	return AuthMiddlerware.TokenGenerator(userId, nil)
+	return AuthMiddlerware.TokenGenerator(userId, jwt.MapClaims{sessionClaim: sessionId, roleClaim: role})
+}
+
+// 需要登录的接口使用，先校验 token，再检查 token 所属的会话有没有被撤销
+func Auth(sessions store.SessionStore) gin.HandlersChain {
+	return gin.HandlersChain{AuthMiddlerware.MiddlewareFunc(), RequireSession(sessions)}
 }
 
-func init() {
+// 检查 token 所属的会话，退出登录或者被撤销的会话签发的 token 在过期之前也不能再使用
+// 每个请求都会检查，会话被撤销后立即不能再获取开锁密钥
+func RequireSession(sessions store.SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := jwt.ExtractClaims(c)
+		userId, _ := claims["id"].(string)
+		sessionId, _ := claims[sessionClaim].(string)
+		if !primitive.IsValidObjectID(sessionId) {
+			utils.ResponseError(utils.UNAUTH, "登录已失效，请重新登录", c)
+			c.Abort()
+			return
+		}
+
+		session, err := sessions.Get(c.Request.Context(), utils.ObjectIdHex(sessionId))
+		if err != nil && err != store.ErrNotFound {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			c.Abort()
+			return
+		}
+		if err == store.ErrNotFound || session.Revoked || session.UserId.Hex() != userId {
+			utils.ResponseError(utils.UNAUTH, "登录已失效，请重新登录", c)
+			c.Abort()
+			return
+		}
+		// 更新会话的最后使用时间和 ip，更新失败不影响请求
+		now := time.Now().Local()
+		if now.Sub(session.LastUsedTime) > touchInterval || session.Ip != c.ClientIP() {
+			if err := sessions.Touch(c.Request.Context(), session.Id, c.ClientIP(), now); err != nil {
+				logger.Ctx(c.Request.Context()).WithField("sessionId", sessionId).Errorf("touch session failed: %s", err.Error())
+			}
+		}
+		// gin-jwt 把用户 id 放在 userID 里，接口里统一从 id 读取，和接口 key 的请求保持一致
+		c.Set("id", userId)
+		c.Set(SessionKey, sessionId)
+		c.Next()
+	}
+}
 
+// 根据配置初始化 jwt，需要在加载配置之后、注册路由之前调用
+func InitAuth() {
+	cfg := config.Get()
 	AuthMiddlerware = jwt.GinJWTMiddleware{
-		Realm:      config.ProjName,
-		Key:        []byte(config.JwtSecretKey),
-		Timeout:    15 * time.Minute,
-		MaxRefresh: 15 * time.Minute,
+		Realm:      cfg.Server.ProjName,
+		Key:        []byte(cfg.Jwt.SecretKey),
+		Timeout:    time.Duration(cfg.Jwt.Timeout) * time.Minute,
+		MaxRefresh: time.Duration(cfg.Jwt.MaxRefresh) * time.Minute,
 		Authenticator: func(c *gin.Context) (interface{}, error) {
 			return nil, nil
 		},
+		// TokenGenerator 只会把 PayloadFunc 返回的字段写入 token，会话 id 和角色通过 data 传进来
+		PayloadFunc: func(data interface{}) jwt.MapClaims {
+			claims, _ := data.(jwt.MapClaims)
+			return claims
+		},
 		//Authorizator: authorizator,
 		Unauthorized: func(c *gin.Context, code int, message string) {
 			utils.ResponseError(utils.UNAUTH, message, c)
@@ -33,7 +103,16 @@ func init() {
 		},
 
 		TokenLookup:   "header: Authorization",
-		TokenHeadName: config.ProjName,
+		TokenHeadName: cfg.Server.ProjName,
 		TimeFunc:      time.Now,
 	}
 }
+
+// 重新加载配置后更新 token 的有效期，已经签发的 token 不受影响
+func ReloadAuth() {
+	cfg := config.Get()
+	authMu.Lock()
+	defer authMu.Unlock()
+	AuthMiddlerware.Timeout = time.Duration(cfg.Jwt.Timeout) * time.Minute
+	AuthMiddlerware.MaxRefresh = time.Duration(cfg.Jwt.MaxRefresh) * time.Minute
+}
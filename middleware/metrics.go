@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"ezlock/common/metrics"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// 统计每个接口的请求数和处理时间
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		metrics.ObserveRequest(c.Request.Method, route(c), c.Writer.Status(), time.Since(start))
+	}
+}
+
+// 指标里使用的接口名称，接口都没有路径参数，直接使用请求路径
+// 没有匹配到路由的请求统一为 unmatched，防止随意的路径产生大量的指标
+func route(c *gin.Context) string {
+	if c.Writer.Status() == http.StatusNotFound {
+		return "unmatched"
+	}
+	return c.Request.URL.Path
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// 可以报告自己是否就绪的依赖，比如数据库连接
+type ReadyChecker interface {
+	Ready() bool
+}
+
+// 依赖没有就绪的时候直接拒绝请求，不再往数据库发请求
+func RequireReady(checker ReadyChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checker.Ready() {
+			utils.ResponseError(utils.NOT_READY, "数据库还没有连接上，请稍后再试", c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
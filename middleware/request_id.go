@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"ezlock/common/logger"
+	"github.com/gin-gonic/gin"
+	"regexp"
+)
+
+// 请求 id 的请求头和响应头
+const RequestIdHeader = "X-Request-ID"
+
+// 客户端或者网关传过来的请求 id 只接受这些字符，防止伪造日志
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// 给每个请求分配请求 id，请求头里已经有合法的 id 的时候沿用
+// id 会写入响应头，放进请求的 context 里，日志和错误响应都会带上
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIdHeader)
+		if !requestIdPattern.MatchString(id) {
+			id = newRequestId()
+		}
+		c.Header(RequestIdHeader, id)
+		c.Request = c.Request.WithContext(logger.WithRequestId(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	// 读取随机数失败的概率很小，失败的时候 id 是全 0，不影响请求
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"ezlock/model"
+	"ezlock/utils"
+	"github.com/appleboy/gin-jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// 通过校验后用户角色放在 gin.Context 的这个字段里
+const RoleKey = "role"
+
+// 只允许 roles 里的角色访问，需要放在 Auth 之后
+// 角色来自 token，修改角色的时候会撤销用户所有的会话，旧的 token 不会继续使用原来的角色
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := jwt.ExtractClaims(c)[roleClaim].(string)
+		if role == "" {
+			role = model.RoleUser
+		}
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Set(RoleKey, role)
+				c.Next()
+				return
+			}
+		}
+		utils.ResponseError(utils.UNAUTH, "没有管理权限", c)
+		c.Abort()
+	}
+}
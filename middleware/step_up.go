@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"ezlock/config"
+	"ezlock/model"
+	"ezlock/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/dgrijalva/jwt-go.v3"
+	"time"
+)
+
+// 二次验证后签发的 token 放在这个请求头里，和登录 token 一起使用
+const StepUpHeader = "X-Step-Up-Token"
+
+// 二次验证 token 里存放类型和可以执行的操作的字段
+const (
+	typeClaim  = "typ"
+	scopeClaim = "scp"
+	stepUpType = "step_up"
+)
+
+// 二次验证 token 使用从 jwt 密钥派生的密钥签名，不能当作登录 token 使用，登录 token 也不能当作二次验证 token 使用
+func stepUpKey() []byte {
+	sum := sha256.Sum256(append([]byte("ezlock-step-up:"), AuthMiddlerware.Key...))
+	return sum[:]
+}
+
+// 签发二次验证 token，只对签发时的会话有效，只能执行 scopes 里的操作
+func CreateStepUpToken(userId, sessionId string, scopes []string) (string, time.Time, error) {
+	now := time.Now()
+	expire := now.Add(time.Duration(config.Get().Jwt.StepUpExpire) * time.Minute)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":         userId,
+		sessionClaim: sessionId,
+		typeClaim:    stepUpType,
+		scopeClaim:   scopes,
+		"iat":        now.Unix(),
+		"exp":        expire.Unix(),
+	})
+	signed, err := token.SignedString(stepUpKey())
+	return signed, expire, err
+}
+
+// 敏感操作使用，需要放在 Auth 或者 AuthOrApiKey 之后，登录访问的请求需要带上包含 scope 的二次验证 token
+// 接口 key 没有办法做交互式的验证，只能执行 model.StepUpApiKeyExempt 里列出的操作，其他操作直接拒绝
+func RequireStepUp(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(ApiKeyKey); ok {
+			if !model.StepUpApiKeyExempt(scope) {
+				utils.ResponseError(utils.UNAUTH, fmt.Sprintf("接口 key 不能执行此操作 [%s]", scope), c)
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+		if !validStepUp(c.GetHeader(StepUpHeader), c.GetString("id"), c.GetString(SessionKey), scope) {
+			utils.ResponseError(utils.STEP_UP_REQUIRED, fmt.Sprintf("此操作需要先进行二次验证 [%s]", scope), c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 检查签名、有效期、类型，以及 token 是不是当前会话签发的、有没有包含 scope
+func validStepUp(raw, userId, sessionId, scope string) bool {
+	if raw == "" {
+		return false
+	}
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return stepUpKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims[typeClaim] != stepUpType || claims["id"] != userId || claims[sessionClaim] != sessionId {
+		return false
+	}
+	scopes, _ := claims[scopeClaim].([]interface{})
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
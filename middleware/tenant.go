@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"ezlock/store"
+	"ezlock/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 切换到组织的请求头，没有带的时候访问的是个人数据
+const OrgIdHeader = "X-Org-Id"
+
+// 通过校验后当前用户在组织里的成员记录放在 gin.Context 的这个字段里，访问个人数据的请求没有
+const OrgMemberKey = "orgMember"
+
+// 按照请求头把请求限制在一个租户里，需要放在 Auth 或者 AuthOrApiKey 之后
+// 带了组织 id 的请求必须是这个组织的成员，之后门锁、门卡、授权和日志的读写都只在这个组织里
+func RequireTenant(orgMembers store.OrgMemberStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		orgId := c.GetHeader(OrgIdHeader)
+		if orgId == "" {
+			c.Request = c.Request.WithContext(store.WithTenant(ctx, primitive.NilObjectID))
+			c.Next()
+			return
+		}
+		if !primitive.IsValidObjectID(orgId) {
+			utils.ResponseError(utils.PARAM_ERR, "组织id不合法", c)
+			c.Abort()
+			return
+		}
+		member, err := orgMembers.Get(ctx, utils.ObjectIdHex(orgId), utils.ObjectIdHex(c.GetString("id")))
+		if err == store.ErrNotFound {
+			utils.ResponseError(utils.UNAUTH, "您不是这个组织的成员", c)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			utils.ResponseStoreError(utils.MONGO_ERR, err, c)
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(store.WithTenant(ctx, member.OrgId))
+		c.Set(OrgMemberKey, member)
+		c.Next()
+	}
+}
+
+// 不按租户隔离，请求可以读写所有组织和个人的数据
+// 只能用在平台管理、导出和注销个人数据这些需要跨租户的接口上
+func AllTenants() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(store.AllTenants(c.Request.Context()))
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"ezlock/common/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+)
+
+// 每个请求一个 span，数据库和 utils 里的 span 都是它的子 span
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, span := tracing.StartServer(c.Request.Context(), c.Request.Header, c.Request.Method+" "+c.Request.URL.Path,
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("url.path", c.Request.URL.Path),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		if status == http.StatusNotFound {
+			span.SetName(c.Request.Method + " unmatched")
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if userId := c.GetString("id"); userId != "" {
+			span.SetAttributes(tracing.UserId(userId))
+		}
+		// 参数已经被接口解析过了，这里不会再读取请求体
+		if c.Request.Form != nil {
+			if mac := c.Request.Form.Get("mac"); mac != "" {
+				span.SetAttributes(tracing.Mac(mac))
+			}
+		}
+	}
+}
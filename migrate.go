@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"ezlock/common/migrate"
+	"ezlock/config"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = `usage: ezlock migrate <command>
+
+commands:
+  up          执行所有还没有执行的迁移
+  down [n]    回滚最近执行的 n 个迁移，默认为 1
+  status      查看所有迁移的执行状态`
+
+// 执行 ezlock migrate 子命令，返回进程的退出码
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		return 2
+	}
+	steps := 1
+	switch args[0] {
+	case "up", "status":
+		if len(args) != 1 {
+			fmt.Println(migrateUsage)
+			return 2
+		}
+	case "down":
+		if len(args) > 2 {
+			fmt.Println(migrateUsage)
+			return 2
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Println(migrateUsage)
+				return 2
+			}
+			steps = n
+		}
+	default:
+		fmt.Println(migrateUsage)
+		return 2
+	}
+
+	db, _, migrator, err := openStore()
+	if err != nil {
+		fmt.Println("open store error: ", err.Error())
+		return 1
+	}
+	cfg := config.Get().Store
+	// 命令行只尝试连接一个超时时间，连不上直接退出
+	stop := make(chan struct{})
+	time.AfterFunc(time.Duration(cfg.Timeout)*time.Second, func() { close(stop) })
+	if err := db.Connect(stop, nil); err != nil {
+		fmt.Printf("%s connect error: %s\n", cfg.Backend, err.Error())
+		return 1
+	}
+	defer db.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.MigrateTimeout)*time.Second)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		n, err := migrator.Up(ctx, func(m migrate.Migration) {
+			fmt.Printf("applied  %4d %s\n", m.Version, m.Name)
+		})
+		fmt.Printf("%d migrations applied\n", n)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+	case "down":
+		n, err := migrator.Down(ctx, steps, func(m migrate.Migration) {
+			fmt.Printf("reverted %4d %s\n", m.Version, m.Name)
+		})
+		fmt.Printf("%d migrations reverted\n", n)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d %-32s %s\n", status.Version, status.Name, appliedAt)
+		}
+	}
+	return 0
+}
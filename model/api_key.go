@@ -0,0 +1,52 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 接口 key 表名称
+var ApiKeyTableName = "ApiKey"
+
+// 接口 key 的权限范围，每个接口需要其中一个，没有对应权限范围的接口只能登录后访问
+const (
+	ScopeLockRead  = "lock:read"  // 查看门锁、门卡和授权
+	ScopeLockOpen  = "lock:open"  // 生成开锁密钥
+	ScopeAuthWrite = "auth:write" // 分享和撤销授权
+	ScopeCardWrite = "card:write" // 添加、修改和删除门卡
+	ScopeLogRead   = "log:read"   // 查看开锁日志
+	ScopeLogWrite  = "log:write"  // 上传开锁日志
+)
+
+// 是否是支持的权限范围
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeLockRead, ScopeLockOpen, ScopeAuthWrite, ScopeCardWrite, ScopeLogRead, ScopeLogWrite:
+		return true
+	}
+	return false
+}
+
+// 表结构，给酒店管理系统这类第三方系统使用，请求以创建者的身份执行，再按照权限范围和门锁限制
+type ApiKey struct {
+	Id           primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserId       primitive.ObjectID   `json:"userId" bson:"userId"`             // 创建者
+	Name         string               `json:"name" bson:"name"`                 // 名称，用于区分不同的系统
+	KeyHash      string               `json:"-" bson:"keyHash"`                 // key 的哈希，不保存原文
+	Scopes       []string             `json:"scopes" bson:"scopes"`             // 权限范围
+	LockIds      []primitive.ObjectID `json:"lockIds" bson:"lockIds"`           // 可以操作的门锁，为空的时候不限制
+	Revoked      bool                 `json:"revoked" bson:"revoked"`           // 是否已经撤销
+	LastUsedTime time.Time            `json:"lastUsedTime" bson:"lastUsedTime"` // 最近一次使用的时间
+	UpdateTime   time.Time            `json:"updateTime" bson:"updateTime"`     // 更新时间，轮换的时候也会更新
+	CreateTime   time.Time            `json:"createTime" bson:"createTime"`     // 写入时间
+}
+
+// 是否有某个权限范围
+func (k *ApiKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
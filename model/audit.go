@@ -0,0 +1,31 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 管理操作审计表名称
+var AuditTableName = "Audit"
+
+// 审计记录的结果，修改数据的操作在执行之前写入 pending，执行之后再更新结果
+const (
+	AuditPending   = "pending"
+	AuditSucceeded = "succeeded"
+	AuditFailed    = "failed"
+)
+
+// 表结构，管理接口的每一次操作都会写入一条，除了操作结果不会修改
+type Audit struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	ActorId    primitive.ObjectID `json:"actorId,omitempty" bson:"actorId,omitempty"` // 操作的管理员，命令行操作的时候为空
+	ActorRole  string             `json:"actorRole" bson:"actorRole"`                 // 操作时管理员的角色，命令行操作的时候为 cli
+	Action     string             `json:"action" bson:"action"`                       // 操作名称，比如 search_user、disable_user
+	TargetType string             `json:"targetType" bson:"targetType"`               // 操作对象的类型 user、lock、auth 或者 audit
+	TargetId   string             `json:"targetId" bson:"targetId"`                   // 操作对象的 id、mac 或者手机号
+	Detail     string             `json:"detail" bson:"detail"`                       // 操作的参数和原因
+	Outcome    string             `json:"outcome" bson:"outcome"`                     // 操作结果 pending、succeeded 或者 failed
+	Ip         string             `json:"ip" bson:"ip"`
+	RequestId  string             `json:"requestId" bson:"requestId"`
+	CreateTime time.Time          `json:"createTime" bson:"createTime"` // 操作时间
+}
@@ -1,7 +1,7 @@
 package model
 
 import (
-	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
@@ -18,23 +18,26 @@ type Perms struct {
 type Auth struct {
 	Perms
 	// omitempty如果不是空值才包含_id,是空值就不包含，这样的mongo可以自动生成，不写omitempty，每次插入的时候就必须要传_id了
-	Id         bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
-	SendId     bson.ObjectId `json:"sendId" bson:"sendId"`                             // 发送者id
-	ReceiverId bson.ObjectId `json:"receiverId,omitempty" bson:"receiverId,omitempty"` // 发送者id
-	LockId     bson.ObjectId `json:"lockId" bson:"lockId"`                             // 被授权的门锁id
-	AuthType   string        `json:"authType" bson:"authType"`                         // 授权类型
-	Deadline   string        `json:"deadline" bson:"deadline"`                         // 截止时间
-	StartDate  string        `json:"startDate" bson:"startDate"`                       // 授权开始日期
-	EndDate    string        `json:"endDate" bson:"endDate"`                           // 授权结束日期
-	StartTime  string        `json:"startTime" bson:"startTime"`                       // 授权开始时间
-	EndTime    string        `json:"endTime" bson:"endTime"`                           // 授权结束时间
-	Valid      bool          `json:"valid" bson:"valid"`                               // 授权是否有效
-	Token      string        `json:"token" bson:"token"`                               // 一次性授权时携带的token
-	UpdateTime time.Time     `json:"updateTime" bson:"updateTime"`                     // 更新时间
-	CreateTime time.Time     `json:"createTime" bson:"createTime"`                     // 写入时间
+	Id         primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	SendId     primitive.ObjectID   `json:"sendId" bson:"sendId"`                             // 发送者id
+	ReceiverId primitive.ObjectID   `json:"receiverId,omitempty" bson:"receiverId,omitempty"` // 发送者id
+	Phone      string               `json:"phone,omitempty" bson:"phone,omitempty"`           // 分享给手机号的时候接收者的手机号，这个手机号的用户验证后自动领取
+	LockId     primitive.ObjectID   `json:"lockId" bson:"lockId"`                             // 被授权的门锁id，授权给空间的时候为空
+	SpaceIds   []primitive.ObjectID `json:"spaceIds,omitempty" bson:"spaceIds,omitempty"`     // 被授权的空间，包括这些空间和下层空间里的所有门锁
+	OrgId      primitive.ObjectID   `json:"orgId" bson:"orgId,omitempty"`                     // 授权所属的组织，和门锁一致
+	AuthType   string               `json:"authType" bson:"authType"`                         // 授权类型
+	Deadline   string               `json:"deadline" bson:"deadline"`                         // 截止时间
+	StartDate  string               `json:"startDate" bson:"startDate"`                       // 授权开始日期
+	EndDate    string               `json:"endDate" bson:"endDate"`                           // 授权结束日期
+	StartTime  string               `json:"startTime" bson:"startTime"`                       // 授权开始时间
+	EndTime    string               `json:"endTime" bson:"endTime"`                           // 授权结束时间
+	Valid      bool                 `json:"valid" bson:"valid"`                               // 授权是否有效
+	Token      string               `json:"token" bson:"token"`                               // 一次性授权时携带的token
+	UpdateTime time.Time            `json:"updateTime" bson:"updateTime"`                     // 更新时间
+	CreateTime time.Time            `json:"createTime" bson:"createTime"`                     // 写入时间
 }
 
-// 创建表的时候初始化一些操作，比如建立索引
-func init() {
-
+// 是否是授权给空间的，否则是授权给一把门锁的
+func (a *Auth) IsSpaceAuth() bool {
+	return len(a.SpaceIds) > 0
 }
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 出厂设备表名称
+var DeviceTableName = "Device"
+
+// 认领码最多可以输错的次数，超过以后需要管理员重新生成认领码
+const DeviceMaxClaimAttempts = 5
+
+// 表结构，工厂导入的门锁，密钥由服务端生成后写入门锁，认领码印在包装盒上
+// 绑定门锁的时候必须提供认领码，门锁的密钥使用这里的密钥，不接受客户端上传的密钥
+type Device struct {
+	Id            primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Mac           string             `json:"mac" bson:"mac"`                       // mac 地址
+	Serial        string             `json:"serial" bson:"serial"`                 // 序列号
+	Model         string             `json:"model" bson:"model"`                   // 硬件型号
+	Key           string             `json:"-" bson:"key"`                         // 出厂写入门锁的加密密钥
+	ClaimCodeHash string             `json:"-" bson:"claimCodeHash"`               // 认领码的哈希，不保存原文
+	Attempts      int                `json:"attempts" bson:"attempts"`             // 认领码连续输错的次数
+	LockId        primitive.ObjectID `json:"lockId" bson:"lockId,omitempty"`       // 绑定后生成的门锁，没有认领的时候为空
+	ClaimedBy     primitive.ObjectID `json:"claimedBy" bson:"claimedBy,omitempty"` // 认领的用户
+	ClaimTime     time.Time          `json:"claimTime" bson:"claimTime,omitempty"` // 认领时间
+	UpdateTime    time.Time          `json:"updateTime" bson:"updateTime"`         // 更新时间
+	CreateTime    time.Time          `json:"createTime" bson:"createTime"`         // 导入时间
+}
+
+// 是否已经被认领
+func (d *Device) Claimed() bool {
+	return !d.LockId.IsZero()
+}
+
+// 认领码输错的次数是否已经用完
+func (d *Device) ClaimLocked() bool {
+	return d.Attempts >= DeviceMaxClaimAttempts
+}
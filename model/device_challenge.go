@@ -0,0 +1,19 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 门锁挑战表名称
+var DeviceChallengeTableName = "DeviceChallenge"
+
+// 表结构，每签发一次门锁挑战写入一条，随机数只放在签名的 token 里，这里只记录是否已经使用
+type DeviceChallenge struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserId     primitive.ObjectID `json:"userId" bson:"userId"`         // 申请挑战的用户
+	Mac        string             `json:"mac" bson:"mac"`               // 挑战的门锁
+	Used       bool               `json:"used" bson:"used"`             // 已经提交过应答，不能再使用
+	ExpireTime time.Time          `json:"expireTime" bson:"expireTime"` // 过期时间
+	CreateTime time.Time          `json:"createTime" bson:"createTime"` // 签发时间
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// 密码登录失败记录表名称
+var LoginFailureTableName = "LoginFailure"
+
+// 表结构，记录连续输错密码的次数，达到 password.maxAttempts 之后锁定一段时间
+// 用户存在的时候按照用户记录，不存在的时候按照用户名记录，防止用不存在的用户名无限尝试
+type LoginFailure struct {
+	Key         string    `json:"key" bson:"_id"`                 // user:用户id 或者 name:用户名
+	Failures    int       `json:"failures" bson:"failures"`       // 连续输错的次数，锁定之后清零
+	LockedUntil time.Time `json:"lockedUntil" bson:"lockedUntil"` // 锁定的结束时间，之前的登录直接拒绝
+	ExpireTime  time.Time `json:"expireTime" bson:"expireTime"`   // 超过这个时间没有再输错的话重新计数
+}
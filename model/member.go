@@ -0,0 +1,40 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 门锁成员表名称
+var MemberTableName = "Member"
+
+// 门锁成员的角色，拥有者就是门锁的 own，不在成员表里
+const (
+	MemberOwner  = "owner"  // 拥有者，可以删除、转让门锁和管理所有成员
+	MemberAdmin  = "admin"  // 管理员，可以修改门锁信息、管理门卡、分享授权和管理普通成员
+	MemberMember = "member" // 普通成员，可以开锁和查看日志
+)
+
+// 是否是可以设置给成员的角色
+func ValidMemberRole(role string) bool {
+	return role == MemberAdmin || role == MemberMember
+}
+
+// 表结构，一个用户在一把锁上只有一条记录
+type Member struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	LockId     primitive.ObjectID `json:"lockId" bson:"lockId"`       // 门锁id
+	UserId     primitive.ObjectID `json:"userId" bson:"userId"`       // 成员的用户id
+	Role       string             `json:"role" bson:"role"`           // 角色，admin 或者 member
+	InvitedBy  primitive.ObjectID `json:"invitedBy" bson:"invitedBy"` // 添加这个成员的用户
+	UpdateTime time.Time          `json:"updateTime" bson:"updateTime"`
+	CreateTime time.Time          `json:"createTime" bson:"createTime"`
+}
+
+// 成员是否具有 perms 中为 true 的权限，管理员具有所有权限，普通成员只有查看日志的权限
+func (m *Member) HasPerms(perms Perms) bool {
+	if m.Role == MemberAdmin {
+		return true
+	}
+	return !perms.AddCard && !perms.ShareAuth
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 组织表名称
+var OrganizationTableName = "Organization"
+
+// 组织成员表名称
+var OrgMemberTableName = "OrgMember"
+
+// 组织成员的角色，和门锁成员的角色取值相同
+const (
+	OrgRoleOwner  = MemberOwner  // 创建者，可以管理所有成员，组织里的门锁都可以管理
+	OrgRoleAdmin  = MemberAdmin  // 管理员，可以管理普通成员，组织里的门锁都可以管理
+	OrgRoleMember = MemberMember // 普通成员，只能使用组织里授权给自己或者自己绑定的门锁
+)
+
+// 是否是可以设置给组织成员的角色，创建者不能设置
+func ValidOrgRole(role string) bool {
+	return role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+// 组织，一个组织是一个租户，组织里的门锁、门卡、授权和日志和其他组织以及个人的数据互相隔离
+type Organization struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`             // 名称
+	OwnerId    primitive.ObjectID `json:"ownerId" bson:"ownerId"`       // 创建者
+	UpdateTime time.Time          `json:"updateTime" bson:"updateTime"` // 更新时间
+	CreateTime time.Time          `json:"createTime" bson:"createTime"` // 写入时间
+}
+
+// 组织成员表结构，一个用户在一个组织里只有一条记录，创建者也在成员表里
+type OrgMember struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	OrgId      primitive.ObjectID `json:"orgId" bson:"orgId"`         // 组织id
+	UserId     primitive.ObjectID `json:"userId" bson:"userId"`       // 成员的用户id
+	Role       string             `json:"role" bson:"role"`           // 角色
+	InvitedBy  primitive.ObjectID `json:"invitedBy" bson:"invitedBy"` // 添加这个成员的用户，创建者为空
+	UpdateTime time.Time          `json:"updateTime" bson:"updateTime"`
+	CreateTime time.Time          `json:"createTime" bson:"createTime"`
+}
+
+// 是否可以管理组织里所有的门锁
+func (m *OrgMember) CanManageLocks() bool {
+	return m.Role == OrgRoleOwner || m.Role == OrgRoleAdmin
+}
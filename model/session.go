@@ -0,0 +1,23 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 登录会话表名称
+var SessionTableName = "Session"
+
+// 表结构，一次登录对应一个会话，刷新 token 每次使用都会轮换，会话 id 不变
+type Session struct {
+	Id           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserId       primitive.ObjectID `json:"userId" bson:"userId"`             // 登录的用户
+	RefreshHash  string             `json:"-" bson:"refreshHash"`             // 当前刷新 token 的哈希，不保存原文
+	Revoked      bool               `json:"revoked" bson:"revoked"`           // 退出登录或者被撤销
+	ExpireTime   time.Time          `json:"expireTime" bson:"expireTime"`     // 刷新 token 的过期时间，每次轮换重新计算
+	Device       string             `json:"device" bson:"device"`             // 登录时上报的设备信息，没有上报的时候为 User-Agent
+	Ip           string             `json:"ip" bson:"ip"`                     // 最近一次使用的 ip
+	LastUsedTime time.Time          `json:"lastUsedTime" bson:"lastUsedTime"` // 最近一次使用的时间
+	UpdateTime   time.Time          `json:"updateTime" bson:"updateTime"`     // 更新时间
+	CreateTime   time.Time          `json:"createTime" bson:"createTime"`     // 写入时间
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 空间表名称
+var SpaceTableName = "Space"
+
+// 空间的层级，从上到下是 site、building、floor、unit
+const (
+	SpaceSite     = "site"     // 小区或者园区，最上层
+	SpaceBuilding = "building" // 楼栋
+	SpaceFloor    = "floor"    // 楼层
+	SpaceUnit     = "unit"     // 房间
+)
+
+// 每一层的上一层，site 没有上一层
+var spaceParentTypes = map[string]string{
+	SpaceSite:     "",
+	SpaceBuilding: SpaceSite,
+	SpaceFloor:    SpaceBuilding,
+	SpaceUnit:     SpaceFloor,
+}
+
+// 是否是合法的空间层级
+func ValidSpaceType(spaceType string) bool {
+	_, ok := spaceParentTypes[spaceType]
+	return ok
+}
+
+// 空间的上一层必须是什么层级，site 返回空字符串
+func SpaceParentType(spaceType string) string {
+	return spaceParentTypes[spaceType]
+}
+
+// 表结构，门锁可以挂在任意一层空间下，授权给空间的时候包括这个空间和所有下层空间里的门锁
+type Space struct {
+	Id         primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	Own        primitive.ObjectID   `json:"own" bson:"own"`                               // 空间的拥有者，只有拥有者可以管理和授权
+	ParentId   primitive.ObjectID   `json:"parentId,omitempty" bson:"parentId,omitempty"` // 上一层空间，site 为空
+	Ancestors  []primitive.ObjectID `json:"ancestors" bson:"ancestors"`                   // 从 site 开始的所有上层空间，用来查询整棵子树
+	Type       string               `json:"type" bson:"type"`                             // 层级
+	Name       string               `json:"name" bson:"name"`                             // 名称
+	Desc       string               `json:"desc" bson:"desc"`                             // 描述
+	UpdateTime time.Time            `json:"updateTime" bson:"updateTime"`                 // 更新时间
+	CreateTime time.Time            `json:"createTime" bson:"createTime"`                 // 写入时间
+}
@@ -0,0 +1,33 @@
+package model
+
+// 需要二次验证的敏感操作，二次验证后签发的 token 只能用于申请时指定的操作
+const (
+	StepUpLockDelete    = "lock:delete"    // 删除门锁
+	StepUpAuthRevoke    = "auth:revoke"    // 撤销授权
+	StepUpCardDelete    = "card:delete"    // 删除门卡
+	StepUpLockTransfer  = "lock:transfer"  // 把门锁转让给其他用户
+	StepUpDataExport    = "data:export"    // 导出个人数据或者门锁的开锁日志
+	StepUpAccountDelete = "account:delete" // 注销账号，包括把门锁转给其他用户
+	StepUpPasswordSet   = "password:set"   // 设置或者修改登录密码，密码本身也能用来二次验证
+	StepUpOrgTransfer   = "org:transfer"   // 把组织移交给其他成员
+)
+
+// 是否是需要二次验证的操作
+func ValidStepUpScope(scope string) bool {
+	switch scope {
+	case StepUpLockDelete, StepUpAuthRevoke, StepUpCardDelete, StepUpLockTransfer, StepUpDataExport, StepUpAccountDelete, StepUpPasswordSet, StepUpOrgTransfer:
+		return true
+	}
+	return false
+}
+
+// 接口 key 访问的时候不需要二次验证的操作，只有撤销授权、删除门卡和导出开锁日志
+// 接口 key 是用户登录后创建的，还要有对应的权限范围，随时可以撤销，机器调用没有办法交互验证
+// 删除门锁、转让门锁和组织、注销账号和修改密码不在这里，接口 key 访问的时候直接拒绝
+func StepUpApiKeyExempt(scope string) bool {
+	switch scope {
+	case StepUpAuthRevoke, StepUpCardDelete, StepUpDataExport:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 门锁转让记录表名称
+var TransferTableName = "Transfer"
+
+// 转让的状态，只有 pending 可以变成其他状态
+const (
+	TransferPending   = "pending"   // 等待接收者接受
+	TransferAccepted  = "accepted"  // 已经接受，门锁已经属于接收者
+	TransferRejected  = "rejected"  // 接收者拒绝
+	TransferCanceled  = "canceled"  // 发起者取消
+	TransferExpired   = "expired"   // 超过有效期没有处理
+	TransferReclaimed = "reclaimed" // 新的住户在门锁旁边通过门锁应答找回，或者原拥有者删除门锁以后用认领码重新绑定，不需要原拥有者同意，记录创建时就是这个状态
+)
+
+// 表结构，转让完成后记录保留，作为门锁的转让历史
+type Transfer struct {
+	Id           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	LockId       primitive.ObjectID `json:"lockId" bson:"lockId"`                 // 转让的门锁
+	FromId       primitive.ObjectID `json:"fromId" bson:"fromId"`                 // 发起转让的原拥有者
+	ToPhone      string             `json:"toPhone" bson:"toPhone"`               // 接收者的手机号，绑定了这个手机号的用户可以接受
+	ToId         primitive.ObjectID `json:"toId,omitempty" bson:"toId,omitempty"` // 接受转让的用户
+	Status       string             `json:"status" bson:"status"`                 // 状态
+	AuthsRevoked int                `json:"authsRevoked" bson:"authsRevoked"`     // 接受的时候撤销的授权数量
+	WipedCards   []string           `json:"wipedCards" bson:"wipedCards"`         // 接受的时候删除的门卡卡号，新的拥有者需要把删除指令写入门锁
+	ExpireTime   time.Time          `json:"expireTime" bson:"expireTime"`         // 超过这个时间没有接受的转让失效
+	UpdateTime   time.Time          `json:"updateTime" bson:"updateTime"`         // 更新时间
+	CreateTime   time.Time          `json:"createTime" bson:"createTime"`         // 发起时间
+}
+
+// 是否还可以接受、拒绝或者取消
+func (t *Transfer) IsPending(now time.Time) bool {
+	return t.Status == TransferPending && now.Before(t.ExpireTime)
+}
@@ -1,74 +1,60 @@
 package model
 
 import (
-	"ezlock/common/mongo"
-	"ezlock/config"
-	"fmt"
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
-	"os"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
 // 门锁信息表名称
 var UserTableName = "User"
 
-// 表结构
-type User struct {
-	// omitempty如果不是空值才包含_id,是空值就不包含，这样的mongo可以自动生成，不写omitempty，每次插入的时候就必须要传_id了
-	Id          bson.ObjectId `json:"_id,omitempty" bson:"_id,omitempty"`
-	NickName    string        `json:"nickName" bson:"nickName"`                 // 用户昵称
-	OpenId      string        `json:"openId" bson:"openId"`                     // 微信的 openId
-	UnionId     string        `json:"unionId" bson:"unionId"`                   // 微信的 openId
-	SessionKey  string        `json:"sessionKey" bson:"sessionKey"`             // 微信 服务器返回的session key
-	PhoneNumber string        `json:"phoneNumber" bson:"phoneNumber"`           // 用户手机号码
-	DefaultLock bson.ObjectId `json:"defaultLock" bson:"defaultLock,omitempty"` // 用户默认拥有的锁
-	Gender      int           `json:"gender" bson:"gender"`                     // 用户性别
-	City        string        `json:"city" bson:"city"`                         // 用户所在城市
-	Province    string        `json:"province" bson:"province"`                 // 用户所在省份
-	Country     string        `json:"country" bson:"country"`                   // 用户所在国家
-	AvatarUrl   string        `json:"avatarUrl" bson:"avatarUrl"`               // 用户头像链接
-	Language    string        `json:"language" bson:"language"`
-	UpdateTime  time.Time     `json:"updateTime" bson:"updateTime"` // 更新时间
-	CreateTime  time.Time     `json:"createTime" bson:"createTime"` // 写入时间
-}
-
-// 创建表的时候初始化一些操作，比如建立索引
-func init() {
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-	// 连接到当前表
-	coll := mgoSession.DB(config.DataBaseName).C(UserTableName)
-	// 建立name索引, 方便查询
-	err := coll.EnsureIndex(mgo.Index{
-		Key:  []string{"nickName"},
-		Name: "Index_NickName",
-	})
-
-	if err != nil {
-		fmt.Printf("User Create Index_NickName Failed: %s\n", err.Error())
-		os.Exit(1)
-	}
+// 用户角色，没有设置的时候是普通用户
+const (
+	RoleUser    = "user"
+	RoleSupport = "support" // 客服，可以查询用户、门锁和日志，撤销授权
+	RoleAdmin   = "admin"   // 平台管理员，还可以禁用用户和修改角色
+)
 
-	err = coll.EnsureIndex(mgo.Index{
-		Key:    []string{"openId"},
-		Unique: true,
-		Name:   "Index_OpenId",
-	})
+// 注销后的用户昵称
+const DeletedNickName = "已注销用户"
 
-	if err != nil {
-		fmt.Printf("User Create Index_OpenId Failed: %s\n", err.Error())
-		os.Exit(1)
+// 检查角色名称是否合法
+func ValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
 	}
+	return false
+}
 
-	// 建立创建时间的 倒叙 索引
-	err = coll.EnsureIndex(mgo.Index{
-		Key:  []string{"-createTime"},
-		Name: "Index_CreateTime",
-	})
+// 表结构
+type User struct {
+	// omitempty如果不是空值才包含_id,是空值就不包含，这样的mongo可以自动生成，不写omitempty，每次插入的时候就必须要传_id了
+	Id           primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	NickName     string             `json:"nickName" bson:"nickName"`                 // 用户昵称
+	OpenId       string             `json:"openId" bson:"openId"`                     // 微信的 openId
+	UnionId      string             `json:"unionId" bson:"unionId"`                   // 微信的 openId
+	SessionKey   string             `json:"sessionKey" bson:"sessionKey"`             // 微信 服务器返回的session key
+	PhoneNumber  string             `json:"phoneNumber" bson:"phoneNumber"`           // 用户手机号码
+	UserName     string             `json:"userName" bson:"userName"`                 // 密码登录使用的用户名，没有设置密码的时候为空
+	PasswordHash string             `json:"-" bson:"passwordHash"`                    // bcrypt 哈希后的密码，不保存原文
+	Role         string             `json:"role" bson:"role"`                         // 用户角色，空值是普通用户
+	Disabled     bool               `json:"disabled" bson:"disabled"`                 // 被管理员禁用，不能再登录
+	DefaultLock  primitive.ObjectID `json:"defaultLock" bson:"defaultLock,omitempty"` // 用户默认拥有的锁
+	Gender       int                `json:"gender" bson:"gender"`                     // 用户性别
+	City         string             `json:"city" bson:"city"`                         // 用户所在城市
+	Province     string             `json:"province" bson:"province"`                 // 用户所在省份
+	Country      string             `json:"country" bson:"country"`                   // 用户所在国家
+	AvatarUrl    string             `json:"avatarUrl" bson:"avatarUrl"`               // 用户头像链接
+	Language     string             `json:"language" bson:"language"`
+	UpdateTime   time.Time          `json:"updateTime" bson:"updateTime"` // 更新时间
+	CreateTime   time.Time          `json:"createTime" bson:"createTime"` // 写入时间
+}
 
-	if err != nil {
-		fmt.Printf("User Create Index_CreateTime Failed: %s\n", err.Error())
-		os.Exit(1)
+// 用户的角色，没有设置的时候是普通用户
+func (u *User) GetRole() string {
+	if u.Role == "" {
+		return RoleUser
 	}
+	return u.Role
 }
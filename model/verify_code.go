@@ -0,0 +1,20 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 短信验证码表名称
+var VerifyCodeTableName = "VerifyCode"
+
+// 表结构，每发送一次短信验证码写入一条，只保存验证码的哈希
+type VerifyCode struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Phone      string             `json:"phone" bson:"phone"`           // 接收验证码的手机号
+	CodeHash   string             `json:"-" bson:"codeHash"`            // 验证码的哈希
+	Attempts   int                `json:"attempts" bson:"attempts"`     // 校验失败的次数
+	Used       bool               `json:"used" bson:"used"`             // 已经登录成功，不能再使用
+	ExpireTime time.Time          `json:"expireTime" bson:"expireTime"` // 过期时间
+	CreateTime time.Time          `json:"createTime" bson:"createTime"` // 发送时间
+}
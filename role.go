@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"ezlock/config"
+	"ezlock/model"
+	"ezlock/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const roleUsage = `usage: ezlock role <userId> <role>
+
+roles:
+  user        普通用户
+  support     客服，可以查询用户、门锁、授权和日志，可以撤销授权
+  admin       管理员，还可以禁用用户和修改角色`
+
+// 执行 ezlock role 子命令，返回进程的退出码
+// 管理接口只有管理员可以修改角色，第一个管理员需要用命令行设置
+func runRole(args []string) int {
+	if len(args) != 2 || !primitive.IsValidObjectID(args[0]) || !model.ValidRole(args[1]) {
+		fmt.Println(roleUsage)
+		return 2
+	}
+
+	db, s, _, err := openStore()
+	if err != nil {
+		fmt.Println("open store error: ", err.Error())
+		return 1
+	}
+	cfg := config.Get().Store
+	// 命令行只尝试连接一个超时时间，连不上直接退出
+	stop := make(chan struct{})
+	time.AfterFunc(time.Duration(cfg.Timeout)*time.Second, func() { close(stop) })
+	if err := db.Connect(stop, nil); err != nil {
+		fmt.Printf("%s connect error: %s\n", cfg.Backend, err.Error())
+		return 1
+	}
+	defer db.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.OperationTimeout)*time.Second)
+	defer cancel()
+
+	// 先写审计记录，写入失败的时候不修改角色
+	audit := &model.Audit{
+		ActorRole:  "cli",
+		Action:     "set_role",
+		TargetType: "user",
+		TargetId:   args[0],
+		Detail:     args[1],
+		Outcome:    model.AuditPending,
+		CreateTime: time.Now().Local(),
+	}
+	if err := s.Audits.Insert(ctx, audit); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	userId := utils.ObjectIdHex(args[0])
+	err = s.Users.SetRole(ctx, userId, args[1])
+	// 用户需要重新登录才能拿到带新角色的 token
+	if err == nil {
+		_, err = s.Sessions.RevokeByUser(ctx, userId)
+	}
+	outcome := model.AuditSucceeded
+	if err != nil {
+		outcome = model.AuditFailed
+	}
+	if err := s.Audits.SetOutcome(ctx, audit.Id, outcome); err != nil {
+		fmt.Println(err.Error())
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	fmt.Printf("user %s role set to %s\n", args[0], args[1])
+	return 0
+}
@@ -3,20 +3,53 @@ package router
 import (
 	"ezlock/controller"
 	"ezlock/middleware"
+	"ezlock/model"
 	"github.com/gin-gonic/gin"
 )
 
-// 账户相关的接口
-func Account(router *gin.Engine) {
+// 账户相关的接口，auth 为校验登录的中间件
+func Account(router *gin.RouterGroup, ctl *controller.Controller, auth gin.HandlersChain) {
 	// 用户的登录
-	router.POST("/login", controller.Login)
+	router.POST("/login", ctl.Login)
+	// 使用短信验证码或者用户名密码登录
+	router.POST("/login/:provider", ctl.ProviderLogin)
+	// 发送短信登录验证码
+	router.POST("/sms/send_code", ctl.SendSmsCode)
+	// 用刷新 token 换取新的 token
+	router.POST("/token/refresh", ctl.RefreshToken)
+	// 退出登录
+	router.Group("", auth...).POST("/logout", ctl.Logout)
 	account := router.Group("/account")
-	account.Use(middleware.AuthMiddlerware.MiddlewareFunc())
+	account.Use(auth...)
 	{
 		// 获取用户手机号码
-		account.POST("/get_phone_number", controller.GetPhone)
+		account.POST("/get_phone_number", ctl.GetPhone)
 		// 获取用户信息
-		account.POST("/get_user_info", controller.GetUserInfo)
+		account.POST("/get_user_info", ctl.GetUserInfo)
+		// 设置密码登录的用户名和密码，需要二次验证
+		account.POST("/set_password", middleware.RequireStepUp(model.StepUpPasswordSet), ctl.SetPassword)
+		// 获取已登录的设备
+		account.GET("/sessions", ctl.GetSessionList)
+		// 撤销某个设备的登录
+		account.DELETE("/session", ctl.RevokeSession)
+		// 退出所有设备的登录
+		account.POST("/logout_all", ctl.SignOutEverywhere)
+		// 获取接口 key 列表
+		account.GET("/api_keys", ctl.GetApiKeyList)
+		// 创建接口 key
+		account.POST("/api_key", ctl.CreateApiKey)
+		// 轮换接口 key，旧的 key 立即失效
+		account.POST("/api_key/rotate", ctl.RotateApiKey)
+		// 撤销接口 key
+		account.DELETE("/api_key", ctl.RevokeApiKey)
+		// 给绑定的手机号发送二次验证的验证码
+		account.POST("/step_up/send_code", ctl.SendStepUpCode)
+		// 使用短信验证码、密码或者微信重新登录二次验证，返回执行敏感操作需要的 token
+		account.POST("/step_up", ctl.StepUp)
+		// 导出个人数据，包括在所有组织里的数据，返回 zip 压缩包，需要二次验证
+		account.GET("/export", middleware.RequireStepUp(model.StepUpDataExport), middleware.AllTenants(), ctl.ExportAccountData)
+		// 注销账号，处理在所有组织里的数据，需要二次验证
+		account.POST("/delete", middleware.RequireStepUp(model.StepUpAccountDelete), middleware.AllTenants(), ctl.DeleteAccount)
 	}
 
 }
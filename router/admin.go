@@ -0,0 +1,50 @@
+package router
+
+import (
+	"ezlock/controller"
+	"ezlock/middleware"
+	"ezlock/model"
+	"github.com/gin-gonic/gin"
+)
+
+// 管理相关的接口，使用配置的管理 token 访问，不受数据库是否就绪的影响
+func Admin(router *gin.Engine) {
+	admin := router.Group("/admin")
+	admin.Use(middleware.RequireAdminToken())
+	{
+		// 查看当前生效的配置
+		admin.GET("/config", controller.GetConfig)
+	}
+}
+
+// 平台管理员的接口，使用登录 token 访问，客服和管理员可以查询和撤销授权，只有管理员可以禁用用户和修改角色
+// 所有操作都会写入审计记录，可以查询和操作所有组织的数据
+func Platform(router *gin.RouterGroup, ctl *controller.Controller, auth gin.HandlersChain) {
+	platform := router.Group("/admin/v1", auth...)
+	platform.Use(middleware.RequireRole(model.RoleSupport, model.RoleAdmin), middleware.AllTenants())
+	{
+		// 按照用户id或者手机号查找用户
+		platform.GET("/user", ctl.AdminSearchUser)
+		// 按照 mac 或者拥有者手机号查找门锁
+		platform.GET("/lock", ctl.AdminSearchLock)
+		// 查看任意门锁的授权
+		platform.GET("/lock/auth", ctl.AdminGetLockAuthList)
+		// 查看任意门锁的开锁日志
+		platform.GET("/lock/log", ctl.AdminGetLockLog)
+		// 强制撤销授权
+		platform.POST("/auth/revoke", ctl.AdminRevokeAuth)
+	}
+	admin := platform.Group("", middleware.RequireRole(model.RoleAdmin))
+	{
+		// 禁用或者恢复用户
+		admin.POST("/user/disable", ctl.AdminDisableUser)
+		// 修改用户角色
+		admin.POST("/user/role", ctl.AdminSetUserRole)
+		// 查看审计记录
+		admin.GET("/audit", ctl.AdminGetAuditList)
+		// 导入工厂生产的门锁，返回生成的密钥和认领码
+		admin.POST("/device/import", ctl.AdminImportDevices)
+		// 重新生成没有认领的设备的认领码
+		admin.POST("/device/claim_code", ctl.AdminResetClaimCode)
+	}
+}
@@ -3,62 +3,142 @@ package router
 import (
 	"ezlock/controller"
 	"ezlock/middleware"
+	"ezlock/model"
 	"github.com/gin-gonic/gin"
 )
 
-// v1版本的api
-func Api(router *gin.Engine) {
+// v1版本的api，auth 为校验登录的中间件，keyAuth 为校验登录或者接口 key 的中间件
+// 接口 key 只能访问设置了权限范围的接口，绑定、修改、删除门锁和领取授权只能登录后操作
+// 删除门锁、撤销授权、删除门卡、导出日志这些敏感操作，登录访问的时候还需要带上二次验证的 token
+// 接口 key 访问撤销授权、删除门卡和导出日志不需要二次验证，见 model.StepUpApiKeyExempt
+// tenant 把请求限制在请求头指定的组织里，没有指定的时候只能访问个人数据
+func Api(router *gin.RouterGroup, ctl *controller.Controller, auth, keyAuth gin.HandlersChain, tenant gin.HandlerFunc) {
 
 	api := router.Group("/api/v1")
-	api.Use(middleware.AuthMiddlerware.MiddlewareFunc())
+	api.Use(auth...)
+	api.Use(tenant)
 	{
-		// 获取默认门锁信息
-		api.GET("/lock/default", controller.GetDefaultLock)
 		// 设置默认门锁
-		api.POST("/lock/default", controller.SetDefaultLock)
+		api.POST("/lock/default", ctl.SetDefaultLock)
 
-		// 获取锁列表 showValid 为false 显示所有表列表，showValid 为true显示所有可用锁列表
-		api.GET("/lock/list", controller.GetLockList)
-		// 生成开锁密钥
-		api.POST("/lock/open", controller.GetOpenLockKey)
-		// 绑定新锁，添加设备
-		api.POST("/lock/info", controller.AddLock)
-		// 修改门锁信息 只可以修改属于自己的并且没有被删除的锁
-		api.PUT("/lock/info", controller.UpdateLock)
-		// 删除门锁信息 只可以删除属于自己的门锁，逻辑删除
-		api.DELETE("/lock/info", controller.DeleteLock)
+		// 获取门锁挑战，绑定和找回门锁之前需要门锁用出厂密钥应答
+		api.POST("/lock/challenge", ctl.IssueDeviceChallenge)
+		// 绑定新锁，添加设备，需要认领码和门锁的应答，已经删除的门锁可以重新绑定
+		api.POST("/lock/info", ctl.AddLock)
+		// 在门锁旁边找回已经被别人绑定的门锁，原拥有者的授权、门卡和成员全部清除
+		api.POST("/lock/reclaim", ctl.ReclaimLock)
+		// 修改门锁信息 拥有者和管理员可以修改没有被删除的锁
+		api.PUT("/lock/info", ctl.UpdateLock)
+		// 删除门锁信息 只可以删除属于自己的门锁，逻辑删除，需要二次验证
+		api.DELETE("/lock/info", middleware.RequireStepUp(model.StepUpLockDelete), ctl.DeleteLock)
+		// 撤销门锁上所有的授权，门锁拥有者和管理员可以操作，需要二次验证，spaces 为 true 的时候也撤销包括这把锁的空间授权
+		api.POST("/lock/auth/revoke_all", middleware.RequireStepUp(model.StepUpAuthRevoke), ctl.RevokeAllLockAuth)
+
+		// 使用门锁的授权
+		api.PUT("/lock/auth", ctl.UseLockAuth)
+
+		// 查看门锁的拥有者和成员
+		api.GET("/lock/member/list", ctl.GetLockMemberList)
+		// 按手机号添加成员，拥有者可以添加管理员和普通成员，管理员只能添加普通成员
+		api.POST("/lock/member", ctl.AddLockMember)
+		// 修改成员角色，只有拥有者可以操作
+		api.PUT("/lock/member", ctl.SetLockMemberRole)
+		// 移除成员或者自己退出
+		api.DELETE("/lock/member", ctl.RemoveLockMember)
+
+		// 新建组织，创建者自动成为组织的 owner
+		api.POST("/org", ctl.CreateOrg)
+		// 获取自己加入的组织
+		api.GET("/org/list", ctl.GetOrgList)
+		// 查看组织的成员
+		api.GET("/org/member/list", ctl.GetOrgMemberList)
+		// 按手机号添加组织成员，创建者可以添加管理员和普通成员，管理员只能添加普通成员
+		api.POST("/org/member", ctl.AddOrgMember)
+		// 修改组织成员角色，只有创建者可以操作
+		api.PUT("/org/member", ctl.SetOrgMemberRole)
+		// 把组织移交给其他成员，只有创建者可以操作，需要二次验证
+		api.POST("/org/owner", middleware.RequireStepUp(model.StepUpOrgTransfer), ctl.TransferOrgOwner)
+		// 移除组织成员或者自己退出
+		api.DELETE("/org/member", ctl.RemoveOrgMember)
+
+		// 新建空间，site、building、floor、unit 逐层往下建
+		api.POST("/space", ctl.CreateSpace)
+		// 修改空间的名称和描述
+		api.PUT("/space", ctl.UpdateSpace)
+		// 删除没有下层空间的空间，里面的门锁移出空间
+		api.DELETE("/space", ctl.DeleteSpace)
+		// 获取空间树，包括每个空间里的门锁
+		api.GET("/space/tree", ctl.GetSpaceTree)
+		// 获取空间和所有下层空间里的门锁
+		api.GET("/space/lock/list", ctl.GetSpaceLockList)
+		// 把门锁放到空间里或者移出空间
+		api.PUT("/space/lock", ctl.SetLockSpace)
+		// 授权给空间，包括下层空间里的所有门锁
+		api.POST("/space/auth", ctl.CreateSpaceAuth)
+		// 查看授权给空间的授权
+		api.GET("/space/auth/list", ctl.GetSpaceAuthList)
+
+		// 把门锁转让给手机号对应的用户，需要二次验证
+		api.POST("/lock/transfer", middleware.RequireStepUp(model.StepUpLockTransfer), ctl.CreateLockTransfer)
+		// 取消自己发起的转让
+		api.DELETE("/lock/transfer", ctl.CancelLockTransfer)
+		// 获取自己发起的和转给自己的转让
+		api.GET("/lock/transfer/list", ctl.GetTransferList)
+		// 查看门锁的转让历史
+		api.GET("/lock/transfer/history", ctl.GetLockTransferHistory)
+		// 接受转让，门锁上的授权和门卡全部失效
+		api.POST("/lock/transfer/accept", ctl.AcceptLockTransfer)
+		// 拒绝转让
+		api.POST("/lock/transfer/reject", ctl.RejectLockTransfer)
+		// 生成删除原来门卡的指令
+		api.POST("/lock/transfer/wipe", ctl.WipeTransferCards)
+	}
 
+	keyApi := router.Group("/api/v1")
+	keyApi.Use(keyAuth...)
+	keyApi.Use(tenant)
+	{
+		lockRead := middleware.RequireScope(model.ScopeLockRead)
+		// 获取默认门锁信息
+		keyApi.GET("/lock/default", lockRead, ctl.GetDefaultLock)
+		// 获取锁列表 showValid 为false 显示所有表列表，showValid 为true显示所有可用锁列表
+		keyApi.GET("/lock/list", lockRead, ctl.GetLockList)
 		// 查看某一把锁对应的授权详细信息
-		api.GET("/lock/auth/list", controller.GetLockAuthList)
+		keyApi.GET("/lock/auth/list", lockRead, ctl.GetLockAuthList)
+		// 查看此锁绑定的门卡
+		keyApi.GET("/lock/card", lockRead, ctl.GetLockCardList)
+
+		// 生成开锁密钥
+		keyApi.POST("/lock/open", middleware.RequireScope(model.ScopeLockOpen), ctl.GetOpenLockKey)
 
+		authWrite := middleware.RequireScope(model.ScopeAuthWrite)
 		// 分享门锁的授权
-		api.POST("/lock/auth", controller.CreateLockAuth)
-		// 使用门锁的授权
-		api.PUT("/lock/auth", controller.UseLockAuth)
-		// 撤销自己发出的门锁的授权信息
-		api.POST("/auth/revoke", controller.RevokeAuth)
+		keyApi.POST("/lock/auth", authWrite, ctl.CreateLockAuth)
+		// 撤销自己发出的门锁的授权信息，登录访问的需要二次验证
+		keyApi.POST("/auth/revoke", authWrite, middleware.RequireStepUp(model.StepUpAuthRevoke), ctl.RevokeAuth)
 
+		cardWrite := middleware.RequireScope(model.ScopeCardWrite)
 		// 生成添加门卡的密钥
-		api.POST("/lock/card/add", controller.GetAddCardKey)
-		// 生成删除门卡的密钥
-		api.POST("/lock/card/del", controller.GetDelCardKey)
-
+		keyApi.POST("/lock/card/add", cardWrite, ctl.GetAddCardKey)
+		cardDelete := middleware.RequireStepUp(model.StepUpCardDelete)
+		// 生成删除门卡的密钥，登录访问的需要二次验证
+		keyApi.POST("/lock/card/del", cardWrite, cardDelete, ctl.GetDelCardKey)
 		// 添加门卡
-		api.POST("/lock/card", controller.SetLockCard)
+		keyApi.POST("/lock/card", cardWrite, ctl.SetLockCard)
 		// 更新门卡信息
-		api.PUT("/lock/card", controller.UpdateCard)
-		// 查看此锁绑定的门卡
-		api.GET("/lock/card", controller.GetLockCardList)
-		// 删除门卡
-		api.DELETE("/lock/card", controller.DelCard)
+		keyApi.PUT("/lock/card", cardWrite, ctl.UpdateCard)
+		// 删除门卡，登录访问的需要二次验证
+		keyApi.DELETE("/lock/card", cardWrite, cardDelete, ctl.DelCard)
 
+		logRead := middleware.RequireScope(model.ScopeLogRead)
 		// 生成获取日志的密钥
-		api.PUT("/lock/log", controller.GetLogKey)
+		keyApi.PUT("/lock/log", logRead, ctl.GetLogKey)
 		// 查看某一把锁对应的操作日志
-		api.GET("/lock/log", controller.GetLockOperateLog)
+		keyApi.GET("/lock/log", logRead, ctl.GetLockOperateLog)
+		// 导出某一把锁的全部操作日志，登录访问的需要二次验证
+		keyApi.GET("/lock/log/export", logRead, middleware.RequireStepUp(model.StepUpDataExport), ctl.ExportLockLog)
 		// 添加某一把锁对应的操作日志，将硬件给的日志信息解密，写入数据库
-		api.POST("/lock/log", controller.SetLockOperateLog)
-
+		keyApi.POST("/lock/log", middleware.RequireScope(model.ScopeLogWrite), ctl.SetLockOperateLog)
 	}
 
 }
@@ -0,0 +1,17 @@
+package router
+
+import (
+	"ezlock/common/health"
+	"ezlock/controller"
+	"github.com/gin-gonic/gin"
+)
+
+// 健康检查相关的接口，不需要登录，也不受数据库是否就绪的影响
+func Health(router *gin.Engine, checks []health.Check) {
+	// 存活检查
+	router.GET("/healthz", controller.Healthz)
+	// 就绪检查，检查数据库、索引和配置
+	router.GET("/readyz", controller.Readyz(checks))
+	// 构建版本信息
+	router.GET("/version", controller.Version)
+}
@@ -0,0 +1,132 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+type apiKeyStore struct {
+	*db
+}
+
+func (s *apiKeyStore) Get(ctx context.Context, id primitive.ObjectID) (*model.ApiKey, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &key, nil
+}
+
+func (s *apiKeyStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.ApiKey, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	keys := []model.ApiKey{}
+	for _, key := range s.apiKeys {
+		if key.UserId == userId && !key.Revoked {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreateTime.Before(keys[j].CreateTime) })
+	return keys, nil
+}
+
+func (s *apiKeyStore) Insert(ctx context.Context, key *model.ApiKey) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if key.Id.IsZero() {
+		key.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.apiKeys[key.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.apiKeys[key.Id] = *key
+	return nil
+}
+
+func (s *apiKeyStore) Rotate(ctx context.Context, id, userId primitive.ObjectID, keyHash string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok || key.UserId != userId || key.Revoked {
+		return store.ErrNotFound
+	}
+	key.KeyHash = keyHash
+	key.UpdateTime = time.Now().Local()
+	s.apiKeys[id] = key
+	return nil
+}
+
+func (s *apiKeyStore) Revoke(ctx context.Context, id, userId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok || key.UserId != userId {
+		return store.ErrNotFound
+	}
+	key.Revoked = true
+	key.UpdateTime = time.Now().Local()
+	s.apiKeys[id] = key
+	return nil
+}
+
+func (s *apiKeyStore) RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, key := range s.apiKeys {
+		if key.UserId != userId || key.Revoked {
+			continue
+		}
+		key.Revoked = true
+		key.UpdateTime = time.Now().Local()
+		s.apiKeys[id] = key
+		count++
+	}
+	return count, nil
+}
+
+func (s *apiKeyStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	key.LastUsedTime = at
+	s.apiKeys[id] = key
+	return nil
+}
@@ -0,0 +1,68 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+)
+
+type auditStore struct {
+	*db
+}
+
+func (s *auditStore) Insert(ctx context.Context, audit *model.Audit) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if audit.Id.IsZero() {
+		audit.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.audits[audit.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.audits[audit.Id] = *audit
+	return nil
+}
+
+func (s *auditStore) SetOutcome(ctx context.Context, id primitive.ObjectID, outcome string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	audit, ok := s.audits[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	audit.Outcome = outcome
+	s.audits[id] = audit
+	return nil
+}
+
+func (s *auditStore) Find(ctx context.Context, actorId primitive.ObjectID, limit int) ([]model.Audit, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	audits := []model.Audit{}
+	for _, audit := range s.audits {
+		if actorId.IsZero() || audit.ActorId == actorId {
+			audits = append(audits, audit)
+		}
+	}
+	sort.Slice(audits, func(i, j int) bool {
+		return audits[i].CreateTime.After(audits[j].CreateTime)
+	})
+	if len(audits) > limit {
+		audits = audits[:limit]
+	}
+	return audits, nil
+}
@@ -0,0 +1,229 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type authStore struct {
+	*db
+}
+
+func (s *authStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Auth, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	auth, ok := s.auths[id]
+	if !ok || !store.InTenant(ctx, auth.OrgId) {
+		return nil, store.ErrNotFound
+	}
+	return &auth, nil
+}
+
+func (s *authStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Auth, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	auths := []model.Auth{}
+	for _, auth := range s.auths {
+		if auth.LockId == lockId && store.InTenant(ctx, auth.OrgId) {
+			auths = append(auths, auth)
+		}
+	}
+	return auths, nil
+}
+
+func (s *authStore) FindByLockAndUser(ctx context.Context, lockId, userId primitive.ObjectID) ([]model.Auth, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	auths := []model.Auth{}
+	for _, auth := range s.auths {
+		if auth.LockId != lockId || !store.InTenant(ctx, auth.OrgId) {
+			continue
+		}
+		if auth.SendId == userId || auth.ReceiverId == userId {
+			auths = append(auths, auth)
+		}
+	}
+	return auths, nil
+}
+
+func (s *authStore) FindByReceiver(ctx context.Context, receiverId primitive.ObjectID, valid bool, perms model.Perms) ([]model.Auth, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	auths := []model.Auth{}
+	for _, auth := range s.auths {
+		if auth.ReceiverId != receiverId || (valid && !auth.Valid) || !store.InTenant(ctx, auth.OrgId) {
+			continue
+		}
+		if (perms.AddCard && !auth.AddCard) || (perms.ShareAuth && !auth.ShareAuth) || (perms.ViewLog && !auth.ViewLog) {
+			continue
+		}
+		auths = append(auths, auth)
+	}
+	return auths, nil
+}
+
+func (s *authStore) Insert(ctx context.Context, auth *model.Auth) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if auth.Id.IsZero() {
+		auth.Id = primitive.NewObjectID()
+	}
+	auth.OrgId = store.TenantId(ctx, auth.OrgId)
+	if _, ok := s.auths[auth.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.auths[auth.Id] = *auth
+	return nil
+}
+
+func (s *authStore) SetReceiver(ctx context.Context, id, receiverId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	auth, ok := s.auths[id]
+	if !ok || !auth.ReceiverId.IsZero() || !store.InTenant(ctx, auth.OrgId) {
+		return store.ErrNotFound
+	}
+	auth.ReceiverId = receiverId
+	auth.UpdateTime = time.Now().Local()
+	s.auths[id] = auth
+	return nil
+}
+
+func (s *authStore) Invalidate(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	auth, ok := s.auths[id]
+	if !ok || !store.InTenant(ctx, auth.OrgId) {
+		return store.ErrNotFound
+	}
+	auth.Valid = false
+	auth.UpdateTime = time.Now().Local()
+	s.auths[id] = auth
+	return nil
+}
+
+func (s *authStore) InvalidateByLock(ctx context.Context, lockId primitive.ObjectID) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, auth := range s.auths {
+		if auth.LockId != lockId || !auth.Valid || !store.InTenant(ctx, auth.OrgId) {
+			continue
+		}
+		auth.Valid = false
+		auth.UpdateTime = time.Now().Local()
+		s.auths[id] = auth
+		count++
+	}
+	return count, nil
+}
+
+func (s *authStore) Revoke(ctx context.Context, id, sendId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	auth, ok := s.auths[id]
+	if !ok || auth.SendId != sendId || !store.InTenant(ctx, auth.OrgId) {
+		return store.ErrNotFound
+	}
+	auth.Valid = false
+	auth.UpdateTime = time.Now().Local()
+	s.auths[id] = auth
+	return nil
+}
+
+func (s *authStore) FindBySpace(ctx context.Context, spaceId primitive.ObjectID) ([]model.Auth, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	auths := []model.Auth{}
+	for _, auth := range s.auths {
+		if !store.InTenant(ctx, auth.OrgId) {
+			continue
+		}
+		for _, id := range auth.SpaceIds {
+			if id == spaceId {
+				auths = append(auths, auth)
+				break
+			}
+		}
+	}
+	return auths, nil
+}
+
+func (s *authStore) FindBySender(ctx context.Context, sendId primitive.ObjectID) ([]model.Auth, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	auths := []model.Auth{}
+	for _, auth := range s.auths {
+		if auth.SendId == sendId && store.InTenant(ctx, auth.OrgId) {
+			auths = append(auths, auth)
+		}
+	}
+	return auths, nil
+}
+
+func (s *authStore) ClaimByPhone(ctx context.Context, phone string, receiverId primitive.ObjectID) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, auth := range s.auths {
+		if auth.Phone != phone || !auth.ReceiverId.IsZero() || !auth.Valid || auth.SendId == receiverId || !store.InTenant(ctx, auth.OrgId) {
+			continue
+		}
+		auth.ReceiverId = receiverId
+		auth.UpdateTime = time.Now().Local()
+		s.auths[id] = auth
+		count++
+	}
+	return count, nil
+}
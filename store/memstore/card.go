@@ -0,0 +1,118 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type cardStore struct {
+	*db
+}
+
+func (s *cardStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Card, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	card, ok := s.cards[id]
+	if !ok || !store.InTenant(ctx, card.OrgId) {
+		return nil, store.ErrNotFound
+	}
+	return &card, nil
+}
+
+func (s *cardStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Card, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	cards := []model.Card{}
+	for _, card := range s.cards {
+		if card.Lock == lockId && store.InTenant(ctx, card.OrgId) {
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+func (s *cardStore) Insert(ctx context.Context, card *model.Card) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if card.Id.IsZero() {
+		card.Id = primitive.NewObjectID()
+	}
+	card.OrgId = store.TenantId(ctx, card.OrgId)
+	if _, ok := s.cards[card.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.cards[card.Id] = *card
+	return nil
+}
+
+func (s *cardStore) UpdateInfo(ctx context.Context, id primitive.ObjectID, name, desc string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	card, ok := s.cards[id]
+	if !ok || !store.InTenant(ctx, card.OrgId) {
+		return store.ErrNotFound
+	}
+	if len(name) != 0 {
+		card.Name = name
+	}
+	if len(desc) != 0 {
+		card.Desc = desc
+	}
+	card.UpdateTime = time.Now().Local()
+	s.cards[id] = card
+	return nil
+}
+
+func (s *cardStore) InvalidateByNumber(ctx context.Context, lockId primitive.ObjectID, number string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	for id, card := range s.cards {
+		if card.Lock != lockId || card.Number != number || !card.Valid || !store.InTenant(ctx, card.OrgId) {
+			continue
+		}
+		card.Valid = false
+		card.UpdateTime = time.Now().Local()
+		s.cards[id] = card
+		return nil
+	}
+	return store.ErrNotFound
+}
+
+func (s *cardStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Card, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	cards := []model.Card{}
+	for _, card := range s.cards {
+		if card.UserId == userId && store.InTenant(ctx, card.OrgId) {
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
@@ -0,0 +1,121 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type deviceStore struct {
+	*db
+}
+
+func (s *deviceStore) GetByMac(ctx context.Context, mac string) (*model.Device, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, device := range s.devices {
+		if device.Mac == mac {
+			return &device, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *deviceStore) Insert(ctx context.Context, device *model.Device) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if device.Id.IsZero() {
+		device.Id = primitive.NewObjectID()
+	}
+	// 和数据库的 mac、序列号唯一索引保持一致
+	for _, exists := range s.devices {
+		if exists.Id == device.Id || exists.Mac == device.Mac || exists.Serial == device.Serial {
+			return store.ErrDuplicate
+		}
+	}
+	s.devices[device.Id] = *device
+	return nil
+}
+
+func (s *deviceStore) Claim(ctx context.Context, id, lockId, userId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok || device.Claimed() {
+		return store.ErrNotFound
+	}
+	device.LockId = lockId
+	device.ClaimedBy = userId
+	device.Attempts = 0
+	device.ClaimTime = time.Now().Local()
+	device.UpdateTime = device.ClaimTime
+	s.devices[id] = device
+	return nil
+}
+
+func (s *deviceStore) Reclaim(ctx context.Context, id, userId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok || !device.Claimed() {
+		return store.ErrNotFound
+	}
+	device.ClaimedBy = userId
+	device.ClaimTime = time.Now().Local()
+	device.UpdateTime = device.ClaimTime
+	s.devices[id] = device
+	return nil
+}
+
+func (s *deviceStore) RecordFailure(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	device.Attempts++
+	device.UpdateTime = time.Now().Local()
+	s.devices[id] = device
+	return nil
+}
+
+func (s *deviceStore) SetClaimCode(ctx context.Context, id primitive.ObjectID, claimCodeHash string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	device.ClaimCodeHash = claimCodeHash
+	device.Attempts = 0
+	device.UpdateTime = time.Now().Local()
+	s.devices[id] = device
+	return nil
+}
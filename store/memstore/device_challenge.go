@@ -0,0 +1,46 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type deviceChallengeStore struct {
+	*db
+}
+
+func (s *deviceChallengeStore) Insert(ctx context.Context, challenge *model.DeviceChallenge) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if challenge.Id.IsZero() {
+		challenge.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.challenges[challenge.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.challenges[challenge.Id] = *challenge
+	return nil
+}
+
+func (s *deviceChallengeStore) Use(ctx context.Context, id, userId primitive.ObjectID, mac string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	challenge, ok := s.challenges[id]
+	if !ok || challenge.Used || challenge.UserId != userId || challenge.Mac != mac || !time.Now().Before(challenge.ExpireTime) {
+		return store.ErrNotFound
+	}
+	challenge.Used = true
+	s.challenges[id] = challenge
+	return nil
+}
@@ -0,0 +1,269 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type lockStore struct {
+	*db
+}
+
+func (s *lockStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Lock, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	lock, ok := s.locks[id]
+	if !ok || !store.InTenant(ctx, lock.OrgId) {
+		return nil, store.ErrNotFound
+	}
+	return &lock, nil
+}
+
+func (s *lockStore) GetByMac(ctx context.Context, mac string) (*model.Lock, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, lock := range s.locks {
+		if lock.Mac == mac && store.InTenant(ctx, lock.OrgId) {
+			return &lock, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *lockStore) FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Lock, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	locks := []model.Lock{}
+	for _, id := range ids {
+		if lock, ok := s.locks[id]; ok && store.InTenant(ctx, lock.OrgId) {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+func (s *lockStore) FindByOwner(ctx context.Context, own primitive.ObjectID, valid bool) ([]model.Lock, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	locks := []model.Lock{}
+	for _, lock := range s.locks {
+		if lock.Own != own || (valid && !lock.Valid) || !store.InTenant(ctx, lock.OrgId) {
+			continue
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+func (s *lockStore) FindByOrg(ctx context.Context, orgId primitive.ObjectID, valid bool) ([]model.Lock, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	locks := []model.Lock{}
+	for _, lock := range s.locks {
+		if lock.OrgId != orgId || (valid && !lock.Valid) || !store.InTenant(ctx, lock.OrgId) {
+			continue
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+func (s *lockStore) Insert(ctx context.Context, lock *model.Lock) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if lock.Id.IsZero() {
+		lock.Id = primitive.NewObjectID()
+	}
+	lock.OrgId = store.TenantId(ctx, lock.OrgId)
+	for _, exists := range s.locks {
+		// 和 mongo 的 mac 唯一索引保持一致
+		if exists.Id == lock.Id || exists.Mac == lock.Mac {
+			return store.ErrDuplicate
+		}
+	}
+	s.locks[lock.Id] = *lock
+	return nil
+}
+
+func (s *lockStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.locks[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.locks, id)
+	return nil
+}
+
+func (s *lockStore) Revive(ctx context.Context, lock *model.Lock) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	exists, ok := s.locks[lock.Id]
+	if !ok || exists.Valid {
+		return store.ErrNotFound
+	}
+	exists.Name = lock.Name
+	exists.Desc = lock.Desc
+	exists.Version = lock.Version
+	exists.Own = lock.Own
+	exists.OrgId = store.TenantId(ctx, lock.OrgId)
+	exists.SpaceId = primitive.NilObjectID
+	exists.Valid = true
+	exists.UpdateTime = time.Now().Local()
+	s.locks[lock.Id] = exists
+	*lock = exists
+	return nil
+}
+
+func (s *lockStore) UpdateInfo(ctx context.Context, mac string, own primitive.ObjectID, name, desc string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	for id, lock := range s.locks {
+		if lock.Mac != mac || lock.Own != own || !lock.Valid || !store.InTenant(ctx, lock.OrgId) {
+			continue
+		}
+		if len(name) != 0 {
+			lock.Name = name
+		}
+		if len(desc) != 0 {
+			lock.Desc = desc
+		}
+		lock.UpdateTime = time.Now().Local()
+		s.locks[id] = lock
+		return nil
+	}
+	return store.ErrNotFound
+}
+
+func (s *lockStore) Invalidate(ctx context.Context, mac string, own primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	for id, lock := range s.locks {
+		if lock.Mac != mac || lock.Own != own || !store.InTenant(ctx, lock.OrgId) {
+			continue
+		}
+		lock.Valid = false
+		lock.UpdateTime = time.Now().Local()
+		s.locks[id] = lock
+		return nil
+	}
+	return store.ErrNotFound
+}
+
+func (s *lockStore) SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	lock, ok := s.locks[id]
+	if !ok || lock.Own != own || !lock.Valid || !store.InTenant(ctx, lock.OrgId) {
+		return store.ErrNotFound
+	}
+	lock.Own = newOwn
+	lock.SpaceId = primitive.NilObjectID
+	lock.UpdateTime = time.Now().Local()
+	s.locks[id] = lock
+	return nil
+}
+
+func (s *lockStore) FindBySpaces(ctx context.Context, spaceIds []primitive.ObjectID) ([]model.Lock, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	spaces := make(map[primitive.ObjectID]bool, len(spaceIds))
+	for _, id := range spaceIds {
+		spaces[id] = true
+	}
+	locks := []model.Lock{}
+	for _, lock := range s.locks {
+		if !lock.SpaceId.IsZero() && spaces[lock.SpaceId] && store.InTenant(ctx, lock.OrgId) {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+func (s *lockStore) SetSpace(ctx context.Context, id, own, spaceId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	lock, ok := s.locks[id]
+	if !ok || lock.Own != own || !lock.Valid || !store.InTenant(ctx, lock.OrgId) {
+		return store.ErrNotFound
+	}
+	lock.SpaceId = spaceId
+	lock.UpdateTime = time.Now().Local()
+	s.locks[id] = lock
+	return nil
+}
+
+func (s *lockStore) ClearSpace(ctx context.Context, spaceId primitive.ObjectID) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, lock := range s.locks {
+		if lock.SpaceId != spaceId || !store.InTenant(ctx, lock.OrgId) {
+			continue
+		}
+		lock.SpaceId = primitive.NilObjectID
+		lock.UpdateTime = time.Now().Local()
+		s.locks[id] = lock
+		count++
+	}
+	return count, nil
+}
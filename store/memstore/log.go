@@ -0,0 +1,89 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type logStore struct {
+	*db
+}
+
+func (s *logStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Log, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	logs := []model.Log{}
+	for _, log := range s.logs {
+		if log.LockId == lockId && store.InTenant(ctx, log.OrgId) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func (s *logStore) Upsert(ctx context.Context, log *model.Log) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	log.OrgId = store.TenantId(ctx, log.OrgId)
+	for id, exists := range s.logs {
+		if exists.RowInfo == log.RowInfo {
+			// 和数据库的原始信息唯一索引保持一致，不覆盖别的租户的日志
+			if exists.OrgId != log.OrgId {
+				return store.ErrDuplicate
+			}
+			log.Id = id
+			s.logs[id] = *log
+			return nil
+		}
+	}
+	if log.Id.IsZero() {
+		log.Id = primitive.NewObjectID()
+	}
+	s.logs[log.Id] = *log
+	return nil
+}
+
+func (s *logStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Log, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	logs := []model.Log{}
+	for _, log := range s.logs {
+		if log.UserId == userId && store.InTenant(ctx, log.OrgId) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func (s *logStore) AnonymizeUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, log := range s.logs {
+		if log.UserId != userId || !store.InTenant(ctx, log.OrgId) {
+			continue
+		}
+		log.UserId = primitive.NilObjectID
+		s.logs[id] = log
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,74 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"time"
+)
+
+type loginFailureStore struct {
+	*db
+}
+
+func (s *loginFailureStore) Get(ctx context.Context, key string) (*model.LoginFailure, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	failure, ok := s.failures[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &failure, nil
+}
+
+func (s *loginFailureStore) AddFailure(ctx context.Context, key string, now, expireTime time.Time) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	failure, ok := s.failures[key]
+	if !ok {
+		failure = model.LoginFailure{Key: key}
+	}
+	if !failure.ExpireTime.After(now) {
+		failure.Failures = 0
+	}
+	failure.Failures++
+	failure.ExpireTime = expireTime
+	s.failures[key] = failure
+	return failure.Failures, nil
+}
+
+func (s *loginFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	failure, ok := s.failures[key]
+	if !ok {
+		return store.ErrNotFound
+	}
+	failure.Failures = 0
+	failure.LockedUntil = until
+	s.failures[key] = failure
+	return nil
+}
+
+func (s *loginFailureStore) Reset(ctx context.Context, key string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	delete(s.failures, key)
+	return nil
+}
@@ -0,0 +1,128 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+type memberStore struct {
+	*db
+}
+
+func (s *memberStore) Get(ctx context.Context, lockId, userId primitive.ObjectID) (*model.Member, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, member := range s.members {
+		if member.LockId == lockId && member.UserId == userId {
+			return &member, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *memberStore) find(match func(m model.Member) bool) []model.Member {
+	s.RLock()
+	defer s.RUnlock()
+
+	members := []model.Member{}
+	for _, member := range s.members {
+		if match(member) {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].CreateTime.Before(members[j].CreateTime) })
+	return members
+}
+
+func (s *memberStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Member, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.find(func(m model.Member) bool { return m.LockId == lockId }), nil
+}
+
+func (s *memberStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Member, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.find(func(m model.Member) bool { return m.UserId == userId }), nil
+}
+
+func (s *memberStore) Insert(ctx context.Context, member *model.Member) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if member.Id.IsZero() {
+		member.Id = primitive.NewObjectID()
+	}
+	// 和数据库的门锁、用户唯一索引保持一致
+	for _, exists := range s.members {
+		if exists.Id == member.Id || (exists.LockId == member.LockId && exists.UserId == member.UserId) {
+			return store.ErrDuplicate
+		}
+	}
+	s.members[member.Id] = *member
+	return nil
+}
+
+func (s *memberStore) SetRole(ctx context.Context, lockId, userId primitive.ObjectID, role string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	for id, member := range s.members {
+		if member.LockId == lockId && member.UserId == userId {
+			member.Role = role
+			member.UpdateTime = time.Now().Local()
+			s.members[id] = member
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s *memberStore) Delete(ctx context.Context, lockId, userId primitive.ObjectID) error {
+	count, err := s.delete(ctx, func(m model.Member) bool { return m.LockId == lockId && m.UserId == userId })
+	if err == nil && count == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *memberStore) DeleteByLock(ctx context.Context, lockId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, func(m model.Member) bool { return m.LockId == lockId })
+}
+
+func (s *memberStore) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, func(m model.Member) bool { return m.UserId == userId })
+}
+
+func (s *memberStore) delete(ctx context.Context, match func(m model.Member) bool) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, member := range s.members {
+		if match(member) {
+			delete(s.members, id)
+			count++
+		}
+	}
+	return count, nil
+}
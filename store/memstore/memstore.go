@@ -0,0 +1,84 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"ezlock/model"
+	"ezlock/store"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// 所有表的数据都放在内存里，一把读写锁保护，主要用于单元测试和本地调试
+type db struct {
+	sync.RWMutex
+	users      map[primitive.ObjectID]model.User
+	locks      map[primitive.ObjectID]model.Lock
+	auths      map[primitive.ObjectID]model.Auth
+	cards      map[primitive.ObjectID]model.Card
+	logs       map[primitive.ObjectID]model.Log
+	sessions   map[primitive.ObjectID]model.Session
+	codes      map[primitive.ObjectID]model.VerifyCode
+	failures   map[string]model.LoginFailure
+	audits     map[primitive.ObjectID]model.Audit
+	apiKeys    map[primitive.ObjectID]model.ApiKey
+	transfers  map[primitive.ObjectID]model.Transfer
+	members    map[primitive.ObjectID]model.Member
+	spaces     map[primitive.ObjectID]model.Space
+	orgs       map[primitive.ObjectID]model.Organization
+	orgMembers map[primitive.ObjectID]model.OrgMember
+	devices    map[primitive.ObjectID]model.Device
+	challenges map[primitive.ObjectID]model.DeviceChallenge
+}
+
+// 创建内存实现的 Store，每次调用都是一份独立的空数据
+func New() *store.Store {
+	d := &db{
+		users:      map[primitive.ObjectID]model.User{},
+		locks:      map[primitive.ObjectID]model.Lock{},
+		auths:      map[primitive.ObjectID]model.Auth{},
+		cards:      map[primitive.ObjectID]model.Card{},
+		logs:       map[primitive.ObjectID]model.Log{},
+		sessions:   map[primitive.ObjectID]model.Session{},
+		codes:      map[primitive.ObjectID]model.VerifyCode{},
+		failures:   map[string]model.LoginFailure{},
+		audits:     map[primitive.ObjectID]model.Audit{},
+		apiKeys:    map[primitive.ObjectID]model.ApiKey{},
+		transfers:  map[primitive.ObjectID]model.Transfer{},
+		members:    map[primitive.ObjectID]model.Member{},
+		spaces:     map[primitive.ObjectID]model.Space{},
+		orgs:       map[primitive.ObjectID]model.Organization{},
+		orgMembers: map[primitive.ObjectID]model.OrgMember{},
+		devices:    map[primitive.ObjectID]model.Device{},
+		challenges: map[primitive.ObjectID]model.DeviceChallenge{},
+	}
+	return &store.Store{
+		Users:         &userStore{d},
+		Locks:         &lockStore{d},
+		Auths:         &authStore{d},
+		Cards:         &cardStore{d},
+		Logs:          &logStore{d},
+		Sessions:      &sessionStore{d},
+		VerifyCodes:   &verifyCodeStore{d},
+		LoginFailures: &loginFailureStore{d},
+		Audits:        &auditStore{d},
+		ApiKeys:       &apiKeyStore{d},
+		Transfers:     &transferStore{d},
+		Members:       &memberStore{d},
+		Spaces:        &spaceStore{d},
+		Orgs:          &orgStore{d},
+		OrgMembers:    &orgMemberStore{d},
+		Devices:       &deviceStore{d},
+		Challenges:    &deviceChallengeStore{d},
+	}
+}
+
+// 和数据库实现保持一致，请求已经取消或者超时的时候不再操作数据
+func ctxErr(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s", store.ErrTimeout, err.Error())
+	}
+	return err
+}
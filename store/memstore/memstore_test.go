@@ -0,0 +1,14 @@
+package memstore_test
+
+import (
+	"ezlock/store"
+	"ezlock/store/memstore"
+	"ezlock/store/storetest"
+	"testing"
+)
+
+func TestMemStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store {
+		return memstore.New()
+	})
+}
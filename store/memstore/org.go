@@ -0,0 +1,192 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+type orgStore struct {
+	*db
+}
+
+func (s *orgStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Organization, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	org, ok := s.orgs[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &org, nil
+}
+
+func (s *orgStore) FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Organization, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	orgs := []model.Organization{}
+	for _, id := range ids {
+		if org, ok := s.orgs[id]; ok {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs, nil
+}
+
+func (s *orgStore) Insert(ctx context.Context, org *model.Organization) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if org.Id.IsZero() {
+		org.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.orgs[org.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.orgs[org.Id] = *org
+	return nil
+}
+
+func (s *orgStore) SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	org, ok := s.orgs[id]
+	if !ok || org.OwnerId != own {
+		return store.ErrNotFound
+	}
+	org.OwnerId = newOwn
+	org.UpdateTime = time.Now().Local()
+	s.orgs[id] = org
+	return nil
+}
+
+type orgMemberStore struct {
+	*db
+}
+
+func (s *orgMemberStore) Get(ctx context.Context, orgId, userId primitive.ObjectID) (*model.OrgMember, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, member := range s.orgMembers {
+		if member.OrgId == orgId && member.UserId == userId {
+			return &member, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *orgMemberStore) find(match func(m model.OrgMember) bool) []model.OrgMember {
+	s.RLock()
+	defer s.RUnlock()
+
+	members := []model.OrgMember{}
+	for _, member := range s.orgMembers {
+		if match(member) {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].CreateTime.Before(members[j].CreateTime) })
+	return members
+}
+
+func (s *orgMemberStore) FindByOrg(ctx context.Context, orgId primitive.ObjectID) ([]model.OrgMember, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.find(func(m model.OrgMember) bool { return m.OrgId == orgId }), nil
+}
+
+func (s *orgMemberStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.OrgMember, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.find(func(m model.OrgMember) bool { return m.UserId == userId }), nil
+}
+
+func (s *orgMemberStore) Insert(ctx context.Context, member *model.OrgMember) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if member.Id.IsZero() {
+		member.Id = primitive.NewObjectID()
+	}
+	// 和数据库的组织、用户唯一索引保持一致
+	for _, exists := range s.orgMembers {
+		if exists.Id == member.Id || (exists.OrgId == member.OrgId && exists.UserId == member.UserId) {
+			return store.ErrDuplicate
+		}
+	}
+	s.orgMembers[member.Id] = *member
+	return nil
+}
+
+func (s *orgMemberStore) SetRole(ctx context.Context, orgId, userId primitive.ObjectID, role string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	for id, member := range s.orgMembers {
+		if member.OrgId == orgId && member.UserId == userId {
+			member.Role = role
+			member.UpdateTime = time.Now().Local()
+			s.orgMembers[id] = member
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (s *orgMemberStore) Delete(ctx context.Context, orgId, userId primitive.ObjectID) error {
+	count, err := s.delete(ctx, func(m model.OrgMember) bool { return m.OrgId == orgId && m.UserId == userId })
+	if err == nil && count == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *orgMemberStore) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, func(m model.OrgMember) bool { return m.UserId == userId })
+}
+
+func (s *orgMemberStore) delete(ctx context.Context, match func(m model.OrgMember) bool) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, member := range s.orgMembers {
+		if match(member) {
+			delete(s.orgMembers, id)
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,132 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type sessionStore struct {
+	*db
+}
+
+func (s *sessionStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Session, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &session, nil
+}
+
+func (s *sessionStore) Insert(ctx context.Context, session *model.Session) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if session.Id.IsZero() {
+		session.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.sessions[session.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.sessions[session.Id] = *session
+	return nil
+}
+
+func (s *sessionStore) Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expireTime time.Time) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Revoked || session.RefreshHash != oldHash {
+		return store.ErrNotFound
+	}
+	session.RefreshHash = newHash
+	session.ExpireTime = expireTime
+	session.UpdateTime = time.Now().Local()
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *sessionStore) FindActiveByUser(ctx context.Context, userId primitive.ObjectID, now time.Time) ([]model.Session, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	sessions := []model.Session{}
+	for _, session := range s.sessions {
+		if session.UserId == userId && !session.Revoked && session.ExpireTime.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *sessionStore) Touch(ctx context.Context, id primitive.ObjectID, ip string, at time.Time) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	session.Ip = ip
+	session.LastUsedTime = at
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *sessionStore) Revoke(ctx context.Context, id, userId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.UserId != userId {
+		return store.ErrNotFound
+	}
+	session.Revoked = true
+	session.UpdateTime = time.Now().Local()
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *sessionStore) RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, session := range s.sessions {
+		if session.UserId != userId || session.Revoked {
+			continue
+		}
+		session.Revoked = true
+		session.UpdateTime = time.Now().Local()
+		s.sessions[id] = session
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,141 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+type spaceStore struct {
+	*db
+}
+
+func (s *spaceStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Space, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	space, ok := s.spaces[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &space, nil
+}
+
+func (s *spaceStore) find(match func(space model.Space) bool) []model.Space {
+	s.RLock()
+	defer s.RUnlock()
+
+	spaces := []model.Space{}
+	for _, space := range s.spaces {
+		if match(space) {
+			spaces = append(spaces, space)
+		}
+	}
+	sort.Slice(spaces, func(i, j int) bool { return spaces[i].CreateTime.Before(spaces[j].CreateTime) })
+	return spaces
+}
+
+func (s *spaceStore) FindByOwner(ctx context.Context, own primitive.ObjectID) ([]model.Space, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.find(func(space model.Space) bool { return space.Own == own }), nil
+}
+
+func (s *spaceStore) FindSubtree(ctx context.Context, ids []primitive.ObjectID) ([]model.Space, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	roots := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		roots[id] = true
+	}
+	return s.find(func(space model.Space) bool {
+		if roots[space.Id] {
+			return true
+		}
+		for _, ancestor := range space.Ancestors {
+			if roots[ancestor] {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (s *spaceStore) Insert(ctx context.Context, space *model.Space) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if space.Id.IsZero() {
+		space.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.spaces[space.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.spaces[space.Id] = *space
+	return nil
+}
+
+func (s *spaceStore) UpdateInfo(ctx context.Context, id, own primitive.ObjectID, name, desc string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	space, ok := s.spaces[id]
+	if !ok || space.Own != own {
+		return store.ErrNotFound
+	}
+	if len(name) != 0 {
+		space.Name = name
+	}
+	if len(desc) != 0 {
+		space.Desc = desc
+	}
+	space.UpdateTime = time.Now().Local()
+	s.spaces[id] = space
+	return nil
+}
+
+func (s *spaceStore) Delete(ctx context.Context, id, own primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	space, ok := s.spaces[id]
+	if !ok || space.Own != own {
+		return store.ErrNotFound
+	}
+	delete(s.spaces, id)
+	return nil
+}
+
+func (s *spaceStore) DeleteByOwner(ctx context.Context, own primitive.ObjectID) (int, error) {
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	count := 0
+	for id, space := range s.spaces {
+		if space.Own == own {
+			delete(s.spaces, id)
+			count++
+		}
+	}
+	return count, nil
+}
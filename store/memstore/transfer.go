@@ -0,0 +1,112 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+type transferStore struct {
+	*db
+}
+
+func (s *transferStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Transfer, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	transfer, ok := s.transfers[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &transfer, nil
+}
+
+func (s *transferStore) find(match func(t model.Transfer) bool) []model.Transfer {
+	s.RLock()
+	defer s.RUnlock()
+
+	transfers := []model.Transfer{}
+	for _, transfer := range s.transfers {
+		if match(transfer) {
+			transfers = append(transfers, transfer)
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].CreateTime.After(transfers[j].CreateTime) })
+	return transfers
+}
+
+func (s *transferStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Transfer, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.find(func(t model.Transfer) bool { return t.LockId == lockId }), nil
+}
+
+func (s *transferStore) FindByUser(ctx context.Context, userId primitive.ObjectID, phone string) ([]model.Transfer, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.find(func(t model.Transfer) bool {
+		return t.FromId == userId || t.ToId == userId || (phone != "" && t.ToPhone == phone)
+	}), nil
+}
+
+func (s *transferStore) Insert(ctx context.Context, transfer *model.Transfer) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if transfer.Id.IsZero() {
+		transfer.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.transfers[transfer.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.transfers[transfer.Id] = *transfer
+	return nil
+}
+
+func (s *transferStore) Accept(ctx context.Context, id, toId primitive.ObjectID, authsRevoked int, wipedCards []string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	transfer, ok := s.transfers[id]
+	if !ok || transfer.Status != model.TransferPending {
+		return store.ErrNotFound
+	}
+	transfer.Status = model.TransferAccepted
+	transfer.ToId = toId
+	transfer.AuthsRevoked = authsRevoked
+	transfer.WipedCards = wipedCards
+	transfer.UpdateTime = time.Now().Local()
+	s.transfers[id] = transfer
+	return nil
+}
+
+func (s *transferStore) Close(ctx context.Context, id primitive.ObjectID, status string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	transfer, ok := s.transfers[id]
+	if !ok || transfer.Status != model.TransferPending {
+		return store.ErrNotFound
+	}
+	transfer.Status = status
+	transfer.UpdateTime = time.Now().Local()
+	s.transfers[id] = transfer
+	return nil
+}
@@ -0,0 +1,256 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type userStore struct {
+	*db
+}
+
+func (s *userStore) Get(ctx context.Context, id primitive.ObjectID) (*model.User, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *userStore) GetByOpenId(ctx context.Context, openId string) (*model.User, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, user := range s.users {
+		if user.OpenId == openId {
+			return &user, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *userStore) GetByPhone(ctx context.Context, phoneNumber string) (*model.User, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	var found *model.User
+	for _, user := range s.users {
+		if user.PhoneNumber != phoneNumber {
+			continue
+		}
+		if found == nil || user.CreateTime.Before(found.CreateTime) {
+			u := user
+			found = &u
+		}
+	}
+	if found == nil {
+		return nil, store.ErrNotFound
+	}
+	return found, nil
+}
+
+func (s *userStore) GetByUserName(ctx context.Context, userName string) (*model.User, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, user := range s.users {
+		if user.UserName != "" && user.UserName == userName {
+			return &user, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *userStore) Insert(ctx context.Context, user *model.User) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if user.Id.IsZero() {
+		user.Id = primitive.NewObjectID()
+	}
+	for _, exists := range s.users {
+		// 和 mongo 的 openId、userName 唯一索引保持一致，空值不参与唯一约束
+		if exists.Id == user.Id ||
+			(user.OpenId != "" && exists.OpenId == user.OpenId) ||
+			(user.UserName != "" && exists.UserName == user.UserName) {
+			return store.ErrDuplicate
+		}
+	}
+	s.users[user.Id] = *user
+	return nil
+}
+
+func (s *userStore) UpdateProfile(ctx context.Context, user *model.User) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	exists, ok := s.users[user.Id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	exists.SessionKey = user.SessionKey
+	exists.NickName = user.NickName
+	exists.UnionId = user.UnionId
+	exists.Gender = user.Gender
+	exists.Province = user.Province
+	exists.City = user.City
+	exists.Country = user.Country
+	exists.AvatarUrl = user.AvatarUrl
+	exists.Language = user.Language
+	exists.UpdateTime = time.Now().Local()
+	s.users[user.Id] = exists
+	return nil
+}
+
+func (s *userStore) SetPhoneNumber(ctx context.Context, id primitive.ObjectID, phoneNumber string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	user.PhoneNumber = phoneNumber
+	user.UpdateTime = time.Now().Local()
+	s.users[id] = user
+	return nil
+}
+
+func (s *userStore) SetDefaultLock(ctx context.Context, id, lockId primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	user.DefaultLock = lockId
+	user.UpdateTime = time.Now().Local()
+	s.users[id] = user
+	return nil
+}
+
+func (s *userStore) SetPassword(ctx context.Context, id primitive.ObjectID, userName, passwordHash string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	for _, exists := range s.users {
+		if exists.Id != id && exists.UserName == userName {
+			return store.ErrDuplicate
+		}
+	}
+	user.UserName = userName
+	user.PasswordHash = passwordHash
+	user.UpdateTime = time.Now().Local()
+	s.users[id] = user
+	return nil
+}
+
+func (s *userStore) FindByPhone(ctx context.Context, phoneNumber string) ([]model.User, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	users := []model.User{}
+	for _, user := range s.users {
+		if user.PhoneNumber == phoneNumber {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (s *userStore) SetRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	user.Role = role
+	user.UpdateTime = time.Now().Local()
+	s.users[id] = user
+	return nil
+}
+
+func (s *userStore) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	user.Disabled = disabled
+	user.UpdateTime = time.Now().Local()
+	s.users[id] = user
+	return nil
+}
+
+func (s *userStore) Anonymize(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	s.users[id] = model.User{
+		Id:         user.Id,
+		NickName:   model.DeletedNickName,
+		Role:       user.Role,
+		Disabled:   true,
+		UpdateTime: time.Now().Local(),
+		CreateTime: user.CreateTime,
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package memstore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type verifyCodeStore struct {
+	*db
+}
+
+func (s *verifyCodeStore) Insert(ctx context.Context, code *model.VerifyCode) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if code.Id.IsZero() {
+		code.Id = primitive.NewObjectID()
+	}
+	if _, ok := s.codes[code.Id]; ok {
+		return store.ErrDuplicate
+	}
+	s.codes[code.Id] = *code
+	return nil
+}
+
+func (s *verifyCodeStore) Latest(ctx context.Context, phone string) (*model.VerifyCode, error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	var latest *model.VerifyCode
+	for _, code := range s.codes {
+		if code.Phone != phone {
+			continue
+		}
+		if latest == nil || code.CreateTime.After(latest.CreateTime) {
+			c := code
+			latest = &c
+		}
+	}
+	if latest == nil {
+		return nil, store.ErrNotFound
+	}
+	return latest, nil
+}
+
+func (s *verifyCodeStore) AddAttempt(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	code, ok := s.codes[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	code.Attempts++
+	s.codes[id] = code
+	return nil
+}
+
+func (s *verifyCodeStore) Use(ctx context.Context, id primitive.ObjectID) error {
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	code, ok := s.codes[id]
+	if !ok || code.Used {
+		return store.ErrNotFound
+	}
+	code.Used = true
+	s.codes[id] = code
+	return nil
+}
@@ -0,0 +1,123 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type apiKeyStore struct {
+	base
+}
+
+func (s *apiKeyStore) Get(ctx context.Context, id primitive.ObjectID) (*model.ApiKey, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.ApiKeyTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	key := &model.ApiKey{}
+	if err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(key); err != nil {
+		return nil, convertErr(err)
+	}
+	return key, nil
+}
+
+func (s *apiKeyStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.ApiKey, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.ApiKeyTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createTime", Value: 1}})
+	cursor, err := coll.Find(ctx, bson.M{"userId": userId, "revoked": false}, opts)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	keys := []model.ApiKey{}
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, convertErr(err)
+	}
+	return keys, nil
+}
+
+func (s *apiKeyStore) Insert(ctx context.Context, key *model.ApiKey) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.ApiKeyTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if key.Id.IsZero() {
+		key.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, key)
+	return convertErr(err)
+}
+
+func (s *apiKeyStore) Rotate(ctx context.Context, id, userId primitive.ObjectID, keyHash string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.ApiKeyTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{
+		"_id":     id,
+		"userId":  userId,
+		"revoked": false,
+	}, bson.M{
+		"$set": bson.M{"keyHash": keyHash, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *apiKeyStore) Revoke(ctx context.Context, id, userId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.ApiKeyTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{
+		"_id":    id,
+		"userId": userId,
+	}, bson.M{
+		"$set": bson.M{"revoked": true, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *apiKeyStore) RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.ApiKeyTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.UpdateMany(ctx, bson.M{
+		"userId":  userId,
+		"revoked": false,
+	}, bson.M{
+		"$set": bson.M{"revoked": true, "updateTime": time.Now().Local()},
+	})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.ModifiedCount), nil
+}
+
+func (s *apiKeyStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.ApiKeyTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"lastUsedTime": at},
+	}))
+}
@@ -0,0 +1,60 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type auditStore struct {
+	base
+}
+
+func (s *auditStore) Insert(ctx context.Context, audit *model.Audit) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuditTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if audit.Id.IsZero() {
+		audit.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, audit)
+	return convertErr(err)
+}
+
+func (s *auditStore) SetOutcome(ctx context.Context, id primitive.ObjectID, outcome string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuditTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{"$set": bson.M{"outcome": outcome}}))
+}
+
+func (s *auditStore) Find(ctx context.Context, actorId primitive.ObjectID, limit int) ([]model.Audit, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuditTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	q := bson.M{}
+	if !actorId.IsZero() {
+		q["actorId"] = actorId
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createTime", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := coll.Find(ctx, q, opts)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	audits := []model.Audit{}
+	if err = cursor.All(ctx, &audits); err != nil {
+		return nil, convertErr(err)
+	}
+	return audits, nil
+}
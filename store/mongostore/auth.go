@@ -0,0 +1,211 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+type authStore struct {
+	base
+}
+
+func (s *authStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Auth, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	auth := &model.Auth{}
+	if err = coll.FindOne(ctx, tenant(ctx, bson.M{"_id": id})).Decode(auth); err != nil {
+		return nil, convertErr(err)
+	}
+	return auth, nil
+}
+
+func (s *authStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return s.find(ctx, coll, bson.M{"lockId": lockId})
+}
+
+func (s *authStore) FindByLockAndUser(ctx context.Context, lockId, userId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	q := bson.M{
+		"lockId": lockId,
+		"$or": []bson.M{
+			{"sendId": userId},
+			{"receiverId": userId},
+		},
+	}
+	return s.find(ctx, coll, q)
+}
+
+func (s *authStore) FindByReceiver(ctx context.Context, receiverId primitive.ObjectID, valid bool, perms model.Perms) ([]model.Auth, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	q := bson.M{
+		"receiverId": receiverId,
+	}
+	if valid {
+		q["valid"] = true
+	}
+	if perms.AddCard {
+		q["addCard"] = true
+	}
+	if perms.ShareAuth {
+		q["shareAuth"] = true
+	}
+	if perms.ViewLog {
+		q["viewLog"] = true
+	}
+	return s.find(ctx, coll, q)
+}
+
+func (s *authStore) find(ctx context.Context, coll *driver.Collection, q bson.M) ([]model.Auth, error) {
+	cursor, err := coll.Find(ctx, tenant(ctx, q))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	auths := []model.Auth{}
+	if err = cursor.All(ctx, &auths); err != nil {
+		return nil, convertErr(err)
+	}
+	return auths, nil
+}
+
+func (s *authStore) Insert(ctx context.Context, auth *model.Auth) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if auth.Id.IsZero() {
+		auth.Id = primitive.NewObjectID()
+	}
+	auth.OrgId = store.TenantId(ctx, auth.OrgId)
+	_, err = coll.InsertOne(ctx, auth)
+	return convertErr(err)
+}
+
+func (s *authStore) SetReceiver(ctx context.Context, id, receiverId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上 receiverId 不存在，保证同一个授权只能被领取一次
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{
+		"_id":        id,
+		"receiverId": bson.M{"$exists": false},
+	}), bson.M{
+		"$set": bson.M{"receiverId": receiverId, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *authStore) Invalidate(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{"_id": id}), bson.M{
+		"$set": bson.M{"valid": false, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *authStore) InvalidateByLock(ctx context.Context, lockId primitive.ObjectID) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.UpdateMany(ctx, tenant(ctx, bson.M{
+		"lockId": lockId,
+		"valid":  true,
+	}), bson.M{
+		"$set": bson.M{"valid": false, "updateTime": time.Now().Local()},
+	})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.ModifiedCount), nil
+}
+
+func (s *authStore) Revoke(ctx context.Context, id, sendId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{
+		"_id":    id,
+		"sendId": sendId,
+	}), bson.M{
+		"$set": bson.M{"valid": false, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *authStore) FindBySpace(ctx context.Context, spaceId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return s.find(ctx, coll, bson.M{"spaceIds": spaceId})
+}
+
+func (s *authStore) FindBySender(ctx context.Context, sendId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return s.find(ctx, coll, bson.M{"sendId": sendId})
+}
+
+func (s *authStore) ClaimByPhone(ctx context.Context, phone string, receiverId primitive.ObjectID) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.AuthTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.UpdateMany(ctx, tenant(ctx, bson.M{
+		"phone":      phone,
+		"receiverId": bson.M{"$exists": false},
+		"valid":      true,
+		"sendId":     bson.M{"$ne": receiverId},
+	}), bson.M{
+		"$set": bson.M{"receiverId": receiverId, "updateTime": time.Now().Local()},
+	})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.ModifiedCount), nil
+}
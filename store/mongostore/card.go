@@ -0,0 +1,110 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type cardStore struct {
+	base
+}
+
+func (s *cardStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Card, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.CardTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	card := &model.Card{}
+	if err = coll.FindOne(ctx, tenant(ctx, bson.M{"_id": id})).Decode(card); err != nil {
+		return nil, convertErr(err)
+	}
+	return card, nil
+}
+
+func (s *cardStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Card, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.CardTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, tenant(ctx, bson.M{"lock": lockId}))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	cards := []model.Card{}
+	if err = cursor.All(ctx, &cards); err != nil {
+		return nil, convertErr(err)
+	}
+	return cards, nil
+}
+
+func (s *cardStore) Insert(ctx context.Context, card *model.Card) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.CardTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if card.Id.IsZero() {
+		card.Id = primitive.NewObjectID()
+	}
+	card.OrgId = store.TenantId(ctx, card.OrgId)
+	_, err = coll.InsertOne(ctx, card)
+	return convertErr(err)
+}
+
+func (s *cardStore) UpdateInfo(ctx context.Context, id primitive.ObjectID, name, desc string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.CardTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	updateVal := &struct {
+		Name       string    `bson:"name,omitempty"`
+		Desc       string    `bson:"desc,omitempty"`
+		UpdateTime time.Time `bson:"updateTime"` // 更新时间
+	}{
+		Name:       name,
+		Desc:       desc,
+		UpdateTime: time.Now().Local(),
+	}
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{"_id": id}), bson.M{"$set": updateVal}))
+}
+
+func (s *cardStore) InvalidateByNumber(ctx context.Context, lockId primitive.ObjectID, number string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.CardTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{"lock": lockId, "number": number, "valid": true}), bson.M{
+		"$set": bson.M{"valid": false, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *cardStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Card, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.CardTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, tenant(ctx, bson.M{"userId": userId}))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	cards := []model.Card{}
+	if err = cursor.All(ctx, &cards); err != nil {
+		return nil, convertErr(err)
+	}
+	return cards, nil
+}
@@ -0,0 +1,94 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type deviceStore struct {
+	base
+}
+
+func (s *deviceStore) GetByMac(ctx context.Context, mac string) (*model.Device, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	device := &model.Device{}
+	if err = coll.FindOne(ctx, bson.M{"mac": mac}).Decode(device); err != nil {
+		return nil, convertErr(err)
+	}
+	return device, nil
+}
+
+func (s *deviceStore) Insert(ctx context.Context, device *model.Device) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if device.Id.IsZero() {
+		device.Id = primitive.NewObjectID()
+	}
+	// mac 和序列号有唯一索引，已经导入过的时候返回 ErrDuplicate
+	_, err = coll.InsertOne(ctx, device)
+	return convertErr(err)
+}
+
+func (s *deviceStore) Claim(ctx context.Context, id, lockId, userId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上没有认领，同一台设备并发绑定的时候只有一个能成功
+	now := time.Now().Local()
+	return updateErr(coll.UpdateOne(ctx, bson.M{"_id": id, "lockId": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"lockId": lockId, "claimedBy": userId, "attempts": 0, "claimTime": now, "updateTime": now},
+	}))
+}
+
+func (s *deviceStore) Reclaim(ctx context.Context, id, userId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	now := time.Now().Local()
+	return updateErr(coll.UpdateOne(ctx, bson.M{"_id": id, "lockId": bson.M{"$exists": true}}, bson.M{
+		"$set": bson.M{"claimedBy": userId, "claimTime": now, "updateTime": now},
+	}))
+}
+
+func (s *deviceStore) RecordFailure(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$inc": bson.M{"attempts": 1},
+		"$set": bson.M{"updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *deviceStore) SetClaimCode(ctx context.Context, id primitive.ObjectID, claimCodeHash string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"claimCodeHash": claimCodeHash, "attempts": 0, "updateTime": time.Now().Local()},
+	}))
+}
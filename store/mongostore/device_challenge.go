@@ -0,0 +1,46 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type deviceChallengeStore struct {
+	base
+}
+
+func (s *deviceChallengeStore) Insert(ctx context.Context, challenge *model.DeviceChallenge) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceChallengeTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if challenge.Id.IsZero() {
+		challenge.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, challenge)
+	return convertErr(err)
+}
+
+func (s *deviceChallengeStore) Use(ctx context.Context, id, userId primitive.ObjectID, mac string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.DeviceChallengeTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上 used，同一个应答并发提交的时候只有一个能成功
+	return updateErr(coll.UpdateOne(ctx, bson.M{
+		"_id":        id,
+		"userId":     userId,
+		"mac":        mac,
+		"used":       false,
+		"expireTime": bson.M{"$gt": time.Now().Local()},
+	}, bson.M{
+		"$set": bson.M{"used": true},
+	}))
+}
@@ -0,0 +1,275 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type lockStore struct {
+	base
+}
+
+func (s *lockStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Lock, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	lock := &model.Lock{}
+	if err = coll.FindOne(ctx, tenant(ctx, bson.M{"_id": id})).Decode(lock); err != nil {
+		return nil, convertErr(err)
+	}
+	return lock, nil
+}
+
+func (s *lockStore) GetByMac(ctx context.Context, mac string) (*model.Lock, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	lock := &model.Lock{}
+	if err = coll.FindOne(ctx, tenant(ctx, bson.M{"mac": mac})).Decode(lock); err != nil {
+		return nil, convertErr(err)
+	}
+	return lock, nil
+}
+
+func (s *lockStore) FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Lock, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, tenant(ctx, bson.M{"_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	locks := []model.Lock{}
+	if err = cursor.All(ctx, &locks); err != nil {
+		return nil, convertErr(err)
+	}
+	return locks, nil
+}
+
+func (s *lockStore) FindByOwner(ctx context.Context, own primitive.ObjectID, valid bool) ([]model.Lock, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	q := bson.M{"own": own}
+	if valid {
+		q["valid"] = true
+	}
+	cursor, err := coll.Find(ctx, tenant(ctx, q))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	locks := []model.Lock{}
+	if err = cursor.All(ctx, &locks); err != nil {
+		return nil, convertErr(err)
+	}
+	return locks, nil
+}
+
+func (s *lockStore) FindByOrg(ctx context.Context, orgId primitive.ObjectID, valid bool) ([]model.Lock, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	q := bson.M{"orgId": orgId}
+	if valid {
+		q["valid"] = true
+	}
+	// 别的租户查不到这个组织的门锁
+	if !store.InTenant(ctx, orgId) {
+		return []model.Lock{}, nil
+	}
+	cursor, err := coll.Find(ctx, q)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	locks := []model.Lock{}
+	if err = cursor.All(ctx, &locks); err != nil {
+		return nil, convertErr(err)
+	}
+	return locks, nil
+}
+
+func (s *lockStore) Insert(ctx context.Context, lock *model.Lock) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if lock.Id.IsZero() {
+		lock.Id = primitive.NewObjectID()
+	}
+	lock.OrgId = store.TenantId(ctx, lock.OrgId)
+	_, err = coll.InsertOne(ctx, lock)
+	return convertErr(err)
+}
+
+func (s *lockStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return convertErr(err)
+	}
+	if res.DeletedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *lockStore) Revive(ctx context.Context, lock *model.Lock) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	lock.OrgId = store.TenantId(ctx, lock.OrgId)
+	lock.SpaceId = primitive.NilObjectID
+	lock.Valid = true
+	lock.UpdateTime = time.Now().Local()
+	set := bson.M{
+		"name":       lock.Name,
+		"desc":       lock.Desc,
+		"version":    lock.Version,
+		"own":        lock.Own,
+		"valid":      true,
+		"updateTime": lock.UpdateTime,
+	}
+	unset := bson.M{"spaceId": ""}
+	// 个人的门锁没有 orgId 字段，和插入的时候保持一致
+	if lock.OrgId.IsZero() {
+		unset["orgId"] = ""
+	} else {
+		set["orgId"] = lock.OrgId
+	}
+	return updateErr(coll.UpdateOne(ctx, bson.M{"_id": lock.Id, "valid": false}, bson.M{"$set": set, "$unset": unset}))
+}
+
+func (s *lockStore) UpdateInfo(ctx context.Context, mac string, own primitive.ObjectID, name, desc string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	updateVal := &struct {
+		Name       string    `bson:"name,omitempty"`
+		Desc       string    `bson:"desc,omitempty"`
+		UpdateTime time.Time `bson:"updateTime"` // 更新时间
+	}{
+		Name:       name,
+		Desc:       desc,
+		UpdateTime: time.Now().Local(),
+	}
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{
+		"mac":   mac,
+		"own":   own,
+		"valid": true,
+	}), bson.M{
+		"$set": updateVal,
+	}))
+}
+
+func (s *lockStore) Invalidate(ctx context.Context, mac string, own primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{
+		"mac": mac,
+		"own": own,
+	}), bson.M{
+		"$set": bson.M{"valid": false, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *lockStore) SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{
+		"_id":   id,
+		"own":   own,
+		"valid": true,
+	}), bson.M{
+		"$set":   bson.M{"own": newOwn, "updateTime": time.Now().Local()},
+		"$unset": bson.M{"spaceId": ""},
+	}))
+}
+
+func (s *lockStore) FindBySpaces(ctx context.Context, spaceIds []primitive.ObjectID) ([]model.Lock, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, tenant(ctx, bson.M{"spaceId": bson.M{"$in": spaceIds}}))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	locks := []model.Lock{}
+	if err = cursor.All(ctx, &locks); err != nil {
+		return nil, convertErr(err)
+	}
+	return locks, nil
+}
+
+func (s *lockStore) SetSpace(ctx context.Context, id, own, spaceId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"spaceId": spaceId, "updateTime": time.Now().Local()}}
+	if spaceId.IsZero() {
+		update = bson.M{"$set": bson.M{"updateTime": time.Now().Local()}, "$unset": bson.M{"spaceId": ""}}
+	}
+	return updateErr(coll.UpdateOne(ctx, tenant(ctx, bson.M{"_id": id, "own": own, "valid": true}), update))
+}
+
+func (s *lockStore) ClearSpace(ctx context.Context, spaceId primitive.ObjectID) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LockTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.UpdateMany(ctx, tenant(ctx, bson.M{"spaceId": spaceId}), bson.M{
+		"$set":   bson.M{"updateTime": time.Now().Local()},
+		"$unset": bson.M{"spaceId": ""},
+	})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.ModifiedCount), nil
+}
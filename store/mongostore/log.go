@@ -0,0 +1,85 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type logStore struct {
+	base
+}
+
+func (s *logStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Log, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LogTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, tenant(ctx, bson.M{"lockId": lockId}))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	logs := []model.Log{}
+	if err = cursor.All(ctx, &logs); err != nil {
+		return nil, convertErr(err)
+	}
+	return logs, nil
+}
+
+func (s *logStore) Upsert(ctx context.Context, log *model.Log) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LogTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 原始信息有唯一索引，已经存在的日志属于别的租户的时候插入会返回 ErrDuplicate
+	log.OrgId = store.TenantId(ctx, log.OrgId)
+	q := bson.M{"rawInfo": log.RowInfo}
+	if log.OrgId.IsZero() {
+		q["orgId"] = bson.M{"$exists": false}
+	} else {
+		q["orgId"] = log.OrgId
+	}
+	_, err = coll.ReplaceOne(ctx, q, log, options.Replace().SetUpsert(true))
+	return convertErr(err)
+}
+
+func (s *logStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Log, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LogTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, tenant(ctx, bson.M{"userId": userId}))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	logs := []model.Log{}
+	if err = cursor.All(ctx, &logs); err != nil {
+		return nil, convertErr(err)
+	}
+	return logs, nil
+}
+
+func (s *logStore) AnonymizeUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LogTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.UpdateMany(ctx, tenant(ctx, bson.M{"userId": userId}), bson.M{
+		"$set": bson.M{"userId": primitive.NilObjectID},
+	})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.ModifiedCount), nil
+}
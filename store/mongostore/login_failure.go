@@ -0,0 +1,76 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type loginFailureStore struct {
+	base
+}
+
+func (s *loginFailureStore) Get(ctx context.Context, key string) (*model.LoginFailure, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LoginFailureTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	failure := &model.LoginFailure{}
+	if err = coll.FindOne(ctx, bson.M{"_id": key}).Decode(failure); err != nil {
+		return nil, convertErr(err)
+	}
+	return failure, nil
+}
+
+func (s *loginFailureStore) AddFailure(ctx context.Context, key string, now, expireTime time.Time) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.LoginFailureTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	// 上一次输错已经过期的先清零，再原子的加一，并发输错的时候不会少算
+	_, err = coll.UpdateOne(ctx, bson.M{"_id": key, "expireTime": bson.M{"$lte": now}}, bson.M{
+		"$set": bson.M{"failures": 0},
+	})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	failure := &model.LoginFailure{}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"expireTime": expireTime},
+	}, opts).Decode(failure)
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return failure.Failures, nil
+}
+
+func (s *loginFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LoginFailureTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{"_id": key}, bson.M{
+		"$set": bson.M{"failures": 0, "lockedUntil": until},
+	}))
+}
+
+func (s *loginFailureStore) Reset(ctx context.Context, key string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.LoginFailureTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = coll.DeleteOne(ctx, bson.M{"_id": key})
+	return convertErr(err)
+}
@@ -0,0 +1,113 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type memberStore struct {
+	base
+}
+
+func (s *memberStore) Get(ctx context.Context, lockId, userId primitive.ObjectID) (*model.Member, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.MemberTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	member := &model.Member{}
+	if err = coll.FindOne(ctx, bson.M{"lockId": lockId, "userId": userId}).Decode(member); err != nil {
+		return nil, convertErr(err)
+	}
+	return member, nil
+}
+
+func (s *memberStore) find(ctx context.Context, filter bson.M) ([]model.Member, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.MemberTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createTime", Value: 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	members := []model.Member{}
+	if err = cursor.All(ctx, &members); err != nil {
+		return nil, convertErr(err)
+	}
+	return members, nil
+}
+
+func (s *memberStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Member, error) {
+	return s.find(ctx, bson.M{"lockId": lockId})
+}
+
+func (s *memberStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Member, error) {
+	return s.find(ctx, bson.M{"userId": userId})
+}
+
+func (s *memberStore) Insert(ctx context.Context, member *model.Member) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.MemberTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if member.Id.IsZero() {
+		member.Id = primitive.NewObjectID()
+	}
+	// lockId 和 userId 有唯一索引，已经是成员的时候返回 ErrDuplicate
+	_, err = coll.InsertOne(ctx, member)
+	return convertErr(err)
+}
+
+func (s *memberStore) SetRole(ctx context.Context, lockId, userId primitive.ObjectID, role string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.MemberTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{"lockId": lockId, "userId": userId}, bson.M{
+		"$set": bson.M{"role": role, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *memberStore) Delete(ctx context.Context, lockId, userId primitive.ObjectID) error {
+	count, err := s.delete(ctx, bson.M{"lockId": lockId, "userId": userId})
+	if err == nil && count == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *memberStore) DeleteByLock(ctx context.Context, lockId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, bson.M{"lockId": lockId})
+}
+
+func (s *memberStore) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, bson.M{"userId": userId})
+}
+
+func (s *memberStore) delete(ctx context.Context, filter bson.M) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.MemberTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.DeletedCount), nil
+}
@@ -0,0 +1,373 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"ezlock/common/migrate"
+	"ezlock/common/mongo"
+	"ezlock/model"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// 迁移记录表名称
+var MigrationTableName = "Migration"
+
+// 迁移记录的表结构，版本号作为 _id
+type migrationRecord struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// mongo 的迁移列表，新的迁移只能追加在最后，已经发布的不能修改
+func Migrator(db *mongo.DB) *migrate.Migrator {
+	m := migrator{db}
+	return migrate.New(m, []migrate.Migration{
+		m.indexes(1, "create_user_indexes", model.UserTableName,
+			// 建立name索引, 方便查询
+			index("Index_NickName", "nickName", 1, false),
+			index("Index_OpenId", "openId", 1, true),
+			// 建立创建时间的 倒叙 索引
+			index("Index_CreateTime", "createTime", -1, false),
+		),
+		m.indexes(2, "create_lock_indexes", model.LockTableName,
+			index("Index_Name", "name", 1, false),
+			index("Index_Mac", "mac", 1, true),
+			index("Index_Own", "own", 1, false),
+			index("Index_CreateTime", "createTime", -1, false),
+		),
+		{
+			// 原来 Card 和 Log 的索引错误的建在了 Lock 表上，Index_Lock 还是唯一索引，导致只能绑定一把锁
+			// 错误的索引不需要恢复，回滚什么都不做
+			Version: 3,
+			Name:    "drop_misplaced_lock_indexes",
+			Up:      m.dropIndexes(model.LockTableName, "Index_Lock", "Index_OpenType"),
+			Down:    func(ctx context.Context) error { return nil },
+		},
+		m.indexes(4, "create_card_indexes", model.CardTableName,
+			index("Index_Name", "name", 1, false),
+			// 门卡删除后卡号还会保留，所以不能是唯一索引
+			compoundIndex("Index_Lock_Number", "lock", "number"),
+			index("Index_CreateTime", "createTime", -1, false),
+		),
+		m.indexes(5, "create_log_indexes", model.LogTableName,
+			index("Index_LockId", "lockId", 1, false),
+			// 硬件会重复上传日志，按照原始信息去重
+			index("Index_RawInfo", "rawInfo", 1, true),
+			index("Index_OpenType", "openType", 1, false),
+			index("Index_CreateTime", "createTime", -1, false),
+		),
+		m.indexes(6, "create_auth_indexes", model.AuthTableName,
+			index("Index_LockId", "lockId", 1, false),
+			index("Index_SendId", "sendId", 1, false),
+			index("Index_ReceiverId", "receiverId", 1, false),
+		),
+		{
+			// 原来获取手机号写的是 phone 字段，而 model 里是 phoneNumber
+			Version: 7,
+			Name:    "rename_user_phone",
+			Up:      m.renameUserPhone,
+		},
+		{
+			// 原来领取授权的时候整个文档被替换成了只有 receiverId，这些授权已经没有门锁信息，只能设置为无效
+			Version: 8,
+			Name:    "invalidate_broken_auths",
+			Up:      m.invalidateBrokenAuths,
+		},
+		m.indexes(9, "create_session_indexes", model.SessionTableName,
+			index("Index_UserId", "userId", 1, false),
+		),
+		{
+			// 短信和密码登录的用户没有 openId，openId 的唯一索引只约束不为空的值
+			Version: 10,
+			Name:    "create_user_identity_indexes",
+			Up:      m.createUserIdentityIndexes,
+			Down:    m.dropUserIdentityIndexes,
+			Check:   m.checkIndexes(model.UserTableName, "Index_OpenId", "Index_UserName", "Index_PhoneNumber"),
+		},
+		m.indexes(11, "create_verify_code_indexes", model.VerifyCodeTableName,
+			compoundIndex("Index_Phone_CreateTime", "phone", "createTime"),
+		),
+		m.indexes(12, "create_audit_indexes", model.AuditTableName,
+			compoundIndex("Index_ActorId_CreateTime", "actorId", "createTime"),
+			index("Index_CreateTime", "createTime", -1, false),
+		),
+		m.indexes(13, "create_api_key_indexes", model.ApiKeyTableName,
+			index("Index_UserId", "userId", 1, false),
+		),
+		// 导出和注销用户数据的时候按用户查询门卡和日志
+		m.indexes(14, "create_card_user_indexes", model.CardTableName,
+			index("Index_UserId", "userId", 1, false),
+		),
+		m.indexes(15, "create_log_user_indexes", model.LogTableName,
+			index("Index_UserId", "userId", 1, false),
+		),
+		m.indexes(16, "create_auth_phone_indexes", model.AuthTableName,
+			index("Index_Phone", "phone", 1, false),
+		),
+		m.indexes(17, "create_transfer_indexes", model.TransferTableName,
+			index("Index_LockId", "lockId", 1, false),
+			index("Index_FromId", "fromId", 1, false),
+			index("Index_ToId", "toId", 1, false),
+			index("Index_ToPhone", "toPhone", 1, false),
+		),
+		m.indexes(18, "create_member_indexes", model.MemberTableName,
+			uniqueCompoundIndex("Index_LockId_UserId", "lockId", "userId"),
+			index("Index_UserId", "userId", 1, false),
+		),
+		// 按子树查询空间，按空间查询门锁和授权
+		m.indexes(19, "create_space_indexes", model.SpaceTableName,
+			index("Index_Own", "own", 1, false),
+			index("Index_Ancestors", "ancestors", 1, false),
+		),
+		m.indexes(20, "create_lock_space_indexes", model.LockTableName,
+			index("Index_SpaceId", "spaceId", 1, false),
+		),
+		m.indexes(21, "create_auth_space_indexes", model.AuthTableName,
+			index("Index_SpaceIds", "spaceIds", 1, false),
+		),
+		m.indexes(22, "create_org_member_indexes", model.OrgMemberTableName,
+			uniqueCompoundIndex("Index_OrgId_UserId", "orgId", "userId"),
+			index("Index_UserId", "userId", 1, false),
+		),
+		// 门锁、门卡、授权和日志的查询都会带上组织
+		m.indexes(23, "create_lock_org_indexes", model.LockTableName,
+			index("Index_OrgId", "orgId", 1, false),
+		),
+		m.indexes(24, "create_card_org_indexes", model.CardTableName,
+			index("Index_OrgId", "orgId", 1, false),
+		),
+		m.indexes(25, "create_auth_org_indexes", model.AuthTableName,
+			index("Index_OrgId", "orgId", 1, false),
+		),
+		m.indexes(26, "create_log_org_indexes", model.LogTableName,
+			index("Index_OrgId", "orgId", 1, false),
+		),
+		m.indexes(27, "create_device_indexes", model.DeviceTableName,
+			index("Index_Mac", "mac", 1, true),
+			index("Index_Serial", "serial", 1, true),
+		),
+	})
+}
+
+type migrator struct {
+	db *mongo.DB
+}
+
+// 建立单个字段的索引，order 1 为正序，-1 为倒序
+func index(name, key string, order int, unique bool) driver.IndexModel {
+	return driver.IndexModel{
+		Keys:    bson.D{{Key: key, Value: order}},
+		Options: options.Index().SetName(name).SetUnique(unique),
+	}
+}
+
+// 建立只约束不为空字符串的唯一索引
+func nonEmptyUniqueIndex(name, key string) driver.IndexModel {
+	return driver.IndexModel{
+		Keys: bson.D{{Key: key, Value: 1}},
+		Options: options.Index().SetName(name).SetUnique(true).
+			SetPartialFilterExpression(bson.M{key: bson.M{"$gt": ""}}),
+	}
+}
+
+// 建立多个字段的正序索引
+func compoundIndex(name string, keys ...string) driver.IndexModel {
+	doc := bson.D{}
+	for _, key := range keys {
+		doc = append(doc, bson.E{Key: key, Value: 1})
+	}
+	return driver.IndexModel{
+		Keys:    doc,
+		Options: options.Index().SetName(name),
+	}
+}
+
+// 建立多个字段的正序唯一索引
+func uniqueCompoundIndex(name string, keys ...string) driver.IndexModel {
+	index := compoundIndex(name, keys...)
+	index.Options.SetUnique(true)
+	return index
+}
+
+// 建立索引的迁移，回滚的时候删除这些索引
+func (m migrator) indexes(version int, name, table string, indexes ...driver.IndexModel) migrate.Migration {
+	names := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		names = append(names, *index.Options.Name)
+	}
+	return migrate.Migration{
+		Version: version,
+		Name:    name,
+		Up: func(ctx context.Context) error {
+			database, err := m.db.Database()
+			if err != nil {
+				return err
+			}
+			_, err = database.Collection(table).Indexes().CreateMany(ctx, indexes)
+			return err
+		},
+		Down:  m.dropIndexes(table, names...),
+		Check: m.checkIndexes(table, names...),
+	}
+}
+
+// 检查索引是否存在
+func (m migrator) checkIndexes(table string, names ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		database, err := m.db.Database()
+		if err != nil {
+			return err
+		}
+		specs, err := database.Collection(table).Indexes().ListSpecifications(ctx)
+		if err != nil {
+			return err
+		}
+		exists := make(map[string]bool, len(specs))
+		for _, spec := range specs {
+			exists[spec.Name] = true
+		}
+		for _, name := range names {
+			if !exists[name] {
+				return fmt.Errorf("index %s.%s is missing", table, name)
+			}
+		}
+		return nil
+	}
+}
+
+// 删除索引，索引或者表不存在的时候忽略
+func (m migrator) dropIndexes(table string, names ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		database, err := m.db.Database()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			_, err := database.Collection(table).Indexes().DropOne(ctx, name)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// IndexNotFound 和 NamespaceNotFound
+func isNotFound(err error) bool {
+	var cmdErr driver.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 26 || cmdErr.Code == 27
+	}
+	return false
+}
+
+func (m migrator) renameUserPhone(ctx context.Context) error {
+	database, err := m.db.Database()
+	if err != nil {
+		return err
+	}
+	coll := database.Collection(model.UserTableName)
+	// phoneNumber 为空的直接改名
+	_, err = coll.UpdateMany(ctx, bson.M{
+		"phone": bson.M{"$exists": true},
+		"$or": bson.A{
+			bson.M{"phoneNumber": bson.M{"$exists": false}},
+			bson.M{"phoneNumber": ""},
+		},
+	}, bson.M{"$rename": bson.M{"phone": "phoneNumber"}})
+	if err != nil {
+		return err
+	}
+	// phoneNumber 已经有值的以 phoneNumber 为准
+	_, err = coll.UpdateMany(ctx, bson.M{"phone": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"phone": ""}})
+	return err
+}
+
+func (m migrator) invalidateBrokenAuths(ctx context.Context) error {
+	database, err := m.db.Database()
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection(model.AuthTableName).UpdateMany(ctx, bson.M{
+		"lockId": bson.M{"$exists": false},
+	}, bson.M{
+		"$set": bson.M{"valid": false, "updateTime": time.Now().Local()},
+	})
+	return err
+}
+
+func (m migrator) createUserIdentityIndexes(ctx context.Context) error {
+	if err := m.dropIndexes(model.UserTableName, "Index_OpenId")(ctx); err != nil {
+		return err
+	}
+	database, err := m.db.Database()
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection(model.UserTableName).Indexes().CreateMany(ctx, []driver.IndexModel{
+		nonEmptyUniqueIndex("Index_OpenId", "openId"),
+		nonEmptyUniqueIndex("Index_UserName", "userName"),
+		index("Index_PhoneNumber", "phoneNumber", 1, false),
+	})
+	return err
+}
+
+// 恢复原来的 openId 唯一索引，已经有多个没有 openId 的用户的时候会失败
+func (m migrator) dropUserIdentityIndexes(ctx context.Context) error {
+	if err := m.dropIndexes(model.UserTableName, "Index_PhoneNumber", "Index_UserName", "Index_OpenId")(ctx); err != nil {
+		return err
+	}
+	database, err := m.db.Database()
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection(model.UserTableName).Indexes().CreateOne(ctx, index("Index_OpenId", "openId", 1, true))
+	return err
+}
+
+func (m migrator) Applied(ctx context.Context) (map[int]time.Time, error) {
+	database, err := m.db.Database()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := database.Collection(MigrationTableName).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	records := []migrationRecord{}
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, convertErr(err)
+	}
+	applied := make(map[int]time.Time, len(records))
+	for _, record := range records {
+		applied[record.Version] = record.AppliedAt
+	}
+	return applied, nil
+}
+
+func (m migrator) Record(ctx context.Context, migration migrate.Migration) error {
+	database, err := m.db.Database()
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection(MigrationTableName).InsertOne(ctx, migrationRecord{
+		Version:   migration.Version,
+		Name:      migration.Name,
+		AppliedAt: time.Now().Local(),
+	})
+	return convertErr(err)
+}
+
+func (m migrator) Remove(ctx context.Context, migration migrate.Migration) error {
+	database, err := m.db.Database()
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection(MigrationTableName).DeleteOne(ctx, bson.M{"_id": migration.Version})
+	return convertErr(err)
+}
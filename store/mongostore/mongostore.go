@@ -0,0 +1,87 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"ezlock/common/mongo"
+	"ezlock/store"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// 创建 mongo 实现的 Store，db 还没有连接上的时候所有操作都返回 mongo.ErrNotConnected
+func New(db *mongo.DB) *store.Store {
+	b := base{db}
+	return &store.Store{
+		Users:         &userStore{b},
+		Locks:         &lockStore{b},
+		Auths:         &authStore{b},
+		Cards:         &cardStore{b},
+		Logs:          &logStore{b},
+		Sessions:      &sessionStore{b},
+		VerifyCodes:   &verifyCodeStore{b},
+		LoginFailures: &loginFailureStore{b},
+		Audits:        &auditStore{b},
+		ApiKeys:       &apiKeyStore{b},
+		Transfers:     &transferStore{b},
+		Members:       &memberStore{b},
+		Spaces:        &spaceStore{b},
+		Orgs:          &orgStore{b},
+		OrgMembers:    &orgMemberStore{b},
+		Devices:       &deviceStore{b},
+		Challenges:    &deviceChallengeStore{b},
+	}
+}
+
+type base struct {
+	db *mongo.DB
+}
+
+// 连接到对应的表，返回的 ctx 带上了单次操作的超时时间，用完需要调用 cancel
+func (b base) collection(ctx context.Context, name string) (context.Context, context.CancelFunc, *driver.Collection, error) {
+	return b.db.Collection(ctx, name)
+}
+
+// 把 mongo 的错误转换为 store 的错误
+func convertErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == driver.ErrNoDocuments {
+		return store.ErrNotFound
+	}
+	if driver.IsDuplicateKeyError(err) {
+		return store.ErrDuplicate
+	}
+	if driver.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s", store.ErrTimeout, err.Error())
+	}
+	return err
+}
+
+// 更新操作没有匹配到任何记录的时候返回 store.ErrNotFound
+func updateErr(res *driver.UpdateResult, err error) error {
+	if err != nil {
+		return convertErr(err)
+	}
+	if res.MatchedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// 在查询条件里加上 ctx 里的租户，个人数据没有 orgId 字段，不按租户过滤的时候原样返回
+// 门锁、门卡、授权和日志表的所有操作都要经过这里
+func tenant(ctx context.Context, q bson.M) bson.M {
+	orgId, all := store.TenantOf(ctx)
+	if all {
+		return q
+	}
+	if orgId.IsZero() {
+		q["orgId"] = bson.M{"$exists": false}
+	} else {
+		q["orgId"] = orgId
+	}
+	return q
+}
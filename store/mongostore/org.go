@@ -0,0 +1,171 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type orgStore struct {
+	base
+}
+
+func (s *orgStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Organization, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrganizationTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	org := &model.Organization{}
+	if err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(org); err != nil {
+		return nil, convertErr(err)
+	}
+	return org, nil
+}
+
+func (s *orgStore) FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Organization, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrganizationTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	orgs := []model.Organization{}
+	if err = cursor.All(ctx, &orgs); err != nil {
+		return nil, convertErr(err)
+	}
+	return orgs, nil
+}
+
+func (s *orgStore) Insert(ctx context.Context, org *model.Organization) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrganizationTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if org.Id.IsZero() {
+		org.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, org)
+	return convertErr(err)
+}
+
+func (s *orgStore) SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrganizationTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{"_id": id, "ownerId": own}, bson.M{
+		"$set": bson.M{"ownerId": newOwn, "updateTime": time.Now().Local()},
+	}))
+}
+
+type orgMemberStore struct {
+	base
+}
+
+func (s *orgMemberStore) Get(ctx context.Context, orgId, userId primitive.ObjectID) (*model.OrgMember, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrgMemberTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	member := &model.OrgMember{}
+	if err = coll.FindOne(ctx, bson.M{"orgId": orgId, "userId": userId}).Decode(member); err != nil {
+		return nil, convertErr(err)
+	}
+	return member, nil
+}
+
+func (s *orgMemberStore) find(ctx context.Context, filter bson.M) ([]model.OrgMember, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrgMemberTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createTime", Value: 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	members := []model.OrgMember{}
+	if err = cursor.All(ctx, &members); err != nil {
+		return nil, convertErr(err)
+	}
+	return members, nil
+}
+
+func (s *orgMemberStore) FindByOrg(ctx context.Context, orgId primitive.ObjectID) ([]model.OrgMember, error) {
+	return s.find(ctx, bson.M{"orgId": orgId})
+}
+
+func (s *orgMemberStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.OrgMember, error) {
+	return s.find(ctx, bson.M{"userId": userId})
+}
+
+func (s *orgMemberStore) Insert(ctx context.Context, member *model.OrgMember) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrgMemberTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if member.Id.IsZero() {
+		member.Id = primitive.NewObjectID()
+	}
+	// orgId 和 userId 有唯一索引，已经是成员的时候返回 ErrDuplicate
+	_, err = coll.InsertOne(ctx, member)
+	return convertErr(err)
+}
+
+func (s *orgMemberStore) SetRole(ctx context.Context, orgId, userId primitive.ObjectID, role string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrgMemberTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{"orgId": orgId, "userId": userId}, bson.M{
+		"$set": bson.M{"role": role, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *orgMemberStore) Delete(ctx context.Context, orgId, userId primitive.ObjectID) error {
+	count, err := s.delete(ctx, bson.M{"orgId": orgId, "userId": userId})
+	if err == nil && count == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *orgMemberStore) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, bson.M{"userId": userId})
+}
+
+func (s *orgMemberStore) delete(ctx context.Context, filter bson.M) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.OrgMemberTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.DeletedCount), nil
+}
@@ -0,0 +1,126 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type sessionStore struct {
+	base
+}
+
+func (s *sessionStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Session, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.SessionTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	session := &model.Session{}
+	if err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(session); err != nil {
+		return nil, convertErr(err)
+	}
+	return session, nil
+}
+
+func (s *sessionStore) Insert(ctx context.Context, session *model.Session) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.SessionTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if session.Id.IsZero() {
+		session.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, session)
+	return convertErr(err)
+}
+
+func (s *sessionStore) Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expireTime time.Time) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.SessionTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上旧的哈希，同一个刷新 token 并发使用的时候只有一个能成功
+	return updateErr(coll.UpdateOne(ctx, bson.M{
+		"_id":         id,
+		"refreshHash": oldHash,
+		"revoked":     false,
+	}, bson.M{
+		"$set": bson.M{"refreshHash": newHash, "expireTime": expireTime, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *sessionStore) FindActiveByUser(ctx context.Context, userId primitive.ObjectID, now time.Time) ([]model.Session, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.SessionTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, bson.M{
+		"userId":     userId,
+		"revoked":    false,
+		"expireTime": bson.M{"$gt": now},
+	})
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	sessions := []model.Session{}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, convertErr(err)
+	}
+	return sessions, nil
+}
+
+func (s *sessionStore) Touch(ctx context.Context, id primitive.ObjectID, ip string, at time.Time) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.SessionTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"ip": ip, "lastUsedTime": at},
+	}))
+}
+
+func (s *sessionStore) Revoke(ctx context.Context, id, userId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.SessionTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{
+		"_id":    id,
+		"userId": userId,
+	}, bson.M{
+		"$set": bson.M{"revoked": true, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *sessionStore) RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.SessionTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.UpdateMany(ctx, bson.M{
+		"userId":  userId,
+		"revoked": false,
+	}, bson.M{
+		"$set": bson.M{"revoked": true, "updateTime": time.Now().Local()},
+	})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.ModifiedCount), nil
+}
@@ -0,0 +1,122 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type spaceStore struct {
+	base
+}
+
+func (s *spaceStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Space, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.SpaceTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	space := &model.Space{}
+	if err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(space); err != nil {
+		return nil, convertErr(err)
+	}
+	return space, nil
+}
+
+func (s *spaceStore) find(ctx context.Context, filter bson.M) ([]model.Space, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.SpaceTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createTime", Value: 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	spaces := []model.Space{}
+	if err = cursor.All(ctx, &spaces); err != nil {
+		return nil, convertErr(err)
+	}
+	return spaces, nil
+}
+
+func (s *spaceStore) FindByOwner(ctx context.Context, own primitive.ObjectID) ([]model.Space, error) {
+	return s.find(ctx, bson.M{"own": own})
+}
+
+func (s *spaceStore) FindSubtree(ctx context.Context, ids []primitive.ObjectID) ([]model.Space, error) {
+	return s.find(ctx, bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"ancestors": bson.M{"$in": ids}},
+	}})
+}
+
+func (s *spaceStore) Insert(ctx context.Context, space *model.Space) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.SpaceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if space.Id.IsZero() {
+		space.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, space)
+	return convertErr(err)
+}
+
+func (s *spaceStore) UpdateInfo(ctx context.Context, id, own primitive.ObjectID, name, desc string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.SpaceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 空值不修改
+	set := bson.M{"updateTime": time.Now().Local()}
+	if len(name) != 0 {
+		set["name"] = name
+	}
+	if len(desc) != 0 {
+		set["desc"] = desc
+	}
+	return updateErr(coll.UpdateOne(ctx, bson.M{"_id": id, "own": own}, bson.M{"$set": set}))
+}
+
+func (s *spaceStore) Delete(ctx context.Context, id, own primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.SpaceTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": id, "own": own})
+	if err != nil {
+		return convertErr(err)
+	}
+	if res.DeletedCount == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *spaceStore) DeleteByOwner(ctx context.Context, own primitive.ObjectID) (int, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.SpaceTableName)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	res, err := coll.DeleteMany(ctx, bson.M{"own": own})
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	return int(res.DeletedCount), nil
+}
@@ -0,0 +1,109 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type transferStore struct {
+	base
+}
+
+func (s *transferStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Transfer, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.TransferTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	transfer := &model.Transfer{}
+	if err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(transfer); err != nil {
+		return nil, convertErr(err)
+	}
+	return transfer, nil
+}
+
+func (s *transferStore) find(ctx context.Context, filter bson.M) ([]model.Transfer, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.TransferTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createTime", Value: -1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	transfers := []model.Transfer{}
+	if err = cursor.All(ctx, &transfers); err != nil {
+		return nil, convertErr(err)
+	}
+	return transfers, nil
+}
+
+func (s *transferStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Transfer, error) {
+	return s.find(ctx, bson.M{"lockId": lockId})
+}
+
+func (s *transferStore) FindByUser(ctx context.Context, userId primitive.ObjectID, phone string) ([]model.Transfer, error) {
+	or := bson.A{bson.M{"fromId": userId}, bson.M{"toId": userId}}
+	if phone != "" {
+		or = append(or, bson.M{"toPhone": phone})
+	}
+	return s.find(ctx, bson.M{"$or": or})
+}
+
+func (s *transferStore) Insert(ctx context.Context, transfer *model.Transfer) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.TransferTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if transfer.Id.IsZero() {
+		transfer.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, transfer)
+	return convertErr(err)
+}
+
+func (s *transferStore) Accept(ctx context.Context, id, toId primitive.ObjectID, authsRevoked int, wipedCards []string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.TransferTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{
+		"_id":    id,
+		"status": model.TransferPending,
+	}, bson.M{
+		"$set": bson.M{
+			"status":       model.TransferAccepted,
+			"toId":         toId,
+			"authsRevoked": authsRevoked,
+			"wipedCards":   wipedCards,
+			"updateTime":   time.Now().Local(),
+		},
+	}))
+}
+
+func (s *transferStore) Close(ctx context.Context, id primitive.ObjectID, status string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.TransferTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateOne(ctx, bson.M{
+		"_id":    id,
+		"status": model.TransferPending,
+	}, bson.M{
+		"$set": bson.M{"status": status, "updateTime": time.Now().Local()},
+	}))
+}
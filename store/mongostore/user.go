@@ -0,0 +1,216 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type userStore struct {
+	base
+}
+
+func (s *userStore) Get(ctx context.Context, id primitive.ObjectID) (*model.User, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	user := &model.User{}
+	if err = coll.FindOne(ctx, bson.M{"_id": id}).Decode(user); err != nil {
+		return nil, convertErr(err)
+	}
+	return user, nil
+}
+
+func (s *userStore) GetByOpenId(ctx context.Context, openId string) (*model.User, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	user := &model.User{}
+	if err = coll.FindOne(ctx, bson.M{"openId": openId}).Decode(user); err != nil {
+		return nil, convertErr(err)
+	}
+	return user, nil
+}
+
+func (s *userStore) GetByPhone(ctx context.Context, phoneNumber string) (*model.User, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	user := &model.User{}
+	opts := options.FindOne().SetSort(bson.D{{Key: "createTime", Value: 1}})
+	if err = coll.FindOne(ctx, bson.M{"phoneNumber": phoneNumber}, opts).Decode(user); err != nil {
+		return nil, convertErr(err)
+	}
+	return user, nil
+}
+
+func (s *userStore) GetByUserName(ctx context.Context, userName string) (*model.User, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	user := &model.User{}
+	if err = coll.FindOne(ctx, bson.M{"userName": userName}).Decode(user); err != nil {
+		return nil, convertErr(err)
+	}
+	return user, nil
+}
+
+func (s *userStore) Insert(ctx context.Context, user *model.User) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if user.Id.IsZero() {
+		user.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, user)
+	return convertErr(err)
+}
+
+func (s *userStore) UpdateProfile(ctx context.Context, user *model.User) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	updateVal := bson.M{
+		"sessionKey": user.SessionKey,
+		"nickName":   user.NickName,
+		"unionId":    user.UnionId,
+		"gender":     user.Gender,
+		"province":   user.Province,
+		"city":       user.City,
+		"country":    user.Country,
+		"avatarUrl":  user.AvatarUrl,
+		"language":   user.Language,
+		"updateTime": time.Now().Local(),
+	}
+	return updateErr(coll.UpdateByID(ctx, user.Id, bson.M{"$set": updateVal}))
+}
+
+func (s *userStore) SetPhoneNumber(ctx context.Context, id primitive.ObjectID, phoneNumber string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"phoneNumber": phoneNumber, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *userStore) SetDefaultLock(ctx context.Context, id, lockId primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"defaultLock": lockId, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *userStore) SetPassword(ctx context.Context, id primitive.ObjectID, userName, passwordHash string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// userName 有唯一索引，被其他用户使用的时候返回 ErrDuplicate
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"userName": userName, "passwordHash": passwordHash, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *userStore) FindByPhone(ctx context.Context, phoneNumber string) ([]model.User, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, bson.M{"phoneNumber": phoneNumber})
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	users := []model.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, convertErr(err)
+	}
+	return users, nil
+}
+
+func (s *userStore) SetRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"role": role, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *userStore) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"disabled": disabled, "updateTime": time.Now().Local()},
+	}))
+}
+
+func (s *userStore) Anonymize(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.UserTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// openId 和 userName 是部分唯一索引，清空后不再占用
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{
+			"nickName":     model.DeletedNickName,
+			"openId":       "",
+			"unionId":      "",
+			"sessionKey":   "",
+			"phoneNumber":  "",
+			"userName":     "",
+			"passwordHash": "",
+			"gender":       0,
+			"city":         "",
+			"province":     "",
+			"country":      "",
+			"avatarUrl":    "",
+			"language":     "",
+			"disabled":     true,
+			"updateTime":   time.Now().Local(),
+		},
+		"$unset": bson.M{"defaultLock": ""},
+	}))
+}
@@ -0,0 +1,65 @@
+package mongostore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type verifyCodeStore struct {
+	base
+}
+
+func (s *verifyCodeStore) Insert(ctx context.Context, code *model.VerifyCode) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.VerifyCodeTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if code.Id.IsZero() {
+		code.Id = primitive.NewObjectID()
+	}
+	_, err = coll.InsertOne(ctx, code)
+	return convertErr(err)
+}
+
+func (s *verifyCodeStore) Latest(ctx context.Context, phone string) (*model.VerifyCode, error) {
+	ctx, cancel, coll, err := s.collection(ctx, model.VerifyCodeTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	code := &model.VerifyCode{}
+	opts := options.FindOne().SetSort(bson.D{{Key: "createTime", Value: -1}})
+	if err = coll.FindOne(ctx, bson.M{"phone": phone}, opts).Decode(code); err != nil {
+		return nil, convertErr(err)
+	}
+	return code, nil
+}
+
+func (s *verifyCodeStore) AddAttempt(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.VerifyCodeTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateErr(coll.UpdateByID(ctx, id, bson.M{"$inc": bson.M{"attempts": 1}}))
+}
+
+func (s *verifyCodeStore) Use(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, coll, err := s.collection(ctx, model.VerifyCodeTableName)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上 used，同一个验证码并发登录的时候只有一个能成功
+	return updateErr(coll.UpdateOne(ctx, bson.M{"_id": id, "used": false}, bson.M{
+		"$set": bson.M{"used": true},
+	}))
+}
@@ -0,0 +1,151 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const apiKeyColumns = `id, user_id, name, key_hash, scopes, lock_ids, revoked, last_used_time, update_time, create_time`
+
+type apiKeyStore struct {
+	base
+}
+
+// 权限范围和门锁 id 都用逗号分隔保存在一个字段里
+func joinIds(ids []primitive.ObjectID) string {
+	hexes := make([]string, 0, len(ids))
+	for _, id := range ids {
+		hexes = append(hexes, id.Hex())
+	}
+	return strings.Join(hexes, ",")
+}
+
+func splitIds(s string) []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, hex := range splitList(s) {
+		ids = append(ids, parseId(sql.NullString{String: hex, Valid: true}))
+	}
+	return ids
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func scanApiKey(row scanner) (*model.ApiKey, error) {
+	key := &model.ApiKey{}
+	var id, userId, scopes, lockIds string
+	var lastUsedTime sql.NullTime
+	err := row.Scan(&id, &userId, &key.Name, &key.KeyHash, &scopes, &lockIds, &key.Revoked,
+		&lastUsedTime, &key.UpdateTime, &key.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	key.Id = parseId(sql.NullString{String: id, Valid: true})
+	key.UserId = parseId(sql.NullString{String: userId, Valid: true})
+	key.Scopes = splitList(scopes)
+	key.LockIds = splitIds(lockIds)
+	key.LastUsedTime = lastUsedTime.Time
+	return key, nil
+}
+
+func (s *apiKeyStore) Get(ctx context.Context, id primitive.ObjectID) (*model.ApiKey, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanApiKey(s.queryRow(ctx, conn, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id.Hex()))
+}
+
+func (s *apiKeyStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.ApiKey, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.query(ctx, conn, `SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE user_id = ? AND revoked = FALSE ORDER BY create_time`, userId.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []model.ApiKey{}
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, convertErr(rows.Err())
+}
+
+func (s *apiKeyStore) Insert(ctx context.Context, key *model.ApiKey) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if key.Id.IsZero() {
+		key.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO api_keys (`+apiKeyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.Id.Hex(), key.UserId.Hex(), key.Name, key.KeyHash, strings.Join(key.Scopes, ","), joinIds(key.LockIds),
+		key.Revoked, nullTime(key.LastUsedTime), key.UpdateTime, key.CreateTime)
+}
+
+func (s *apiKeyStore) Rotate(ctx context.Context, id, userId primitive.ObjectID, keyHash string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE api_keys SET key_hash = ?, update_time = ?
+		WHERE id = ? AND user_id = ? AND revoked = FALSE`,
+		keyHash, time.Now().Local(), id.Hex(), userId.Hex())
+}
+
+func (s *apiKeyStore) Revoke(ctx context.Context, id, userId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE api_keys SET revoked = TRUE, update_time = ? WHERE id = ? AND user_id = ?`,
+		time.Now().Local(), id.Hex(), userId.Hex())
+}
+
+func (s *apiKeyStore) RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	affected, err := s.exec(ctx, conn, "update", `UPDATE api_keys SET revoked = TRUE, update_time = ? WHERE user_id = ? AND revoked = FALSE`,
+		time.Now().Local(), userId.Hex())
+	return int(affected), err
+}
+
+func (s *apiKeyStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE api_keys SET last_used_time = ? WHERE id = ?`, at, id.Hex())
+}
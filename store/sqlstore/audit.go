@@ -0,0 +1,82 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const auditColumns = `id, actor_id, actor_role, action, target_type, target_id, detail, outcome, ip, request_id, create_time`
+
+type auditStore struct {
+	base
+}
+
+func scanAudit(row scanner) (*model.Audit, error) {
+	audit := &model.Audit{}
+	var id string
+	var actorId sql.NullString
+	err := row.Scan(&id, &actorId, &audit.ActorRole, &audit.Action, &audit.TargetType, &audit.TargetId,
+		&audit.Detail, &audit.Outcome, &audit.Ip, &audit.RequestId, &audit.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	audit.Id = parseId(sql.NullString{String: id, Valid: true})
+	audit.ActorId = parseId(actorId)
+	return audit, nil
+}
+
+func (s *auditStore) Insert(ctx context.Context, audit *model.Audit) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if audit.Id.IsZero() {
+		audit.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO audits (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		audit.Id.Hex(), nullId(audit.ActorId), audit.ActorRole, audit.Action, audit.TargetType, audit.TargetId,
+		audit.Detail, audit.Outcome, audit.Ip, audit.RequestId, audit.CreateTime)
+}
+
+func (s *auditStore) SetOutcome(ctx context.Context, id primitive.ObjectID, outcome string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE audits SET outcome = ? WHERE id = ?`, outcome, id.Hex())
+}
+
+func (s *auditStore) Find(ctx context.Context, actorId primitive.ObjectID, limit int) ([]model.Audit, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query := `SELECT ` + auditColumns + ` FROM audits ORDER BY create_time DESC LIMIT ?`
+	args := []interface{}{limit}
+	if !actorId.IsZero() {
+		query = `SELECT ` + auditColumns + ` FROM audits WHERE actor_id = ? ORDER BY create_time DESC LIMIT ?`
+		args = []interface{}{actorId.Hex(), limit}
+	}
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	audits := []model.Audit{}
+	for rows.Next() {
+		audit, err := scanAudit(rows)
+		if err != nil {
+			return nil, err
+		}
+		audits = append(audits, *audit)
+	}
+	return audits, convertErr(rows.Err())
+}
@@ -0,0 +1,212 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const authColumns = `id, send_id, receiver_id, lock_id, auth_type, deadline, start_date, end_date, start_time, end_time,
+	view_log, add_card, share_auth, valid, token, phone, space_ids, org_id, update_time, create_time`
+
+type authStore struct {
+	base
+}
+
+func scanAuth(row scanner) (*model.Auth, error) {
+	auth := &model.Auth{}
+	var id, sendId, spaceIds string
+	var receiverId, lockId, orgId sql.NullString
+	err := row.Scan(&id, &sendId, &receiverId, &lockId, &auth.AuthType, &auth.Deadline, &auth.StartDate, &auth.EndDate,
+		&auth.StartTime, &auth.EndTime, &auth.ViewLog, &auth.AddCard, &auth.ShareAuth, &auth.Valid, &auth.Token,
+		&auth.Phone, &spaceIds, &orgId, &auth.UpdateTime, &auth.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	auth.Id = parseId(sql.NullString{String: id, Valid: true})
+	auth.SendId = parseId(sql.NullString{String: sendId, Valid: true})
+	auth.ReceiverId = parseId(receiverId)
+	auth.LockId = parseId(lockId)
+	auth.OrgId = parseId(orgId)
+	if spaceIds != "" {
+		auth.SpaceIds = splitIds(spaceIds)
+	}
+	return auth, nil
+}
+
+func (s *authStore) find(ctx context.Context, conn *sql.DB, query string, args ...interface{}) ([]model.Auth, error) {
+	query, args = tenant(ctx, `SELECT `+authColumns+` FROM auths WHERE `+query, args...)
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	auths := []model.Auth{}
+	for rows.Next() {
+		auth, err := scanAuth(rows)
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, *auth)
+	}
+	return auths, convertErr(rows.Err())
+}
+
+func (s *authStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Auth, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+authColumns+` FROM auths WHERE id = ?`, id.Hex())
+	return scanAuth(s.queryRow(ctx, conn, query, args...))
+}
+
+func (s *authStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return s.find(ctx, conn, `lock_id = ?`, lockId.Hex())
+}
+
+func (s *authStore) FindByLockAndUser(ctx context.Context, lockId, userId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return s.find(ctx, conn, `lock_id = ? AND (send_id = ? OR receiver_id = ?)`, lockId.Hex(), userId.Hex(), userId.Hex())
+}
+
+func (s *authStore) FindByReceiver(ctx context.Context, receiverId primitive.ObjectID, valid bool, perms model.Perms) ([]model.Auth, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query := `receiver_id = ?`
+	if valid {
+		query += ` AND valid = TRUE`
+	}
+	if perms.AddCard {
+		query += ` AND add_card = TRUE`
+	}
+	if perms.ShareAuth {
+		query += ` AND share_auth = TRUE`
+	}
+	if perms.ViewLog {
+		query += ` AND view_log = TRUE`
+	}
+	return s.find(ctx, conn, query, receiverId.Hex())
+}
+
+func (s *authStore) Insert(ctx context.Context, auth *model.Auth) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if auth.Id.IsZero() {
+		auth.Id = primitive.NewObjectID()
+	}
+	auth.OrgId = store.TenantId(ctx, auth.OrgId)
+	return s.insert(ctx, conn, `INSERT INTO auths (`+authColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		auth.Id.Hex(), auth.SendId.Hex(), nullId(auth.ReceiverId), nullId(auth.LockId), auth.AuthType, auth.Deadline,
+		auth.StartDate, auth.EndDate, auth.StartTime, auth.EndTime, auth.ViewLog, auth.AddCard, auth.ShareAuth,
+		auth.Valid, auth.Token, auth.Phone, joinIds(auth.SpaceIds), nullId(auth.OrgId), auth.UpdateTime, auth.CreateTime)
+}
+
+func (s *authStore) SetReceiver(ctx context.Context, id, receiverId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上 receiver_id 为空，保证只能被领取一次
+	query, args := tenant(ctx, `UPDATE auths SET receiver_id = ?, update_time = ? WHERE id = ? AND receiver_id IS NULL`,
+		receiverId.Hex(), time.Now().Local(), id.Hex())
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *authStore) Invalidate(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE auths SET valid = FALSE, update_time = ? WHERE id = ?`,
+		time.Now().Local(), id.Hex())
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *authStore) InvalidateByLock(ctx context.Context, lockId primitive.ObjectID) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE auths SET valid = FALSE, update_time = ? WHERE lock_id = ? AND valid = TRUE`,
+		time.Now().Local(), lockId.Hex())
+	affected, err := s.exec(ctx, conn, "update", query, args...)
+	return int(affected), err
+}
+
+func (s *authStore) Revoke(ctx context.Context, id, sendId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE auths SET valid = FALSE, update_time = ? WHERE id = ? AND send_id = ?`,
+		time.Now().Local(), id.Hex(), sendId.Hex())
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *authStore) FindBySpace(ctx context.Context, spaceId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// id 都是 24 位十六进制，按子串匹配不会匹配到别的 id
+	return s.find(ctx, conn, `space_ids LIKE ?`, "%"+spaceId.Hex()+"%")
+}
+
+func (s *authStore) FindBySender(ctx context.Context, sendId primitive.ObjectID) ([]model.Auth, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return s.find(ctx, conn, `send_id = ?`, sendId.Hex())
+}
+
+func (s *authStore) ClaimByPhone(ctx context.Context, phone string, receiverId primitive.ObjectID) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE auths SET receiver_id = ?, update_time = ?
+		WHERE phone = ? AND receiver_id IS NULL AND valid = TRUE AND send_id <> ?`,
+		receiverId.Hex(), time.Now().Local(), phone, receiverId.Hex())
+	affected, err := s.exec(ctx, conn, "update", query, args...)
+	return int(affected), err
+}
@@ -0,0 +1,140 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const cardColumns = `id, name, number, description, lock_id, user_id, org_id, valid, update_time, create_time`
+
+type cardStore struct {
+	base
+}
+
+func scanCard(row scanner) (*model.Card, error) {
+	card := &model.Card{}
+	var id, lockId string
+	var userId, orgId sql.NullString
+	err := row.Scan(&id, &card.Name, &card.Number, &card.Desc, &lockId, &userId, &orgId, &card.Valid, &card.UpdateTime, &card.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	card.Id = parseId(sql.NullString{String: id, Valid: true})
+	card.Lock = parseId(sql.NullString{String: lockId, Valid: true})
+	card.UserId = parseId(userId)
+	card.OrgId = parseId(orgId)
+	return card, nil
+}
+
+func (s *cardStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Card, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+cardColumns+` FROM cards WHERE id = ?`, id.Hex())
+	return scanCard(s.queryRow(ctx, conn, query, args...))
+}
+
+func (s *cardStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Card, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+cardColumns+` FROM cards WHERE lock_id = ?`, lockId.Hex())
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cards := []model.Card{}
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, *card)
+	}
+	return cards, convertErr(rows.Err())
+}
+
+func (s *cardStore) Insert(ctx context.Context, card *model.Card) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if card.Id.IsZero() {
+		card.Id = primitive.NewObjectID()
+	}
+	card.OrgId = store.TenantId(ctx, card.OrgId)
+	return s.insert(ctx, conn, `INSERT INTO cards (`+cardColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		card.Id.Hex(), card.Name, card.Number, card.Desc, card.Lock.Hex(), nullId(card.UserId), nullId(card.OrgId),
+		card.Valid, card.UpdateTime, card.CreateTime)
+}
+
+func (s *cardStore) UpdateInfo(ctx context.Context, id primitive.ObjectID, name, desc string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 空值不修改
+	sets := "update_time = ?"
+	args := []interface{}{time.Now().Local()}
+	if name != "" {
+		sets += ", name = ?"
+		args = append(args, name)
+	}
+	if desc != "" {
+		sets += ", description = ?"
+		args = append(args, desc)
+	}
+	query, args := tenant(ctx, `UPDATE cards SET `+sets+` WHERE id = ?`, append(args, id.Hex())...)
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *cardStore) InvalidateByNumber(ctx context.Context, lockId primitive.ObjectID, number string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE cards SET valid = FALSE, update_time = ? WHERE lock_id = ? AND number = ? AND valid = TRUE`,
+		time.Now().Local(), lockId.Hex(), number)
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *cardStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Card, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+cardColumns+` FROM cards WHERE user_id = ?`, userId.Hex())
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cards := []model.Card{}
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, *card)
+	}
+	return cards, convertErr(rows.Err())
+}
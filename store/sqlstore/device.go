@@ -0,0 +1,106 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const deviceColumns = `id, mac, serial, model, secret_key, claim_code_hash, attempts, lock_id, claimed_by, claim_time, update_time, create_time`
+
+type deviceStore struct {
+	base
+}
+
+func scanDevice(row scanner) (*model.Device, error) {
+	device := &model.Device{}
+	var id string
+	var lockId, claimedBy sql.NullString
+	var claimTime sql.NullTime
+	err := row.Scan(&id, &device.Mac, &device.Serial, &device.Model, &device.Key, &device.ClaimCodeHash,
+		&device.Attempts, &lockId, &claimedBy, &claimTime, &device.UpdateTime, &device.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	device.Id = parseId(sql.NullString{String: id, Valid: true})
+	device.LockId = parseId(lockId)
+	device.ClaimedBy = parseId(claimedBy)
+	device.ClaimTime = claimTime.Time
+	return device, nil
+}
+
+func (s *deviceStore) GetByMac(ctx context.Context, mac string) (*model.Device, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanDevice(s.queryRow(ctx, conn, `SELECT `+deviceColumns+` FROM devices WHERE mac = ?`, mac))
+}
+
+func (s *deviceStore) Insert(ctx context.Context, device *model.Device) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if device.Id.IsZero() {
+		device.Id = primitive.NewObjectID()
+	}
+	// mac 和 serial 有唯一索引，已经导入过的时候返回 ErrDuplicate
+	return s.insert(ctx, conn, `INSERT INTO devices (`+deviceColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		device.Id.Hex(), device.Mac, device.Serial, device.Model, device.Key, device.ClaimCodeHash, device.Attempts,
+		nullId(device.LockId), nullId(device.ClaimedBy), nullTime(device.ClaimTime), device.UpdateTime, device.CreateTime)
+}
+
+func (s *deviceStore) Claim(ctx context.Context, id, lockId, userId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上没有认领，同一台设备并发绑定的时候只有一个能成功
+	now := time.Now().Local()
+	return s.update(ctx, conn, `UPDATE devices SET lock_id = ?, claimed_by = ?, attempts = 0, claim_time = ?, update_time = ?
+		WHERE id = ? AND lock_id IS NULL`,
+		lockId.Hex(), userId.Hex(), now, now, id.Hex())
+}
+
+func (s *deviceStore) Reclaim(ctx context.Context, id, userId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	now := time.Now().Local()
+	return s.update(ctx, conn, `UPDATE devices SET claimed_by = ?, claim_time = ?, update_time = ? WHERE id = ? AND lock_id IS NOT NULL`,
+		userId.Hex(), now, now, id.Hex())
+}
+
+func (s *deviceStore) RecordFailure(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE devices SET attempts = attempts + 1, update_time = ? WHERE id = ?`,
+		time.Now().Local(), id.Hex())
+}
+
+func (s *deviceStore) SetClaimCode(ctx context.Context, id primitive.ObjectID, claimCodeHash string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE devices SET claim_code_hash = ?, attempts = 0, update_time = ? WHERE id = ?`,
+		claimCodeHash, time.Now().Local(), id.Hex())
+}
@@ -0,0 +1,41 @@
+package sqlstore
+
+import (
+	"context"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const deviceChallengeColumns = `id, user_id, mac, used, expire_time, create_time`
+
+type deviceChallengeStore struct {
+	base
+}
+
+func (s *deviceChallengeStore) Insert(ctx context.Context, challenge *model.DeviceChallenge) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if challenge.Id.IsZero() {
+		challenge.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO device_challenges (`+deviceChallengeColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		challenge.Id.Hex(), challenge.UserId.Hex(), challenge.Mac, challenge.Used, challenge.ExpireTime, challenge.CreateTime)
+}
+
+func (s *deviceChallengeStore) Use(ctx context.Context, id, userId primitive.ObjectID, mac string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上 used，同一个应答并发提交的时候只有一个能成功
+	return s.update(ctx, conn, `UPDATE device_challenges SET used = TRUE
+		WHERE id = ? AND user_id = ? AND mac = ? AND used = FALSE AND expire_time > ?`,
+		id.Hex(), userId.Hex(), mac, time.Now().Local())
+}
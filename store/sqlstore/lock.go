@@ -0,0 +1,261 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const lockColumns = `id, name, mac, description, model, version, secret_key, own_id, org_id, space_id, valid, update_time, create_time`
+
+type lockStore struct {
+	base
+}
+
+func scanLock(row scanner) (*model.Lock, error) {
+	lock := &model.Lock{}
+	var id string
+	var own, orgId, spaceId sql.NullString
+	err := row.Scan(&id, &lock.Name, &lock.Mac, &lock.Desc, &lock.Model, &lock.Version, &lock.Key, &own, &orgId, &spaceId,
+		&lock.Valid, &lock.UpdateTime, &lock.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	lock.Id = parseId(sql.NullString{String: id, Valid: true})
+	lock.Own = parseId(own)
+	lock.OrgId = parseId(orgId)
+	lock.SpaceId = parseId(spaceId)
+	return lock, nil
+}
+
+// 读取查询出来的所有门锁
+func scanLocks(rows *sql.Rows) ([]model.Lock, error) {
+	defer rows.Close()
+	locks := []model.Lock{}
+	for rows.Next() {
+		lock, err := scanLock(rows)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, *lock)
+	}
+	return locks, convertErr(rows.Err())
+}
+
+func (s *lockStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Lock, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+lockColumns+` FROM locks WHERE id = ?`, id.Hex())
+	return scanLock(s.queryRow(ctx, conn, query, args...))
+}
+
+func (s *lockStore) GetByMac(ctx context.Context, mac string) (*model.Lock, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+lockColumns+` FROM locks WHERE mac = ?`, mac)
+	return scanLock(s.queryRow(ctx, conn, query, args...))
+}
+
+func (s *lockStore) FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Lock, error) {
+	if len(ids) == 0 {
+		return []model.Lock{}, nil
+	}
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	marks, args := inIds(ids)
+	query, args := tenant(ctx, `SELECT `+lockColumns+` FROM locks WHERE id IN (`+marks+`)`, args...)
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanLocks(rows)
+}
+
+func (s *lockStore) FindByOwner(ctx context.Context, own primitive.ObjectID, valid bool) ([]model.Lock, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query := `SELECT ` + lockColumns + ` FROM locks WHERE own_id = ?`
+	if valid {
+		query += ` AND valid = TRUE`
+	}
+	query, args := tenant(ctx, query, own.Hex())
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanLocks(rows)
+}
+
+func (s *lockStore) FindByOrg(ctx context.Context, orgId primitive.ObjectID, valid bool) ([]model.Lock, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query := `SELECT ` + lockColumns + ` FROM locks WHERE org_id = ?`
+	if valid {
+		query += ` AND valid = TRUE`
+	}
+	query, args := tenant(ctx, query, orgId.Hex())
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanLocks(rows)
+}
+
+func (s *lockStore) Insert(ctx context.Context, lock *model.Lock) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if lock.Id.IsZero() {
+		lock.Id = primitive.NewObjectID()
+	}
+	lock.OrgId = store.TenantId(ctx, lock.OrgId)
+	return s.insert(ctx, conn, `INSERT INTO locks (`+lockColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		lock.Id.Hex(), lock.Name, lock.Mac, lock.Desc, lock.Model, lock.Version, lock.Key, nullId(lock.Own),
+		nullId(lock.OrgId), nullId(lock.SpaceId), lock.Valid, lock.UpdateTime, lock.CreateTime)
+}
+
+func (s *lockStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	affected, err := s.exec(ctx, conn, "delete", `DELETE FROM locks WHERE id = ?`, id.Hex())
+	if err == nil && affected == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *lockStore) Revive(ctx context.Context, lock *model.Lock) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	lock.OrgId = store.TenantId(ctx, lock.OrgId)
+	lock.SpaceId = primitive.NilObjectID
+	lock.Valid = true
+	lock.UpdateTime = time.Now().Local()
+	return s.update(ctx, conn, `UPDATE locks SET name = ?, description = ?, version = ?, own_id = ?, org_id = ?, space_id = NULL,
+		valid = TRUE, update_time = ? WHERE id = ? AND valid = FALSE`,
+		lock.Name, lock.Desc, lock.Version, nullId(lock.Own), nullId(lock.OrgId), lock.UpdateTime, lock.Id.Hex())
+}
+
+func (s *lockStore) UpdateInfo(ctx context.Context, mac string, own primitive.ObjectID, name, desc string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 空值不修改
+	sets := "update_time = ?"
+	args := []interface{}{time.Now().Local()}
+	if name != "" {
+		sets += ", name = ?"
+		args = append(args, name)
+	}
+	if desc != "" {
+		sets += ", description = ?"
+		args = append(args, desc)
+	}
+	query, args := tenant(ctx, `UPDATE locks SET `+sets+` WHERE mac = ? AND own_id = ? AND valid = TRUE`, append(args, mac, own.Hex())...)
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *lockStore) Invalidate(ctx context.Context, mac string, own primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE locks SET valid = FALSE, update_time = ? WHERE mac = ? AND own_id = ?`,
+		time.Now().Local(), mac, own.Hex())
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *lockStore) SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE locks SET own_id = ?, space_id = NULL, update_time = ? WHERE id = ? AND own_id = ? AND valid = TRUE`,
+		newOwn.Hex(), time.Now().Local(), id.Hex(), own.Hex())
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *lockStore) FindBySpaces(ctx context.Context, spaceIds []primitive.ObjectID) ([]model.Lock, error) {
+	if len(spaceIds) == 0 {
+		return []model.Lock{}, nil
+	}
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	marks, args := inIds(spaceIds)
+	query, args := tenant(ctx, `SELECT `+lockColumns+` FROM locks WHERE space_id IN (`+marks+`)`, args...)
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanLocks(rows)
+}
+
+func (s *lockStore) SetSpace(ctx context.Context, id, own, spaceId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE locks SET space_id = ?, update_time = ? WHERE id = ? AND own_id = ? AND valid = TRUE`,
+		nullId(spaceId), time.Now().Local(), id.Hex(), own.Hex())
+	return s.update(ctx, conn, query, args...)
+}
+
+func (s *lockStore) ClearSpace(ctx context.Context, spaceId primitive.ObjectID) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE locks SET space_id = NULL, update_time = ? WHERE space_id = ?`,
+		time.Now().Local(), spaceId.Hex())
+	affected, err := s.exec(ctx, conn, "update", query, args...)
+	return int(affected), err
+}
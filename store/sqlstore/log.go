@@ -0,0 +1,115 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const logColumns = `id, lock_id, user_id, org_id, open_type, success, raw_info, create_time`
+
+type logStore struct {
+	base
+}
+
+func scanLog(row scanner) (*model.Log, error) {
+	log := &model.Log{}
+	var id, lockId string
+	var userId, orgId sql.NullString
+	err := row.Scan(&id, &lockId, &userId, &orgId, &log.OpenType, &log.Success, &log.RowInfo, &log.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	log.Id = parseId(sql.NullString{String: id, Valid: true})
+	log.LockId = parseId(sql.NullString{String: lockId, Valid: true})
+	log.UserId = parseId(userId)
+	log.OrgId = parseId(orgId)
+	return log, nil
+}
+
+func (s *logStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Log, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+logColumns+` FROM logs WHERE lock_id = ?`, lockId.Hex())
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	logs := []model.Log{}
+	for rows.Next() {
+		log, err := scanLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, *log)
+	}
+	return logs, convertErr(rows.Err())
+}
+
+func (s *logStore) Upsert(ctx context.Context, log *model.Log) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	id := log.Id
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+	log.OrgId = store.TenantId(ctx, log.OrgId)
+	// 原始信息已经存在的时候覆盖除 id 以外的字段，和 mongo 的 ReplaceOne 一致
+	// 已经存在的日志属于别的租户的时候不覆盖，和 mongo 一样返回 ErrDuplicate
+	affected, err := s.exec(ctx, conn, "insert", `INSERT INTO logs (`+logColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (raw_info) DO UPDATE SET lock_id = excluded.lock_id, user_id = excluded.user_id,
+		open_type = excluded.open_type, success = excluded.success, create_time = excluded.create_time
+		WHERE logs.org_id = excluded.org_id OR (logs.org_id IS NULL AND excluded.org_id IS NULL)`,
+		id.Hex(), log.LockId.Hex(), nullId(log.UserId), nullId(log.OrgId), log.OpenType, log.Success, log.RowInfo, log.CreateTime)
+	if err == nil && affected == 0 {
+		return store.ErrDuplicate
+	}
+	return err
+}
+
+func (s *logStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Log, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `SELECT `+logColumns+` FROM logs WHERE user_id = ?`, userId.Hex())
+	rows, err := s.query(ctx, conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	logs := []model.Log{}
+	for rows.Next() {
+		log, err := scanLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, *log)
+	}
+	return logs, convertErr(rows.Err())
+}
+
+func (s *logStore) AnonymizeUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	query, args := tenant(ctx, `UPDATE logs SET user_id = NULL WHERE user_id = ?`, userId.Hex())
+	affected, err := s.exec(ctx, conn, "update", query, args...)
+	return int(affected), err
+}
@@ -0,0 +1,73 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"time"
+)
+
+const loginFailureColumns = `id, failures, locked_until, expire_time`
+
+type loginFailureStore struct {
+	base
+}
+
+func (s *loginFailureStore) Get(ctx context.Context, key string) (*model.LoginFailure, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	failure := &model.LoginFailure{}
+	var lockedUntil sql.NullTime
+	err = s.queryRow(ctx, conn, `SELECT `+loginFailureColumns+` FROM login_failures WHERE id = ?`, key).
+		Scan(&failure.Key, &failure.Failures, &lockedUntil, &failure.ExpireTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	failure.LockedUntil = lockedUntil.Time
+	return failure, nil
+}
+
+func (s *loginFailureStore) AddFailure(ctx context.Context, key string, now, expireTime time.Time) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	// 上一次输错已经过期的时候从一开始计数，插入和加一在一条语句里完成，并发输错的时候不会少算
+	err = s.insert(ctx, conn, `INSERT INTO login_failures (`+loginFailureColumns+`) VALUES (?, 1, NULL, ?)
+		ON CONFLICT (id) DO UPDATE SET expire_time = excluded.expire_time,
+		failures = CASE WHEN login_failures.expire_time <= ? THEN 1 ELSE login_failures.failures + 1 END`,
+		key, expireTime, now)
+	if err != nil {
+		return 0, err
+	}
+	var failures int
+	err = s.queryRow(ctx, conn, `SELECT failures FROM login_failures WHERE id = ?`, key).Scan(&failures)
+	return failures, convertErr(err)
+}
+
+func (s *loginFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE login_failures SET failures = 0, locked_until = ? WHERE id = ?`, until, key)
+}
+
+func (s *loginFailureStore) Reset(ctx context.Context, key string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = s.exec(ctx, conn, "delete", `DELETE FROM login_failures WHERE id = ?`, key)
+	return err
+}
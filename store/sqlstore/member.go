@@ -0,0 +1,127 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const memberColumns = `id, lock_id, user_id, role, invited_by, update_time, create_time`
+
+type memberStore struct {
+	base
+}
+
+func scanMember(row scanner) (*model.Member, error) {
+	member := &model.Member{}
+	var id, lockId, userId string
+	var invitedBy sql.NullString
+	err := row.Scan(&id, &lockId, &userId, &member.Role, &invitedBy, &member.UpdateTime, &member.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	member.Id = parseId(sql.NullString{String: id, Valid: true})
+	member.LockId = parseId(sql.NullString{String: lockId, Valid: true})
+	member.UserId = parseId(sql.NullString{String: userId, Valid: true})
+	member.InvitedBy = parseId(invitedBy)
+	return member, nil
+}
+
+func (s *memberStore) Get(ctx context.Context, lockId, userId primitive.ObjectID) (*model.Member, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanMember(s.queryRow(ctx, conn, `SELECT `+memberColumns+` FROM members WHERE lock_id = ? AND user_id = ?`,
+		lockId.Hex(), userId.Hex()))
+}
+
+func (s *memberStore) find(ctx context.Context, where string, args ...interface{}) ([]model.Member, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.query(ctx, conn, `SELECT `+memberColumns+` FROM members WHERE `+where+` ORDER BY create_time`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []model.Member{}
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *member)
+	}
+	return members, convertErr(rows.Err())
+}
+
+func (s *memberStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Member, error) {
+	return s.find(ctx, `lock_id = ?`, lockId.Hex())
+}
+
+func (s *memberStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Member, error) {
+	return s.find(ctx, `user_id = ?`, userId.Hex())
+}
+
+func (s *memberStore) Insert(ctx context.Context, member *model.Member) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if member.Id.IsZero() {
+		member.Id = primitive.NewObjectID()
+	}
+	// lock_id 和 user_id 有唯一索引，已经是成员的时候返回 ErrDuplicate
+	return s.insert(ctx, conn, `INSERT INTO members (`+memberColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		member.Id.Hex(), member.LockId.Hex(), member.UserId.Hex(), member.Role, nullId(member.InvitedBy),
+		member.UpdateTime, member.CreateTime)
+}
+
+func (s *memberStore) SetRole(ctx context.Context, lockId, userId primitive.ObjectID, role string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE members SET role = ?, update_time = ? WHERE lock_id = ? AND user_id = ?`,
+		role, time.Now().Local(), lockId.Hex(), userId.Hex())
+}
+
+func (s *memberStore) Delete(ctx context.Context, lockId, userId primitive.ObjectID) error {
+	count, err := s.delete(ctx, `lock_id = ? AND user_id = ?`, lockId.Hex(), userId.Hex())
+	if err == nil && count == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *memberStore) DeleteByLock(ctx context.Context, lockId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, `lock_id = ?`, lockId.Hex())
+}
+
+func (s *memberStore) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, `user_id = ?`, userId.Hex())
+}
+
+func (s *memberStore) delete(ctx context.Context, where string, args ...interface{}) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	affected, err := s.exec(ctx, conn, "delete", `DELETE FROM members WHERE `+where, args...)
+	return int(affected), err
+}
@@ -0,0 +1,684 @@
+package sqlstore
+
+import (
+	"context"
+	"ezlock/common/migrate"
+	"ezlock/common/sqldb"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sql 的迁移列表，新的迁移只能追加在最后，已经发布的不能修改
+// 建表语句 postgres 和 sqlite 共用，sqlite 的时间类型在执行的时候替换
+func Migrator(db *sqldb.DB) *migrate.Migrator {
+	m := migrator{db}
+	return migrate.New(m, []migrate.Migration{
+		{
+			// auths 和 cards 通过外键关联到 locks，门锁只做逻辑删除所以不会级联删除
+			Version: 1,
+			Name:    "create_tables",
+			Up:      m.exec(createTables...),
+			Down: m.exec(
+				`DROP TABLE IF EXISTS logs`,
+				`DROP TABLE IF EXISTS cards`,
+				`DROP TABLE IF EXISTS auths`,
+				`DROP TABLE IF EXISTS locks`,
+				`DROP TABLE IF EXISTS users`,
+			),
+			Check: m.checkIndexes(createTables...),
+		},
+		{
+			Version: 2,
+			Name:    "create_lookup_indexes",
+			Up:      m.exec(lookupIndexes...),
+			Down: m.exec(
+				`DROP INDEX IF EXISTS index_auths_send_id`,
+				`DROP INDEX IF EXISTS index_cards_lock_id_number`,
+			),
+			Check: m.checkIndexes(lookupIndexes...),
+		},
+		{
+			Version: 3,
+			Name:    "create_sessions",
+			Up:      m.exec(createSessions...),
+			Down:    m.exec(`DROP TABLE IF EXISTS sessions`),
+			Check:   m.checkIndexes(createSessions...),
+		},
+		{
+			// sqlite 一次只能增加或者删除一个字段
+			Version: 4,
+			Name:    "add_session_device",
+			Up: m.exec(
+				`ALTER TABLE sessions ADD COLUMN device VARCHAR(255) NOT NULL DEFAULT ''`,
+				`ALTER TABLE sessions ADD COLUMN ip VARCHAR(64) NOT NULL DEFAULT ''`,
+				`ALTER TABLE sessions ADD COLUMN last_used_time TIMESTAMPTZ`,
+			),
+			Down: m.exec(
+				`ALTER TABLE sessions DROP COLUMN last_used_time`,
+				`ALTER TABLE sessions DROP COLUMN ip`,
+				`ALTER TABLE sessions DROP COLUMN device`,
+			),
+		},
+		{
+			// 短信和密码登录的用户没有 openId，open_id 的唯一索引只约束不为空的值
+			// 回滚的时候已经有多个没有 openId 的用户会失败
+			Version: 5,
+			Name:    "add_user_identity",
+			Up:      m.exec(addUserIdentity...),
+			Down: m.exec(
+				`DROP INDEX IF EXISTS index_users_phone_number`,
+				`DROP INDEX IF EXISTS index_users_user_name`,
+				`DROP INDEX IF EXISTS index_users_open_id`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS index_users_open_id ON users (open_id)`,
+				`ALTER TABLE users DROP COLUMN password_hash`,
+				`ALTER TABLE users DROP COLUMN user_name`,
+			),
+			Check: m.checkIndexes(addUserIdentity...),
+		},
+		{
+			Version: 6,
+			Name:    "create_verify_codes",
+			Up:      m.exec(createVerifyCodes...),
+			Down:    m.exec(`DROP TABLE IF EXISTS verify_codes`),
+			Check:   m.checkIndexes(createVerifyCodes...),
+		},
+		{
+			Version: 7,
+			Name:    "create_login_failures",
+			Up:      m.exec(createLoginFailures...),
+			Down:    m.exec(`DROP TABLE IF EXISTS login_failures`),
+			Check:   m.checkIndexes(createLoginFailures...),
+		},
+		{
+			Version: 8,
+			Name:    "add_user_role",
+			Up: m.exec(
+				`ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT ''`,
+				`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`,
+			),
+			Down: m.exec(
+				`ALTER TABLE users DROP COLUMN disabled`,
+				`ALTER TABLE users DROP COLUMN role`,
+			),
+		},
+		{
+			Version: 9,
+			Name:    "create_audits",
+			Up:      m.exec(createAudits...),
+			Down:    m.exec(`DROP TABLE IF EXISTS audits`),
+			Check:   m.checkIndexes(createAudits...),
+		},
+		{
+			Version: 10,
+			Name:    "create_api_keys",
+			Up:      m.exec(createApiKeys...),
+			Down:    m.exec(`DROP TABLE IF EXISTS api_keys`),
+			Check:   m.checkIndexes(createApiKeys...),
+		},
+		{
+			Version: 11,
+			Name:    "create_user_data_indexes",
+			Up:      m.exec(userDataIndexes...),
+			Down: m.exec(
+				`DROP INDEX IF EXISTS index_logs_user_id`,
+				`DROP INDEX IF EXISTS index_cards_user_id`,
+			),
+			Check: m.checkIndexes(userDataIndexes...),
+		},
+		{
+			Version: 12,
+			Name:    "add_auth_phone",
+			Up:      m.exec(addAuthPhone...),
+			Down: m.exec(
+				`DROP INDEX IF EXISTS index_auths_phone`,
+				`ALTER TABLE auths DROP COLUMN phone`,
+			),
+			Check: m.checkIndexes(addAuthPhone...),
+		},
+		{
+			Version: 13,
+			Name:    "create_transfers",
+			Up:      m.exec(createTransfers...),
+			Down:    m.exec(`DROP TABLE IF EXISTS transfers`),
+			Check:   m.checkIndexes(createTransfers...),
+		},
+		{
+			Version: 14,
+			Name:    "create_members",
+			Up:      m.exec(createMembers...),
+			Down:    m.exec(`DROP TABLE IF EXISTS members`),
+			Check:   m.checkIndexes(createMembers...),
+		},
+		{
+			// sqlite 删除字段前要先删除字段上的索引
+			Version: 15,
+			Name:    "create_spaces",
+			Up:      m.exec(createSpaces...),
+			Down: m.exec(
+				`DROP INDEX IF EXISTS index_locks_space_id`,
+				`ALTER TABLE locks DROP COLUMN space_id`,
+				`DROP TABLE IF EXISTS spaces`,
+			),
+			Check: m.checkIndexes(createSpaces...),
+		},
+		{
+			// sqlite 不能修改字段的约束，重建 auths 表让授权给空间的记录 lock_id 可以为空
+			// 回滚的时候授权给空间的记录会被删除
+			Version: 16,
+			Name:    "add_auth_spaces",
+			Up:      m.exec(rebuildAuths(`lock_id VARCHAR(24) REFERENCES locks (id)`, true)...),
+			Down: m.exec(append([]string{`DELETE FROM auths WHERE lock_id IS NULL`},
+				rebuildAuths(`lock_id VARCHAR(24) NOT NULL REFERENCES locks (id)`, false)...)...),
+			Check: m.checkIndexes(rebuildAuths("", true)...),
+		},
+		{
+			Version: 17,
+			Name:    "create_organizations",
+			Up:      m.exec(createOrganizations...),
+			Down: m.exec(
+				`DROP TABLE IF EXISTS org_members`,
+				`DROP TABLE IF EXISTS organizations`,
+			),
+			Check: m.checkIndexes(createOrganizations...),
+		},
+		{
+			// 已有的数据 org_id 都为空，属于个人
+			Version: 18,
+			Name:    "add_org_ids",
+			Up:      m.exec(addOrgIds...),
+			Down: m.exec(
+				`DROP INDEX IF EXISTS index_logs_org_id`,
+				`ALTER TABLE logs DROP COLUMN org_id`,
+				`DROP INDEX IF EXISTS index_auths_org_id`,
+				`ALTER TABLE auths DROP COLUMN org_id`,
+				`DROP INDEX IF EXISTS index_cards_org_id`,
+				`ALTER TABLE cards DROP COLUMN org_id`,
+				`DROP INDEX IF EXISTS index_locks_org_id`,
+				`ALTER TABLE locks DROP COLUMN org_id`,
+			),
+			Check: m.checkIndexes(addOrgIds...),
+		},
+		{
+			Version: 19,
+			Name:    "create_devices",
+			Up:      m.exec(createDevices...),
+			Down:    m.exec(`DROP TABLE IF EXISTS devices`),
+			Check:   m.checkIndexes(createDevices...),
+		},
+		{
+			Version: 20,
+			Name:    "create_device_challenges",
+			Up:      m.exec(createDeviceChallenges...),
+			Down:    m.exec(`DROP TABLE IF EXISTS device_challenges`),
+			Check:   m.checkIndexes(createDeviceChallenges...),
+		},
+	})
+}
+
+// 第一个版本的表和索引
+var createTables = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id           VARCHAR(24) PRIMARY KEY,
+		nick_name    VARCHAR(255) NOT NULL DEFAULT '',
+		open_id      VARCHAR(64) NOT NULL,
+		union_id     VARCHAR(64) NOT NULL DEFAULT '',
+		session_key  VARCHAR(128) NOT NULL DEFAULT '',
+		phone_number VARCHAR(32) NOT NULL DEFAULT '',
+		default_lock VARCHAR(24),
+		gender       INTEGER NOT NULL DEFAULT 0,
+		city         VARCHAR(64) NOT NULL DEFAULT '',
+		province     VARCHAR(64) NOT NULL DEFAULT '',
+		country      VARCHAR(64) NOT NULL DEFAULT '',
+		avatar_url   TEXT NOT NULL DEFAULT '',
+		language     VARCHAR(32) NOT NULL DEFAULT '',
+		update_time  TIMESTAMPTZ NOT NULL,
+		create_time  TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_users_open_id ON users (open_id)`,
+	`CREATE INDEX IF NOT EXISTS index_users_nick_name ON users (nick_name)`,
+
+	`CREATE TABLE IF NOT EXISTS locks (
+		id          VARCHAR(24) PRIMARY KEY,
+		name        VARCHAR(255) NOT NULL DEFAULT '',
+		mac         VARCHAR(64) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		model       VARCHAR(64) NOT NULL DEFAULT '',
+		version     VARCHAR(64) NOT NULL DEFAULT '',
+		secret_key  VARCHAR(64) NOT NULL DEFAULT '',
+		own_id      VARCHAR(24),
+		valid       BOOLEAN NOT NULL DEFAULT TRUE,
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_locks_mac ON locks (mac)`,
+	`CREATE INDEX IF NOT EXISTS index_locks_own_id ON locks (own_id)`,
+
+	`CREATE TABLE IF NOT EXISTS auths (
+		id          VARCHAR(24) PRIMARY KEY,
+		send_id     VARCHAR(24) NOT NULL,
+		receiver_id VARCHAR(24),
+		lock_id     VARCHAR(24) NOT NULL REFERENCES locks (id),
+		auth_type   VARCHAR(8) NOT NULL DEFAULT '',
+		deadline    VARCHAR(32) NOT NULL DEFAULT '',
+		start_date  VARCHAR(32) NOT NULL DEFAULT '',
+		end_date    VARCHAR(32) NOT NULL DEFAULT '',
+		start_time  VARCHAR(32) NOT NULL DEFAULT '',
+		end_time    VARCHAR(32) NOT NULL DEFAULT '',
+		view_log    BOOLEAN NOT NULL DEFAULT FALSE,
+		add_card    BOOLEAN NOT NULL DEFAULT FALSE,
+		share_auth  BOOLEAN NOT NULL DEFAULT FALSE,
+		valid       BOOLEAN NOT NULL DEFAULT TRUE,
+		token       VARCHAR(64) NOT NULL DEFAULT '',
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_auths_lock_id ON auths (lock_id)`,
+	`CREATE INDEX IF NOT EXISTS index_auths_receiver_id ON auths (receiver_id)`,
+
+	`CREATE TABLE IF NOT EXISTS cards (
+		id          VARCHAR(24) PRIMARY KEY,
+		name        VARCHAR(255) NOT NULL DEFAULT '',
+		number      VARCHAR(64) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		lock_id     VARCHAR(24) NOT NULL REFERENCES locks (id),
+		user_id     VARCHAR(24),
+		valid       BOOLEAN NOT NULL DEFAULT TRUE,
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_cards_lock_id ON cards (lock_id)`,
+
+	`CREATE TABLE IF NOT EXISTS logs (
+		id          VARCHAR(24) PRIMARY KEY,
+		lock_id     VARCHAR(24) NOT NULL,
+		user_id     VARCHAR(24),
+		open_type   VARCHAR(8) NOT NULL DEFAULT '',
+		success     BOOLEAN NOT NULL DEFAULT FALSE,
+		raw_info    TEXT NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_logs_raw_info ON logs (raw_info)`,
+	`CREATE INDEX IF NOT EXISTS index_logs_lock_id ON logs (lock_id)`,
+}
+
+// 第二个版本增加的索引
+var lookupIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS index_auths_send_id ON auths (send_id)`,
+	`CREATE INDEX IF NOT EXISTS index_cards_lock_id_number ON cards (lock_id, number)`,
+}
+
+// 登录会话，刷新 token 只保存哈希
+var createSessions = []string{
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id           VARCHAR(24) PRIMARY KEY,
+		user_id      VARCHAR(24) NOT NULL REFERENCES users (id),
+		refresh_hash VARCHAR(64) NOT NULL,
+		revoked      BOOLEAN NOT NULL DEFAULT FALSE,
+		expire_time  TIMESTAMPTZ NOT NULL,
+		update_time  TIMESTAMPTZ NOT NULL,
+		create_time  TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_sessions_user_id ON sessions (user_id)`,
+}
+
+// 密码登录的用户名和密码，手机号登录需要按手机号查询用户
+var addUserIdentity = []string{
+	`ALTER TABLE users ADD COLUMN user_name VARCHAR(64) NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN password_hash VARCHAR(128) NOT NULL DEFAULT ''`,
+	`DROP INDEX IF EXISTS index_users_open_id`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_users_open_id ON users (open_id) WHERE open_id <> ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_users_user_name ON users (user_name) WHERE user_name <> ''`,
+	`CREATE INDEX IF NOT EXISTS index_users_phone_number ON users (phone_number)`,
+}
+
+// 短信验证码，只保存哈希
+var createVerifyCodes = []string{
+	`CREATE TABLE IF NOT EXISTS verify_codes (
+		id          VARCHAR(24) PRIMARY KEY,
+		phone       VARCHAR(32) NOT NULL,
+		code_hash   VARCHAR(64) NOT NULL,
+		attempts    INTEGER NOT NULL DEFAULT 0,
+		used        BOOLEAN NOT NULL DEFAULT FALSE,
+		expire_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_verify_codes_phone_create_time ON verify_codes (phone, create_time)`,
+}
+
+// 密码登录失败记录，主键为 user:用户id 或者 name:用户名
+var createLoginFailures = []string{
+	`CREATE TABLE IF NOT EXISTS login_failures (
+		id           VARCHAR(128) PRIMARY KEY,
+		failures     INTEGER NOT NULL DEFAULT 0,
+		locked_until TIMESTAMPTZ,
+		expire_time  TIMESTAMPTZ NOT NULL
+	)`,
+}
+
+// 管理操作审计，命令行操作的时候 actor_id 为 NULL
+var createAudits = []string{
+	`CREATE TABLE IF NOT EXISTS audits (
+		id          VARCHAR(24) PRIMARY KEY,
+		actor_id    VARCHAR(24),
+		actor_role  VARCHAR(16) NOT NULL DEFAULT '',
+		action      VARCHAR(64) NOT NULL,
+		target_type VARCHAR(16) NOT NULL DEFAULT '',
+		target_id   VARCHAR(64) NOT NULL DEFAULT '',
+		detail      TEXT NOT NULL DEFAULT '',
+		outcome     VARCHAR(16) NOT NULL DEFAULT '',
+		ip          VARCHAR(64) NOT NULL DEFAULT '',
+		request_id  VARCHAR(128) NOT NULL DEFAULT '',
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_audits_actor_id_create_time ON audits (actor_id, create_time)`,
+	`CREATE INDEX IF NOT EXISTS index_audits_create_time ON audits (create_time)`,
+}
+
+// 接口 key，只保存哈希，权限范围和门锁 id 用逗号分隔
+var createApiKeys = []string{
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id             VARCHAR(24) PRIMARY KEY,
+		user_id        VARCHAR(24) NOT NULL REFERENCES users (id),
+		name           VARCHAR(64) NOT NULL DEFAULT '',
+		key_hash       VARCHAR(64) NOT NULL,
+		scopes         TEXT NOT NULL DEFAULT '',
+		lock_ids       TEXT NOT NULL DEFAULT '',
+		revoked        BOOLEAN NOT NULL DEFAULT FALSE,
+		last_used_time TIMESTAMPTZ,
+		update_time    TIMESTAMPTZ NOT NULL,
+		create_time    TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_api_keys_user_id ON api_keys (user_id)`,
+}
+
+// 导出和注销用户数据的时候按用户查询门卡和日志
+var userDataIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS index_cards_user_id ON cards (user_id)`,
+	`CREATE INDEX IF NOT EXISTS index_logs_user_id ON logs (user_id)`,
+}
+
+// 分享给手机号的授权，这个手机号的用户验证后按手机号领取
+var addAuthPhone = []string{
+	`ALTER TABLE auths ADD COLUMN phone VARCHAR(32) NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS index_auths_phone ON auths (phone)`,
+}
+
+// 门锁转让记录，wiped_cards 是逗号分隔的卡号
+var createTransfers = []string{
+	`CREATE TABLE IF NOT EXISTS transfers (
+		id            VARCHAR(24) PRIMARY KEY,
+		lock_id       VARCHAR(24) NOT NULL REFERENCES locks (id),
+		from_id       VARCHAR(24) NOT NULL REFERENCES users (id),
+		to_phone      VARCHAR(32) NOT NULL DEFAULT '',
+		to_id         VARCHAR(24) REFERENCES users (id),
+		status        VARCHAR(16) NOT NULL,
+		auths_revoked INTEGER NOT NULL DEFAULT 0,
+		wiped_cards   TEXT NOT NULL DEFAULT '',
+		expire_time   TIMESTAMPTZ NOT NULL,
+		update_time   TIMESTAMPTZ NOT NULL,
+		create_time   TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_transfers_lock_id ON transfers (lock_id)`,
+	`CREATE INDEX IF NOT EXISTS index_transfers_from_id ON transfers (from_id)`,
+	`CREATE INDEX IF NOT EXISTS index_transfers_to_id ON transfers (to_id)`,
+	`CREATE INDEX IF NOT EXISTS index_transfers_to_phone ON transfers (to_phone)`,
+}
+
+// 门锁的管理员和普通成员，拥有者还是 locks.own_id
+var createMembers = []string{
+	`CREATE TABLE IF NOT EXISTS members (
+		id          VARCHAR(24) PRIMARY KEY,
+		lock_id     VARCHAR(24) NOT NULL REFERENCES locks (id),
+		user_id     VARCHAR(24) NOT NULL REFERENCES users (id),
+		role        VARCHAR(16) NOT NULL,
+		invited_by  VARCHAR(24),
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_members_lock_id_user_id ON members (lock_id, user_id)`,
+	`CREATE INDEX IF NOT EXISTS index_members_user_id ON members (user_id)`,
+}
+
+// 小区、楼栋、楼层和房间，ancestors 是逗号分隔的所有上层空间 id
+var createSpaces = []string{
+	`CREATE TABLE IF NOT EXISTS spaces (
+		id          VARCHAR(24) PRIMARY KEY,
+		own_id      VARCHAR(24) NOT NULL REFERENCES users (id),
+		parent_id   VARCHAR(24) REFERENCES spaces (id),
+		ancestors   TEXT NOT NULL DEFAULT '',
+		space_type  VARCHAR(16) NOT NULL,
+		name        VARCHAR(255) NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS index_spaces_own_id ON spaces (own_id)`,
+	`ALTER TABLE locks ADD COLUMN space_id VARCHAR(24)`,
+	`CREATE INDEX IF NOT EXISTS index_locks_space_id ON locks (space_id)`,
+}
+
+// 用新的 lock_id 定义重建 auths 表，spaceIds 为 true 的时候带上逗号分隔的 space_ids 字段
+func rebuildAuths(lockId string, spaceIds bool) []string {
+	columns := `id, send_id, receiver_id, lock_id, auth_type, deadline, start_date, end_date, start_time, end_time,
+		view_log, add_card, share_auth, valid, token, phone, update_time, create_time`
+	spaceColumn := ""
+	if spaceIds {
+		spaceColumn = `
+		space_ids   TEXT NOT NULL DEFAULT '',`
+	}
+	return []string{
+		`CREATE TABLE auths_rebuild (
+		id          VARCHAR(24) PRIMARY KEY,
+		send_id     VARCHAR(24) NOT NULL,
+		receiver_id VARCHAR(24),
+		` + lockId + `,
+		auth_type   VARCHAR(8) NOT NULL DEFAULT '',
+		deadline    VARCHAR(32) NOT NULL DEFAULT '',
+		start_date  VARCHAR(32) NOT NULL DEFAULT '',
+		end_date    VARCHAR(32) NOT NULL DEFAULT '',
+		start_time  VARCHAR(32) NOT NULL DEFAULT '',
+		end_time    VARCHAR(32) NOT NULL DEFAULT '',
+		view_log    BOOLEAN NOT NULL DEFAULT FALSE,
+		add_card    BOOLEAN NOT NULL DEFAULT FALSE,
+		share_auth  BOOLEAN NOT NULL DEFAULT FALSE,
+		valid       BOOLEAN NOT NULL DEFAULT TRUE,
+		token       VARCHAR(64) NOT NULL DEFAULT '',
+		phone       VARCHAR(32) NOT NULL DEFAULT '',` + spaceColumn + `
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+		`INSERT INTO auths_rebuild (` + columns + `) SELECT ` + columns + ` FROM auths`,
+		`DROP TABLE auths`,
+		`ALTER TABLE auths_rebuild RENAME TO auths`,
+		`CREATE INDEX IF NOT EXISTS index_auths_lock_id ON auths (lock_id)`,
+		`CREATE INDEX IF NOT EXISTS index_auths_receiver_id ON auths (receiver_id)`,
+		`CREATE INDEX IF NOT EXISTS index_auths_send_id ON auths (send_id)`,
+		`CREATE INDEX IF NOT EXISTS index_auths_phone ON auths (phone)`,
+	}
+}
+
+// 组织和组织成员，创建者也在 org_members 里
+var createOrganizations = []string{
+	`CREATE TABLE IF NOT EXISTS organizations (
+		id          VARCHAR(24) PRIMARY KEY,
+		name        VARCHAR(255) NOT NULL DEFAULT '',
+		owner_id    VARCHAR(24) NOT NULL REFERENCES users (id),
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS org_members (
+		id          VARCHAR(24) PRIMARY KEY,
+		org_id      VARCHAR(24) NOT NULL REFERENCES organizations (id),
+		user_id     VARCHAR(24) NOT NULL REFERENCES users (id),
+		role        VARCHAR(16) NOT NULL,
+		invited_by  VARCHAR(24),
+		update_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_org_members_org_id_user_id ON org_members (org_id, user_id)`,
+	`CREATE INDEX IF NOT EXISTS index_org_members_user_id ON org_members (user_id)`,
+}
+
+// 门锁、门卡、授权和日志所属的组织，为空表示个人数据
+// 和 space_id 一样不加外键，sqlite 不能删除带外键的字段
+var addOrgIds = []string{
+	`ALTER TABLE locks ADD COLUMN org_id VARCHAR(24)`,
+	`CREATE INDEX IF NOT EXISTS index_locks_org_id ON locks (org_id)`,
+	`ALTER TABLE cards ADD COLUMN org_id VARCHAR(24)`,
+	`CREATE INDEX IF NOT EXISTS index_cards_org_id ON cards (org_id)`,
+	`ALTER TABLE auths ADD COLUMN org_id VARCHAR(24)`,
+	`CREATE INDEX IF NOT EXISTS index_auths_org_id ON auths (org_id)`,
+	`ALTER TABLE logs ADD COLUMN org_id VARCHAR(24)`,
+	`CREATE INDEX IF NOT EXISTS index_logs_org_id ON logs (org_id)`,
+}
+
+// 工厂导入的设备，lock_id 在认领以后才有值
+var createDevices = []string{
+	`CREATE TABLE IF NOT EXISTS devices (
+		id              VARCHAR(24) PRIMARY KEY,
+		mac             VARCHAR(64) NOT NULL,
+		serial          VARCHAR(64) NOT NULL,
+		model           VARCHAR(64) NOT NULL DEFAULT '',
+		secret_key      VARCHAR(64) NOT NULL,
+		claim_code_hash VARCHAR(64) NOT NULL,
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		lock_id         VARCHAR(24) REFERENCES locks (id),
+		claimed_by      VARCHAR(24),
+		claim_time      TIMESTAMPTZ,
+		update_time     TIMESTAMPTZ NOT NULL,
+		create_time     TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_devices_mac ON devices (mac)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS index_devices_serial ON devices (serial)`,
+}
+
+// 门锁挑战，只按 id 查询，不需要额外的索引
+var createDeviceChallenges = []string{
+	`CREATE TABLE IF NOT EXISTS device_challenges (
+		id          VARCHAR(24) PRIMARY KEY,
+		user_id     VARCHAR(24) NOT NULL,
+		mac         VARCHAR(64) NOT NULL,
+		used        BOOLEAN NOT NULL DEFAULT FALSE,
+		expire_time TIMESTAMPTZ NOT NULL,
+		create_time TIMESTAMPTZ NOT NULL
+	)`,
+}
+
+// 从建索引的语句里取出索引名称
+var indexNamePattern = regexp.MustCompile(`CREATE (?:UNIQUE )?INDEX IF NOT EXISTS (\w+)`)
+
+type migrator struct {
+	db *sqldb.DB
+}
+
+// 转换成当前数据库的写法
+func (m migrator) dialect(stmt string) string {
+	if m.db.Driver() == sqldb.Sqlite {
+		// sqlite 只认识 TIMESTAMP 才会把字段解析成时间
+		stmt = strings.Replace(stmt, "TIMESTAMPTZ", "TIMESTAMP", -1)
+	}
+	return m.db.Rebind(stmt)
+}
+
+// 在一个事务里执行多条语句
+func (m migrator) exec(stmts ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		conn, err := m.db.Database()
+		if err != nil {
+			return err
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, m.dialect(stmt)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+}
+
+// 检查语句里建立的索引是否存在
+func (m migrator) checkIndexes(stmts ...string) func(ctx context.Context) error {
+	names := []string{}
+	for _, stmt := range stmts {
+		if match := indexNamePattern.FindStringSubmatch(stmt); match != nil {
+			names = append(names, match[1])
+		}
+	}
+	return func(ctx context.Context) error {
+		conn, err := m.db.Database()
+		if err != nil {
+			return err
+		}
+		query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?`
+		if m.db.Driver() == sqldb.Postgres {
+			query = `SELECT COUNT(*) FROM pg_indexes WHERE schemaname = current_schema() AND indexname = ?`
+		}
+		for _, name := range names {
+			var count int
+			if err := conn.QueryRowContext(ctx, m.dialect(query), name).Scan(&count); err != nil {
+				return convertErr(err)
+			}
+			if count == 0 {
+				return fmt.Errorf("index %s is missing", name)
+			}
+		}
+		return nil
+	}
+}
+
+// 迁移记录表在第一次读取的时候创建
+func (m migrator) Applied(ctx context.Context) (map[int]time.Time, error) {
+	conn, err := m.db.Database()
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.ExecContext(ctx, m.dialect(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`))
+	if err != nil {
+		return nil, convertErr(err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, convertErr(err)
+		}
+		applied[version] = at
+	}
+	return applied, convertErr(rows.Err())
+}
+
+func (m migrator) Record(ctx context.Context, migration migrate.Migration) error {
+	return m.write(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		migration.Version, migration.Name, time.Now().Local())
+}
+
+func (m migrator) Remove(ctx context.Context, migration migrate.Migration) error {
+	return m.write(ctx, `DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+}
+
+func (m migrator) write(ctx context.Context, query string, args ...interface{}) error {
+	conn, err := m.db.Database()
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, m.dialect(query), args...)
+	return convertErr(err)
+}
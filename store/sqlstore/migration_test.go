@@ -0,0 +1,148 @@
+package sqlstore_test
+
+import (
+	"context"
+	"ezlock/common/sqldb"
+	"ezlock/store/sqlstore"
+	"testing"
+)
+
+// 最早几个迁移建立的索引，之后的迁移只会增加索引
+var wantIndexes = []string{
+	"index_auths_lock_id",
+	"index_auths_receiver_id",
+	"index_auths_send_id",
+	"index_cards_lock_id",
+	"index_cards_lock_id_number",
+	"index_locks_mac",
+	"index_locks_own_id",
+	"index_logs_lock_id",
+	"index_logs_raw_info",
+	"index_users_nick_name",
+	"index_users_open_id",
+}
+
+func TestMigrateUp(t *testing.T) {
+	ctx := context.Background()
+	db := openSqlite(t)
+	migrator := sqlstore.Migrator(db)
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := migrator.Up(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(statuses) {
+		t.Fatalf("expect %d migrations applied, got %d", len(statuses), n)
+	}
+
+	// 再执行一次不会重复执行
+	n, err = migrator.Up(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expect no migrations applied again, got %d", n)
+	}
+
+	latest := statuses[len(statuses)-1].Version
+	if version, count := recordedVersion(t, db); version != latest || count != len(statuses) {
+		t.Fatalf("expect version %d with %d records, got version %d with %d records", latest, len(statuses), version, count)
+	}
+	statuses, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt.IsZero() {
+			t.Fatalf("migration %d %s not applied", status.Version, status.Name)
+		}
+	}
+
+	expectIndexes(t, db)
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	ctx := context.Background()
+	db := openSqlite(t)
+	migrator := sqlstore.Migrator(db)
+	applied, err := migrator.Up(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 回滚最新的一个迁移，再执行一次，结果和直接执行一样
+	n, err := migrator.Down(ctx, 1, nil)
+	if err != nil || n != 1 {
+		t.Fatalf("expect 1 migration reverted, got %d %v", n, err)
+	}
+	if _, count := recordedVersion(t, db); count != applied-1 {
+		t.Fatalf("expect %d records after down, got %d", applied-1, count)
+	}
+	n, err = migrator.Up(ctx, nil)
+	if err != nil || n != 1 {
+		t.Fatalf("expect 1 migration applied again, got %d %v", n, err)
+	}
+	if version, count := recordedVersion(t, db); count != applied || version != applied {
+		t.Fatalf("expect version %d after up again, got version %d with %d records", applied, version, count)
+	}
+	expectIndexes(t, db)
+}
+
+// 检查 wantIndexes 里的索引都存在
+func expectIndexes(t *testing.T, db *sqldb.DB) {
+	t.Helper()
+	indexes := map[string]bool{}
+	for _, name := range listIndexes(t, db) {
+		indexes[name] = true
+	}
+	for _, name := range wantIndexes {
+		if !indexes[name] {
+			t.Fatalf("index %s is missing", name)
+		}
+	}
+}
+
+// 迁移记录里最大的版本号和记录的数量
+func recordedVersion(t *testing.T, db *sqldb.DB) (int, int) {
+	t.Helper()
+	conn, err := db.Database()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version, count int
+	row := conn.QueryRow(`SELECT COALESCE(MAX(version), 0), COUNT(*) FROM schema_migrations`)
+	if err := row.Scan(&version, &count); err != nil {
+		t.Fatal(err)
+	}
+	return version, count
+}
+
+// sqlite 里所有手动建立的索引
+func listIndexes(t *testing.T, db *sqldb.DB) []string {
+	t.Helper()
+	conn, err := db.Database()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := conn.Query(`SELECT name FROM sqlite_master WHERE type = 'index' AND name NOT LIKE 'sqlite_autoindex_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
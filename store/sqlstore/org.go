@@ -0,0 +1,203 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const orgColumns = `id, name, owner_id, update_time, create_time`
+
+const orgMemberColumns = `id, org_id, user_id, role, invited_by, update_time, create_time`
+
+type orgStore struct {
+	base
+}
+
+func scanOrg(row scanner) (*model.Organization, error) {
+	org := &model.Organization{}
+	var id, ownerId string
+	err := row.Scan(&id, &org.Name, &ownerId, &org.UpdateTime, &org.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	org.Id = parseId(sql.NullString{String: id, Valid: true})
+	org.OwnerId = parseId(sql.NullString{String: ownerId, Valid: true})
+	return org, nil
+}
+
+func (s *orgStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Organization, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanOrg(s.queryRow(ctx, conn, `SELECT `+orgColumns+` FROM organizations WHERE id = ?`, id.Hex()))
+}
+
+func (s *orgStore) FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Organization, error) {
+	if len(ids) == 0 {
+		return []model.Organization{}, nil
+	}
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	marks, args := inIds(ids)
+	rows, err := s.query(ctx, conn, `SELECT `+orgColumns+` FROM organizations WHERE id IN (`+marks+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orgs := []model.Organization{}
+	for rows.Next() {
+		org, err := scanOrg(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, *org)
+	}
+	return orgs, convertErr(rows.Err())
+}
+
+func (s *orgStore) Insert(ctx context.Context, org *model.Organization) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if org.Id.IsZero() {
+		org.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO organizations (`+orgColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		org.Id.Hex(), org.Name, org.OwnerId.Hex(), org.UpdateTime, org.CreateTime)
+}
+
+func (s *orgStore) SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE organizations SET owner_id = ?, update_time = ? WHERE id = ? AND owner_id = ?`,
+		newOwn.Hex(), time.Now().Local(), id.Hex(), own.Hex())
+}
+
+type orgMemberStore struct {
+	base
+}
+
+func scanOrgMember(row scanner) (*model.OrgMember, error) {
+	member := &model.OrgMember{}
+	var id, orgId, userId string
+	var invitedBy sql.NullString
+	err := row.Scan(&id, &orgId, &userId, &member.Role, &invitedBy, &member.UpdateTime, &member.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	member.Id = parseId(sql.NullString{String: id, Valid: true})
+	member.OrgId = parseId(sql.NullString{String: orgId, Valid: true})
+	member.UserId = parseId(sql.NullString{String: userId, Valid: true})
+	member.InvitedBy = parseId(invitedBy)
+	return member, nil
+}
+
+func (s *orgMemberStore) Get(ctx context.Context, orgId, userId primitive.ObjectID) (*model.OrgMember, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanOrgMember(s.queryRow(ctx, conn, `SELECT `+orgMemberColumns+` FROM org_members WHERE org_id = ? AND user_id = ?`,
+		orgId.Hex(), userId.Hex()))
+}
+
+func (s *orgMemberStore) find(ctx context.Context, where string, args ...interface{}) ([]model.OrgMember, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.query(ctx, conn, `SELECT `+orgMemberColumns+` FROM org_members WHERE `+where+` ORDER BY create_time`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []model.OrgMember{}
+	for rows.Next() {
+		member, err := scanOrgMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *member)
+	}
+	return members, convertErr(rows.Err())
+}
+
+func (s *orgMemberStore) FindByOrg(ctx context.Context, orgId primitive.ObjectID) ([]model.OrgMember, error) {
+	return s.find(ctx, `org_id = ?`, orgId.Hex())
+}
+
+func (s *orgMemberStore) FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.OrgMember, error) {
+	return s.find(ctx, `user_id = ?`, userId.Hex())
+}
+
+func (s *orgMemberStore) Insert(ctx context.Context, member *model.OrgMember) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if member.Id.IsZero() {
+		member.Id = primitive.NewObjectID()
+	}
+	// org_id 和 user_id 有唯一索引，已经是成员的时候返回 ErrDuplicate
+	return s.insert(ctx, conn, `INSERT INTO org_members (`+orgMemberColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		member.Id.Hex(), member.OrgId.Hex(), member.UserId.Hex(), member.Role, nullId(member.InvitedBy),
+		member.UpdateTime, member.CreateTime)
+}
+
+func (s *orgMemberStore) SetRole(ctx context.Context, orgId, userId primitive.ObjectID, role string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE org_members SET role = ?, update_time = ? WHERE org_id = ? AND user_id = ?`,
+		role, time.Now().Local(), orgId.Hex(), userId.Hex())
+}
+
+func (s *orgMemberStore) Delete(ctx context.Context, orgId, userId primitive.ObjectID) error {
+	count, err := s.delete(ctx, `org_id = ? AND user_id = ?`, orgId.Hex(), userId.Hex())
+	if err == nil && count == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *orgMemberStore) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	return s.delete(ctx, `user_id = ?`, userId.Hex())
+}
+
+func (s *orgMemberStore) delete(ctx context.Context, where string, args ...interface{}) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	affected, err := s.exec(ctx, conn, "delete", `DELETE FROM org_members WHERE `+where, args...)
+	return int(affected), err
+}
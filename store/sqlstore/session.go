@@ -0,0 +1,125 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const sessionColumns = `id, user_id, refresh_hash, revoked, expire_time, device, ip, last_used_time, update_time, create_time`
+
+type sessionStore struct {
+	base
+}
+
+func scanSession(row scanner) (*model.Session, error) {
+	session := &model.Session{}
+	var id, userId string
+	var lastUsedTime sql.NullTime
+	err := row.Scan(&id, &userId, &session.RefreshHash, &session.Revoked, &session.ExpireTime,
+		&session.Device, &session.Ip, &lastUsedTime, &session.UpdateTime, &session.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	session.LastUsedTime = lastUsedTime.Time
+	session.Id = parseId(sql.NullString{String: id, Valid: true})
+	session.UserId = parseId(sql.NullString{String: userId, Valid: true})
+	return session, nil
+}
+
+func (s *sessionStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Session, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanSession(s.queryRow(ctx, conn, `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id.Hex()))
+}
+
+func (s *sessionStore) Insert(ctx context.Context, session *model.Session) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if session.Id.IsZero() {
+		session.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.Id.Hex(), session.UserId.Hex(), session.RefreshHash, session.Revoked, session.ExpireTime,
+		session.Device, session.Ip, nullTime(session.LastUsedTime), session.UpdateTime, session.CreateTime)
+}
+
+func (s *sessionStore) Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expireTime time.Time) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上旧的哈希，同一个刷新 token 并发使用的时候只有一个能成功
+	return s.update(ctx, conn, `UPDATE sessions SET refresh_hash = ?, expire_time = ?, update_time = ?
+		WHERE id = ? AND refresh_hash = ? AND revoked = FALSE`,
+		newHash, expireTime, time.Now().Local(), id.Hex(), oldHash)
+}
+
+func (s *sessionStore) FindActiveByUser(ctx context.Context, userId primitive.ObjectID, now time.Time) ([]model.Session, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.query(ctx, conn, `SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = ? AND revoked = FALSE AND expire_time > ?`, userId.Hex(), now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []model.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, convertErr(rows.Err())
+}
+
+func (s *sessionStore) Touch(ctx context.Context, id primitive.ObjectID, ip string, at time.Time) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE sessions SET ip = ?, last_used_time = ? WHERE id = ?`, ip, at, id.Hex())
+}
+
+func (s *sessionStore) Revoke(ctx context.Context, id, userId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE sessions SET revoked = TRUE, update_time = ? WHERE id = ? AND user_id = ?`,
+		time.Now().Local(), id.Hex(), userId.Hex())
+}
+
+func (s *sessionStore) RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	affected, err := s.exec(ctx, conn, "update", `UPDATE sessions SET revoked = TRUE, update_time = ? WHERE user_id = ? AND revoked = FALSE`,
+		time.Now().Local(), userId.Hex())
+	return int(affected), err
+}
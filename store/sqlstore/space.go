@@ -0,0 +1,146 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const spaceColumns = `id, own_id, parent_id, ancestors, space_type, name, description, update_time, create_time`
+
+type spaceStore struct {
+	base
+}
+
+func scanSpace(row scanner) (*model.Space, error) {
+	space := &model.Space{}
+	var id, own, ancestors string
+	var parentId sql.NullString
+	err := row.Scan(&id, &own, &parentId, &ancestors, &space.Type, &space.Name, &space.Desc,
+		&space.UpdateTime, &space.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	space.Id = parseId(sql.NullString{String: id, Valid: true})
+	space.Own = parseId(sql.NullString{String: own, Valid: true})
+	space.ParentId = parseId(parentId)
+	space.Ancestors = splitIds(ancestors)
+	return space, nil
+}
+
+func (s *spaceStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Space, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanSpace(s.queryRow(ctx, conn, `SELECT `+spaceColumns+` FROM spaces WHERE id = ?`, id.Hex()))
+}
+
+func (s *spaceStore) find(ctx context.Context, where string, args ...interface{}) ([]model.Space, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.query(ctx, conn, `SELECT `+spaceColumns+` FROM spaces WHERE `+where+` ORDER BY create_time`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	spaces := []model.Space{}
+	for rows.Next() {
+		space, err := scanSpace(rows)
+		if err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, *space)
+	}
+	return spaces, convertErr(rows.Err())
+}
+
+func (s *spaceStore) FindByOwner(ctx context.Context, own primitive.ObjectID) ([]model.Space, error) {
+	return s.find(ctx, `own_id = ?`, own.Hex())
+}
+
+func (s *spaceStore) FindSubtree(ctx context.Context, ids []primitive.ObjectID) ([]model.Space, error) {
+	if len(ids) == 0 {
+		return []model.Space{}, nil
+	}
+	// ancestors 是逗号分隔的 id，id 都是 24 位十六进制，按子串匹配不会匹配到别的 id
+	marks, args := inIds(ids)
+	where := []string{`id IN (` + marks + `)`}
+	for _, id := range ids {
+		where = append(where, `ancestors LIKE ?`)
+		args = append(args, "%"+id.Hex()+"%")
+	}
+	return s.find(ctx, strings.Join(where, " OR "), args...)
+}
+
+func (s *spaceStore) Insert(ctx context.Context, space *model.Space) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if space.Id.IsZero() {
+		space.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO spaces (`+spaceColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		space.Id.Hex(), space.Own.Hex(), nullId(space.ParentId), joinIds(space.Ancestors), space.Type, space.Name,
+		space.Desc, space.UpdateTime, space.CreateTime)
+}
+
+func (s *spaceStore) UpdateInfo(ctx context.Context, id, own primitive.ObjectID, name, desc string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 空值不修改
+	sets := "update_time = ?"
+	args := []interface{}{time.Now().Local()}
+	if name != "" {
+		sets += ", name = ?"
+		args = append(args, name)
+	}
+	if desc != "" {
+		sets += ", description = ?"
+		args = append(args, desc)
+	}
+	args = append(args, id.Hex(), own.Hex())
+	return s.update(ctx, conn, `UPDATE spaces SET `+sets+` WHERE id = ? AND own_id = ?`, args...)
+}
+
+func (s *spaceStore) Delete(ctx context.Context, id, own primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	affected, err := s.exec(ctx, conn, "delete", `DELETE FROM spaces WHERE id = ? AND own_id = ?`, id.Hex(), own.Hex())
+	if err == nil && affected == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *spaceStore) DeleteByOwner(ctx context.Context, own primitive.ObjectID) (int, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
+	affected, err := s.exec(ctx, conn, "delete", `DELETE FROM spaces WHERE own_id = ?`, own.Hex())
+	return int(affected), err
+}
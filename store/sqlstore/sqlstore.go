@@ -0,0 +1,170 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"ezlock/common/sqldb"
+	"ezlock/store"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 创建关系数据库实现的 Store，db 还没有连接上的时候所有操作都返回 sqldb.ErrNotConnected
+func New(db *sqldb.DB) *store.Store {
+	b := base{db}
+	return &store.Store{
+		Users:         &userStore{b},
+		Locks:         &lockStore{b},
+		Auths:         &authStore{b},
+		Cards:         &cardStore{b},
+		Logs:          &logStore{b},
+		Sessions:      &sessionStore{b},
+		VerifyCodes:   &verifyCodeStore{b},
+		LoginFailures: &loginFailureStore{b},
+		Audits:        &auditStore{b},
+		ApiKeys:       &apiKeyStore{b},
+		Transfers:     &transferStore{b},
+		Members:       &memberStore{b},
+		Spaces:        &spaceStore{b},
+		Orgs:          &orgStore{b},
+		OrgMembers:    &orgMemberStore{b},
+		Devices:       &deviceStore{b},
+		Challenges:    &deviceChallengeStore{b},
+	}
+}
+
+type base struct {
+	db *sqldb.DB
+}
+
+// 获取连接池，返回的 ctx 带上了单次操作的超时时间，用完需要调用 cancel
+func (b base) conn(ctx context.Context) (context.Context, context.CancelFunc, *sql.DB, error) {
+	return b.db.Conn(ctx)
+}
+
+// 查询单条记录
+func (b base) queryRow(ctx context.Context, conn *sql.DB, query string, args ...interface{}) *sql.Row {
+	ctx, done := b.db.Trace(ctx, "select", query)
+	row := conn.QueryRowContext(ctx, b.db.Rebind(query), args...)
+	done(row.Err())
+	return row
+}
+
+// 查询多条记录
+func (b base) query(ctx context.Context, conn *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := b.db.Trace(ctx, "select", query)
+	rows, err := conn.QueryContext(ctx, b.db.Rebind(query), args...)
+	done(err)
+	return rows, convertErr(err)
+}
+
+// 执行插入语句
+func (b base) insert(ctx context.Context, conn *sql.DB, query string, args ...interface{}) error {
+	ctx, done := b.db.Trace(ctx, "insert", query)
+	_, err := conn.ExecContext(ctx, b.db.Rebind(query), args...)
+	done(err)
+	return convertErr(err)
+}
+
+// 执行更新语句，没有更新任何记录的时候返回 store.ErrNotFound
+func (b base) update(ctx context.Context, conn *sql.DB, query string, args ...interface{}) error {
+	affected, err := b.exec(ctx, conn, "update", query, args...)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// 执行语句，返回影响的记录数
+func (b base) exec(ctx context.Context, conn *sql.DB, operation, query string, args ...interface{}) (int64, error) {
+	ctx, done := b.db.Trace(ctx, operation, query)
+	res, err := conn.ExecContext(ctx, b.db.Rebind(query), args...)
+	done(err)
+	if err != nil {
+		return 0, convertErr(err)
+	}
+	affected, err := res.RowsAffected()
+	return affected, convertErr(err)
+}
+
+// 把 database/sql 的错误转换为 store 的错误
+func convertErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows {
+		return store.ErrNotFound
+	}
+	if sqldb.IsDuplicate(err) {
+		return store.ErrDuplicate
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s", store.ErrTimeout, err.Error())
+	}
+	return err
+}
+
+// ObjectID 为空的时候存 NULL
+func nullId(id primitive.ObjectID) interface{} {
+	if id.IsZero() {
+		return nil
+	}
+	return id.Hex()
+}
+
+// 零值的时间写成 NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// 读取可能为 NULL 的 ObjectID
+func parseId(s sql.NullString) primitive.ObjectID {
+	if !s.Valid {
+		return primitive.NilObjectID
+	}
+	id, err := primitive.ObjectIDFromHex(s.String)
+	if err != nil {
+		return primitive.NilObjectID
+	}
+	return id
+}
+
+// 在查询条件的最后加上 ctx 里的租户，组织 id 追加在 args 后面，不按租户过滤的时候原样返回
+// 门锁、门卡、授权和日志表的所有语句都要经过这里
+func tenant(ctx context.Context, query string, args ...interface{}) (string, []interface{}) {
+	orgId, all := store.TenantOf(ctx)
+	if all {
+		return query, args
+	}
+	if orgId.IsZero() {
+		return query + ` AND org_id IS NULL`, args
+	}
+	return query + ` AND org_id = ?`, append(args, orgId.Hex())
+}
+
+// 把 ObjectID 列表转换成 in 查询的占位符和参数
+func inIds(ids []primitive.ObjectID) (string, []interface{}) {
+	marks := make([]byte, 0, len(ids)*2)
+	args := make([]interface{}, 0, len(ids))
+	for i, id := range ids {
+		if i > 0 {
+			marks = append(marks, ',')
+		}
+		marks = append(marks, '?')
+		args = append(args, id.Hex())
+	}
+	return string(marks), args
+}
+
+// 可以是 *sql.Row 或者 *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
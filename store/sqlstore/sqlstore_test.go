@@ -0,0 +1,60 @@
+package sqlstore_test
+
+import (
+	"context"
+	"ezlock/common/sqldb"
+	"ezlock/store"
+	"ezlock/store/sqlstore"
+	"ezlock/store/storetest"
+	"testing"
+	"time"
+)
+
+// 每个用例一个新的 sqlite 内存数据库，已经执行完所有迁移
+func newSqlite(t *testing.T) *sqldb.DB {
+	t.Helper()
+	db := openSqlite(t)
+	if _, err := sqlstore.Migrator(db).Up(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// 还没有执行过迁移的 sqlite 内存数据库
+func openSqlite(t *testing.T) *sqldb.DB {
+	t.Helper()
+	db, err := sqldb.New(sqldb.Options{
+		Driver:           sqldb.Sqlite,
+		Dsn:              ":memory:",
+		Timeout:          time.Second,
+		OperationTimeout: time.Second,
+		PoolLimit:        1,
+		RetryInterval:    time.Second,
+		MaxRetryInterval: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Connect(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close(context.Background()) })
+	return db
+}
+
+func TestSqliteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store {
+		return sqlstore.New(newSqlite(t))
+	})
+}
+
+func TestNotConnected(t *testing.T) {
+	db, err := sqldb.New(sqldb.Options{Driver: sqldb.Sqlite, Dsn: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sqlstore.New(db)
+	if _, err := s.Users.GetByOpenId(context.Background(), "open-1"); err != sqldb.ErrNotConnected {
+		t.Fatalf("expect ErrNotConnected, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+const transferColumns = `id, lock_id, from_id, to_phone, to_id, status, auths_revoked, wiped_cards,
+	expire_time, update_time, create_time`
+
+type transferStore struct {
+	base
+}
+
+func scanTransfer(row scanner) (*model.Transfer, error) {
+	transfer := &model.Transfer{}
+	var id, lockId, fromId, wipedCards string
+	var toId sql.NullString
+	err := row.Scan(&id, &lockId, &fromId, &transfer.ToPhone, &toId, &transfer.Status, &transfer.AuthsRevoked,
+		&wipedCards, &transfer.ExpireTime, &transfer.UpdateTime, &transfer.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	transfer.Id = parseId(sql.NullString{String: id, Valid: true})
+	transfer.LockId = parseId(sql.NullString{String: lockId, Valid: true})
+	transfer.FromId = parseId(sql.NullString{String: fromId, Valid: true})
+	transfer.ToId = parseId(toId)
+	transfer.WipedCards = splitList(wipedCards)
+	return transfer, nil
+}
+
+func (s *transferStore) Get(ctx context.Context, id primitive.ObjectID) (*model.Transfer, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanTransfer(s.queryRow(ctx, conn, `SELECT `+transferColumns+` FROM transfers WHERE id = ?`, id.Hex()))
+}
+
+func (s *transferStore) find(ctx context.Context, where string, args ...interface{}) ([]model.Transfer, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.query(ctx, conn, `SELECT `+transferColumns+` FROM transfers WHERE `+where+` ORDER BY create_time DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	transfers := []model.Transfer{}
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, *transfer)
+	}
+	return transfers, convertErr(rows.Err())
+}
+
+func (s *transferStore) FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Transfer, error) {
+	return s.find(ctx, `lock_id = ?`, lockId.Hex())
+}
+
+func (s *transferStore) FindByUser(ctx context.Context, userId primitive.ObjectID, phone string) ([]model.Transfer, error) {
+	if phone == "" {
+		return s.find(ctx, `from_id = ? OR to_id = ?`, userId.Hex(), userId.Hex())
+	}
+	return s.find(ctx, `from_id = ? OR to_id = ? OR to_phone = ?`, userId.Hex(), userId.Hex(), phone)
+}
+
+func (s *transferStore) Insert(ctx context.Context, transfer *model.Transfer) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if transfer.Id.IsZero() {
+		transfer.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO transfers (`+transferColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		transfer.Id.Hex(), transfer.LockId.Hex(), transfer.FromId.Hex(), transfer.ToPhone, nullId(transfer.ToId),
+		transfer.Status, transfer.AuthsRevoked, strings.Join(transfer.WipedCards, ","),
+		transfer.ExpireTime, transfer.UpdateTime, transfer.CreateTime)
+}
+
+func (s *transferStore) Accept(ctx context.Context, id, toId primitive.ObjectID, authsRevoked int, wipedCards []string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE transfers SET status = ?, to_id = ?, auths_revoked = ?, wiped_cards = ?, update_time = ?
+		WHERE id = ? AND status = ?`,
+		model.TransferAccepted, toId.Hex(), authsRevoked, strings.Join(wipedCards, ","), time.Now().Local(),
+		id.Hex(), model.TransferPending)
+}
+
+func (s *transferStore) Close(ctx context.Context, id primitive.ObjectID, status string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE transfers SET status = ?, update_time = ? WHERE id = ? AND status = ?`,
+		status, time.Now().Local(), id.Hex(), model.TransferPending)
+}
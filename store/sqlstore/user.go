@@ -0,0 +1,192 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const userColumns = `id, nick_name, open_id, union_id, session_key, phone_number, user_name, password_hash, role, disabled,
+	default_lock, gender, city, province, country, avatar_url, language, update_time, create_time`
+
+type userStore struct {
+	base
+}
+
+func scanUser(row scanner) (*model.User, error) {
+	user := &model.User{}
+	var id string
+	var defaultLock sql.NullString
+	err := row.Scan(&id, &user.NickName, &user.OpenId, &user.UnionId, &user.SessionKey, &user.PhoneNumber,
+		&user.UserName, &user.PasswordHash, &user.Role, &user.Disabled, &defaultLock, &user.Gender, &user.City,
+		&user.Province, &user.Country, &user.AvatarUrl, &user.Language, &user.UpdateTime, &user.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	user.Id = parseId(sql.NullString{String: id, Valid: true})
+	user.DefaultLock = parseId(defaultLock)
+	return user, nil
+}
+
+func (s *userStore) Get(ctx context.Context, id primitive.ObjectID) (*model.User, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanUser(s.queryRow(ctx, conn, `SELECT `+userColumns+` FROM users WHERE id = ?`, id.Hex()))
+}
+
+func (s *userStore) GetByOpenId(ctx context.Context, openId string) (*model.User, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanUser(s.queryRow(ctx, conn, `SELECT `+userColumns+` FROM users WHERE open_id = ?`, openId))
+}
+
+func (s *userStore) GetByPhone(ctx context.Context, phoneNumber string) (*model.User, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanUser(s.queryRow(ctx, conn, `SELECT `+userColumns+` FROM users WHERE phone_number = ?
+		ORDER BY create_time LIMIT 1`, phoneNumber))
+}
+
+func (s *userStore) GetByUserName(ctx context.Context, userName string) (*model.User, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanUser(s.queryRow(ctx, conn, `SELECT `+userColumns+` FROM users WHERE user_name = ?`, userName))
+}
+
+func (s *userStore) Insert(ctx context.Context, user *model.User) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if user.Id.IsZero() {
+		user.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.Id.Hex(), user.NickName, user.OpenId, user.UnionId, user.SessionKey, user.PhoneNumber,
+		user.UserName, user.PasswordHash, user.Role, user.Disabled, nullId(user.DefaultLock), user.Gender, user.City,
+		user.Province, user.Country, user.AvatarUrl, user.Language, user.UpdateTime, user.CreateTime)
+}
+
+func (s *userStore) UpdateProfile(ctx context.Context, user *model.User) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE users SET session_key = ?, nick_name = ?, union_id = ?, gender = ?, province = ?,
+		city = ?, country = ?, avatar_url = ?, language = ?, update_time = ? WHERE id = ?`,
+		user.SessionKey, user.NickName, user.UnionId, user.Gender, user.Province,
+		user.City, user.Country, user.AvatarUrl, user.Language, time.Now().Local(), user.Id.Hex())
+}
+
+func (s *userStore) SetPhoneNumber(ctx context.Context, id primitive.ObjectID, phoneNumber string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE users SET phone_number = ?, update_time = ? WHERE id = ?`,
+		phoneNumber, time.Now().Local(), id.Hex())
+}
+
+func (s *userStore) SetDefaultLock(ctx context.Context, id, lockId primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE users SET default_lock = ?, update_time = ? WHERE id = ?`,
+		nullId(lockId), time.Now().Local(), id.Hex())
+}
+
+func (s *userStore) SetPassword(ctx context.Context, id primitive.ObjectID, userName, passwordHash string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE users SET user_name = ?, password_hash = ?, update_time = ? WHERE id = ?`,
+		userName, passwordHash, time.Now().Local(), id.Hex())
+}
+
+func (s *userStore) FindByPhone(ctx context.Context, phoneNumber string) ([]model.User, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	rows, err := s.query(ctx, conn, `SELECT `+userColumns+` FROM users WHERE phone_number = ? ORDER BY create_time`, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, convertErr(rows.Err())
+}
+
+func (s *userStore) SetRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE users SET role = ?, update_time = ? WHERE id = ?`,
+		role, time.Now().Local(), id.Hex())
+}
+
+func (s *userStore) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE users SET disabled = ?, update_time = ? WHERE id = ?`,
+		disabled, time.Now().Local(), id.Hex())
+}
+
+func (s *userStore) Anonymize(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE users SET nick_name = ?, open_id = '', union_id = '', session_key = '',
+		phone_number = '', user_name = '', password_hash = '', default_lock = NULL, gender = 0, city = '',
+		province = '', country = '', avatar_url = '', language = '', disabled = TRUE, update_time = ? WHERE id = ?`,
+		model.DeletedNickName, time.Now().Local(), id.Hex())
+}
@@ -0,0 +1,71 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const verifyCodeColumns = `id, phone, code_hash, attempts, used, expire_time, create_time`
+
+type verifyCodeStore struct {
+	base
+}
+
+func scanVerifyCode(row scanner) (*model.VerifyCode, error) {
+	code := &model.VerifyCode{}
+	var id string
+	err := row.Scan(&id, &code.Phone, &code.CodeHash, &code.Attempts, &code.Used, &code.ExpireTime, &code.CreateTime)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	code.Id = parseId(sql.NullString{String: id, Valid: true})
+	return code, nil
+}
+
+func (s *verifyCodeStore) Insert(ctx context.Context, code *model.VerifyCode) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if code.Id.IsZero() {
+		code.Id = primitive.NewObjectID()
+	}
+	return s.insert(ctx, conn, `INSERT INTO verify_codes (`+verifyCodeColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		code.Id.Hex(), code.Phone, code.CodeHash, code.Attempts, code.Used, code.ExpireTime, code.CreateTime)
+}
+
+func (s *verifyCodeStore) Latest(ctx context.Context, phone string) (*model.VerifyCode, error) {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	return scanVerifyCode(s.queryRow(ctx, conn, `SELECT `+verifyCodeColumns+` FROM verify_codes WHERE phone = ?
+		ORDER BY create_time DESC LIMIT 1`, phone))
+}
+
+func (s *verifyCodeStore) AddAttempt(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return s.update(ctx, conn, `UPDATE verify_codes SET attempts = attempts + 1 WHERE id = ?`, id.Hex())
+}
+
+func (s *verifyCodeStore) Use(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel, conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// 条件里带上 used，同一个验证码并发登录的时候只有一个能成功
+	return s.update(ctx, conn, `UPDATE verify_codes SET used = TRUE WHERE id = ? AND used = FALSE`, id.Hex())
+}
@@ -0,0 +1,339 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"ezlock/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// 查询的记录不存在，各个实现都需要把自己的"没找到"错误转换成这个错误
+var ErrNotFound = errors.New("not found")
+
+// 违反唯一约束，比如重复绑定同一个mac地址的门锁
+var ErrDuplicate = errors.New("duplicate key")
+
+// 数据库操作超时，各个实现需要用 %w 包装这个错误，方便上层区分超时和其他错误
+var ErrTimeout = errors.New("database operation timeout")
+
+// 用户表的操作
+type UserStore interface {
+	// 根据id获取用户
+	Get(ctx context.Context, id primitive.ObjectID) (*model.User, error)
+	// 根据微信的 openId 获取用户
+	GetByOpenId(ctx context.Context, openId string) (*model.User, error)
+	// 根据手机号获取用户，多个用户使用同一个手机号的时候返回最早注册的
+	GetByPhone(ctx context.Context, phoneNumber string) (*model.User, error)
+	// 根据密码登录的用户名获取用户
+	GetByUserName(ctx context.Context, userName string) (*model.User, error)
+	// 新建用户
+	Insert(ctx context.Context, user *model.User) error
+	// 更新用户登录时微信返回的资料和 sessionKey
+	UpdateProfile(ctx context.Context, user *model.User) error
+	// 更新用户手机号
+	SetPhoneNumber(ctx context.Context, id primitive.ObjectID, phoneNumber string) error
+	// 设置用户默认锁
+	SetDefaultLock(ctx context.Context, id, lockId primitive.ObjectID) error
+	// 设置密码登录的用户名和密码哈希，用户名已经被其他用户使用的时候返回 ErrDuplicate
+	SetPassword(ctx context.Context, id primitive.ObjectID, userName, passwordHash string) error
+	// 获取绑定了手机号的所有用户
+	FindByPhone(ctx context.Context, phoneNumber string) ([]model.User, error)
+	// 设置用户角色
+	SetRole(ctx context.Context, id primitive.ObjectID, role string) error
+	// 禁用或者启用用户
+	SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error
+	// 注销用户，清除所有个人资料和登录方式并禁用，保留 id 以便历史数据关联
+	Anonymize(ctx context.Context, id primitive.ObjectID) error
+}
+
+// 门锁表的操作
+type LockStore interface {
+	// 根据id获取门锁
+	Get(ctx context.Context, id primitive.ObjectID) (*model.Lock, error)
+	// 根据mac地址获取门锁
+	GetByMac(ctx context.Context, mac string) (*model.Lock, error)
+	// 根据id列表获取门锁
+	FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Lock, error)
+	// 获取用户拥有的门锁 valid 为true只返回没有被删除的
+	FindByOwner(ctx context.Context, own primitive.ObjectID, valid bool) ([]model.Lock, error)
+	// 获取组织里所有的门锁 valid 为true只返回没有被删除的
+	FindByOrg(ctx context.Context, orgId primitive.ObjectID, valid bool) ([]model.Lock, error)
+	// 绑定新锁
+	Insert(ctx context.Context, lock *model.Lock) error
+	// 删除门锁记录，只用于绑定失败的时候回滚刚插入的门锁，其他时候只做逻辑删除
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// 重新启用已经删除的门锁，删除后再次绑定或者找回的时候使用，mac 有唯一索引不能再插入一条
+	// 名称、描述、版本和拥有者使用 lock 里的，组织使用请求所在的租户，空间清空，门锁没有被删除的时候返回 ErrNotFound
+	Revive(ctx context.Context, lock *model.Lock) error
+	// 修改门锁的名称和描述，只能修改属于own的并且没有被删除的锁，空值不修改
+	UpdateInfo(ctx context.Context, mac string, own primitive.ObjectID, name, desc string) error
+	// 逻辑删除属于own的门锁
+	Invalidate(ctx context.Context, mac string, own primitive.ObjectID) error
+	// 把属于 own 的有效门锁转给 newOwn，门锁不存在或者不属于 own 的时候返回 ErrNotFound
+	// 原拥有者的空间不属于新拥有者，门锁同时移出所在的空间
+	SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error
+	// 获取挂在这些空间下的所有门锁，包括已经删除的
+	FindBySpaces(ctx context.Context, spaceIds []primitive.ObjectID) ([]model.Lock, error)
+	// 把属于 own 的有效门锁放到空间里，spaceId 为空的时候移出空间
+	SetSpace(ctx context.Context, id, own, spaceId primitive.ObjectID) error
+	// 把空间里的门锁都移出空间，返回移出的数量，删除空间的时候使用
+	ClearSpace(ctx context.Context, spaceId primitive.ObjectID) (int, error)
+}
+
+// 授权表的操作
+type AuthStore interface {
+	// 根据id获取授权
+	Get(ctx context.Context, id primitive.ObjectID) (*model.Auth, error)
+	// 获取门锁上所有的授权，包括已经失效的
+	FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Auth, error)
+	// 获取某一把锁上 userId 发出或者收到的授权
+	FindByLockAndUser(ctx context.Context, lockId, userId primitive.ObjectID) ([]model.Auth, error)
+	// 获取授权给空间并且包括 spaceId 的授权，包括已经失效的
+	FindBySpace(ctx context.Context, spaceId primitive.ObjectID) ([]model.Auth, error)
+	// 获取用户发出的所有授权，包括已经失效的
+	FindBySender(ctx context.Context, sendId primitive.ObjectID) ([]model.Auth, error)
+	// 获取用户收到的授权 valid 为true只返回有效的，perms 中为true的权限必须具备
+	FindByReceiver(ctx context.Context, receiverId primitive.ObjectID, valid bool, perms model.Perms) ([]model.Auth, error)
+	// 新建授权
+	Insert(ctx context.Context, auth *model.Auth) error
+	// 领取授权，授权已经被领取的话返回 ErrNotFound
+	SetReceiver(ctx context.Context, id, receiverId primitive.ObjectID) error
+	// 把分享给手机号并且还没有被领取的有效授权设置给 receiverId，不包括 receiverId 自己发出的，返回领取的数量
+	ClaimByPhone(ctx context.Context, phone string, receiverId primitive.ObjectID) (int, error)
+	// 设置授权失效
+	Invalidate(ctx context.Context, id primitive.ObjectID) error
+	// 设置门锁上所有有效的授权失效，返回失效的数量
+	InvalidateByLock(ctx context.Context, lockId primitive.ObjectID) (int, error)
+	// 撤销 sendId 发出的授权
+	Revoke(ctx context.Context, id, sendId primitive.ObjectID) error
+}
+
+// 门卡表的操作
+type CardStore interface {
+	// 根据id获取门卡
+	Get(ctx context.Context, id primitive.ObjectID) (*model.Card, error)
+	// 获取门锁绑定的所有门卡，包括已经删除的
+	FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Card, error)
+	// 获取用户添加的所有门卡，包括已经删除的
+	FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Card, error)
+	// 添加门卡
+	Insert(ctx context.Context, card *model.Card) error
+	// 修改门卡的名称和描述，空值不修改
+	UpdateInfo(ctx context.Context, id primitive.ObjectID, name, desc string) error
+	// 根据卡号逻辑删除门锁上有效的门卡
+	InvalidateByNumber(ctx context.Context, lockId primitive.ObjectID, number string) error
+}
+
+// 开锁日志表的操作
+type LogStore interface {
+	// 获取门锁的开锁日志
+	FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Log, error)
+	// 获取用户的开锁日志
+	FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Log, error)
+	// 清除日志里的开锁用户，返回修改的数量，用户注销的时候使用
+	AnonymizeUser(ctx context.Context, userId primitive.ObjectID) (int, error)
+	// 写入日志，硬件可能重复上传，按照原始信息去重
+	Upsert(ctx context.Context, log *model.Log) error
+}
+
+// 登录会话表的操作
+type SessionStore interface {
+	// 根据id获取会话
+	Get(ctx context.Context, id primitive.ObjectID) (*model.Session, error)
+	// 新建会话
+	Insert(ctx context.Context, session *model.Session) error
+	// 轮换刷新 token，只有当前的哈希等于 oldHash 并且会话没有被撤销的时候才更新，否则返回 ErrNotFound
+	Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expireTime time.Time) error
+	// 获取用户没有撤销并且没有过期的会话
+	FindActiveByUser(ctx context.Context, userId primitive.ObjectID, now time.Time) ([]model.Session, error)
+	// 记录会话最近一次使用的 ip 和时间
+	Touch(ctx context.Context, id primitive.ObjectID, ip string, at time.Time) error
+	// 撤销 userId 的会话，之后这个会话的 token 都不能再使用
+	Revoke(ctx context.Context, id, userId primitive.ObjectID) error
+	// 撤销用户所有的会话，返回撤销的数量
+	RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error)
+}
+
+// 短信验证码表的操作
+type VerifyCodeStore interface {
+	// 写入新发送的验证码
+	Insert(ctx context.Context, code *model.VerifyCode) error
+	// 获取手机号最近一次发送的验证码
+	Latest(ctx context.Context, phone string) (*model.VerifyCode, error)
+	// 校验失败次数加一
+	AddAttempt(ctx context.Context, id primitive.ObjectID) error
+	// 标记验证码已经使用，已经使用过的返回 ErrNotFound，同一个验证码只能登录一次
+	Use(ctx context.Context, id primitive.ObjectID) error
+}
+
+// 密码登录失败记录的操作
+type LoginFailureStore interface {
+	// 获取失败记录
+	Get(ctx context.Context, key string) (*model.LoginFailure, error)
+	// 输错次数加一并返回加一之后的次数，没有记录或者记录已经过期的时候从一开始计数
+	AddFailure(ctx context.Context, key string, now, expireTime time.Time) (int, error)
+	// 锁定到 until 并且清零输错次数
+	Lock(ctx context.Context, key string, until time.Time) error
+	// 登录成功之后删除失败记录，没有记录的时候不返回错误
+	Reset(ctx context.Context, key string) error
+}
+
+// 管理操作审计表的操作
+type AuditStore interface {
+	// 写入审计记录
+	Insert(ctx context.Context, audit *model.Audit) error
+	// 更新审计记录的操作结果，没有找到的时候返回 ErrNotFound
+	SetOutcome(ctx context.Context, id primitive.ObjectID, outcome string) error
+	// 按照时间倒序获取审计记录，actorId 为空的时候返回所有管理员的，最多返回 limit 条
+	Find(ctx context.Context, actorId primitive.ObjectID, limit int) ([]model.Audit, error)
+}
+
+// 接口 key 表的操作
+type ApiKeyStore interface {
+	// 根据id获取接口 key
+	Get(ctx context.Context, id primitive.ObjectID) (*model.ApiKey, error)
+	// 获取用户没有撤销的接口 key
+	FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.ApiKey, error)
+	// 新建接口 key
+	Insert(ctx context.Context, key *model.ApiKey) error
+	// 轮换 userId 的接口 key，旧的 key 立即失效，已经撤销的返回 ErrNotFound
+	Rotate(ctx context.Context, id, userId primitive.ObjectID, keyHash string) error
+	// 撤销 userId 的接口 key
+	Revoke(ctx context.Context, id, userId primitive.ObjectID) error
+	// 撤销用户所有的接口 key，返回撤销的数量
+	RevokeByUser(ctx context.Context, userId primitive.ObjectID) (int, error)
+	// 记录接口 key 最近一次使用的时间
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+// 门锁转让记录表的操作
+type TransferStore interface {
+	// 根据id获取转让记录
+	Get(ctx context.Context, id primitive.ObjectID) (*model.Transfer, error)
+	// 按照发起时间倒序获取门锁的转让记录
+	FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Transfer, error)
+	// 按照发起时间倒序获取 userId 发起、接受的或者转给 phone 的转让记录，phone 为空的时候不按手机号查询
+	FindByUser(ctx context.Context, userId primitive.ObjectID, phone string) ([]model.Transfer, error)
+	// 发起转让
+	Insert(ctx context.Context, transfer *model.Transfer) error
+	// 接受等待中的转让，记录接收者、撤销的授权数量和删除的门卡，已经不是等待中的返回 ErrNotFound
+	Accept(ctx context.Context, id, toId primitive.ObjectID, authsRevoked int, wipedCards []string) error
+	// 把等待中的转让设置为拒绝、取消或者过期，已经不是等待中的返回 ErrNotFound
+	Close(ctx context.Context, id primitive.ObjectID, status string) error
+}
+
+// 门锁成员表的操作
+type MemberStore interface {
+	// 获取用户在门锁上的成员记录，不是成员的时候返回 ErrNotFound
+	Get(ctx context.Context, lockId, userId primitive.ObjectID) (*model.Member, error)
+	// 按照添加时间获取门锁的所有成员
+	FindByLock(ctx context.Context, lockId primitive.ObjectID) ([]model.Member, error)
+	// 获取用户加入的所有门锁的成员记录
+	FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.Member, error)
+	// 添加成员，已经是成员的时候返回 ErrDuplicate
+	Insert(ctx context.Context, member *model.Member) error
+	// 修改成员的角色
+	SetRole(ctx context.Context, lockId, userId primitive.ObjectID, role string) error
+	// 移除成员
+	Delete(ctx context.Context, lockId, userId primitive.ObjectID) error
+	// 移除门锁的所有成员，返回移除的数量
+	DeleteByLock(ctx context.Context, lockId primitive.ObjectID) (int, error)
+	// 移除用户加入的所有门锁的成员记录，返回移除的数量
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error)
+}
+
+// 空间表的操作
+type SpaceStore interface {
+	// 根据id获取空间
+	Get(ctx context.Context, id primitive.ObjectID) (*model.Space, error)
+	// 获取用户拥有的所有空间
+	FindByOwner(ctx context.Context, own primitive.ObjectID) ([]model.Space, error)
+	// 获取这些空间和它们所有的下层空间，不存在的空间忽略
+	FindSubtree(ctx context.Context, ids []primitive.ObjectID) ([]model.Space, error)
+	// 新建空间
+	Insert(ctx context.Context, space *model.Space) error
+	// 修改属于 own 的空间的名称和描述，空值不修改
+	UpdateInfo(ctx context.Context, id, own primitive.ObjectID, name, desc string) error
+	// 删除属于 own 的空间，不检查下层空间
+	Delete(ctx context.Context, id, own primitive.ObjectID) error
+	// 删除用户所有的空间，返回删除的数量，用户注销的时候使用
+	DeleteByOwner(ctx context.Context, own primitive.ObjectID) (int, error)
+}
+
+// 组织表的操作
+type OrganizationStore interface {
+	// 根据id获取组织
+	Get(ctx context.Context, id primitive.ObjectID) (*model.Organization, error)
+	// 根据id列表获取组织
+	FindByIds(ctx context.Context, ids []primitive.ObjectID) ([]model.Organization, error)
+	// 新建组织
+	Insert(ctx context.Context, org *model.Organization) error
+	// 把组织移交给新的创建者，当前创建者不是 own 的时候返回 ErrNotFound
+	SetOwner(ctx context.Context, id, own, newOwn primitive.ObjectID) error
+}
+
+// 组织成员表的操作
+type OrgMemberStore interface {
+	// 获取用户在组织里的成员记录，不是成员的时候返回 ErrNotFound
+	Get(ctx context.Context, orgId, userId primitive.ObjectID) (*model.OrgMember, error)
+	// 按照添加时间获取组织的所有成员
+	FindByOrg(ctx context.Context, orgId primitive.ObjectID) ([]model.OrgMember, error)
+	// 获取用户加入的所有组织的成员记录
+	FindByUser(ctx context.Context, userId primitive.ObjectID) ([]model.OrgMember, error)
+	// 添加成员，已经是成员的时候返回 ErrDuplicate
+	Insert(ctx context.Context, member *model.OrgMember) error
+	// 修改成员的角色
+	SetRole(ctx context.Context, orgId, userId primitive.ObjectID, role string) error
+	// 移除成员
+	Delete(ctx context.Context, orgId, userId primitive.ObjectID) error
+	// 移除用户加入的所有组织的成员记录，返回移除的数量
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int, error)
+}
+
+// 出厂设备表的操作，不按租户隔离
+type DeviceStore interface {
+	// 根据mac地址获取设备
+	GetByMac(ctx context.Context, mac string) (*model.Device, error)
+	// 导入设备，mac 或者序列号已经存在的时候返回 ErrDuplicate
+	Insert(ctx context.Context, device *model.Device) error
+	// 记录设备已经绑定到门锁，清空输错次数，已经被认领的时候返回 ErrNotFound
+	Claim(ctx context.Context, id, lockId, userId primitive.ObjectID) error
+	// 已经认领的设备被新的用户重新绑定或者找回，记录新的认领用户和时间，没有被认领的时候返回 ErrNotFound
+	Reclaim(ctx context.Context, id, userId primitive.ObjectID) error
+	// 认领码输错一次，累加输错次数
+	RecordFailure(ctx context.Context, id primitive.ObjectID) error
+	// 更换认领码并清空输错次数
+	SetClaimCode(ctx context.Context, id primitive.ObjectID, claimCodeHash string) error
+}
+
+// 门锁挑战表的操作，每个挑战只能提交一次应答
+type DeviceChallengeStore interface {
+	// 写入新签发的挑战
+	Insert(ctx context.Context, challenge *model.DeviceChallenge) error
+	// 标记签发给 userId 和 mac 的挑战已经使用，不存在、已经使用或者过期的时候返回 ErrNotFound
+	Use(ctx context.Context, id, userId primitive.ObjectID, mac string) error
+}
+
+// 所有表的操作集合，controller 通过它访问数据
+// 所有操作都接收请求的 ctx，客户端断开或者超时的时候数据库操作会一起中止
+// 门锁、门卡、授权和日志按照 ctx 里的租户隔离，参考 WithTenant
+type Store struct {
+	Users         UserStore
+	Locks         LockStore
+	Auths         AuthStore
+	Cards         CardStore
+	Logs          LogStore
+	Sessions      SessionStore
+	VerifyCodes   VerifyCodeStore
+	LoginFailures LoginFailureStore
+	Audits        AuditStore
+	ApiKeys       ApiKeyStore
+	Transfers     TransferStore
+	Members       MemberStore
+	Spaces        SpaceStore
+	Orgs          OrganizationStore
+	OrgMembers    OrgMemberStore
+	Devices       DeviceStore
+	Challenges    DeviceChallengeStore
+}
@@ -0,0 +1,873 @@
+package storetest
+
+import (
+	"context"
+	"ezlock/model"
+	"ezlock/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+// store 各个实现共用的测试用例，每个用例使用一份新的空数据
+func Run(t *testing.T, newStore func(t *testing.T) *store.Store) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newStore(t))
+		})
+	}
+}
+
+// 用例里访问 store 使用的 context
+var ctx = context.Background()
+
+var cases = []struct {
+	name string
+	run  func(t *testing.T, s *store.Store)
+}{
+	{"UserInsertAndGet", testUserInsertAndGet},
+	{"UserUpdate", testUserUpdate},
+	{"LockMacUnique", testLockMacUnique},
+	{"LockOwnerOnly", testLockOwnerOnly},
+	{"AuthReceiverClaimedOnce", testAuthReceiverClaimedOnce},
+	{"AuthFindByReceiver", testAuthFindByReceiver},
+	{"CardInvalidateByNumber", testCardInvalidateByNumber},
+	{"LogUpsertDedup", testLogUpsertDedup},
+	{"UserPhoneAndUserName", testUserPhoneAndUserName},
+	{"LoginFailureLockout", testLoginFailureLockout},
+	{"UserRoleAndDisabled", testUserRoleAndDisabled},
+	{"AuditFindAndOutcome", testAuditFindAndOutcome},
+	{"ApiKeyRotateAndRevoke", testApiKeyRotateAndRevoke},
+	{"UserDataTransferAndAnonymize", testUserDataTransferAndAnonymize},
+	{"AuthClaimByPhone", testAuthClaimByPhone},
+	{"AuthInvalidateByLock", testAuthInvalidateByLock},
+	{"TenantScoping", testTenantScoping},
+	{"DeviceClaimOnce", testDeviceClaimOnce},
+	{"LockDeleteAndRevive", testLockDeleteAndRevive},
+	{"DeviceChallengeUseOnce", testDeviceChallengeUseOnce},
+	{"InsertExistingId", testInsertExistingId},
+	{"CanceledContext", testCanceledContext},
+}
+
+func newUser(t *testing.T, s *store.Store, openId string) *model.User {
+	t.Helper()
+	user := &model.User{
+		Id:         primitive.NewObjectID(),
+		OpenId:     openId,
+		NickName:   openId,
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	if err := s.Users.Insert(ctx, user); err != nil {
+		t.Fatalf("insert user %s: %s", openId, err.Error())
+	}
+	return user
+}
+
+func newLock(t *testing.T, s *store.Store, own primitive.ObjectID, mac string) *model.Lock {
+	t.Helper()
+	lock := &model.Lock{
+		Name:       "front door",
+		Mac:        mac,
+		Key:        "0123456789abcdef",
+		Own:        own,
+		Valid:      true,
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	if err := s.Locks.Insert(ctx, lock); err != nil {
+		t.Fatalf("insert lock %s: %s", mac, err.Error())
+	}
+	return lock
+}
+
+func newAuth(t *testing.T, s *store.Store, lock *model.Lock, perms model.Perms) *model.Auth {
+	t.Helper()
+	auth := &model.Auth{
+		Perms:      perms,
+		Id:         primitive.NewObjectID(),
+		SendId:     lock.Own,
+		LockId:     lock.Id,
+		AuthType:   "1",
+		Valid:      true,
+		UpdateTime: time.Now().Local(),
+		CreateTime: time.Now().Local(),
+	}
+	if err := s.Auths.Insert(ctx, auth); err != nil {
+		t.Fatalf("insert auth: %s", err.Error())
+	}
+	return auth
+}
+
+func testUserInsertAndGet(t *testing.T, s *store.Store) {
+	user := newUser(t, s, "open-1")
+	got, err := s.Users.Get(ctx, user.Id)
+	if err != nil || got.OpenId != "open-1" {
+		t.Fatalf("get user: %+v, %v", got, err)
+	}
+	got, err = s.Users.GetByOpenId(ctx, "open-1")
+	if err != nil || got.Id != user.Id {
+		t.Fatalf("get user by openId: %+v, %v", got, err)
+	}
+	if _, err := s.Users.Get(ctx, primitive.NewObjectID()); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if _, err := s.Users.GetByOpenId(ctx, "open-2"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func testUserUpdate(t *testing.T, s *store.Store) {
+	user := newUser(t, s, "open-1")
+	user.NickName = "renamed"
+	user.SessionKey = "session"
+	if err := s.Users.UpdateProfile(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.SetPhoneNumber(ctx, user.Id, "13800000001"); err != nil {
+		t.Fatal(err)
+	}
+	lockId := primitive.NewObjectID()
+	if err := s.Users.SetDefaultLock(ctx, user.Id, lockId); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Users.Get(ctx, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NickName != "renamed" || got.SessionKey != "session" || got.PhoneNumber != "13800000001" || got.DefaultLock != lockId {
+		t.Fatalf("unexpected user %+v", got)
+	}
+	if err := s.Users.SetPhoneNumber(ctx, primitive.NewObjectID(), "13800000001"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func testLockMacUnique(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	if lock.Id.IsZero() {
+		t.Fatal("insert should assign an id")
+	}
+	// 同一个 mac 的门锁只能绑定一次
+	err := s.Locks.Insert(ctx, &model.Lock{Mac: lock.Mac, Own: own, Valid: true})
+	if err != store.ErrDuplicate {
+		t.Fatalf("expect ErrDuplicate, got %v", err)
+	}
+	got, err := s.Locks.GetByMac(ctx, lock.Mac)
+	if err != nil || got.Id != lock.Id {
+		t.Fatalf("get lock by mac: %+v, %v", got, err)
+	}
+	locks, err := s.Locks.FindByIds(ctx, []primitive.ObjectID{lock.Id, primitive.NewObjectID()})
+	if err != nil || len(locks) != 1 {
+		t.Fatalf("find locks by ids: %+v, %v", locks, err)
+	}
+}
+
+func testLockOwnerOnly(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	other := newUser(t, s, "open-2").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	newLock(t, s, own, "AA:00:00:00:00:02")
+
+	if err := s.Locks.UpdateInfo(ctx, lock.Mac, other, "stolen", ""); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.Locks.UpdateInfo(ctx, lock.Mac, own, "back door", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Locks.Invalidate(ctx, lock.Mac, other); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.Locks.Invalidate(ctx, lock.Mac, own); err != nil {
+		t.Fatal(err)
+	}
+	// 删除以后不能再修改
+	if err := s.Locks.UpdateInfo(ctx, lock.Mac, own, "front door", ""); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+
+	got, err := s.Locks.Get(ctx, lock.Id)
+	if err != nil || got.Name != "back door" || got.Desc != "" || got.Valid {
+		t.Fatalf("unexpected lock %+v, %v", got, err)
+	}
+	all, err := s.Locks.FindByOwner(ctx, own, false)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("find all locks: %+v, %v", all, err)
+	}
+	valid, err := s.Locks.FindByOwner(ctx, own, true)
+	if err != nil || len(valid) != 1 {
+		t.Fatalf("find valid locks: %+v, %v", valid, err)
+	}
+}
+
+func testAuthReceiverClaimedOnce(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	friend := newUser(t, s, "open-2").Id
+	stranger := newUser(t, s, "open-3").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	auth := newAuth(t, s, lock, model.Perms{})
+
+	if err := s.Auths.SetReceiver(ctx, auth.Id, friend); err != nil {
+		t.Fatal(err)
+	}
+	// 授权只能被领取一次
+	if err := s.Auths.SetReceiver(ctx, auth.Id, stranger); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	auths, err := s.Auths.FindByLockAndUser(ctx, lock.Id, friend)
+	if err != nil || len(auths) != 1 || auths[0].ReceiverId != friend {
+		t.Fatalf("find auths: %+v, %v", auths, err)
+	}
+
+	// 只有发出者可以撤销
+	if err := s.Auths.Revoke(ctx, auth.Id, friend); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.Auths.Revoke(ctx, auth.Id, own); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Auths.Get(ctx, auth.Id)
+	if err != nil || got.Valid {
+		t.Fatalf("auth should be revoked: %+v, %v", got, err)
+	}
+}
+
+func testAuthFindByReceiver(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	friend := newUser(t, s, "open-2").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	viewer := newAuth(t, s, lock, model.Perms{ViewLog: true})
+	plain := newAuth(t, s, lock, model.Perms{})
+	for _, auth := range []*model.Auth{viewer, plain} {
+		if err := s.Auths.SetReceiver(ctx, auth.Id, friend); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Auths.Invalidate(ctx, plain.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		valid bool
+		perms model.Perms
+		want  int
+	}{
+		{"all", false, model.Perms{}, 2},
+		{"valid", true, model.Perms{}, 1},
+		{"viewLog", false, model.Perms{ViewLog: true}, 1},
+		{"addCard", false, model.Perms{AddCard: true}, 0},
+	}
+	for _, tt := range tests {
+		auths, err := s.Auths.FindByReceiver(ctx, friend, tt.valid, tt.perms)
+		if err != nil || len(auths) != tt.want {
+			t.Fatalf("%s: expect %d auths, got %d, %v", tt.name, tt.want, len(auths), err)
+		}
+	}
+}
+
+func testCardInvalidateByNumber(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	card := &model.Card{Number: "12345678", Lock: lock.Id, UserId: own, Valid: true}
+	if err := s.Cards.Insert(ctx, card); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cards.UpdateInfo(ctx, card.Id, "blue card", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cards.InvalidateByNumber(ctx, lock.Id, "87654321"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.Cards.InvalidateByNumber(ctx, lock.Id, card.Number); err != nil {
+		t.Fatal(err)
+	}
+	// 已经删除的门卡不能再删除
+	if err := s.Cards.InvalidateByNumber(ctx, lock.Id, card.Number); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	cards, err := s.Cards.FindByLock(ctx, lock.Id)
+	if err != nil || len(cards) != 1 || cards[0].Valid || cards[0].Name != "blue card" {
+		t.Fatalf("unexpected cards %+v, %v", cards, err)
+	}
+}
+
+func testLogUpsertDedup(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	// 硬件重复上传的同一条日志只保存一次
+	for _, raw := range []string{"open_1", "open_2", "open_1"} {
+		log := &model.Log{LockId: lock.Id, UserId: own, OpenType: "1", Success: true, RowInfo: raw, CreateTime: time.Now().Local()}
+		if err := s.Logs.Upsert(ctx, log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logs, err := s.Logs.FindByLock(ctx, lock.Id)
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("expect 2 logs, got %+v, %v", logs, err)
+	}
+}
+
+func testUserPhoneAndUserName(t *testing.T, s *store.Store) {
+	first := newUser(t, s, "open-1")
+	second := newUser(t, s, "open-2")
+	// 短信和密码注册的用户没有 openId，不能违反 openId 的唯一约束
+	for _, phone := range []string{"13800000002", "13800000003"} {
+		user := &model.User{Id: primitive.NewObjectID(), PhoneNumber: phone, UpdateTime: time.Now().Local(), CreateTime: time.Now().Local()}
+		if err := s.Users.Insert(ctx, user); err != nil {
+			t.Fatalf("insert user without openId: %s", err.Error())
+		}
+	}
+	for _, user := range []*model.User{first, second} {
+		if err := s.Users.SetPhoneNumber(ctx, user.Id, "13800000001"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := s.Users.GetByPhone(ctx, "13800000001"); err != nil || got.Id != first.Id {
+		t.Fatalf("expect the earliest user, got %+v, %v", got, err)
+	}
+	if _, err := s.Users.GetByPhone(ctx, "13800000009"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+
+	if err := s.Users.SetPassword(ctx, first.Id, "alice", "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.SetPassword(ctx, second.Id, "alice", "hash-2"); err != store.ErrDuplicate {
+		t.Fatalf("expect ErrDuplicate, got %v", err)
+	}
+	got, err := s.Users.GetByUserName(ctx, "alice")
+	if err != nil || got.Id != first.Id || got.PasswordHash != "hash-1" {
+		t.Fatalf("get user by name: %+v, %v", got, err)
+	}
+	if _, err := s.Users.GetByUserName(ctx, "bob"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func testLoginFailureLockout(t *testing.T, s *store.Store) {
+	now := time.Now().Local()
+	expire := now.Add(time.Minute)
+	if _, err := s.LoginFailures.Get(ctx, "name:alice"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	for want := 1; want <= 3; want++ {
+		failures, err := s.LoginFailures.AddFailure(ctx, "name:alice", now, expire)
+		if err != nil || failures != want {
+			t.Fatalf("expect %d failures, got %d, %v", want, failures, err)
+		}
+	}
+	// 其他用户名单独计数
+	if failures, err := s.LoginFailures.AddFailure(ctx, "name:bob", now, expire); err != nil || failures != 1 {
+		t.Fatalf("expect 1 failure for bob, got %d, %v", failures, err)
+	}
+	// 上一次输错已经过期，重新计数
+	later := expire.Add(time.Second)
+	if failures, err := s.LoginFailures.AddFailure(ctx, "name:alice", later, later.Add(time.Minute)); err != nil || failures != 1 {
+		t.Fatalf("expect counting restarted, got %d, %v", failures, err)
+	}
+
+	until := later.Add(time.Minute)
+	if err := s.LoginFailures.Lock(ctx, "name:alice", until); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.LoginFailures.Get(ctx, "name:alice")
+	if err != nil || got.Failures != 0 || !got.LockedUntil.Equal(until) {
+		t.Fatalf("expect locked with no failures, got %+v, %v", got, err)
+	}
+	if err := s.LoginFailures.Lock(ctx, "name:carol", until); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+
+	if err := s.LoginFailures.Reset(ctx, "name:alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoginFailures.Reset(ctx, "name:alice"); err != nil {
+		t.Fatalf("reset twice: %v", err)
+	}
+	if _, err := s.LoginFailures.Get(ctx, "name:alice"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound after reset, got %v", err)
+	}
+}
+
+// 复制数据的工具可以重复执行，已经存在的记录需要返回 ErrDuplicate
+func testUserRoleAndDisabled(t *testing.T, s *store.Store) {
+	user := newUser(t, s, "open-1")
+	if user.GetRole() != model.RoleUser {
+		t.Fatalf("expect default role %s, got %q", model.RoleUser, user.GetRole())
+	}
+	if err := s.Users.SetRole(ctx, user.Id, model.RoleSupport); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.SetDisabled(ctx, user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Users.Get(ctx, user.Id)
+	if err != nil || got.GetRole() != model.RoleSupport || !got.Disabled {
+		t.Fatalf("get user: %+v, %v", got, err)
+	}
+	if err := s.Users.SetRole(ctx, primitive.NewObjectID(), model.RoleAdmin); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func testAuditFindAndOutcome(t *testing.T, s *store.Store) {
+	actor := primitive.NewObjectID()
+	now := time.Now().Local().Truncate(time.Millisecond)
+	var audits []*model.Audit
+	for i, actorId := range []primitive.ObjectID{actor, {}, actor} {
+		audit := &model.Audit{
+			ActorId:    actorId,
+			ActorRole:  model.RoleAdmin,
+			Action:     "disable_user",
+			TargetType: "user",
+			TargetId:   primitive.NewObjectID().Hex(),
+			Outcome:    model.AuditPending,
+			CreateTime: now.Add(time.Duration(i) * time.Second),
+		}
+		if err := s.Audits.Insert(ctx, audit); err != nil {
+			t.Fatal(err)
+		}
+		audits = append(audits, audit)
+	}
+	if err := s.Audits.SetOutcome(ctx, audits[0].Id, model.AuditSucceeded); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Audits.SetOutcome(ctx, primitive.NewObjectID(), model.AuditFailed); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+
+	// 按照时间倒序，actorId 为空的时候返回全部
+	got, err := s.Audits.Find(ctx, actor, 10)
+	if err != nil || len(got) != 2 || got[0].Id != audits[2].Id || got[1].Id != audits[0].Id {
+		t.Fatalf("find by actor: %+v, %v", got, err)
+	}
+	if got[1].Outcome != model.AuditSucceeded || got[0].Outcome != model.AuditPending {
+		t.Fatalf("unexpected outcome %q and %q", got[1].Outcome, got[0].Outcome)
+	}
+	if got, err := s.Audits.Find(ctx, primitive.NilObjectID, 2); err != nil || len(got) != 2 || got[0].Id != audits[2].Id {
+		t.Fatalf("find all with limit: %+v, %v", got, err)
+	}
+}
+
+func testApiKeyRotateAndRevoke(t *testing.T, s *store.Store) {
+	owner := newUser(t, s, "open-1")
+	other := newUser(t, s, "open-2")
+	lockId := primitive.NewObjectID()
+	var keys []*model.ApiKey
+	for i := 0; i < 3; i++ {
+		key := &model.ApiKey{
+			Id:         primitive.NewObjectID(),
+			UserId:     owner.Id,
+			Name:       "key",
+			KeyHash:    "hash",
+			Scopes:     []string{model.ScopeLockRead, model.ScopeLogRead},
+			LockIds:    []primitive.ObjectID{lockId},
+			UpdateTime: time.Now().Local(),
+			CreateTime: time.Now().Local(),
+		}
+		if err := s.ApiKeys.Insert(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	got, err := s.ApiKeys.Get(ctx, keys[0].Id)
+	if err != nil || len(got.Scopes) != 2 || !got.HasScope(model.ScopeLogRead) || len(got.LockIds) != 1 || got.LockIds[0] != lockId {
+		t.Fatalf("get api key: %+v, %v", got, err)
+	}
+
+	// 不能轮换和撤销别人的 key
+	if err := s.ApiKeys.Rotate(ctx, keys[0].Id, other.Id, "hash-2"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.ApiKeys.Revoke(ctx, keys[0].Id, other.Id); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.ApiKeys.Rotate(ctx, keys[0].Id, owner.Id, "hash-2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ApiKeys.Get(ctx, keys[0].Id); err != nil || got.KeyHash != "hash-2" {
+		t.Fatalf("rotate api key: %+v, %v", got, err)
+	}
+
+	if err := s.ApiKeys.Revoke(ctx, keys[0].Id, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ApiKeys.Rotate(ctx, keys[0].Id, owner.Id, "hash-3"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound after revoke, got %v", err)
+	}
+	if found, err := s.ApiKeys.FindByUser(ctx, owner.Id); err != nil || len(found) != 2 {
+		t.Fatalf("find by user: %+v, %v", found, err)
+	}
+	if count, err := s.ApiKeys.RevokeByUser(ctx, owner.Id); err != nil || count != 2 {
+		t.Fatalf("revoke by user: %d, %v", count, err)
+	}
+	if got, err := s.ApiKeys.Get(ctx, keys[2].Id); err != nil || !got.Revoked {
+		t.Fatalf("key should be revoked: %+v, %v", got, err)
+	}
+}
+
+func testUserDataTransferAndAnonymize(t *testing.T, s *store.Store) {
+	user := newUser(t, s, "open-1")
+	heir := newUser(t, s, "open-2")
+	if err := s.Users.SetPhoneNumber(ctx, user.Id, "13800000001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.SetPassword(ctx, user.Id, "alice", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	lock := newLock(t, s, user.Id, "AA:00:00:00:00:01")
+	auth := newAuth(t, s, lock, model.Perms{})
+	if err := s.Auths.Invalidate(ctx, auth.Id); err != nil {
+		t.Fatal(err)
+	}
+	newAuth(t, s, lock, model.Perms{})
+	card := &model.Card{Number: "12345678", Lock: lock.Id, UserId: user.Id, Valid: true, UpdateTime: time.Now().Local(), CreateTime: time.Now().Local()}
+	if err := s.Cards.Insert(ctx, card); err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{"open_1", "open_2"} {
+		log := &model.Log{LockId: lock.Id, UserId: user.Id, OpenType: "1", Success: true, RowInfo: raw, CreateTime: time.Now().Local()}
+		if err := s.Logs.Upsert(ctx, log); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 导出的时候包括已经失效的授权
+	if auths, err := s.Auths.FindBySender(ctx, user.Id); err != nil || len(auths) != 2 {
+		t.Fatalf("find by sender: %+v, %v", auths, err)
+	}
+	if cards, err := s.Cards.FindByUser(ctx, user.Id); err != nil || len(cards) != 1 || cards[0].Number != card.Number {
+		t.Fatalf("find cards by user: %+v, %v", cards, err)
+	}
+	if logs, err := s.Logs.FindByUser(ctx, user.Id); err != nil || len(logs) != 2 {
+		t.Fatalf("find logs by user: %+v, %v", logs, err)
+	}
+
+	// 只有拥有者可以转让门锁
+	if err := s.Locks.SetOwner(ctx, lock.Id, heir.Id, user.Id); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.Locks.SetOwner(ctx, lock.Id, user.Id, heir.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Locks.Get(ctx, lock.Id); err != nil || got.Own != heir.Id {
+		t.Fatalf("lock not transferred: %+v, %v", got, err)
+	}
+
+	if count, err := s.Logs.AnonymizeUser(ctx, user.Id); err != nil || count != 2 {
+		t.Fatalf("anonymize logs: %d, %v", count, err)
+	}
+	if logs, err := s.Logs.FindByUser(ctx, user.Id); err != nil || len(logs) != 0 {
+		t.Fatalf("logs should be anonymized: %+v, %v", logs, err)
+	}
+	if err := s.Users.Anonymize(ctx, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Users.Get(ctx, user.Id)
+	if err != nil || !got.Disabled || got.PhoneNumber != "" || got.UserName != "" || got.PasswordHash != "" || got.NickName != model.DeletedNickName {
+		t.Fatalf("user not anonymized: %+v, %v", got, err)
+	}
+	if _, err := s.Users.GetByUserName(ctx, "alice"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound after anonymize, got %v", err)
+	}
+	if err := s.Users.Anonymize(ctx, primitive.NewObjectID()); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func testAuthClaimByPhone(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	receiver := newUser(t, s, "open-2").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	phoneAuth := func(phone string) *model.Auth {
+		auth := &model.Auth{
+			Id:         primitive.NewObjectID(),
+			SendId:     own,
+			LockId:     lock.Id,
+			AuthType:   "1",
+			Phone:      phone,
+			Valid:      true,
+			UpdateTime: time.Now().Local(),
+			CreateTime: time.Now().Local(),
+		}
+		if err := s.Auths.Insert(ctx, auth); err != nil {
+			t.Fatal(err)
+		}
+		return auth
+	}
+	pending := phoneAuth("13800000002")
+	other := phoneAuth("13800000003")
+	revoked := phoneAuth("13800000002")
+	if err := s.Auths.Invalidate(ctx, revoked.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// 拥有者不能领取自己发出的授权
+	if count, err := s.Auths.ClaimByPhone(ctx, "13800000002", own); err != nil || count != 0 {
+		t.Fatalf("claim own auth: %d, %v", count, err)
+	}
+	if count, err := s.Auths.ClaimByPhone(ctx, "13800000002", receiver); err != nil || count != 1 {
+		t.Fatalf("claim by phone: %d, %v", count, err)
+	}
+	// 已经领取的授权不会再被领取
+	if count, err := s.Auths.ClaimByPhone(ctx, "13800000002", primitive.NewObjectID()); err != nil || count != 0 {
+		t.Fatalf("claim again: %d, %v", count, err)
+	}
+	for _, c := range []struct {
+		auth     *model.Auth
+		receiver primitive.ObjectID
+	}{{pending, receiver}, {other, primitive.NilObjectID}, {revoked, primitive.NilObjectID}} {
+		got, err := s.Auths.Get(ctx, c.auth.Id)
+		if err != nil || got.ReceiverId != c.receiver {
+			t.Fatalf("auth %s: %+v, %v", c.auth.Phone, got, err)
+		}
+	}
+}
+
+func testAuthInvalidateByLock(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	other := newLock(t, s, own, "AA:00:00:00:00:02")
+	newAuth(t, s, lock, model.Perms{})
+	newAuth(t, s, lock, model.Perms{})
+	revoked := newAuth(t, s, lock, model.Perms{})
+	kept := newAuth(t, s, other, model.Perms{})
+	if err := s.Auths.Invalidate(ctx, revoked.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// 已经失效的授权不计数，其他门锁的授权不受影响
+	count, err := s.Auths.InvalidateByLock(ctx, lock.Id)
+	if err != nil || count != 2 {
+		t.Fatalf("expect 2 auths invalidated, got %d, %v", count, err)
+	}
+	auths, err := s.Auths.FindByLock(ctx, lock.Id)
+	if err != nil || len(auths) != 3 {
+		t.Fatalf("unexpected auths %+v, %v", auths, err)
+	}
+	for _, auth := range auths {
+		if auth.Valid {
+			t.Fatalf("auth %s still valid", auth.Id.Hex())
+		}
+	}
+	if count, err := s.Auths.InvalidateByLock(ctx, lock.Id); err != nil || count != 0 {
+		t.Fatalf("expect nothing to invalidate, got %d, %v", count, err)
+	}
+	if auth, err := s.Auths.Get(ctx, kept.Id); err != nil || !auth.Valid {
+		t.Fatalf("auth on another lock invalidated: %+v, %v", auth, err)
+	}
+}
+
+func testTenantScoping(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	orgId := primitive.NewObjectID()
+	orgCtx := store.WithTenant(ctx, orgId)
+	personal := newLock(t, s, own, "AA:00:00:00:00:01")
+	office := &model.Lock{Name: "office", Mac: "AA:00:00:00:00:02", Key: "0123456789abcdef", Own: own, Valid: true}
+	if err := s.Locks.Insert(orgCtx, office); err != nil {
+		t.Fatal(err)
+	}
+	if office.OrgId != orgId {
+		t.Fatalf("lock should record the tenant, got %s", office.OrgId.Hex())
+	}
+	newAuth(t, s, personal, model.Perms{})
+	orgAuth := &model.Auth{Id: primitive.NewObjectID(), SendId: own, LockId: office.Id, AuthType: "1", Valid: true}
+	if err := s.Auths.Insert(orgCtx, orgAuth); err != nil {
+		t.Fatal(err)
+	}
+
+	// 个人请求看不到组织的数据，组织的请求看不到个人数据
+	if _, err := s.Locks.GetByMac(ctx, office.Mac); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if _, err := s.Locks.Get(orgCtx, personal.Id); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if lock, err := s.Locks.GetByMac(orgCtx, office.Mac); err != nil || lock.OrgId != orgId {
+		t.Fatalf("unexpected lock %+v, %v", lock, err)
+	}
+	if locks, err := s.Locks.FindByOwner(ctx, own, true); err != nil || len(locks) != 1 || locks[0].Id != personal.Id {
+		t.Fatalf("unexpected personal locks %+v, %v", locks, err)
+	}
+	if locks, err := s.Locks.FindByOwner(store.AllTenants(ctx), own, true); err != nil || len(locks) != 2 {
+		t.Fatalf("unexpected locks in all tenants %+v, %v", locks, err)
+	}
+	if _, err := s.Auths.Get(ctx, orgAuth.Id); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+
+	// 修改也只作用在请求所在的租户里
+	if err := s.Auths.Invalidate(ctx, orgAuth.Id); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if count, err := s.Auths.InvalidateByLock(ctx, office.Id); err != nil || count != 0 {
+		t.Fatalf("expect nothing invalidated outside the org, got %d, %v", count, err)
+	}
+	if count, err := s.Auths.InvalidateByLock(orgCtx, office.Id); err != nil || count != 1 {
+		t.Fatalf("expect 1 auth invalidated, got %d, %v", count, err)
+	}
+}
+
+func testDeviceClaimOnce(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	other := newUser(t, s, "open-2").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+	device := &model.Device{
+		Mac:           lock.Mac,
+		Serial:        "SN-0001",
+		Model:         "EZ-1",
+		Key:           "0123456789abcdef0123456789abcdef",
+		ClaimCodeHash: "hash",
+		UpdateTime:    time.Now().Local(),
+		CreateTime:    time.Now().Local(),
+	}
+	if err := s.Devices.Insert(ctx, device); err != nil {
+		t.Fatal(err)
+	}
+	dup := *device
+	dup.Id = primitive.NilObjectID
+	dup.Mac = "AA:00:00:00:00:02"
+	if err := s.Devices.Insert(ctx, &dup); err != store.ErrDuplicate {
+		t.Fatalf("expect ErrDuplicate for the same serial, got %v", err)
+	}
+
+	// 没有认领的设备不能重新认领
+	if err := s.Devices.Reclaim(ctx, device.Id, other); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.Devices.RecordFailure(ctx, device.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Devices.GetByMac(ctx, device.Mac); err != nil || got.Attempts != 1 {
+		t.Fatalf("expect 1 attempt, got %+v, %v", got, err)
+	}
+
+	// 认领清空输错次数，只能认领一次
+	if err := s.Devices.Claim(ctx, device.Id, lock.Id, own); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Devices.Claim(ctx, device.Id, lock.Id, other); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	got, err := s.Devices.GetByMac(ctx, device.Mac)
+	if err != nil || !got.Claimed() || got.LockId != lock.Id || got.ClaimedBy != own || got.Attempts != 0 {
+		t.Fatalf("unexpected device %+v, %v", got, err)
+	}
+	if err := s.Devices.Reclaim(ctx, device.Id, other); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Devices.GetByMac(ctx, device.Mac); err != nil || got.ClaimedBy != other || got.LockId != lock.Id {
+		t.Fatalf("unexpected device %+v, %v", got, err)
+	}
+}
+
+func testLockDeleteAndRevive(t *testing.T, s *store.Store) {
+	own := newUser(t, s, "open-1").Id
+	other := newUser(t, s, "open-2").Id
+	lock := newLock(t, s, own, "AA:00:00:00:00:01")
+
+	// 没有删除的门锁不能重新启用
+	revive := *lock
+	revive.Own = other
+	if err := s.Locks.Revive(ctx, &revive); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if err := s.Locks.Invalidate(ctx, lock.Mac, own); err != nil {
+		t.Fatal(err)
+	}
+	revive.Name = "back door"
+	if err := s.Locks.Revive(ctx, &revive); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Locks.Get(ctx, lock.Id)
+	if err != nil || !got.Valid || got.Own != other || got.Name != "back door" || got.Key != lock.Key {
+		t.Fatalf("unexpected lock %+v, %v", got, err)
+	}
+
+	// 回滚的时候删除门锁记录，mac 可以再次使用
+	if err := s.Locks.Delete(ctx, lock.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Locks.Get(ctx, lock.Id); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	newLock(t, s, own, lock.Mac)
+}
+
+func testDeviceChallengeUseOnce(t *testing.T, s *store.Store) {
+	user := newUser(t, s, "open-1").Id
+	other := newUser(t, s, "open-2").Id
+	newChallenge := func(expire time.Time) *model.DeviceChallenge {
+		t.Helper()
+		challenge := &model.DeviceChallenge{
+			UserId:     user,
+			Mac:        "AA:00:00:00:00:01",
+			ExpireTime: expire,
+			CreateTime: time.Now().Local(),
+		}
+		if err := s.Challenges.Insert(ctx, challenge); err != nil {
+			t.Fatal(err)
+		}
+		return challenge
+	}
+
+	// 只能由签发的用户对签发的门锁使用一次
+	challenge := newChallenge(time.Now().Add(time.Minute).Local())
+	if err := s.Challenges.Use(ctx, challenge.Id, other, challenge.Mac); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound for another user, got %v", err)
+	}
+	if err := s.Challenges.Use(ctx, challenge.Id, user, "AA:00:00:00:00:02"); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound for another lock, got %v", err)
+	}
+	if err := s.Challenges.Use(ctx, challenge.Id, user, challenge.Mac); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Challenges.Use(ctx, challenge.Id, user, challenge.Mac); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound for a used challenge, got %v", err)
+	}
+
+	expired := newChallenge(time.Now().Add(-time.Minute).Local())
+	if err := s.Challenges.Use(ctx, expired.Id, user, expired.Mac); err != store.ErrNotFound {
+		t.Fatalf("expect ErrNotFound for an expired challenge, got %v", err)
+	}
+}
+
+func testInsertExistingId(t *testing.T, s *store.Store) {
+	user := newUser(t, s, "open-1")
+	lock := newLock(t, s, user.Id, "AA:00:00:00:00:01")
+	auth := newAuth(t, s, lock, model.Perms{})
+	card := &model.Card{Number: "12345678", Lock: lock.Id, UserId: user.Id, Valid: true}
+	if err := s.Cards.Insert(ctx, card); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		insert func() error
+	}{
+		{"user", func() error { return s.Users.Insert(ctx, user) }},
+		{"lock", func() error { return s.Locks.Insert(ctx, lock) }},
+		{"auth", func() error { return s.Auths.Insert(ctx, auth) }},
+		{"card", func() error { return s.Cards.Insert(ctx, card) }},
+	}
+	for _, tt := range tests {
+		if err := tt.insert(); err != store.ErrDuplicate {
+			t.Fatalf("%s: expect ErrDuplicate, got %v", tt.name, err)
+		}
+	}
+}
+
+func testCanceledContext(t *testing.T, s *store.Store) {
+	user := newUser(t, s, "open-1")
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	// 请求已经结束的时候不再访问数据
+	if _, err := s.Users.Get(canceled, user.Id); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+	if err := s.Users.SetPhoneNumber(canceled, user.Id, "13800000001"); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+}
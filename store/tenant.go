@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type tenantKey struct{}
+
+// 请求所在的租户，all 为 true 的时候不按租户过滤
+type tenant struct {
+	orgId primitive.ObjectID
+	all   bool
+}
+
+// 把请求的门锁、门卡、授权和日志限制在组织 orgId 里，orgId 为空表示不属于任何组织的个人数据
+// 没有设置租户的请求按个人数据处理，各个实现查询的时候都要带上租户条件，写入的时候都要记录租户
+func WithTenant(ctx context.Context, orgId primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant{orgId: orgId})
+}
+
+// 不按租户过滤，写入的时候使用记录自己的租户，只能在平台管理、数据迁移和用户注销这些需要跨租户的地方使用
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant{all: true})
+}
+
+// 请求所在的组织，个人数据返回空 id，all 为 true 的时候不按租户过滤
+func TenantOf(ctx context.Context) (orgId primitive.ObjectID, all bool) {
+	t, _ := ctx.Value(tenantKey{}).(tenant)
+	return t.orgId, t.all
+}
+
+// 写入的记录属于哪个组织，不按租户过滤的时候使用记录自己的组织
+func TenantId(ctx context.Context, orgId primitive.ObjectID) primitive.ObjectID {
+	if tenantId, all := TenantOf(ctx); !all {
+		return tenantId
+	}
+	return orgId
+}
+
+// 记录是否属于请求所在的租户
+func InTenant(ctx context.Context, orgId primitive.ObjectID) bool {
+	tenantId, all := TenantOf(ctx)
+	return all || tenantId == orgId
+}
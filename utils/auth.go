@@ -1,27 +1,28 @@
 package utils
 
 import (
-	"ezlock/common/mongo"
-	"ezlock/config"
+	"context"
+	"ezlock/common/tracing"
 	"ezlock/model"
-	"github.com/globalsign/mgo/bson"
+	"ezlock/store"
+	"go.opentelemetry.io/otel/attribute"
 	"time"
 )
 
 // 检测对应授权类型是否有效
-func CheckAuthValid(auth model.Auth) bool {
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	q := bson.M{
-		"_id":   auth.LockId,
-		"valid": true,
+func CheckAuthValid(ctx context.Context, s *store.Store, auth model.Auth) (valid bool) {
+	ctx, span := tracing.Start(ctx, "utils.CheckAuthValid", attribute.String("ezlock.auth_id", auth.Id.Hex()))
+	defer func() {
+		span.SetAttributes(attribute.Bool("ezlock.auth_valid", valid))
+		span.End()
+	}()
+	// 授权给空间的只看时间，空间和里面的门锁在使用的时候再检查
+	if auth.IsSpaceAuth() {
+		return CheckAuthTimeValid(auth)
 	}
-	lock := model.Lock{}
 	// 查看门锁是否被删除
-	err := lockColl.Find(q).Select(bson.M{"_id": 1}).One(&lock)
-	if err != nil {
+	lock, err := s.Locks.Get(ctx, auth.LockId)
+	if err != nil || !lock.Valid {
 		// err 可能是没发现，也可能是其他数据库错误，此处直接设置为无效授权
 		return false
 	}
@@ -7,8 +7,11 @@ const (
 	PARAM_ERR = 10000
 
 	MONGO_ERR = 20000
+	NOT_READY = 20001
+	TIMEOUT   = 20002
 
 	WEAPP_ERR = 30000
+	SMS_ERR   = 30001
 
 	UNAUTH = 40000
 
@@ -16,19 +19,30 @@ const (
 
 	INVALID = 40002
 
+	TOO_FREQUENT = 40003
+
+	STEP_UP_REQUIRED = 40004
+
 	ENCRYPT_ERR = 50000
 	DNCRYPT_ERR = 50001
+	EXPORT_ERR  = 50002
 )
 
 // 错误码对应说明
 var ERR_MSG_MAP = map[int]string{
-	OK:          "OK",
-	PARAM_ERR:   "参数错误",
-	MONGO_ERR:   "数据库错误",
-	WEAPP_ERR:   "微信小程序响应错误",
-	UNAUTH:      "没有访问权限",
-	NOT_EXISTS:  "不存在",
-	INVALID:     "已失效",
-	ENCRYPT_ERR: "加密数据失败",
-	DNCRYPT_ERR: "解密数据失败",
+	OK:               "OK",
+	PARAM_ERR:        "参数错误",
+	MONGO_ERR:        "数据库错误",
+	NOT_READY:        "服务还没有就绪",
+	TIMEOUT:          "数据库请求超时",
+	WEAPP_ERR:        "微信小程序响应错误",
+	SMS_ERR:          "短信发送失败",
+	UNAUTH:           "没有访问权限",
+	NOT_EXISTS:       "不存在",
+	INVALID:          "已失效",
+	TOO_FREQUENT:     "操作太频繁",
+	STEP_UP_REQUIRED: "需要二次验证",
+	ENCRYPT_ERR:      "加密数据失败",
+	DNCRYPT_ERR:      "解密数据失败",
+	EXPORT_ERR:       "导出数据失败",
 }
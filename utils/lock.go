@@ -1,98 +1,232 @@
 package utils
 
 import (
-	"ezlock/common/mongo"
+	"context"
+	"ezlock/common/logger"
+	"ezlock/common/metrics"
+	"ezlock/common/tracing"
 	"ezlock/config"
 	"ezlock/model"
+	"ezlock/store"
 	"fmt"
-	"github.com/globalsign/mgo"
-	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"strings"
 	"time"
 )
 
-// 获取给定用户被授权的锁 valid 为true 在有效期限内，false就是所有
-func GetAuthLocks(userId string, valid bool, perms model.Perms) ([]bson.ObjectId, error) {
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-	authColl := mgoSession.DB(config.DataBaseName).C(model.AuthTableName)
+type lockScopeKey struct{}
 
-	q := bson.M{
-		"receiverId": userId,
-	}
-	if valid {
-		q["valid"] = true
-	}
-	if perms.AddCard {
-		q["addCard"] = true
-	}
-	if perms.ShareAuth {
-		q["shareAuth"] = true
+// 限制这个请求只能操作 lockIds 里的门锁，接口 key 限制了门锁的时候使用
+func WithLockScope(ctx context.Context, lockIds []primitive.ObjectID) context.Context {
+	scope := make(map[primitive.ObjectID]bool, len(lockIds))
+	for _, id := range lockIds {
+		scope[id] = true
 	}
-	if perms.ViewLog {
-		q["viewLog"] = true
-	}
-	auths := []model.Auth{}
-	lockIds := []bson.ObjectId{}
+	return context.WithValue(ctx, lockScopeKey{}, scope)
+}
+
+// 这个请求是否可以操作门锁，没有限制的时候都可以
+func LockInScope(ctx context.Context, lockId primitive.ObjectID) bool {
+	scope, ok := ctx.Value(lockScopeKey{}).(map[primitive.ObjectID]bool)
+	return !ok || scope[lockId]
+}
+
+// 获取给定用户被授权的锁 valid 为true 在有效期限内，false就是所有
+func GetAuthLocks(ctx context.Context, s *store.Store, userId string, valid bool, perms model.Perms) (lockIds []primitive.ObjectID, err error) {
+	ctx, span := tracing.Start(ctx, "utils.GetAuthLocks", tracing.UserId(userId))
+	defer tracing.End(span, &err)
+	lockIds = []primitive.ObjectID{}
 	// 找到用户被授权的记录
-	err := authColl.Find(q).All(&auths)
+	auths, err := s.Auths.FindByReceiver(ctx, ObjectIdHex(userId), valid, perms)
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			return nil, err
-		}
-		return lockIds, nil
+		return nil, err
 	}
 
+	span.SetAttributes(attribute.Int("ezlock.auth_count", len(auths)))
 	for _, auth := range auths {
 		// 授权无效
-		if !CheckAuthValid(auth) {
+		if !CheckAuthValid(ctx, s, auth) {
 			// 发现授权已经不在有效期内 则更新一下数据库 设置授权无效
-			err := authColl.UpdateId(auth.Id, bson.M{
-				"$set": bson.M{"valid": false},
-			})
-			if err != nil {
-				return nil, err
+			if auth.Valid {
+				if err := s.Auths.Invalidate(ctx, auth.Id); err != nil {
+					return nil, err
+				}
 			}
 			if valid {
 				continue
 			}
 		}
-		lockIds = append(lockIds, auth.LockId)
+		if !auth.IsSpaceAuth() {
+			lockIds = append(lockIds, auth.LockId)
+			continue
+		}
+		spaceLocks, err := GetSpaceLocks(ctx, s, auth.SendId, auth.SpaceIds)
+		if err != nil {
+			return nil, err
+		}
+		for _, lock := range spaceLocks {
+			lockIds = append(lockIds, lock.Id)
+		}
 	}
 	return lockIds, nil
 }
 
+// 获取 own 的这些空间和下层空间里没有删除的门锁，已经删除的空间和不属于 own 的空间或门锁忽略
+func GetSpaceLocks(ctx context.Context, s *store.Store, own primitive.ObjectID, spaceIds []primitive.ObjectID) (locks []model.Lock, err error) {
+	ctx, span := tracing.Start(ctx, "utils.GetSpaceLocks", tracing.UserId(own.Hex()))
+	defer tracing.End(span, &err)
+	spaces, err := s.Spaces.FindSubtree(ctx, spaceIds)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(spaces))
+	for _, space := range spaces {
+		if space.Own == own {
+			ids = append(ids, space.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return []model.Lock{}, nil
+	}
+	all, err := s.Locks.FindBySpaces(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	locks = make([]model.Lock, 0, len(all))
+	for _, lock := range all {
+		// 门锁转让以后会移出空间，这里再检查一次拥有者
+		if lock.Valid && lock.Own == own {
+			locks = append(locks, lock)
+		}
+	}
+	span.SetAttributes(attribute.Int("ezlock.lock_count", len(locks)))
+	return locks, nil
+}
+
 // 获取给定用户自己拥有的锁
-func GetOwnLocks(userId string, valid bool) ([]bson.ObjectId, error) {
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
+func GetOwnLocks(ctx context.Context, s *store.Store, userId string, valid bool) (lockIds []primitive.ObjectID, err error) {
+	ctx, span := tracing.Start(ctx, "utils.GetOwnLocks", tracing.UserId(userId))
+	defer tracing.End(span, &err)
+	lockIds = []primitive.ObjectID{}
+	locks, err := s.Locks.FindByOwner(ctx, ObjectIdHex(userId), valid)
+	if err != nil {
+		return nil, err
+	}
+	for _, lock := range locks {
+		lockIds = append(lockIds, lock.Id)
+	}
+	return lockIds, nil
+}
 
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-	q := bson.M{
-		"own": bson.ObjectIdHex(userId),
+// 获取给定用户作为成员加入的锁，值为 true 表示是管理员，valid 为true 只返回没有删除的锁
+func GetMemberLocks(ctx context.Context, s *store.Store, userId string, valid bool, perms model.Perms) (memberLocks map[primitive.ObjectID]bool, err error) {
+	ctx, span := tracing.Start(ctx, "utils.GetMemberLocks", tracing.UserId(userId))
+	defer tracing.End(span, &err)
+	memberLocks = map[primitive.ObjectID]bool{}
+	members, err := s.Members.FindByUser(ctx, ObjectIdHex(userId))
+	if err != nil {
+		return nil, err
 	}
-	if valid {
-		q["valid"] = true
+	lockIds := []primitive.ObjectID{}
+	for _, member := range members {
+		if member.HasPerms(perms) {
+			lockIds = append(lockIds, member.LockId)
+		}
 	}
-	locks := []model.Lock{}
-	lockIds := []bson.ObjectId{}
-	// 找到用户被授权的记录
-	err := lockColl.Find(q).Select(bson.M{"_id": 1}).All(&locks)
+	if len(lockIds) == 0 {
+		return memberLocks, nil
+	}
+	// 成员记录不区分租户，只保留当前租户里查得到的锁
+	locks, err := s.Locks.FindByIds(ctx, lockIds)
 	if err != nil {
-		if err != mgo.ErrNotFound {
-			return nil, err
+		return nil, err
+	}
+	inTenant := make(map[primitive.ObjectID]bool, len(locks))
+	for _, lock := range locks {
+		inTenant[lock.Id] = !valid || lock.Valid
+	}
+	for _, member := range members {
+		if member.HasPerms(perms) && inTenant[member.LockId] {
+			memberLocks[member.LockId] = member.Role == model.MemberAdmin
 		}
+	}
+	return memberLocks, nil
+}
+
+// 获取当前组织里的门锁，只有组织的创建者和管理员可以获取，个人数据和普通成员返回空列表
+func GetOrgLocks(ctx context.Context, s *store.Store, userId string, valid bool) (lockIds []primitive.ObjectID, err error) {
+	ctx, span := tracing.Start(ctx, "utils.GetOrgLocks", tracing.UserId(userId))
+	defer tracing.End(span, &err)
+	lockIds = []primitive.ObjectID{}
+	orgId, _ := store.TenantOf(ctx)
+	if orgId.IsZero() {
+		return lockIds, nil
+	}
+	member, err := s.OrgMembers.Get(ctx, orgId, ObjectIdHex(userId))
+	if err == store.ErrNotFound {
+		return lockIds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !member.CanManageLocks() {
 		return lockIds, nil
 	}
+	locks, err := s.Locks.FindByOrg(ctx, orgId, valid)
+	if err != nil {
+		return nil, err
+	}
 	for _, lock := range locks {
 		lockIds = append(lockIds, lock.Id)
 	}
 	return lockIds, nil
 }
 
-// 获取用户目前可用的锁
-func GetAllLocks(userId string, valid bool, perms model.Perms) (map[bson.ObjectId]bool, error) {
-	allLocks := map[bson.ObjectId]bool{}
-	ownLocks, err := GetOwnLocks(userId, valid)
+// 获取用户在门锁上的角色，owner、admin 或者 member，都不是的时候返回空字符串
+// 组织的创建者和管理员在组织的门锁上是 admin
+func LockRole(ctx context.Context, s *store.Store, userId string, lock *model.Lock) (string, error) {
+	if lock.Own.Hex() == userId {
+		return model.MemberOwner, nil
+	}
+	if !lock.OrgId.IsZero() {
+		orgMember, err := s.OrgMembers.Get(ctx, lock.OrgId, ObjectIdHex(userId))
+		if err != nil && err != store.ErrNotFound {
+			return "", err
+		}
+		if err == nil && orgMember.CanManageLocks() {
+			return model.MemberAdmin, nil
+		}
+	}
+	member, err := s.Members.Get(ctx, lock.Id, ObjectIdHex(userId))
+	if err == store.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// 用户是否可以管理门锁，门锁没有删除并且用户是拥有者或者管理员
+func CanManageLock(ctx context.Context, s *store.Store, userId string, lock *model.Lock) (bool, error) {
+	if !lock.Valid {
+		return false, nil
+	}
+	role, err := LockRole(ctx, s, userId, lock)
+	if err != nil {
+		return false, err
+	}
+	return role == model.MemberOwner || role == model.MemberAdmin, nil
+}
+
+// 获取用户目前可用的锁，值为 true 表示用户可以管理这把锁，也就是拥有者或者管理员
+// 只包括 ctx 所在租户里的锁，组织的创建者和管理员可以管理组织里所有的锁
+func GetAllLocks(ctx context.Context, s *store.Store, userId string, valid bool, perms model.Perms) (allLocks map[primitive.ObjectID]bool, err error) {
+	ctx, span := tracing.Start(ctx, "utils.GetAllLocks", tracing.UserId(userId))
+	defer tracing.End(span, &err)
+	allLocks = map[primitive.ObjectID]bool{}
+	ownLocks, err := GetOwnLocks(ctx, s, userId, valid)
 	if err != nil {
 		return nil, err
 	}
@@ -101,52 +235,84 @@ func GetAllLocks(userId string, valid bool, perms model.Perms) (map[bson.ObjectI
 		allLocks[lock] = true
 	}
 
-	authLocks, err := GetAuthLocks(userId, valid, perms)
+	orgLocks, err := GetOrgLocks(ctx, s, userId, valid)
+	if err != nil {
+		return nil, err
+	}
+	for _, lock := range orgLocks {
+		allLocks[lock] = true
+	}
+
+	memberLocks, err := GetMemberLocks(ctx, s, userId, valid, perms)
+	if err != nil {
+		return nil, err
+	}
+	for lock, admin := range memberLocks {
+		if _, ok := allLocks[lock]; !ok {
+			allLocks[lock] = admin
+		}
+	}
+
+	authLocks, err := GetAuthLocks(ctx, s, userId, valid, perms)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, lock := range authLocks {
-		allLocks[lock] = false
+		// 自己的锁或者加入的锁又被别人授权过来，以拥有者或者成员身份为准
+		if _, ok := allLocks[lock]; !ok {
+			allLocks[lock] = false
+		}
 	}
 
+	// 接口 key 只能看到限制范围内的锁
+	for lock := range allLocks {
+		if !LockInScope(ctx, lock) {
+			delete(allLocks, lock)
+		}
+	}
 	return allLocks, nil
 }
 
-func GenerateKey(userId, mac, operate, code string) (key string, err error) {
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
+// 获取用户有权限操作的指定mac地址的门锁
+func getPermittedLock(ctx context.Context, s *store.Store, userId, mac string, perms model.Perms) (lock *model.Lock, err error) {
+	ctx, span := tracing.Start(ctx, "utils.getPermittedLock", tracing.UserId(userId), tracing.Mac(mac))
+	defer tracing.End(span, &err)
+	locks, err := GetAllLocks(ctx, s, userId, true, perms)
+	if err != nil {
+		return nil, err
+	}
+	lock, err = s.Locks.GetByMac(ctx, mac)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := locks[lock.Id]; !ok {
+		return nil, store.ErrNotFound
+	}
+	return lock, nil
+}
 
+func GenerateKey(ctx context.Context, s *store.Store, userId, mac, operate, code string) (key string, err error) {
+	ctx, span := tracing.Start(ctx, "utils.GenerateKey", tracing.UserId(userId), tracing.Mac(mac))
+	defer tracing.End(span, &err)
 	// 查看用户被授权的锁
 	perms := model.Perms{}
-	if operate == config.AddCard {
+	command := config.Get().Command
+	if operate == command.AddCard {
 		perms.AddCard = true
 	}
 
-	if operate == config.AddCard {
+	if operate == command.GetLog {
 		perms.ViewLog = true
 	}
 
-	locks, err := GetAllLocks(userId, true, perms)
-	if err != nil {
-		return "", err
-	}
-
-	lockIds := make([]bson.ObjectId, len(locks))
-	for key := range locks {
-		lockIds = append(lockIds, key)
-	}
-	q := bson.M{
-		"_id": bson.M{"$in": lockIds},
-		"mac": mac,
-	}
-	lock := model.Lock{}
-	// 响应给用户的结构
-	err = lockColl.Find(q).Select(bson.M{"_id": 0, "key": 1}).One(&lock)
+	operation := operationName(command, operate)
+	span.SetAttributes(attribute.String("ezlock.operation", operation))
+	log := logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": mac, "operation": operation})
+	lock, err := getPermittedLock(ctx, s, userId, mac, perms)
 	if err != nil {
+		metrics.KeyIssued(operation, failureReason(err))
+		log.WithField("outcome", failureReason(err)).Warnf("lock key not issued: %s", err.Error())
 		return "", err
 	}
 	// 如果是开门/添加门卡操作 硬件需要将如下指令格式写入日志，其他操作不写日志
@@ -156,40 +322,54 @@ func GenerateKey(userId, mac, operate, code string) (key string, err error) {
 	rawData := []byte(fmt.Sprintf("%s_%s_%s", operate, time.Now().Local().Format("2006-01-02 15:04"), userId))
 	key, err = Encrypt([]byte(code), rawData, []byte(lock.Key))
 	if err != nil {
+		metrics.KeyIssued(operation, "error")
+		log.WithField("outcome", "error").Errorf("encrypt lock key failed: %s", err.Error())
 		return "", err
 	}
+	metrics.KeyIssued(operation, "issued")
+	log.WithField("outcome", "issued").Info("lock key issued")
 	return key, nil
 }
 
-func DncryptData(userId, mac, rawData string) (content string, err error) {
-	// 获取mongo的操作session
-	mgoSession := mongo.GetMgoSession()
-	defer mongo.PutMgoSession(mgoSession)
-
-	lockColl := mgoSession.DB(config.DataBaseName).C(model.LockTableName)
-
-	// 查看用户被授权的锁
-	locks, err := GetAllLocks(userId, true, model.Perms{})
-	if err != nil {
-		return "", err
+// 指标里使用的操作名称，删除门卡的指令带有卡号，需要去掉
+func operationName(command config.Command, operate string) string {
+	switch operate {
+	case command.OpenLock:
+		return "open"
+	case command.GetLog:
+		return "getlog"
+	case command.AddCard:
+		return "addcard"
 	}
-
-	lockIds := make([]bson.ObjectId, len(locks))
-	for key := range locks {
-		lockIds = append(lockIds, key)
+	if prefix := strings.Split(command.DelCard, "%s")[0]; strings.HasPrefix(operate, prefix) {
+		return "delcard"
 	}
-	q := bson.M{
-		"_id": bson.M{"$in": lockIds},
-		"mac": mac,
+	return "other"
+}
+
+// 没有权限的时候 getPermittedLock 返回 ErrNotFound
+func failureReason(err error) string {
+	if err == store.ErrNotFound {
+		return "denied"
 	}
-	lock := model.Lock{}
-	// 响应给用户的结构
-	err = lockColl.Find(q).Select(bson.M{"_id": 0, "key": 1}).One(&lock)
+	return "error"
+}
+
+func DncryptData(ctx context.Context, s *store.Store, userId, mac, rawData string) (content string, err error) {
+	ctx, span := tracing.Start(ctx, "utils.DncryptData", tracing.UserId(userId), tracing.Mac(mac))
+	defer tracing.End(span, &err)
+	// 查看用户被授权的锁
+	log := logger.Ctx(ctx).WithFields(logger.Fields{"userId": userId, "mac": mac})
+	lock, err := getPermittedLock(ctx, s, userId, mac, model.Perms{})
 	if err != nil {
+		metrics.DecryptFailed(failureReason(err))
+		log.WithField("outcome", failureReason(err)).Warnf("lock data not decrypted: %s", err.Error())
 		return "", err
 	}
 	content, err = Dncrypt(rawData, []byte(lock.Key))
 	if err != nil {
+		metrics.DecryptFailed("decrypt")
+		log.WithField("outcome", "decrypt").Warnf("decrypt lock data failed: %s", err.Error())
 		return "", err
 	}
 	return content, nil
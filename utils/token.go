@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+)
+
+// 生成刷新 token，格式为 会话id.随机串，返回 token 和需要保存的哈希
+func NewRefreshToken(sessionId primitive.ObjectID) (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = sessionId.Hex() + "." + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// 解析刷新 token，返回会话 id 和哈希，格式不对的时候 ok 为 false
+func ParseRefreshToken(token string) (sessionId primitive.ObjectID, hash string, ok bool) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return primitive.NilObjectID, "", false
+	}
+	sessionId, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, "", false
+	}
+	return sessionId, HashToken(token), true
+}
+
+// 数据库里只保存 token 的哈希，数据库泄露也拿不到可以使用的 token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 接口 key 的前缀，方便识别和扫描泄露的 key
+const apiKeyPrefix = "ezk_"
+
+// 生成接口 key，格式为 ezk_keyid.随机串，返回 key 和需要保存的哈希
+func NewApiKey(keyId primitive.ObjectID) (key, hash string, err error) {
+	token, _, err := NewRefreshToken(keyId)
+	if err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + token
+	return key, HashToken(key), nil
+}
+
+// 解析接口 key，返回 key id 和哈希，格式不对的时候 ok 为 false
+func ParseApiKey(key string) (keyId primitive.ObjectID, hash string, ok bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return primitive.NilObjectID, "", false
+	}
+	keyId, _, ok = ParseRefreshToken(strings.TrimPrefix(key, apiKeyPrefix))
+	if !ok {
+		return primitive.NilObjectID, "", false
+	}
+	return keyId, HashToken(key), true
+}
+
+// 认领码使用的字符，去掉了容易看错的 0、1、I、O，32 个字符正好可以用一个字节的低 5 位选取
+const claimCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// 认领码的字符数，不包括分隔的横线
+const claimCodeLength = 12
+
+// 生成出厂写入门锁的加密密钥，32 个十六进制字符，作为 AES-256 的密钥使用
+func NewDeviceKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 生成印在包装盒上的认领码，格式为 XXXX-XXXX-XXXX，返回认领码和需要保存的哈希
+func NewClaimCode() (code, hash string, err error) {
+	b := make([]byte, claimCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	var sb strings.Builder
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(claimCodeAlphabet[c%32])
+	}
+	code = sb.String()
+	return code, HashClaimCode(code), nil
+}
+
+// 用户输入的认领码的哈希，忽略大小写、空格和横线
+func HashClaimCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashToken(code)
+}
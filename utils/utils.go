@@ -7,9 +7,13 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"ezlock/common/logger"
+	"ezlock/common/metrics"
+	"ezlock/store"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"io"
 	"net/http"
 )
@@ -31,15 +35,45 @@ func ResponseOkWithCount(count int, result interface{}, c *gin.Context) {
 	})
 }
 
-// 返回错误响应
+// 返回错误响应，带上请求 id 方便根据 id 查找日志
 func ResponseError(code int, msg string, c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	ctx := c.Request.Context()
+	metrics.ResponseError(c.Request.URL.Path, code)
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"path":   c.Request.URL.Path,
+		"userId": c.GetString("id"),
 		"code":   code,
-		"msg":    fmt.Sprintf("%s: %s", ERR_MSG_MAP[code], msg),
-		"result": "",
+	}).Warn(msg)
+	c.JSON(http.StatusOK, gin.H{
+		"code":      code,
+		"msg":       fmt.Sprintf("%s: %s", ERR_MSG_MAP[code], msg),
+		"result":    "",
+		"requestId": logger.RequestId(ctx),
 	})
 }
 
+// 数据访问出错时的错误码，超时统一为 TIMEOUT，其他错误为 code
+func StoreErrorCode(code int, err error) int {
+	if errors.Is(err, store.ErrTimeout) {
+		return TIMEOUT
+	}
+	return code
+}
+
+// 返回数据访问出错的响应
+func ResponseStoreError(code int, err error, c *gin.Context) {
+	ResponseError(StoreErrorCode(code, err), err.Error(), c)
+}
+
+// 把16进制字符串转换成 ObjectID，不合法的时候返回 NilObjectID，查询的时候自然查不到
+func ObjectIdHex(hex string) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return primitive.NilObjectID
+	}
+	return id
+}
+
 // 校验参数
 func CheckParam(params interface{}, c *gin.Context) (ok bool) {
 	ok = true
@@ -118,7 +152,10 @@ func AesCBCDncrypt(encryptData, key []byte) ([]byte, error) {
 	mode := cipher.NewCBCDecrypter(block, iv)
 
 	mode.CryptBlocks(encryptData, encryptData)
-	//解填充
+	//解填充，密钥不对的时候填充长度可能超出数据长度，直接返回错误
+	if len(encryptData) == 0 || int(encryptData[len(encryptData)-1]) > len(encryptData) {
+		return nil, errors.New("invalid padding")
+	}
 	encryptData = PKCS7UnPadding(encryptData)
 	return encryptData, nil
 }